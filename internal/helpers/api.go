@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -14,6 +15,7 @@ type APIClient struct {
 	BaseURL    string
 	AuthToken  string
 	HTTPClient *http.Client
+	tokenMu    sync.RWMutex
 }
 
 // NewAPIClient creates a new APIClient instance
@@ -27,6 +29,20 @@ func NewAPIClient(baseURL, token string) *APIClient {
 	}
 }
 
+// SetAuthToken replaces the token used by subsequent requests
+func (c *APIClient) SetAuthToken(token string) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.AuthToken = token
+}
+
+// GetAuthToken returns the token used for requests
+func (c *APIClient) GetAuthToken() string {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.AuthToken
+}
+
 // request is a helper function to make HTTP requests
 func (c *APIClient) request(method, endpoint string, payload interface{}) (*http.Response, error) {
 	url := c.BaseURL + endpoint
@@ -49,8 +65,8 @@ func (c *APIClient) request(method, endpoint string, payload interface{}) (*http
 	if payload != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-	if c.AuthToken != "" {
-		req.Header.Set("Authorization", c.AuthToken)
+	if token := c.GetAuthToken(); token != "" {
+		req.Header.Set("Authorization", token)
 	}
 
 	resp, err := c.HTTPClient.Do(req)
@@ -19,7 +19,9 @@ func InitModule(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runti
 		return err
 	}
 
-	if err = initializer.RegisterFleetManager(efm); err != nil {
+	// Nakama registers a single fleet manager, the router lets other fleets be added with router.Register
+	router := fleetmanager.NewFleetRouter(efm)
+	if err = initializer.RegisterFleetManager(router); err != nil {
 		logger.WithField("error", err).Error("failed to register Edgegap fleet manager")
 		return err
 	}
@@ -30,6 +32,22 @@ func InitModule(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runti
 		return err
 	}
 
+	// Keep the IP of players staying logged in up to date
+	if err := initializer.RegisterAfterSessionRefresh(fleetmanager.OnSessionRefreshUpdateIp); err != nil {
+		logger.WithField("error", err).Error("failed to register AfterSessionRefresh")
+		return err
+	}
+
+	// Mirror the instance fields set in NAKAMA_MATCHMAKER_LABELS in the matchmaker properties of the players
+	if err := initializer.RegisterBeforeRt("MatchmakerAdd", fleetmanager.OnMatchmakerAddLabels); err != nil {
+		logger.WithField("error", err).Error("failed to register BeforeRt MatchmakerAdd")
+		return err
+	}
+	if err := initializer.RegisterBeforeRt("PartyMatchmakerAdd", fleetmanager.OnPartyMatchmakerAddLabels); err != nil {
+		logger.WithField("error", err).Error("failed to register BeforeRt PartyMatchmakerAdd")
+		return err
+	}
+
 	logger.Info("Edgegap Plugin loaded in '%s'", time.Now().Sub(initStart).String())
 
 	return nil
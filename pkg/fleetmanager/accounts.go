@@ -0,0 +1,93 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+// EdgegapAccountPrimary is the name of the account configured with EDGEGAP_API_TOKEN
+const EdgegapAccountPrimary = "primary"
+
+// edgegapAccount is an Edgegap account deployments can be created on, in failover priority order
+type edgegapAccount struct {
+	name string
+	// tenant is the only tenant deploying on the account
+	tenant    string
+	apiHelper *helpers.APIClient
+}
+
+// parseFailoverAccounts parses a comma separated list of "name=token" or plain tokens, in priority order.
+func parseFailoverAccounts(apiUrl, value string) []edgegapAccount {
+	accounts := make([]edgegapAccount, 0)
+	for i, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name := "secondary-" + strconv.Itoa(i+1)
+		token := entry
+		if n, t, ok := strings.Cut(entry, "="); ok {
+			name, token = strings.TrimSpace(n), strings.TrimSpace(t)
+		}
+
+		accounts = append(accounts, edgegapAccount{
+			name:      name,
+			tenant:    TenantDefault,
+			apiHelper: helpers.NewAPIClient(apiUrl, token),
+		})
+	}
+	return accounts
+}
+
+// isFailoverStatus reports whether a status means the account cannot deploy right now:
+// auth failures, out of quota or rate limited.
+func isFailoverStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrorDeploymentAccountUnknown is returned when the account a deployment was created on cannot be resolved, its
+// requests would otherwise reach another account and find no deployment
+var ErrorDeploymentAccountUnknown = errors.New("account of the deployment is unknown")
+
+// apiHelperFor returns the API client of the account the deployment was created on. Instances stored before accounts
+// were recorded are on the primary account.
+func (em *EdgegapManager) apiHelperFor(requestID string) (*helpers.APIClient, error) {
+	if len(em.accounts) <= 1 {
+		return em.apiHelper, nil
+	}
+
+	instance, err := em.storageManager.getDbInstance(context.Background(), requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the account of deployment %s: %w", requestID, err)
+	}
+	if instance == nil {
+		return nil, fmt.Errorf("%w: no instance %s", ErrorDeploymentAccountUnknown, requestID)
+	}
+
+	ei, err := em.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the account of deployment %s: %w", requestID, err)
+	}
+	if ei.Account == "" {
+		return em.apiHelper, nil
+	}
+
+	for _, account := range em.accounts {
+		if account.name == ei.Account {
+			return account.apiHelper, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: account %s is not configured", ErrorDeploymentAccountUnknown, ei.Account)
+}
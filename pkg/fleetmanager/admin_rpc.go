@@ -0,0 +1,154 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdAdminInstanceDelete          = "admin_instance_delete"
+	RpcIdInstanceExtend               = "instance_extend"
+	RpcIdInstanceResendConnectionInfo = "instance_resend_connection_info"
+)
+
+type adminInstanceDeleteRequest struct {
+	InstanceID string `json:"instance_id"`
+	Force      bool   `json:"force"`
+}
+
+type instanceExtendRequest struct {
+	InstanceID    string `json:"instance_id"`
+	ExtendMinutes int    `json:"extend_minutes"`
+}
+
+type instanceResendConnectionInfoRequest struct {
+	InstanceID string   `json:"instance_id"`
+	UserIds    []string `json:"user_ids"`
+}
+
+// requireS2S rejects RPC calls made by game clients, only servers with the HTTP key are allowed
+func requireS2S(ctx context.Context, logger runtime.Logger, rpcId string) error {
+	if _, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); ok {
+		logger.Warn(LogMessageClientAttemptedS2S + " " + rpcId)
+		return runtime.NewError(ErrorMessageUnauthorized, 7) // PERMISSION_DENIED
+	}
+	return nil
+}
+
+// adminDeleteInstance admin rpc to stop a deployment and remove its instance, force removes stuck records (S2S only)
+func adminDeleteInstance(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdAdminInstanceDelete); err != nil {
+		return "", err
+	}
+
+	var req *adminInstanceDeleteRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.InstanceID == "" {
+		return "", ErrInvalidInput
+	}
+
+	var err error
+	if req.Force {
+		err = fmInstance.ForceDelete(ctx, req.InstanceID)
+	} else {
+		err = fmInstance.Delete(ctx, req.InstanceID)
+	}
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to delete instance %s", req.InstanceID)
+		return "", ErrInternalError
+	}
+
+	replyString, err := json.Marshal(map[string]any{
+		"success":     true,
+		"instance_id": req.InstanceID,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
+
+// adminExtendInstance admin rpc to prolong the deployment of an instance for long matches (S2S only)
+func adminExtendInstance(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdInstanceExtend); err != nil {
+		return "", err
+	}
+
+	var req *instanceExtendRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.InstanceID == "" || req.ExtendMinutes <= 0 {
+		return "", ErrInvalidInput
+	}
+
+	expiresAt, err := fmInstance.Extend(ctx, req.InstanceID, time.Duration(req.ExtendMinutes)*time.Minute)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrorDeploymentUpdateUnsupported):
+			return "", runtime.NewError(err.Error(), 12) // UNIMPLEMENTED
+		case errors.Is(err, ErrorDeploymentNotFound):
+			return "", runtime.NewError(err.Error(), 5) // NOT_FOUND
+		}
+		logger.WithField("error", err.Error()).Error("failed to extend instance %s", req.InstanceID)
+		return "", ErrInternalError
+	}
+
+	replyString, err := json.Marshal(map[string]any{
+		"success":     true,
+		"instance_id": req.InstanceID,
+		"expires_at":  expiresAt,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
+
+// resendConnectionInfo admin rpc to send the connection-info notification again to players who missed it (S2S only)
+func resendConnectionInfo(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdInstanceResendConnectionInfo); err != nil {
+		return "", err
+	}
+
+	var req *instanceResendConnectionInfoRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.InstanceID == "" || len(req.UserIds) == 0 {
+		return "", ErrInvalidInput
+	}
+
+	instance, err := fmInstance.storageManager.getDbInstance(ctx, req.InstanceID)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to get instance %s", req.InstanceID)
+		return "", ErrInternalError
+	}
+	if instance == nil {
+		return "", runtime.NewError("instance not found", 5) // NOT_FOUND
+	}
+	if instance.Status != EdgegapStatusReady || instance.ConnectionInfo == nil {
+		return "", runtime.NewError("instance is not ready", 9) // FAILED_PRECONDITION
+	}
+
+	// Reservation tokens are derived from the instance and user, the resent one matches the original
+	secret := fmInstance.edgegapManager.configuration.NakamaHttpKey
+	err = sendNotifications(ctx, logger, nk, instance, "connection-info", notificationConnectionInfo, req.UserIds, func(userId string) map[string]interface{} {
+		return connectionInfoContent(instance, reservationToken(secret, instance.Id, userId))
+	})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to resend connection info for instance %s", req.InstanceID)
+		return "", ErrInternalError
+	}
+
+	replyString, err := json.Marshal(map[string]any{
+		"success":     true,
+		"instance_id": req.InstanceID,
+		"user_ids":    req.UserIds,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
@@ -0,0 +1,103 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// APIVersion is the semantic version of the EdgegapFleet interface.
+// Methods are only added in minor versions, removing or changing a method requires a new major version.
+const APIVersion = "1.4.0"
+
+// ErrorFleetNotInitialized is returned by GetEdgegapFleet before the fleet manager was registered and initialized
+var ErrorFleetNotInitialized = errors.New("edgegap fleet manager is not initialized")
+
+// EdgegapFleet is the public Go API of the Edgegap fleet manager, for other modules compiled into the same plugin.
+type EdgegapFleet interface {
+	runtime.FleetManagerInitializer
+
+	// StartDeferred deploys a pending instance created with deferred start and returns its deployment ID.
+	StartDeferred(ctx context.Context, id string) (string, error)
+	// ForceDelete removes an instance from storage even if its deployment cannot be stopped.
+	ForceDelete(ctx context.Context, id string) error
+	// StopDeployment stops the Edgegap deployment of an instance, without removing the instance from storage.
+	StopDeployment(ctx context.Context, id string) (*EdgegapApiMessage, error)
+	// ListDeployments returns every deployment of the account from the Edgegap API.
+	ListDeployments(ctx context.Context) ([]EdgegapDeploymentSummary, error)
+	// EdgegapVersion returns the application version used for new deployments.
+	EdgegapVersion(ctx context.Context) (string, error)
+	// SetEdgegapVersion validates the version with Edgegap and uses it for new deployments.
+	SetEdgegapVersion(ctx context.Context, version string) error
+	// SetEntitlementHook sets the hook checking users are entitled to create or join an instance.
+	SetEntitlementHook(hook EntitlementHook)
+	// SetDeploymentHook sets the hook modifying the deployment payloads before they are sent to Edgegap.
+	SetDeploymentHook(hook DeploymentHook)
+	// SetReadyHook sets the hook provisioning per-match resources when an instance is ready.
+	SetReadyHook(hook ReadyHook)
+}
+
+var _ EdgegapFleet = (*EdgegapFleetManager)(nil)
+
+// GetEdgegapFleet returns the registered Edgegap fleet manager.
+func GetEdgegapFleet() (EdgegapFleet, error) {
+	if fmInstance == nil {
+		return nil, ErrorFleetNotInitialized
+	}
+	return fmInstance, nil
+}
+
+// edgegapFleet resolves the Edgegap fleet manager for the RPCs. nk.GetFleetManager returns the fleet manager
+// registered with Nakama, which may belong to another module when several fleets coexist in the same server.
+func edgegapFleet(nk runtime.NakamaModule) (*EdgegapFleetManager, error) {
+	if fmInstance != nil {
+		return fmInstance, nil
+	}
+	switch fm := nk.GetFleetManager().(type) {
+	case *EdgegapFleetManager:
+		return fm, nil
+	case *FleetRouter:
+		return fm.edgegap, nil
+	}
+	return nil, runtime.NewError(ErrorFleetNotInitialized.Error(), 9) // FAILED_PRECONDITION
+}
+
+// EdgegapInfo returns the typed Edgegap metadata of an instance returned by the fleet manager.
+func EdgegapInfo(instance *runtime.InstanceInfo) (*EdgegapInstanceInfo, error) {
+	if instance == nil {
+		return nil, errors.New("instance cannot be nil")
+	}
+	return extractEdgegapInstance(instance)
+}
+
+// StopDeployment stops the Edgegap deployment of an instance.
+func (efm *EdgegapFleetManager) StopDeployment(ctx context.Context, id string) (*EdgegapApiMessage, error) {
+	return efm.edgegapManager.StopDeployment(id)
+}
+
+// ListDeployments returns every deployment of the account from the Edgegap API.
+func (efm *EdgegapFleetManager) ListDeployments(ctx context.Context) ([]EdgegapDeploymentSummary, error) {
+	return efm.edgegapManager.ListAllDeployments()
+}
+
+// EdgegapVersion returns the application version used for new deployments.
+func (efm *EdgegapFleetManager) EdgegapVersion(ctx context.Context) (string, error) {
+	return efm.edgegapManager.getEdgegapVersion()
+}
+
+// SetEdgegapVersion validates the version with Edgegap and stores it for new deployments.
+func (efm *EdgegapFleetManager) SetEdgegapVersion(ctx context.Context, version string) error {
+	if version == "" {
+		return errors.New("version cannot be empty")
+	}
+	if !efm.edgegapManager.configuration.DynamicVersioning {
+		return ErrorDynamicVersioningDisabled
+	}
+
+	if err := efm.edgegapManager.versionManager.validateVersion(version); err != nil {
+		return err
+	}
+
+	return efm.edgegapManager.versionManager.StoreVersion(ctx, version)
+}
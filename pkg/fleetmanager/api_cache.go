@@ -0,0 +1,83 @@
+package fleetmanager
+
+import (
+	"sync"
+	"time"
+)
+
+// apiCache keeps a reply of the Edgegap API for a while, for catalogs every client session asks for but rarely change.
+// The reply is fetched again once expired or when its key changes, e.g. on a new app version.
+type apiCache[T any] struct {
+	mu        sync.Mutex
+	key       string
+	value     T
+	fetched   bool
+	fetchedAt time.Time
+}
+
+// get returns the cached reply for the key, fetching it when missing or older than ttl.
+func (c *apiCache[T]) get(key string, ttl time.Duration, fetch func() (T, error)) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fetched && c.key == key && time.Since(c.fetchedAt) < ttl {
+		return c.value, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, err
+	}
+
+	c.key, c.value, c.fetched, c.fetchedAt = key, value, true, time.Now()
+	return value, nil
+}
+
+// keyedApiCacheMaxEntries bounds a keyed cache, the expired replies are dropped once reached
+const keyedApiCacheMaxEntries = 1_000
+
+type apiCacheEntry[T any] struct {
+	value     T
+	fetchedAt time.Time
+}
+
+// keyedApiCache keeps a reply of the Edgegap API per key for a while, e.g. the status of each deployment.
+type keyedApiCache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]apiCacheEntry[T]
+}
+
+// get returns the cached reply for the key with the time it was fetched and whether it came from the cache, fetching
+// it when missing or older than ttl. The API is called without holding the lock, so a slow reply does not block the
+// other keys.
+func (c *keyedApiCache[T]) get(key string, ttl time.Duration, fetch func() (T, error)) (T, time.Time, bool, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < ttl {
+		return entry.value, entry.fetchedAt, true, nil
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return value, time.Time{}, false, err
+	}
+	entry = apiCacheEntry[T]{value: value, fetchedAt: time.Now()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]apiCacheEntry[T])
+	}
+	if len(c.entries) >= keyedApiCacheMaxEntries {
+		for k, e := range c.entries {
+			if time.Since(e.fetchedAt) >= ttl {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) < keyedApiCacheMaxEntries {
+		c.entries[key] = entry
+	}
+	return value, entry.fetchedAt, false, nil
+}
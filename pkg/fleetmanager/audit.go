@@ -0,0 +1,211 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// InstanceMetadataHeartbeatUrl is the instance metadata key a game server can set (e.g. with the READY event)
+// to expose its live connections to the audit worker
+const InstanceMetadataHeartbeatUrl = "heartbeat_url"
+
+// heartbeatPayload is the reply expected from a game server heartbeat url
+type heartbeatPayload struct {
+	Connections []string `json:"connections"`
+}
+
+// auditInstance recomputes the derived fields of an instance and reports whether it drifted from its stored state.
+// heartbeat holds the live connections reported by the game server, nil when it reported none.
+func (efm *EdgegapFleetManager) auditInstance(instance *runtime.InstanceInfo, heartbeat []string) (bool, error) {
+	ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return false, err
+	}
+
+	logger := efm.logger.WithField("instance_id", instance.Id)
+	drifted := false
+
+	// Game servers can report their live connections, which takes precedence over the last connection event
+	if heartbeat != nil && !slices.Equal(slices.Sorted(slices.Values(heartbeat)), slices.Sorted(slices.Values(ei.Connections))) {
+		logger.WithFields(map[string]any{"stored": len(ei.Connections), "heartbeat": len(heartbeat)}).Warn("audit: connections differ from heartbeat")
+		ei.Connections = slices.Clone(heartbeat)
+		drifted = true
+	}
+
+	// A connected user must not also hold a reservation
+	reservations := helpers.RemoveElements(ei.Reservations, ei.Connections)
+	if len(reservations) != len(ei.Reservations) {
+		logger.WithField("count", len(ei.Reservations)-len(reservations)).Warn("audit: reservations already connected")
+		ei.Reservations = reservations
+		drifted = true
+	}
+
+	if instance.PlayerCount != len(ei.Connections) {
+		logger.WithFields(map[string]any{"stored": instance.PlayerCount, "expected": len(ei.Connections)}).Warn("audit: player count drift")
+		drifted = true
+	}
+
+	if ei.ReservationsCount != len(ei.Reservations) {
+		logger.WithFields(map[string]any{"stored": ei.ReservationsCount, "expected": len(ei.Reservations)}).Warn("audit: reservations count drift")
+		drifted = true
+	}
+
+	instance.Metadata["edgegap"] = ei
+	expectedSeats, err := efm.storageManager.GetAvailableSeat(instance)
+	if err != nil {
+		return false, err
+	}
+	if ei.AvailableSeats != expectedSeats {
+		logger.WithFields(map[string]any{"stored": ei.AvailableSeats, "expected": expectedSeats}).Warn("audit: available seats drift")
+		drifted = true
+	}
+
+	return drifted, nil
+}
+
+// fetchHeartbeats queries the heartbeat url of the instances for their live connections, at most ReconcileWorkers at
+// once, keyed by instance id. Instances without a heartbeat url, or whose heartbeat failed, are left out.
+func (efm *EdgegapFleetManager) fetchHeartbeats(client *helpers.APIClient, instances []*runtime.InstanceInfo) map[string][]string {
+	results := make([][]string, len(instances))
+	forEachBounded(len(instances), efm.edgegapManager.configuration.ReconcileWorkers, func(i int) {
+		heartbeatUrl, ok := instances[i].Metadata[InstanceMetadataHeartbeatUrl].(string)
+		if !ok || heartbeatUrl == "" {
+			return
+		}
+		connections, err := fetchHeartbeatConnections(client, heartbeatUrl)
+		if err != nil {
+			efm.logger.WithFields(map[string]any{"instance_id": instances[i].Id, "error": err.Error()}).Warn("failed to query instance heartbeat")
+			return
+		}
+		results[i] = connections
+	})
+
+	heartbeats := make(map[string][]string)
+	for i, connections := range results {
+		if connections != nil {
+			heartbeats[instances[i].Id] = connections
+		}
+	}
+	return heartbeats
+}
+
+// fetchHeartbeatConnections queries a game server heartbeat url for its live connections.
+func fetchHeartbeatConnections(client *helpers.APIClient, heartbeatUrl string) ([]string, error) {
+	reply, err := client.Get(heartbeatUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected heartbeat status %s", reply.Status)
+	}
+
+	body, err := io.ReadAll(reply.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload heartbeatPayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Connections == nil {
+		payload.Connections = []string{}
+	}
+
+	return payload.Connections, nil
+}
+
+func (efm *EdgegapFleetManager) runAuditScheduler() {
+	// Heartbeat urls are absolute, the client only shares its connections across audits
+	heartbeatClient := helpers.NewAPIClient("", "")
+
+	auditFn := func() {
+		instances, err := efm.storageManager.listDbInstances(efm.ctx)
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to read instances from db for audit")
+			return
+		}
+
+		// Heartbeats are fetched once per audit, the drifted instances are audited again with the same connections
+		heartbeats := map[string][]string{}
+		if efm.edgegapManager.configuration.AuditHeartbeat {
+			heartbeats = efm.fetchHeartbeats(heartbeatClient, instances)
+		}
+
+		repairs := make([]*runtime.InstanceInfo, 0)
+		for _, instance := range instances {
+			drifted, err := efm.auditInstance(instance, heartbeats[instance.Id])
+			if err != nil {
+				efm.logger.WithField("error", err.Error()).Error("failed to audit instance %s", instance.Id)
+				continue
+			}
+			if drifted {
+				repairs = append(repairs, instance)
+			}
+		}
+
+		efm.nk.MetricsGaugeSet("edgegap_audit_drifted_instances", nil, float64(len(repairs)))
+		if len(repairs) == 0 {
+			return
+		}
+
+		// Drifted instances are audited again on a fresh read, repaired conditional on its version
+		ids := make([]string, 0, len(repairs))
+		for _, instance := range repairs {
+			ids = append(ids, instance.Id)
+		}
+		stored, versions, err := efm.storageManager.readDbInstancesForUpdate(efm.ctx, ids...)
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to read drifted instances for repair")
+			return
+		}
+		repairs = repairs[:0]
+		for _, id := range ids {
+			instance, ok := stored[id]
+			if !ok {
+				continue
+			}
+			if drifted, err := efm.auditInstance(instance, heartbeats[id]); err == nil && drifted {
+				repairs = append(repairs, instance)
+			}
+		}
+
+		efm.logger.Info("Audit repairing %d of %d instances", len(repairs), len(instances))
+		if _, err = efm.storageManager.updateDbInstances(efm.ctx, repairs, versions); err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to repair audited instances")
+		}
+	}
+
+	duration, err := time.ParseDuration(efm.edgegapManager.configuration.AuditInterval)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to parse audit interval, disabling audit")
+		return
+	}
+
+	if duration <= 0 {
+		efm.logger.WithField("duration", duration).Info("Skipping audit scheduler: audit_interval set to 0")
+		return
+	}
+
+	t := time.NewTicker(duration)
+	defer t.Stop()
+
+	efm.logger.Info("Starting audit scheduler every %s", duration.String())
+	for {
+		select {
+		case <-efm.ctx.Done():
+			return
+		case <-t.C:
+			auditFn()
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package fleetmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdAuditLogList lists the fleet mutations recorded in the audit log
+const RpcIdAuditLogList = "audit_log_list"
+
+// Audit log actions, every mutation of the fleet
+const (
+	AuditActionCreate        = "create"
+	AuditActionJoin          = "join"
+	AuditActionLeave         = "leave"
+	AuditActionDelete        = "delete"
+	AuditActionVersionChange = "version_change"
+	AuditActionAdmin         = "admin"
+)
+
+// Audit log actor types
+const (
+	AuditActorUser       = "user"
+	AuditActorS2S        = "s2s"
+	AuditActorGameServer = "game_server"
+	AuditActorSystem     = "system"
+)
+
+// runtimeExecutionModeRpc is the RUNTIME_CTX_MODE of RPC calls
+const runtimeExecutionModeRpc = "rpc"
+
+// auditedRpcs are the admin rpcs recorded in the audit log, with whether their payload is kept. Credentials are not.
+var auditedRpcs = map[string]bool{
+	RpcIdAdminInstanceDelete:          true,
+	RpcIdInstanceExtend:               true,
+	RpcIdAdminPersistentCreate:        true,
+	RpcIdAdminPersistentMigrate:       true,
+	RpcIdInstanceResendConnectionInfo: true,
+	RpcIdInstanceTransfer:             true,
+	RpcIdPurgeUserFleetData:           true,
+	RpcIdInstanceEventLog:             true,
+	RpcIdFleetTeardown:                true,
+	RpcIdDeadLetterReplay:             true,
+	RpcIdAdminReplayEvent:             true,
+	RpcIdUpdateEdgegapVersion:         true,
+	RpcIdVersionRollout:               true,
+	RpcIdUpdateNotificationTemplates:  true,
+	RpcIdSavedQuerySet:                true,
+	RpcIdSavedQueryDelete:             true,
+	RpcIdUpdateEdgegapCredentials:     false,
+}
+
+const (
+	auditLogQueueSize     = 1_024
+	auditLogBatchSize     = 100
+	auditLogFlushInterval = time.Second
+	auditLogMaxPayload    = 1_024
+	auditLogMaxScan       = 10_000
+)
+
+// EdgegapAuditEntry is a fleet mutation with its actor and the state of the instance before and after it
+type EdgegapAuditEntry struct {
+	Id         string         `json:"id"`
+	Action     string         `json:"action"`
+	ActorType  string         `json:"actor_type"`
+	Actor      string         `json:"actor,omitempty"`
+	InstanceId string         `json:"instance_id,omitempty"`
+	UserIds    []string       `json:"user_ids,omitempty"`
+	Before     map[string]any `json:"before,omitempty"`
+	After      map[string]any `json:"after,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	Timestamp  time.Time      `json:"timestamp"`
+}
+
+type auditLogListRequest struct {
+	InstanceId string    `json:"instance_id"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+	Limit      int       `json:"limit"`
+	Cursor     string    `json:"cursor"`
+}
+
+type auditLogListReply struct {
+	Entries []*EdgegapAuditEntry `json:"entries"`
+	Cursor  string               `json:"cursor"`
+}
+
+// matches reports whether the entry passes the filters of the request.
+func (r *auditLogListRequest) matches(entry *EdgegapAuditEntry) bool {
+	return (r.InstanceId == "" || entry.InstanceId == r.InstanceId) &&
+		(r.Actor == "" || entry.Actor == r.Actor) &&
+		(r.Action == "" || entry.Action == r.Action) &&
+		(r.Since.IsZero() || !entry.Timestamp.Before(r.Since)) &&
+		(r.Until.IsZero() || entry.Timestamp.Before(r.Until))
+}
+
+// auditLog appends the fleet mutations to the audit collection. Entries are queued and written in batches, so the
+// mutations never wait on the log, and never overwrite an existing entry.
+type auditLog struct {
+	sm    *StorageManager
+	queue chan *EdgegapAuditEntry
+}
+
+// EnableAuditLog records every fleet mutation in the audit collection until the context is done.
+func (sm *StorageManager) EnableAuditLog(ctx context.Context) {
+	sm.audit = &auditLog{
+		sm:    sm,
+		queue: make(chan *EdgegapAuditEntry, auditLogQueueSize),
+	}
+	go sm.audit.run(ctx)
+	sm.logger.Info("Recording fleet mutations in the %s collection", sm.auditCollection)
+}
+
+// auditActor identifies who made the request: the user of client calls, S2S for calls with the http key, the system
+// for the workers and server code.
+func auditActor(ctx context.Context) (string, string) {
+	if userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); ok && userId != "" {
+		return AuditActorUser, userId
+	}
+	if mode, _ := ctx.Value(runtime.RUNTIME_CTX_MODE).(string); mode == runtimeExecutionModeRpc {
+		clientIp, _ := ctx.Value(runtime.RUNTIME_CTX_CLIENT_IP).(string)
+		return AuditActorS2S, clientIp
+	}
+	return AuditActorSystem, ""
+}
+
+// auditSummary summarizes the state of the instance for the audit log, nil without an instance.
+func auditSummary(instance *runtime.InstanceInfo) map[string]any {
+	if instance == nil {
+		return nil
+	}
+	summary := map[string]any{
+		"status":       instance.Status,
+		"player_count": instance.PlayerCount,
+	}
+	if ei, err := extractEdgegapInstance(instance); err == nil {
+		summary["reservations"] = len(ei.Reservations)
+		summary["connections"] = len(ei.Connections)
+		summary["max_players"] = ei.MaxPlayers
+	}
+	return summary
+}
+
+// chronologicalKey returns a storage key sorting by time, the random suffix keeps keys of the same nanosecond apart.
+func chronologicalKey(t time.Time) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%020d-%s", t.UnixNano(), hex.EncodeToString(suffix))
+}
+
+// recordAudit queues the entry in the audit log, stamped with the actor of the context unless already set. It never
+// blocks, entries are dropped and logged when the queue is full.
+func (sm *StorageManager) recordAudit(ctx context.Context, entry *EdgegapAuditEntry) {
+	if sm.audit == nil {
+		return
+	}
+
+	if entry.ActorType == "" {
+		entry.ActorType, entry.Actor = auditActor(ctx)
+	}
+	entry.Timestamp = time.Now().UTC()
+
+	entry.Id = chronologicalKey(entry.Timestamp)
+
+	select {
+	case sm.audit.queue <- entry:
+	default:
+		sm.logger.WithFields(map[string]any{"action": entry.Action, "instance_id": entry.InstanceId}).Error("audit log queue full, dropping entry")
+	}
+}
+
+func (al *auditLog) run(ctx context.Context) {
+	t := time.NewTicker(auditLogFlushInterval)
+	defer t.Stop()
+
+	batch := make([]*EdgegapAuditEntry, 0, auditLogBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		al.write(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Entries still queued are written before stopping
+			for {
+				select {
+				case entry := <-al.queue:
+					batch = append(batch, entry)
+				default:
+					flush()
+					return
+				}
+			}
+		case entry := <-al.queue:
+			batch = append(batch, entry)
+			if len(batch) >= auditLogBatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+// write appends the entries, the "*" version only creates them so existing entries are never overwritten.
+func (al *auditLog) write(entries []*EdgegapAuditEntry) {
+	writes := make([]*runtime.StorageWrite, 0, len(entries))
+	for _, entry := range entries {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			al.sm.logger.WithField("error", err.Error()).Error("failed to marshal audit entry")
+			continue
+		}
+		writes = append(writes, &runtime.StorageWrite{
+			Collection:      al.sm.auditCollection,
+			Key:             entry.Id,
+			Value:           string(value),
+			Version:         "*",
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		})
+	}
+
+	// A fresh context, entries are still written while the node shuts down
+	if _, err := al.sm.nk.StorageWrite(context.Background(), writes); err != nil {
+		al.sm.logger.WithFields(map[string]any{"error": err.Error(), "entries": len(writes)}).Error("failed to write audit log")
+	}
+}
+
+// listAuditLog scans the audit log in chronological order for the entries matching the request, up to its limit. The
+// returned cursor continues the scan, which stops early after auditLogMaxScan entries.
+func (sm *StorageManager) listAuditLog(ctx context.Context, req *auditLogListRequest) ([]*EdgegapAuditEntry, string, error) {
+	entries := make([]*EdgegapAuditEntry, 0)
+	cursor := req.Cursor
+	for scanned := 0; len(entries) < req.Limit && scanned < auditLogMaxScan; {
+		// Pages never hold more entries than still needed, so the cursor never skips a match
+		objects, nextCursor, err := sm.nk.StorageList(ctx, "", "", sm.auditCollection, req.Limit-len(entries), cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, obj := range objects {
+			var entry EdgegapAuditEntry
+			if err = json.Unmarshal([]byte(obj.Value), &entry); err != nil {
+				sm.logger.WithField("error", err.Error()).Warn("failed to parse audit entry %s", obj.Key)
+				continue
+			}
+			if req.matches(&entry) {
+				entries = append(entries, &entry)
+			}
+		}
+		scanned += len(objects)
+		cursor = nextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	return entries, cursor, nil
+}
+
+// withAuditLog records the calls of an admin rpc in the audit log, with its outcome and its payload if kept.
+func withAuditLog(rpcId string, keepPayload bool, fn rpcFunction) rpcFunction {
+	return func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+		reply, err := fn(ctx, logger, db, nk, payload)
+		if fmInstance == nil {
+			return reply, err
+		}
+
+		details := map[string]any{
+			"rpc":     rpcId,
+			"success": err == nil,
+		}
+		if keepPayload {
+			details["payload"] = payload[:min(len(payload), auditLogMaxPayload)]
+		}
+		if err != nil {
+			details["error"] = err.Error()
+		}
+		fmInstance.storageManager.recordAudit(ctx, &EdgegapAuditEntry{Action: AuditActionAdmin, Details: details})
+		return reply, err
+	}
+}
+
+// auditLogList admin rpc listing the fleet mutations of the audit log, filtered by instance, actor, action and time (S2S only)
+func auditLogList(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdAuditLogList); err != nil {
+		return "", err
+	}
+
+	req := &auditLogListRequest{}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), req); err != nil {
+			return "", ErrInvalidInput
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = 100
+	}
+	req.Limit = min(req.Limit, 1_000)
+
+	entries, cursor, err := fmInstance.storageManager.listAuditLog(ctx, req)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list audit log")
+		return "", ErrInternalError
+	}
+
+	reply, err := json.Marshal(auditLogListReply{Entries: entries, Cursor: cursor})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
@@ -0,0 +1,97 @@
+package fleetmanager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestFetchHeartbeats(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/live":
+			_, _ = w.Write([]byte(`{"connections": ["a", "b"]}`))
+		case "/empty":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	instance := func(id, heartbeatUrl string) *runtime.InstanceInfo {
+		metadata := map[string]any{}
+		if heartbeatUrl != "" {
+			metadata[InstanceMetadataHeartbeatUrl] = server.URL + heartbeatUrl
+		}
+		return &runtime.InstanceInfo{Id: id, Metadata: metadata}
+	}
+	instances := []*runtime.InstanceInfo{
+		instance("live", "/live"),
+		instance("empty", "/empty"),
+		instance("failing", "/failing"),
+		instance("none", ""),
+	}
+
+	efm := &EdgegapFleetManager{
+		logger:         fakeLogger{},
+		edgegapManager: &EdgegapManager{configuration: &EdgegapManagerConfiguration{ReconcileWorkers: 2}},
+	}
+	heartbeats := efm.fetchHeartbeats(helpers.NewAPIClient("", ""), instances)
+
+	if calls.Load() != 3 {
+		t.Errorf("fetchHeartbeats() queried %d heartbeats, want 3", calls.Load())
+	}
+	if got, ok := heartbeats["live"]; !ok || !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("live heartbeat = %v, want [a b]", got)
+	}
+	if got, ok := heartbeats["empty"]; !ok || got == nil || len(got) != 0 {
+		t.Errorf("empty heartbeat = %v, %v, want no connections", got, ok)
+	}
+	for _, id := range []string{"failing", "none"} {
+		if _, ok := heartbeats[id]; ok {
+			t.Errorf("heartbeat of %s reported, want none", id)
+		}
+	}
+}
+
+func TestAuditInstanceHeartbeat(t *testing.T) {
+	tests := []struct {
+		name            string
+		heartbeat       []string
+		wantDrift       bool
+		wantConnections []string
+	}{
+		{name: "no heartbeat", wantConnections: []string{"a"}},
+		{name: "same connections", heartbeat: []string{"a"}, wantConnections: []string{"a"}},
+		{name: "connections differ", heartbeat: []string{"a", "b"}, wantDrift: true, wantConnections: []string{"a", "b"}},
+		{name: "no live connection", heartbeat: []string{}, wantDrift: true, wantConnections: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			efm := &EdgegapFleetManager{logger: fakeLogger{}, storageManager: NewStorageManager(nil, nil)}
+			ei := &EdgegapInstanceInfo{MaxPlayers: 4, Connections: []string{"a"}, Reservations: []string{}, AvailableSeats: 3}
+			instance := &runtime.InstanceInfo{Id: "id", PlayerCount: 1, Metadata: map[string]any{"edgegap": ei}}
+
+			drifted, err := efm.auditInstance(instance, tt.heartbeat)
+			if err != nil {
+				t.Fatalf("auditInstance() error = %v", err)
+			}
+			if drifted != tt.wantDrift {
+				t.Errorf("auditInstance() = %v, want %v", drifted, tt.wantDrift)
+			}
+			got := instance.Metadata["edgegap"].(*EdgegapInstanceInfo).Connections
+			if !slices.Equal(got, tt.wantConnections) {
+				t.Errorf("connections = %v, want %v", got, tt.wantConnections)
+			}
+		})
+	}
+}
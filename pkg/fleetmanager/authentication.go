@@ -9,6 +9,13 @@ import (
 	"github.com/heroiclabs/nakama-common/runtime"
 )
 
+// RpcIdReportIp is the client rpc storing the caller IP for placement
+const RpcIdReportIp = "report_ip"
+
+type reportIpReply struct {
+	Ok bool `json:"ok"`
+}
+
 // OnAuthenticateUpdateDevice When the User connect with Device, update and fetch his Client IP, so it can be used to deploy Edgegap's Server
 func OnAuthenticateUpdateDevice(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, out *api.Session, in *api.AuthenticateDeviceRequest) error {
 	return extractIPonAuth(ctx, logger, nk)
@@ -54,38 +61,62 @@ func OnAuthenticateUpdateGoogle(ctx context.Context, logger runtime.Logger, db *
 	return extractIPonAuth(ctx, logger, nk)
 }
 
+// OnSessionRefreshUpdateIp When the User refreshes his session, update his Client IP, so players who stay logged in
+// for long keep a current IP for placement
+func OnSessionRefreshUpdateIp(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, out *api.Session, in *api.SessionRefreshRequest) error {
+	return extractIPonAuth(ctx, logger, nk)
+}
+
 func extractIPonAuth(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule) error {
-	userIp := ctx.Value(runtime.RUNTIME_CTX_CLIENT_IP).(string)
-	accountId := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
-	logger.Info("Update User %s IP: %s", accountId, userIp)
+	// Failing to store the IP only degrades placement, never the authentication
+	_ = storeCallerIp(ctx, logger, nk)
+	return nil
+}
 
-	account, err := nk.AccountGetId(ctx, accountId)
-	if err != nil {
-		return err
+// storeCallerIp stores the client IP of the request as the IP of the calling user.
+func storeCallerIp(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule) error {
+	userIp, _ := ctx.Value(runtime.RUNTIME_CTX_CLIENT_IP).(string)
+	accountId, _ := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if userIp == "" || accountId == "" {
+		logger.Warn("No client IP or user to update")
+		return ErrInvalidInput
 	}
-	user := account.GetUser()
-	var metadata map[string]any
 
-	if err := json.Unmarshal([]byte(user.Metadata), &metadata); err != nil {
-		return err
+	// The IP is only logged when stored in clear
+	key := playerIpKey(ctx)
+	if key == "" {
+		logger.Info("Update User %s IP: %s", accountId, userIp)
+	} else {
+		logger.Info("Update User %s IP", accountId)
+	}
+	storedIp, err := sealPlayerIp(key, userIp)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to encrypt IP of User %s", accountId)
+		return ErrInternalError
 	}
-	metadata["PlayerIp"] = userIp
-
-	err = nk.AccountUpdateId(
-		ctx,
-		accountId,
-		"",
-		metadata,
-		"",
-		"",
-		"",
-		"",
-		"",
-	)
 
+	err = writePlayerIp(ctx, nk, playersCollection(ctx), accountId, storedIp)
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("Failed to update User %s", accountId)
+		return ErrInternalError
 	}
 
 	return nil
 }
+
+// reportIp client rpc storing the caller IP for placement, for clients asked with a report-ip notification or
+// changing network (e.g. mobile data to Wi-Fi) without authenticating again
+func reportIp(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if _, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); !ok {
+		return "", runtime.NewError("report_ip must be called by a user", 7) // PERMISSION_DENIED
+	}
+	if err := storeCallerIp(ctx, logger, nk); err != nil {
+		return "", err
+	}
+
+	reply, err := json.Marshal(reportIpReply{Ok: true})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
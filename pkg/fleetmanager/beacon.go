@@ -0,0 +1,350 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdBeaconList            = "beacon_list"
+	RpcIdBeaconLatenciesSubmit = "beacon_latencies_submit"
+
+	// StorageKeyBeaconLatencies is the key of the measured beacon latencies of each user in the players collection
+	StorageKeyBeaconLatencies = "beacon_latencies"
+
+	// beaconListTtl is how long the beacon list of Edgegap is reused, beacons rarely change
+	beaconListTtl = 5 * time.Minute
+
+	// beaconStaleHalfLives is the age, in half-lives, after which a measurement no longer counts
+	beaconStaleHalfLives = 4
+
+	maxBeaconLatencies = 50
+	maxBeaconRttMs     = 10_000
+)
+
+// EdgegapBeacon is a ping target of Edgegap, measured by game clients to place deployments on latency
+type EdgegapBeacon struct {
+	Fqdn     string          `json:"fqdn"`
+	PublicIp string          `json:"public_ip"`
+	TcpPort  int             `json:"tcp_port"`
+	UdpPort  int             `json:"udp_port"`
+	Location EdgegapLocation `json:"location"`
+}
+
+type edgegapBeaconList struct {
+	Beacons []EdgegapBeacon `json:"beacons"`
+	Count   int             `json:"count"`
+}
+
+type beaconListReply struct {
+	Beacons []EdgegapBeacon `json:"beacons"`
+}
+
+type beaconLatencySample struct {
+	Beacon string  `json:"beacon"`
+	RttMs  float64 `json:"rtt_ms"`
+}
+
+type beaconLatenciesSubmitRequest struct {
+	Latencies []beaconLatencySample `json:"latencies"`
+}
+
+type beaconLatenciesSubmitReply struct {
+	Accepted int    `json:"accepted"`
+	Closest  string `json:"closest,omitempty"`
+}
+
+// EdgegapBeaconLatency is the smoothed round trip time of a user to a beacon, located to place deployments
+type EdgegapBeaconLatency struct {
+	RttMs      float64   `json:"rtt_ms"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	MeasuredAt time.Time `json:"measured_at"`
+}
+
+// EdgegapBeaconLatencies are the latencies of a user by beacon FQDN
+type EdgegapBeaconLatencies struct {
+	Beacons map[string]*EdgegapBeaconLatency `json:"beacons"`
+}
+
+// ListBeacons retrieves the beacons of Edgegap, cached for a few minutes since every client session asks for them.
+func (em *EdgegapManager) ListBeacons() ([]EdgegapBeacon, error) {
+	return em.beacons.get("", beaconListTtl, em.fetchBeacons)
+}
+
+func (em *EdgegapManager) fetchBeacons() ([]EdgegapBeacon, error) {
+	reply, err := em.apiHelper.Get("/v1/locations/beacons")
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not list beacons: status %d", reply.StatusCode)
+	}
+
+	body, err := io.ReadAll(reply.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var list edgegapBeaconList
+	if err = json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal beacon list response: %w", err)
+	}
+	if list.Beacons == nil {
+		list.Beacons = []EdgegapBeacon{}
+	}
+	return list.Beacons, nil
+}
+
+// decayWeight is the weight left to a measurement of the given age, halved every half-life.
+func decayWeight(age, halfLife time.Duration) float64 {
+	return math.Pow(0.5, age.Seconds()/halfLife.Seconds())
+}
+
+// record merges a new sample into the smoothed latency, weighing the previous value down with its age. Stale
+// measurements are replaced by the sample.
+func (l *EdgegapBeaconLatency) record(rttMs float64, now time.Time, halfLife time.Duration) {
+	age := now.Sub(l.MeasuredAt)
+	if l.MeasuredAt.IsZero() || age > beaconStaleHalfLives*halfLife {
+		l.RttMs = rttMs
+	} else {
+		weight := decayWeight(age, halfLife)
+		l.RttMs = (l.RttMs*weight + rttMs) / (weight + 1)
+	}
+	l.MeasuredAt = now
+}
+
+// closest returns the beacon with the lowest latency among the measurements not yet stale.
+func (ls *EdgegapBeaconLatencies) closest(now time.Time, halfLife time.Duration) (string, *EdgegapBeaconLatency) {
+	var bestFqdn string
+	var best *EdgegapBeaconLatency
+	for fqdn, latency := range ls.Beacons {
+		if now.Sub(latency.MeasuredAt) > beaconStaleHalfLives*halfLife {
+			continue
+		}
+		if best == nil || latency.RttMs < best.RttMs {
+			bestFqdn, best = fqdn, latency
+		}
+	}
+	return bestFqdn, best
+}
+
+// getBeaconLatencies reads the measured latencies of the users, users without measurements are omitted.
+func (sm *StorageManager) getBeaconLatencies(ctx context.Context, userIds []string) (map[string]*EdgegapBeaconLatencies, error) {
+	latencies := make(map[string]*EdgegapBeaconLatencies, len(userIds))
+	if len(userIds) == 0 {
+		return latencies, nil
+	}
+
+	reads := make([]*runtime.StorageRead, 0, len(userIds))
+	for _, userId := range userIds {
+		reads = append(reads, &runtime.StorageRead{
+			Collection: sm.playersCollection,
+			Key:        StorageKeyBeaconLatencies,
+			UserID:     userId,
+		})
+	}
+	objects, err := sm.nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range objects {
+		var ls EdgegapBeaconLatencies
+		if err = json.Unmarshal([]byte(obj.Value), &ls); err != nil {
+			sm.logger.WithField("error", err.Error()).Warn("failed to parse beacon latencies of user %s", obj.UserId)
+			continue
+		}
+		latencies[obj.UserId] = &ls
+	}
+	return latencies, nil
+}
+
+// recordBeaconLatencies merges the samples into the latencies of the user, dropping the stale measurements. The write
+// is conditional on the version read, and retried when a concurrent submission of the user replaced it meanwhile.
+func (sm *StorageManager) recordBeaconLatencies(ctx context.Context, userId string, samples []beaconLatencySample, beacons map[string]EdgegapBeacon, halfLife time.Duration) (*EdgegapBeaconLatencies, error) {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		objects, readErr := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+			Collection: sm.playersCollection,
+			Key:        StorageKeyBeaconLatencies,
+			UserID:     userId,
+		}})
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		version := "*"
+		ls := &EdgegapBeaconLatencies{}
+		if len(objects) > 0 {
+			version = objects[0].Version
+			if err = json.Unmarshal([]byte(objects[0].Value), ls); err != nil {
+				sm.logger.WithField("error", err.Error()).Warn("failed to parse beacon latencies of user %s, resetting them", userId)
+				ls = &EdgegapBeaconLatencies{}
+			}
+		}
+		if ls.Beacons == nil {
+			ls.Beacons = make(map[string]*EdgegapBeaconLatency)
+		}
+
+		now := time.Now().UTC()
+		for fqdn, latency := range ls.Beacons {
+			if _, ok := beacons[fqdn]; !ok || now.Sub(latency.MeasuredAt) > beaconStaleHalfLives*halfLife {
+				delete(ls.Beacons, fqdn)
+			}
+		}
+		for _, sample := range samples {
+			beacon := beacons[sample.Beacon]
+			latency, ok := ls.Beacons[sample.Beacon]
+			if !ok {
+				latency = &EdgegapBeaconLatency{}
+				ls.Beacons[sample.Beacon] = latency
+			}
+			latency.Latitude, latency.Longitude = beacon.Location.Latitude, beacon.Location.Longitude
+			latency.record(sample.RttMs, now, halfLife)
+		}
+
+		value, marshalErr := json.Marshal(ls)
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
+		if _, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+			Collection:      sm.playersCollection,
+			Key:             StorageKeyBeaconLatencies,
+			UserID:          userId,
+			Value:           string(value),
+			Version:         version,
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		}}); err == nil {
+			return ls, nil
+		}
+	}
+	return nil, err
+}
+
+// beaconHalfLife returns the half-life of the measured latencies, 0 when latency-based placement is disabled.
+func (em *EdgegapManager) beaconHalfLife() time.Duration {
+	halfLife, err := time.ParseDuration(em.configuration.BeaconHalfLife)
+	if err != nil || halfLife < 0 {
+		return 0
+	}
+	return halfLife
+}
+
+// beaconLocations places the users with recent beacon measurements on their closest beacon, and returns the users
+// left to be placed on their IP.
+func (efm *EdgegapFleetManager) beaconLocations(ctx context.Context, userIds []string) ([]EdgegapGeoCoordinates, []string) {
+	halfLife := efm.edgegapManager.beaconHalfLife()
+	if halfLife == 0 || len(userIds) == 0 {
+		return nil, userIds
+	}
+
+	latencies, err := efm.storageManager.getBeaconLatencies(ctx, userIds)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Warn("failed to read beacon latencies, placing on IPs")
+		return nil, userIds
+	}
+
+	now := time.Now()
+	locations := make([]EdgegapGeoCoordinates, 0, len(latencies))
+	unmeasured := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		ls, ok := latencies[userId]
+		if !ok {
+			unmeasured = append(unmeasured, userId)
+			continue
+		}
+		if _, closest := ls.closest(now, halfLife); closest != nil {
+			locations = append(locations, EdgegapGeoCoordinates{Latitude: closest.Latitude, Longitude: closest.Longitude})
+		} else {
+			unmeasured = append(unmeasured, userId)
+		}
+	}
+
+	if len(locations) > 0 {
+		efm.nk.MetricsCounterAdd("edgegap_beacon_placement", nil, int64(len(locations)))
+	}
+	return locations, unmeasured
+}
+
+// listBeacons client rpc proxying the beacons of Edgegap, for clients to measure their latency to each
+func listBeacons(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	beacons, err := fmInstance.edgegapManager.ListBeacons()
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list Edgegap beacons")
+		return "", ErrInternalError
+	}
+
+	reply, err := json.Marshal(beaconListReply{Beacons: beacons})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
+
+// submitBeaconLatencies client rpc storing the round trip times measured by the user to the beacons
+func submitBeaconLatencies(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok {
+		return "", ErrInvalidInput
+	}
+
+	halfLife := fmInstance.edgegapManager.beaconHalfLife()
+	if halfLife == 0 {
+		return "", runtime.NewError("latency-based placement is disabled", 9) // FAILED_PRECONDITION
+	}
+
+	var req beaconLatenciesSubmitRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || len(req.Latencies) == 0 || len(req.Latencies) > maxBeaconLatencies {
+		return "", ErrInvalidInput
+	}
+
+	beacons, err := fmInstance.edgegapManager.ListBeacons()
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list Edgegap beacons")
+		return "", ErrInternalError
+	}
+	known := make(map[string]EdgegapBeacon, len(beacons))
+	for _, beacon := range beacons {
+		known[beacon.Fqdn] = beacon
+	}
+
+	// Only known beacons are kept, their location comes from Edgegap and not from the client
+	samples := make([]beaconLatencySample, 0, len(req.Latencies))
+	for _, sample := range req.Latencies {
+		if _, ok := known[sample.Beacon]; !ok || sample.RttMs <= 0 || sample.RttMs > maxBeaconRttMs {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if len(samples) == 0 {
+		return "", ErrInvalidInput
+	}
+
+	ls, err := fmInstance.storageManager.recordBeaconLatencies(ctx, userId, samples, known, halfLife)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to store beacon latencies")
+		return "", ErrInternalError
+	}
+	closest, _ := ls.closest(time.Now(), halfLife)
+
+	reply, err := json.Marshal(beaconLatenciesSubmitReply{
+		Accepted: len(samples),
+		Closest:  closest,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
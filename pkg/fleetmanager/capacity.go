@@ -0,0 +1,75 @@
+package fleetmanager
+
+import (
+	"strings"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+// Capacities a deployment can be placed on, recorded on the instance
+const (
+	DeploymentCapacityDedicated = "dedicated"
+	DeploymentCapacityOnDemand  = "on_demand"
+)
+
+// EdgegapDeploymentFilter restricts the hosts a deployment can be placed on
+type EdgegapDeploymentFilter struct {
+	Field      string   `json:"field"`
+	Values     []string `json:"values"`
+	FilterType string   `json:"filter_type"`
+}
+
+// parseLocationTags parses the comma separated location tags of the reserved hosts.
+func parseLocationTags(value string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// dedicatedDeployment returns a copy of the deployment restricted to the hosts tagged as reserved capacity.
+func dedicatedDeployment(deployment *EdgegapDeploymentCreation, tags []string) *EdgegapDeploymentCreation {
+	dedicated := *deployment
+	dedicated.Filters = append(append([]EdgegapDeploymentFilter{}, deployment.Filters...), EdgegapDeploymentFilter{
+		Field:      "location_tags",
+		Values:     tags,
+		FilterType: "any",
+	})
+	return &dedicated
+}
+
+// postCapacityDeployment sends the deployment to the reserved hosts first when configured, falling back to on-demand
+// capacity when none can take it. Failover statuses are returned as is, the next account is tried instead.
+func (em *EdgegapManager) postCapacityDeployment(apiHelper *helpers.APIClient, deployment *EdgegapDeploymentCreation) (*EdgegapDeploymentResponse, int, error) {
+	tags := parseLocationTags(em.configuration.DedicatedLocationTags)
+	if len(tags) > 0 {
+		response, statusCode, err := em.postDeployment(apiHelper, dedicatedDeployment(deployment, tags))
+		if err == nil {
+			response.Capacity = DeploymentCapacityDedicated
+			em.reportCapacity(response.Capacity)
+			return response, statusCode, nil
+		}
+		if !em.configuration.DedicatedFallback || statusCode == 0 || isFailoverStatus(statusCode) {
+			return nil, statusCode, err
+		}
+		em.logger.WithField("error", err.Error()).Warn("No dedicated capacity available, falling back to on-demand")
+	}
+
+	response, statusCode, err := em.postDeployment(apiHelper, deployment)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	response.Capacity = DeploymentCapacityOnDemand
+	if len(tags) > 0 {
+		em.reportCapacity(response.Capacity)
+	}
+	return response, statusCode, nil
+}
+
+// reportCapacity counts the deployments placed on each capacity, to size the reservation.
+func (em *EdgegapManager) reportCapacity(capacity string) {
+	em.storageManager.nk.MetricsCounterAdd("edgegap_deployment_capacity", map[string]string{"capacity": capacity}, 1)
+}
@@ -0,0 +1,281 @@
+package fleetmanager
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Payload casings, for client JSON parsers mapping field names to their own fields (Unity JsonUtility, Unreal)
+const (
+	// PayloadCasingDefault keeps snake_case RPC payloads and PascalCase notification contents
+	PayloadCasingDefault = ""
+	PayloadCasingCamel   = "camel"
+	PayloadCasingSnake   = "snake"
+)
+
+type rpcFunction = func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error)
+
+// parsePayloadCasing validates the configured casing.
+func parsePayloadCasing(value string) (string, error) {
+	switch casing := strings.ToLower(strings.TrimSpace(value)); casing {
+	case PayloadCasingDefault, PayloadCasingCamel, PayloadCasingSnake:
+		return casing, nil
+	}
+	return "", errors.New("invalid payload casing, expects camel or snake: " + value)
+}
+
+// casingWords splits a snake_case, camelCase or PascalCase name in lower case words.
+func casingWords(name string) []string {
+	words := make([]string, 0)
+	var word []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		split := r == '_'
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			split = unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower)
+		}
+		if split && len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+		if r != '_' {
+			word = append(word, unicode.ToLower(r))
+		}
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// payloadKey returns the field name in the casing.
+func payloadKey(casing, name string) string {
+	words := casingWords(name)
+	switch casing {
+	case PayloadCasingSnake:
+		return strings.Join(words, "_")
+	case PayloadCasingCamel:
+		for i := 1; i < len(words); i++ {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+		return strings.Join(words, "")
+	}
+	return name
+}
+
+// jsonFields maps the json names of the struct fields to their types, embedded structs without a json name are flattened.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		embedded := field.Type
+		for embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		if field.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			for k, v := range jsonFields(embedded) {
+				fields[k] = v
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// recase renames the struct fields of a decoded JSON value of the type: to the casing when outbound, back to the json
+// names when inbound. Map keys, e.g. the game metadata, and untyped values are kept as is.
+func recase(value any, t reflect.Type, casing string, outbound bool) any {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t == timeType {
+		return value
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return value
+		}
+		fields := jsonFields(t)
+		names := make(map[string]string, len(fields))
+		for name := range fields {
+			names[payloadKey(casing, name)] = name
+		}
+
+		recased := make(map[string]any, len(object))
+		for key, v := range object {
+			// Inbound fields may also be sent with their json names
+			name, ok := names[key]
+			if outbound || !ok {
+				name = key
+				_, ok = fields[key]
+			}
+			if !ok {
+				recased[key] = v
+				continue
+			}
+
+			if outbound {
+				key = payloadKey(casing, name)
+			} else {
+				key = name
+			}
+			recased[key] = recase(v, fields[name], casing, outbound)
+		}
+		return recased
+	case reflect.Map:
+		if object, ok := value.(map[string]any); ok {
+			for key, v := range object {
+				object[key] = recase(v, t.Elem(), casing, outbound)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if items, ok := value.([]any); ok {
+			for i, v := range items {
+				items[i] = recase(v, t.Elem(), casing, outbound)
+			}
+		}
+	}
+	return value
+}
+
+// recasePayload renames the fields of the JSON payload of the type, payloads that are not JSON objects or arrays are
+// kept as is. Numbers are kept exactly as sent.
+func recasePayload(payload string, t reflect.Type, casing string, outbound bool) (string, error) {
+	trimmed := strings.TrimSpace(payload)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return payload, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(recase(value, t, casing, outbound)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// payloadType returns the type of an RPC payload, nil for untyped payloads.
+func payloadType(payload any) reflect.Type {
+	if payload == nil {
+		return nil
+	}
+	return reflect.TypeOf(payload)
+}
+
+// withPayloadCasing wraps an RPC to accept its request and send its reply in the casing. RPCs absent from the RPC
+// schema, the Edgegap webhooks and rpc_schema itself are never wrapped.
+func withPayloadCasing(casing, rpcId string, fn rpcFunction) rpcFunction {
+	if casing == PayloadCasingDefault {
+		return fn
+	}
+	switch rpcId {
+	case RpcIdEventDeploymentReady, RpcIdEventDeploymentError, RpcIdEventDeploymentTerminated, RpcIdRpcSchema:
+		return fn
+	}
+
+	var rpc *rpcPayload
+	for i := range rpcPayloads {
+		if rpcPayloads[i].id == rpcId {
+			rpc = &rpcPayloads[i]
+		}
+	}
+	if rpc == nil {
+		return fn
+	}
+
+	return func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+		request, err := recasePayload(payload, payloadType(rpc.request), casing, false)
+		if err != nil {
+			return "", ErrInvalidInput
+		}
+
+		reply, err := fn(ctx, logger, db, nk, request)
+		if err != nil {
+			return "", err
+		}
+
+		recased, err := recasePayload(reply, payloadType(rpc.response), casing, true)
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("failed to recase reply of rpc %s", rpcId)
+			return "", ErrInternalError
+		}
+		return recased, nil
+	}
+}
+
+// recaseNotificationContent renames the content fields of a notification in the casing. Ports are always sent as
+// integers, a template may have rendered them as text.
+func recaseNotificationContent(casing string, content map[string]interface{}) map[string]interface{} {
+	if casing == PayloadCasingDefault {
+		return content
+	}
+
+	recased := make(map[string]interface{}, len(content))
+	for key, v := range content {
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Struct, reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			v = recaseValue(v, casing)
+		case reflect.String:
+			if port, err := strconv.Atoi(reflect.ValueOf(v).String()); err == nil && strings.EqualFold(key, "port") {
+				v = port
+			}
+		}
+		recased[payloadKey(casing, key)] = v
+	}
+	return recased
+}
+
+// recaseValue renames the struct fields of a notification content value, keeping it as is on failure.
+func recaseValue(v any, casing string) any {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	recased, err := recasePayload(string(value), reflect.TypeOf(v), casing, true)
+	if err != nil {
+		return v
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(recased))
+	decoder.UseNumber()
+	var decoded any
+	if err = decoder.Decode(&decoded); err != nil {
+		return v
+	}
+	return decoded
+}
@@ -0,0 +1,88 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Faults injected by the chaos mode, to validate client handling of failures in staging
+const (
+	ChaosWebhookDelay        = "webhook_delay"
+	ChaosDropConnectionEvent = "drop_connection_event"
+	ChaosEdgegapError        = "edgegap_error"
+	ChaosVersionNotFound     = "version_not_found"
+)
+
+var chaosFaults = []string{ChaosWebhookDelay, ChaosDropConnectionEvent, ChaosEdgegapError, ChaosVersionNotFound}
+
+// chaosMonkey injects faults at the configured probabilities, a nil chaosMonkey never injects any.
+type chaosMonkey struct {
+	logger        runtime.Logger
+	probabilities map[string]float64
+	webhookDelay  time.Duration
+}
+
+// parseChaosFaults parses "fault=probability" entries, e.g. "edgegap_error=0.1,drop_connection_event=0.05".
+func parseChaosFaults(value string) (map[string]float64, error) {
+	probabilities := make(map[string]float64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fault, probability, ok := strings.Cut(entry, "=")
+		fault = strings.TrimSpace(fault)
+		if !ok || !slices.Contains(chaosFaults, fault) {
+			return nil, fmt.Errorf("invalid chaos fault %q, expects one of %s", entry, strings.Join(chaosFaults, ","))
+		}
+		p, err := strconv.ParseFloat(strings.TrimSpace(probability), 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid chaos fault %q, expects a probability between 0 and 1", entry)
+		}
+		probabilities[fault] = p
+	}
+
+	return probabilities, nil
+}
+
+// newChaosMonkey returns nil unless faults are configured.
+func newChaosMonkey(config *EdgegapManagerConfiguration, logger runtime.Logger) *chaosMonkey {
+	probabilities, err := parseChaosFaults(config.ChaosFaults)
+	if err != nil || len(probabilities) == 0 {
+		return nil
+	}
+	webhookDelay, _ := time.ParseDuration(config.ChaosWebhookDelay)
+
+	logger.Warn("Chaos mode enabled, failures are injected on purpose: %s (never use in production)", config.ChaosFaults)
+	return &chaosMonkey{
+		logger:        logger,
+		probabilities: probabilities,
+		webhookDelay:  webhookDelay,
+	}
+}
+
+// inject reports whether the fault should be injected this time.
+func (cm *chaosMonkey) inject(fault string) bool {
+	if cm == nil {
+		return false
+	}
+	if rand.Float64() >= cm.probabilities[fault] {
+		return false
+	}
+	cm.logger.Warn("Chaos mode injecting %s", fault)
+	return true
+}
+
+// delayWebhook sleeps before a deployment webhook is processed, as if Edgegap delivered it late.
+func (cm *chaosMonkey) delayWebhook() {
+	if cm.inject(ChaosWebhookDelay) {
+		time.Sleep(cm.webhookDelay)
+	}
+}
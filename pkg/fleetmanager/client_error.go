@@ -0,0 +1,80 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Reasons of the client errors, clients branch on them rather than on the messages
+const (
+	ClientErrorInstanceFull     = "instance_full"
+	ClientErrorQuotaReached     = "quota_reached"
+	ClientErrorMaintenance      = "maintenance"
+	ClientErrorCreateInProgress = "create_in_progress"
+	ClientErrorJoinLocked       = "join_locked"
+)
+
+// ErrorMaintenance is returned by instance_create to clients while NAKAMA_MAINTENANCE is set
+var ErrorMaintenance = errors.New("the fleet is under maintenance, instances cannot be created")
+
+// ClientError is the envelope of the recoverable errors of the client RPCs, its JSON is the message of the runtime error
+type ClientError struct {
+	// Code is the gRPC status code of the error
+	Code int `json:"code"`
+	// Reason is the machine-readable cause
+	Reason string `json:"reason"`
+	// Retryable tells whether the same call may succeed later, e.g. once a seat or the quota frees up
+	Retryable bool           `json:"retryable"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// newClientError returns the runtime error carrying the envelope, the message keeps the error readable in the details.
+func newClientError(code int, reason string, retryable bool, err error, details map[string]any) error {
+	if details == nil {
+		details = make(map[string]any, 1)
+	}
+	details["message"] = err.Error()
+
+	envelope, marshalErr := json.Marshal(ClientError{Code: code, Reason: reason, Retryable: retryable, Details: details})
+	if marshalErr != nil {
+		return runtime.NewError(err.Error(), code)
+	}
+	return runtime.NewError(string(envelope), code)
+}
+
+// clientCreateError maps the recoverable errors of Create to their envelope, nil for the other errors.
+func clientCreateError(err error) error {
+	var qerr *QuotaError
+	switch {
+	case errors.As(err, &qerr):
+		details := map[string]any{"mode": qerr.Mode, "active": qerr.Active, "quota": qerr.Quota}
+		if qerr.Tenant != "" {
+			details = map[string]any{"tenant": qerr.Tenant, "active": qerr.Active, "quota": qerr.Quota}
+		}
+		return newClientError(8, ClientErrorQuotaReached, true, err, details) // RESOURCE_EXHAUSTED
+	case errors.Is(err, ErrorMaintenance):
+		return newClientError(14, ClientErrorMaintenance, true, err, nil) // UNAVAILABLE
+	case errors.Is(err, ErrorCreateInProgress):
+		return newClientError(10, ClientErrorCreateInProgress, true, err, nil) // ABORTED
+	}
+	return nil
+}
+
+// clientJoinError maps the recoverable errors of Join to their envelope, nil for the other errors.
+func clientJoinError(err error, instanceId string) error {
+	var lerr *JoinLockError
+	switch {
+	case errors.Is(err, ErrorInstanceFull):
+		return newClientError(8, ClientErrorInstanceFull, true, err, map[string]any{"instance_id": instanceId}) // RESOURCE_EXHAUSTED
+	case errors.As(err, &lerr):
+		return newClientError(10, ClientErrorJoinLocked, true, err, map[string]any{ // ABORTED
+			"instance_id":    lerr.InstanceId,
+			"owner":          lerr.Owner,
+			"expires_at":     lerr.ExpiresAt,
+			"retry_after_ms": max(lerr.RetryAfter.Milliseconds(), 1),
+		})
+	}
+	return nil
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/heroiclabs/nakama-common/runtime"
 )
@@ -13,33 +16,79 @@ const (
 	RpcIdInstanceSessionGet    = "instance_get"
 	RpcIdInstanceSessionCreate = "instance_create"
 	RpcIdInstanceSessionJoin   = "instance_join"
+	RpcIdInstanceWaitlistJoin  = "instance_waitlist_join"
+	RpcIdInstanceSessionStart  = "instance_start"
 )
 
 const (
-	notificationConnectionInfo = 111
-	notificationCreateTimeout  = 112
-	notificationCreateFailed   = 113
+	notificationConnectionInfo   = 111
+	notificationCreateTimeout    = 112
+	notificationCreateFailed     = 113
+	notificationWaitlistPromoted = 114
+	notificationPendingExpired   = 115
+	notificationStatusChanged    = 116
+	notificationReportIp         = 117
+	notificationInstanceReplaced = 118
+	notificationSeatLost         = 119
 )
 
 type findInstanceSessionRequest struct {
-	Query  string `json:"query"`
-	Limit  int    `json:"limit"`
-	Cursor string `json:"cursor"`
+	Query   string           `json:"query"`
+	Limit   int              `json:"limit"`
+	Cursor  string           `json:"cursor"`
+	Region  string           `json:"region"`
+	Country string           `json:"country"`
+	MaxPing int              `json:"max_ping"`
+	Filters []InstanceFilter `json:"filters"`
+	// SavedQuery names a query stored by operators, the query is appended to it
+	SavedQuery string `json:"saved_query"`
+	// Fleet names the fleet manager to list, the Edgegap fleet if empty
+	Fleet string `json:"fleet"`
 }
 
 type joinInstanceSessionRequest struct {
 	InstanceID string   `json:"instance_id"`
+	JoinCode   string   `json:"join_code"`
 	UserIds    []string `json:"user_ids"`
+	Fleet      string   `json:"fleet"`
+}
+
+type startInstanceSessionRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+type instanceWaitlistJoinReply struct {
+	InstanceId string            `json:"instance_id"`
+	Waitlisted bool              `json:"waitlisted"`
+	JoinInfo   *runtime.JoinInfo `json:"join_info,omitempty"`
 }
 
 type getInstanceSessionRequest struct {
 	InstanceID string `json:"instance_id"`
+	Fleet      string `json:"fleet"`
 }
 
 type createInstanceSessionRequest struct {
-	UserIds    []string       `json:"user_ids"`
-	MaxPlayers int            `json:"max_players"`
-	Metadata   map[string]any `json:"metadata"`
+	UserIds       []string       `json:"user_ids"`
+	MaxPlayers    int            `json:"max_players"`
+	MinPlayers    int            `json:"min_players"`
+	Metadata      map[string]any `json:"metadata"`
+	DeferredStart bool           `json:"deferred_start"`
+	// SkipCallerIp and Locations let S2S callers place the deployment without their own IP
+	SkipCallerIp bool                    `json:"skip_caller_ip"`
+	Locations    []EdgegapGeoCoordinates `json:"locations"`
+	// IdempotencyKey replays the reply of a previous create with the same key instead of deploying again
+	IdempotencyKey string `json:"idempotency_key"`
+	// WaitReady blocks the reply until the instance is ready or WaitTimeoutSec elapsed (S2S only)
+	WaitReady      bool `json:"wait_ready"`
+	WaitTimeoutSec int  `json:"wait_timeout_sec"`
+	// Fleet names the fleet manager to create the instance on, the Edgegap fleet if empty
+	Fleet string `json:"fleet"`
+	// MaxDuration is the max duration of the deployment in minutes, after which Edgegap stops it. Clients cannot
+	// exceed EDGEGAP_DEPLOYMENT_MAX_DURATION.
+	MaxDuration int `json:"max_duration"`
+	// ContainerArgs override the container arguments by flag, within EDGEGAP_CONTAINER_ARGS (S2S only)
+	ContainerArgs map[string]string `json:"container_args"`
 }
 
 type instanceSessionListReply struct {
@@ -49,47 +98,184 @@ type instanceSessionListReply struct {
 
 type instanceCreateReply struct {
 	DeploymentId string `json:"deployment_id"`
+	InstanceId   string `json:"instance_id,omitempty"`
+	CallbackId   string `json:"callback_id,omitempty"`
+	JoinCode     string `json:"join_code,omitempty"`
 	Message      string `json:"message"`
 	Ok           bool   `json:"ok"`
+	Duplicate    bool   `json:"duplicate,omitempty"`
+	// Instance and Sessions are only set when waiting for the instance to be ready
+	Instance *runtime.InstanceInfo  `json:"instance,omitempty"`
+	Sessions []*runtime.SessionInfo `json:"sessions,omitempty"`
 }
 
-// createInstanceSession client rpc to create an instance
-func createInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
-	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
-	if !ok {
-		return "", ErrInvalidInput
+type instanceGetReply struct {
+	*runtime.InstanceInfo
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	ExpiresInSec int64      `json:"expires_in_sec,omitempty"`
+}
+
+// connectionInfoContent builds the connection-info notification content sent to a player of a ready instance
+func connectionInfoContent(instanceInfo *runtime.InstanceInfo, sessionId string) map[string]interface{} {
+	content := map[string]interface{}{
+		"IpAddress":  instanceInfo.ConnectionInfo.IpAddress,
+		"DnsName":    instanceInfo.ConnectionInfo.DnsName,
+		"Port":       instanceInfo.ConnectionInfo.Port,
+		"InstanceId": instanceInfo.Id,
+	}
+	if sessionId != "" {
+		content["SessionId"] = sessionId
+	}
+	if expiresAt := instanceExpiry(instanceInfo); !expiresAt.IsZero() {
+		content["ExpiresAt"] = expiresAt
+	}
+	ipv6 := ""
+	if ei, err := extractEdgegapInstance(instanceInfo); err == nil {
+		if len(ei.Endpoints) > 0 {
+			content["Endpoints"] = ei.Endpoints
+		}
+		ipv6 = ei.Ipv6
+	}
+	if ipv6 != "" {
+		content["Ipv6Address"] = ipv6
 	}
+	content["Addresses"] = connectionAddresses(connectionPreference(), instanceInfo.ConnectionInfo, ipv6)
+	if provisioned := provisionedContent(instanceInfo); provisioned != nil {
+		content["Provisioned"] = provisioned
+	}
+	return content
+}
+
+// serverOnlyCreateKeys are the create metadata keys clients cannot set, as Nakama calls out to or trusts their urls,
+// or they opt the instance in billed behaviors
+var serverOnlyCreateKeys = []string{
+	InstanceMetadataCallbackUrl,
+	InstanceMetadataHeartbeatUrl,
+	CreateMetadataReplaceOnCrashKey,
+	CreateMetadataReadyOnDeploymentKey,
+}
+
+// createInstanceSession client rpc to create an instance, S2S callers must provide the user ids or locations
+func createInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userId, isClient := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
 
 	var req *createInstanceSessionRequest
 	if err := json.Unmarshal([]byte(payload), &req); err != nil {
 		logger.WithField("error", err.Error()).Error("failed to unmarshal create Request")
 		return "", ErrInternalError
 	}
+	if req == nil {
+		return "", ErrInvalidInput
+	}
 
-	if len(req.UserIds) == 0 {
+	if len(req.UserIds) == 0 && isClient {
 		req.UserIds = []string{userId}
 	}
 
+	if req.SkipCallerIp || len(req.Locations) > 0 {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]any)
+		}
+		req.Metadata[CreateMetadataSkipCallerIpKey] = req.SkipCallerIp
+		if len(req.Locations) > 0 {
+			req.Metadata[CreateMetadataLocationsKey] = req.Locations
+		}
+	}
+
+	if req.DeferredStart || req.MinPlayers > 0 {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]any)
+		}
+		req.Metadata[CreateMetadataDeferredStartKey] = true
+		if req.MinPlayers > 0 {
+			req.Metadata[CreateMetadataMinPlayersKey] = req.MinPlayers
+		}
+	}
+
+	if req.MaxDuration != 0 {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]any)
+		}
+		req.Metadata[CreateMetadataMaxDurationKey] = req.MaxDuration
+	}
+
+	if len(req.ContainerArgs) > 0 {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]any)
+		}
+		req.Metadata[CreateMetadataContainerArgsKey] = req.ContainerArgs
+	}
+	// Container arguments reach the game server command line, clients cannot set them
+	if _, ok := req.Metadata[CreateMetadataContainerArgsKey]; ok && isClient {
+		return "", runtime.NewError("container_args can only be set by server callers", 7) // PERMISSION_DENIED
+	}
+	// The game server sets the urls itself with its instance events, the operator opts instances in
+	for _, key := range serverOnlyCreateKeys {
+		if _, ok := req.Metadata[key]; ok && isClient {
+			return "", runtime.NewError(key+" can only be set by server callers", 7) // PERMISSION_DENIED
+		}
+	}
+
+	if err := validateCreateRequest(fmInstance.edgegapManager.configuration, req, isClient); err != nil {
+		return "", runtime.NewError(err.Error(), 3) // INVALID_ARGUMENT
+	}
+	// Servers and admin tooling still create instances during maintenance
+	if isClient && fmInstance.edgegapManager.configuration.Maintenance {
+		return "", clientCreateError(ErrorMaintenance)
+	}
+
+	// Clients create instances of the tenant of their session only
+	if isClient && fmInstance.edgegapManager.tenantsEnabled() {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]any)
+		}
+		req.Metadata[TenantMetadataKey] = callerTenant(ctx)
+	}
+
+	fm, err := fleetFor(nk, req.Fleet)
+	if err != nil {
+		return "", err
+	}
+	if req.Fleet != "" {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]any)
+		}
+		req.Metadata[FleetMetadataKey] = req.Fleet
+	}
+	_, isEdgegap := fm.(*EdgegapFleetManager)
+
+	// Waiting for the instance to be ready is meant for tooling and integration tests
+	createCtx := ctx
+	if req.WaitReady {
+		if !isEdgegap {
+			return "", runtime.NewError("wait_ready is only supported by the Edgegap fleet", 3) // INVALID_ARGUMENT
+		}
+		if err := requireS2S(ctx, logger, RpcIdInstanceSessionCreate+" wait_ready"); err != nil {
+			return "", err
+		}
+		if isDeferredCreate(req.Metadata) {
+			return "", runtime.NewError("wait_ready cannot be used with deferred start", 3) // INVALID_ARGUMENT
+		}
+		createCtx = context.WithValue(ctx, syncCreateKey{}, true)
+	}
+
 	var callback runtime.FmCreateCallbackFn = func(status runtime.FmCreateStatus, instanceInfo *runtime.InstanceInfo, sessionInfo []*runtime.SessionInfo, metadata map[string]any, createErr error) {
 		switch status {
 		case runtime.CreateSuccess:
 			logger.Info("Edgegap instance created: %s", instanceInfo.Id)
 
-			content := map[string]interface{}{
-				"IpAddress":  instanceInfo.ConnectionInfo.IpAddress,
-				"DnsName":    instanceInfo.ConnectionInfo.DnsName,
-				"Port":       instanceInfo.ConnectionInfo.Port,
-				"InstanceId": instanceInfo.Id,
-			}
-			// Send connection details notifications to players
-			for _, userId := range req.UserIds {
-				subject := "connection-info"
-
-				code := notificationConnectionInfo
-				err := nk.NotificationSend(ctx, userId, subject, content, code, "", false)
-				if err != nil {
-					logger.WithField("error", err.Error()).Error("Failed to send notification")
+			// Send connection details notifications to players, with their own reservation token
+			err := sendNotifications(ctx, logger, nk, instanceInfo, "connection-info", notificationConnectionInfo, req.UserIds, func(userId string) map[string]interface{} {
+				sessionId := ""
+				for _, session := range sessionInfo {
+					if session.UserId == userId {
+						sessionId = session.SessionId
+					}
 				}
+				return connectionInfoContent(instanceInfo, sessionId)
+			})
+			if err != nil {
+				logger.WithField("error", err.Error()).Error("Failed to send notification")
 			}
 			return
 		case runtime.CreateTimeout:
@@ -98,35 +284,68 @@ func createInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.D
 			logger.WithField("error", createErr).Error("Failed to create Edgegap instance, timed out")
 
 			// Send notification to client that instance session creation timed out
-			for _, userId := range req.UserIds {
-				subject := "create-timeout"
-				content := map[string]interface{}{}
-				code := notificationCreateTimeout
-				err := nk.NotificationSend(ctx, userId, subject, content, code, "", false)
-				if err != nil {
-					logger.WithField("error", err.Error()).Error("Failed to send notification")
-				}
+			err := sendNotifications(ctx, logger, nk, instanceInfo, "create-timeout", notificationCreateTimeout, req.UserIds, failedCreateContent(instanceInfo, createFailureReason(status, createErr)))
+			if err != nil {
+				logger.WithField("error", err.Error()).Error("Failed to send notification")
 			}
 		default:
 			logger.WithField("error", createErr).Error("Failed to create Edgegap instance")
 
 			// Send notification to client that instance session couldn't be created
-			for _, userId := range req.UserIds {
-				subject := "create-failed"
-				content := map[string]interface{}{}
-				code := notificationCreateFailed
-				err := nk.NotificationSend(ctx, userId, subject, content, code, "", false)
-				if err != nil {
-					logger.WithField("error", err.Error()).Error("Failed to send notification")
-				}
+			err := sendNotifications(ctx, logger, nk, instanceInfo, "create-failed", notificationCreateFailed, req.UserIds, failedCreateContent(instanceInfo, createFailureReason(status, createErr)))
+			if err != nil {
+				logger.WithField("error", err.Error()).Error("Failed to send notification")
 			}
 			return
 		}
 	}
 
-	efm := nk.GetFleetManager()
-	metadata, err := efm.Create(ctx, req.MaxPlayers, req.UserIds, nil, req.Metadata, callback)
+	// Duplicate creates of a user within the guard window get the previous instance instead of a new deployment
+	var guard *createGuard
+	if window, _ := time.ParseDuration(fmInstance.edgegapManager.configuration.CreateGuardWindow); isClient && window > 0 {
+		previous, g, err := fmInstance.storageManager.claimCreate(ctx, userId, req.IdempotencyKey, window)
+		if err != nil {
+			if errors.Is(err, ErrorCreateInProgress) {
+				return "", clientCreateError(err)
+			}
+			logger.WithField("error", err.Error()).Error("failed to check duplicate create")
+			return "", ErrInternalError
+		}
+		if previous != nil {
+			logger.Info("Duplicate create of user %s, replying with instance %s", userId, previous.instanceId())
+			replyString, err := json.Marshal(instanceCreateReply{
+				DeploymentId: previous.DeploymentId,
+				InstanceId:   previous.InstanceId,
+				CallbackId:   previous.CallbackId,
+				JoinCode:     previous.JoinCode,
+				Message:      "Instance Already Created",
+				Ok:           true,
+				Duplicate:    true,
+			})
+			if err != nil {
+				return "", ErrInternalError
+			}
+			return string(replyString), nil
+		}
+		guard = g
+	}
+
+	metadata, err := fm.Create(createCtx, req.MaxPlayers, req.UserIds, nil, req.Metadata, callback)
+	guard.release(ctx, req.IdempotencyKey, metadata, err)
 	if err != nil {
+		if cerr := clientCreateError(err); cerr != nil {
+			return "", cerr
+		}
+		if errors.Is(err, ErrorEntitlementDenied) || errors.Is(err, ErrorPersistentAdminOnly) {
+			return "", runtime.NewError(err.Error(), 7) // PERMISSION_DENIED
+		}
+		var verr *ValidationError
+		if errors.As(err, &verr) || errors.Is(err, ErrorPlacementRequired) || errors.Is(err, ErrorInvalidLocations) || errors.Is(err, ErrorUnknownTenant) {
+			return "", runtime.NewError(err.Error(), 3) // INVALID_ARGUMENT
+		}
+		if errors.Is(err, ErrorInsufficientFunds) || errors.Is(err, ErrorRentalUnavailable) || errors.Is(err, ErrorDeploymentRejected) {
+			return "", runtime.NewError(err.Error(), 9) // FAILED_PRECONDITION
+		}
 		logger.WithField("error", err.Error()).Error("Failed to create Edgegap instance")
 		return "", ErrInternalError
 	}
@@ -134,10 +353,33 @@ func createInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.D
 	deploymentId := metadata[DeploymentIdKey]
 	reply := instanceCreateReply{
 		DeploymentId: deploymentId,
+		InstanceId:   metadata[InstanceIdKey],
+		CallbackId:   metadata[CallbackIdKey],
+		JoinCode:     metadata[JoinCodeKey],
 		Message:      "Instance Created",
 		Ok:           true,
 	}
 
+	if req.WaitReady {
+		outcome, err := fmInstance.waiters.wait(ctx, reply.CallbackId, createWaitTimeout(req.WaitTimeoutSec))
+		if err != nil {
+			if errors.Is(err, ErrorCreateWaitTimeout) {
+				return "", runtime.NewError(err.Error()+": "+reply.InstanceId, 4) // DEADLINE_EXCEEDED
+			}
+			return "", ErrInternalError
+		}
+		if outcome.status != runtime.CreateSuccess {
+			message := "instance creation failed"
+			if outcome.err != nil {
+				message += ": " + outcome.err.Error()
+			}
+			return "", runtime.NewError(message, 9) // FAILED_PRECONDITION
+		}
+		reply.Message = "Instance Ready"
+		reply.Instance = outcome.instance
+		reply.Sessions = outcome.sessions
+	}
+
 	replyString, err := json.Marshal(reply)
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("failed to marshal instance create reply")
@@ -155,13 +397,25 @@ func getInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.DB,
 		return "", ErrInternalError
 	}
 
-	efm := nk.GetFleetManager()
-	instance, err := efm.Get(ctx, req.InstanceID)
+	fm, err := fleetFor(nk, req.Fleet)
+	if err != nil {
+		return "", err
+	}
+	if err = checkTenantAccess(ctx, fm, req.InstanceID); err != nil {
+		return "", err
+	}
+	instance, err := fm.Get(ctx, req.InstanceID)
 	if err != nil {
 		return "", err
 	}
 
-	replyString, err := json.Marshal(instance)
+	reply := instanceGetReply{InstanceInfo: instance}
+	if expiresAt := instanceExpiry(instance); !expiresAt.IsZero() {
+		reply.ExpiresAt = &expiresAt
+		reply.ExpiresInSec = max(int64(time.Until(expiresAt).Seconds()), 0)
+	}
+
+	replyString, err := json.Marshal(reply)
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("failed to marshal instance instance")
 		return "", ErrInternalError
@@ -187,11 +441,29 @@ func joinInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.DB,
 		req.UserIds = []string{userId}
 	}
 
-	efm := nk.GetFleetManager()
-	joinInfo, err := efm.Join(ctx, req.InstanceID, req.UserIds, nil)
+	fm, err := fleetFor(nk, req.Fleet)
 	if err != nil {
 		return "", err
 	}
+	if _, isEdgegap := fm.(*EdgegapFleetManager); isEdgegap {
+		if err := resolveJoinCode(ctx, req); err != nil {
+			return "", err
+		}
+	}
+	if err = checkTenantAccess(ctx, fm, req.InstanceID); err != nil {
+		return "", err
+	}
+
+	joinInfo, err := fm.Join(ctx, req.InstanceID, req.UserIds, nil)
+	if err != nil {
+		if errors.Is(err, ErrorEntitlementDenied) {
+			return "", runtime.NewError(err.Error(), 7) // PERMISSION_DENIED
+		}
+		if cerr := clientJoinError(err, req.InstanceID); cerr != nil {
+			return "", cerr
+		}
+		return "", err
+	}
 
 	replyString, err := json.Marshal(joinInfo)
 	if err != nil {
@@ -202,6 +474,189 @@ func joinInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.DB,
 	return string(replyString), nil
 }
 
+// joinInstanceWaitlist client rpc to join a instance, or its waitlist when full
+func joinInstanceWaitlist(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok {
+		return "", ErrInvalidInput
+	}
+
+	var req *joinInstanceSessionRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to unmarshal waitlist join Request")
+		return "", ErrInternalError
+	}
+
+	if len(req.UserIds) == 0 {
+		req.UserIds = []string{userId}
+	}
+
+	fm, err := fleetFor(nk, req.Fleet)
+	if err != nil {
+		return "", err
+	}
+	if _, isEdgegap := fm.(*EdgegapFleetManager); isEdgegap {
+		if err := resolveJoinCode(ctx, req); err != nil {
+			return "", err
+		}
+	}
+	if err = checkTenantAccess(ctx, fm, req.InstanceID); err != nil {
+		return "", err
+	}
+
+	reply := instanceWaitlistJoinReply{
+		InstanceId: req.InstanceID,
+	}
+
+	joinInfo, err := fm.Join(ctx, req.InstanceID, req.UserIds, map[string]string{JoinMetadataWaitlistKey: "true"})
+	switch {
+	case errors.Is(err, ErrorInstanceFullWaitlisted):
+		reply.Waitlisted = true
+	case err != nil:
+		if cerr := clientJoinError(err, req.InstanceID); cerr != nil {
+			return "", cerr
+		}
+		return "", err
+	default:
+		reply.JoinInfo = joinInfo
+	}
+
+	replyString, err := json.Marshal(reply)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to marshal instance waitlist join reply")
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
+
+// resolveJoinCode sets the instance ID of a join request from its join code, when only the code is provided
+func resolveJoinCode(ctx context.Context, req *joinInstanceSessionRequest) error {
+	if req.InstanceID != "" || req.JoinCode == "" {
+		return nil
+	}
+
+	instance, err := fmInstance.storageManager.getDbInstanceByJoinCode(ctx, strings.ToUpper(req.JoinCode))
+	if err != nil {
+		return ErrInternalError
+	}
+	if instance == nil {
+		return runtime.NewError("no instance found with join code", 5) // NOT_FOUND
+	}
+
+	req.InstanceID = instance.Id
+	return nil
+}
+
+// startInstanceSession client rpc to deploy a instance created with deferred start, only its owner can start it
+func startInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	var req *startInstanceSessionRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to unmarshal start Request")
+		return "", ErrInternalError
+	}
+
+	instance, err := fmInstance.storageManager.getDbInstance(ctx, req.InstanceID)
+	if err != nil || instance == nil {
+		return "", runtime.NewError("instance not found", 5) // NOT_FOUND
+	}
+
+	ei, err := fmInstance.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	if userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); ok && ei.OwnerId != "" && userId != ei.OwnerId {
+		return "", runtime.NewError("only the instance owner can start it", 7) // PERMISSION_DENIED
+	}
+
+	deploymentId, err := fmInstance.StartDeferred(ctx, req.InstanceID)
+	if err != nil {
+		if errors.Is(err, ErrorInstanceNotPending) || errors.Is(err, ErrorDeploymentRejected) {
+			return "", runtime.NewError(err.Error(), 9) // FAILED_PRECONDITION
+		}
+		logger.WithField("error", err.Error()).Error("Failed to start Edgegap instance")
+		return "", ErrInternalError
+	}
+
+	reply := instanceCreateReply{
+		DeploymentId: deploymentId,
+		InstanceId:   deploymentId,
+		Message:      "Instance Started",
+		Ok:           true,
+	}
+
+	replyString, err := json.Marshal(reply)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to marshal instance start reply")
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
+
+// notifyWaitlistPromoted sends a notification to waitlisted users that now hold a reservation on the instance
+func notifyWaitlistPromoted(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, instanceId string, userIds []string) {
+	err := sendNotifications(ctx, logger, nk, nil, "waitlist-promoted", notificationWaitlistPromoted, userIds, instanceIdContent(instanceId))
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to send notification")
+	}
+}
+
+// notifySeatLost sends a notification to the players whose reservation was evicted from an overshot instance
+func notifySeatLost(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, instanceId string, userIds []string) {
+	err := sendNotifications(ctx, logger, nk, nil, "seat-lost", notificationSeatLost, userIds, instanceIdContent(instanceId))
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to send notification")
+	}
+}
+
+// notifyPendingExpired sends a notification to the players of a pending instance cancelled before it started
+func notifyPendingExpired(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, instanceId string, userIds []string) {
+	err := sendNotifications(ctx, logger, nk, nil, "pending-expired", notificationPendingExpired, userIds, instanceIdContent(instanceId))
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to send notification")
+	}
+}
+
+// notifyReportIp asks the users without a stored IP to call report_ip, so their next deployments are placed near them
+func notifyReportIp(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, userIds []string) {
+	err := sendNotifications(ctx, logger, nk, nil, "report-ip", notificationReportIp, userIds, func(string) map[string]interface{} {
+		return map[string]interface{}{
+			"Rpc": RpcIdReportIp,
+		}
+	})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to send notification")
+	}
+}
+
+// failedCreateContent holds the reason of the failure, localized as Message by sendNotifications, and refers to the
+// failed instance when known, for clients to correlate it with their create reply
+func failedCreateContent(instanceInfo *runtime.InstanceInfo, reason string) func(string) map[string]interface{} {
+	return func(string) map[string]interface{} {
+		content := map[string]interface{}{
+			"Reason": reason,
+		}
+		if instanceInfo != nil {
+			content["InstanceId"] = instanceInfo.Id
+		}
+		return content
+	}
+}
+
+// instanceIdContent is the content of notifications only referring to the instance
+func instanceIdContent(instanceId string) func(string) map[string]interface{} {
+	return func(string) map[string]interface{} {
+		return map[string]interface{}{
+			"InstanceId": instanceId,
+		}
+	}
+}
+
+// maxPingListPages bounds the pages listed by an instance_list call with max_ping to fill its limit
+const maxPingListPages = 5
+
 // listInstanceSession client rpc to list instances with query
 // Example to list all ready instances with at least 1 available seat
 // query="+value.metadata.edgegap.available_seats:>=1 +value.status:READY"
@@ -219,13 +674,64 @@ func listInstanceSession(ctx context.Context, logger runtime.Logger, db *sql.DB,
 		}
 	}
 
-	efm := nk.GetFleetManager()
-	instances, cursor, err := efm.List(ctx, req.Query, req.Limit, req.Cursor)
+	fm, err := fleetFor(nk, req.Fleet)
+	if err != nil {
+		return "", err
+	}
+	_, isEdgegap := fm.(*EdgegapFleetManager)
+	query, err := fmInstance.edgegapManager.savedQueries.resolve(ctx, req.SavedQuery, req.Query)
+	if err != nil {
+		return "", runtime.NewError(err.Error()+": "+req.SavedQuery, 5) // NOT_FOUND
+	}
+	query, err = compileFilters(locationQuery(query, req.Region, req.Country), req.Filters)
+	if err != nil {
+		return "", runtime.NewError(err.Error(), 3) // INVALID_ARGUMENT
+	}
+	// Clients only list the instances of the tenant of their session
+	if _, isClient := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); isClient && isEdgegap && fmInstance.edgegapManager.tenantsEnabled() {
+		query = tenantQuery(callerTenant(ctx), query)
+	}
+	// Users listing without max ping get the one of their placement preferences
+	if userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); ok && req.MaxPing == 0 && isEdgegap {
+		req.MaxPing = fmInstance.storageManager.preferredMaxPing(ctx, userId)
+	}
+
+	// Estimate ping from the caller's GeoIP location
+	var from *EdgegapLocation
+	if req.MaxPing > 0 && isEdgegap {
+		clientIp, ok := ctx.Value(runtime.RUNTIME_CTX_CLIENT_IP).(string)
+		if !ok {
+			return "", ErrInvalidInput
+		}
+		lookup, err := fmInstance.edgegapManager.LookupIP(clientIp)
+		if err != nil {
+			logger.WithField("error", err.Error()).Warn("failed to lookup client location, skipping max ping filter")
+		} else if lookup.Location.hasCoordinates() {
+			from = &lookup.Location
+		}
+	}
+
+	instances, cursor, err := fm.List(ctx, query, req.Limit, req.Cursor)
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("failed to list instance instances")
 		return "", ErrInternalError
 	}
 
+	// Instances beyond max ping are filtered after paging, the next pages fill the limit, each listing only the missing
+	// instances so the cursor resumes right after the last one returned
+	if from != nil {
+		instances = fmInstance.storageManager.filterByPing(instances, *from, req.MaxPing)
+		for pages := 1; pages < maxPingListPages && cursor != "" && len(instances) < req.Limit; pages++ {
+			var page []*runtime.InstanceInfo
+			page, cursor, err = fm.List(ctx, query, req.Limit-len(instances), cursor)
+			if err != nil {
+				logger.WithField("error", err.Error()).Error("failed to list instance instances")
+				return "", ErrInternalError
+			}
+			instances = append(instances, fmInstance.storageManager.filterByPing(page, *from, req.MaxPing)...)
+		}
+	}
+
 	reply := &instanceSessionListReply{
 		Cursor:    cursor,
 		Instances: instances,
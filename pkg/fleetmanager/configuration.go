@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,18 +14,86 @@ import (
 	"github.com/heroiclabs/nakama-common/runtime"
 )
 
+// storagePrefixPattern keeps the prefixed collection and index names within Nakama's limits
+var storagePrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
 type EdgegapManagerConfiguration struct {
 	NakamaNode             string `json:"nakama_node"`
+	FleetName              string `json:"fleet_name"`
 	ApiUrl                 string `json:"base_url"`
 	ApiToken               string `json:"api_token"`
+	FailoverApiTokens      string `json:"-"`
+	DedicatedLocationTags  string `json:"dedicated_location_tags"`
+	DeploymentFilters      string `json:"deployment_filters"`
+	ContainerArgs          string `json:"container_args"`
+	DeploymentMaxDuration  string `json:"deployment_max_duration"`
+	DeploymentTag          string `json:"deployment_tag"`
+	DeploymentTags         string `json:"deployment_tags"`
+	InstanceCompression    string `json:"instance_compression"`
+	InstanceUsersSplit     bool   `json:"instance_users_split"`
+	InstanceEventLog       bool   `json:"instance_event_log"`
+	Tenants                string `json:"-"`
+	DedicatedFallback      bool   `json:"dedicated_fallback"`
 	Application            string `json:"application"`
 	InitialVersion         string `json:"initial_version"`
+	DynamicVersioning      bool   `json:"dynamic_versioning"`
+	ReadyOnDeployment      bool   `json:"ready_on_deployment"`
+	CrashReplacement       bool   `json:"crash_replacement"`
 	PortName               string `json:"port_name"`
+	PortSchemes            string `json:"port_schemes"`
 	NakamaAccessUrl        string `json:"nakama_access_url"`
 	NakamaHttpKey          string `json:"nakama_http_key"`
+	EncryptionKey          string `json:"-"`
+	PlayerIpKey            string `json:"-"`
 	PollingInterval        string `json:"polling_interval"`
+	ReconcileWorkers       int    `json:"reconcile_workers"`
 	CleanupInterval        string `json:"cleanup_interval"`
 	ReservationMaxDuration string `json:"reservation_max_duration"`
+	SeatHoldTtl            string `json:"seat_hold_ttl"`
+	JoinLockTtl            string `json:"join_lock_ttl"`
+	AuditInterval          string `json:"audit_interval"`
+	RetentionPeriod        string `json:"retention_period"`
+	AuditHeartbeat         bool   `json:"audit_heartbeat"`
+	AuditLog               bool   `json:"audit_log"`
+	DailyStats             bool   `json:"daily_stats"`
+	MergeInterval          string `json:"merge_interval"`
+	MergeMaxFill           int    `json:"merge_max_fill"`
+	MergeMinAge            string `json:"merge_min_age"`
+	PendingMaxDuration     string `json:"pending_max_duration"`
+	ExpiryWarning          string `json:"expiry_warning"`
+	ModeMetadataKey        string `json:"mode_metadata_key"`
+	ModeQuotas             string `json:"mode_quotas"`
+	EntitlementRpc         string `json:"entitlement_rpc"`
+	RentalCost             string `json:"rental_cost"`
+	InstanceCacheTtl       string `json:"instance_cache_ttl"`
+	InstanceCacheSize      int    `json:"instance_cache_size"`
+	IndexLagWindow         string `json:"index_lag_window"`
+	BeaconHalfLife         string `json:"beacon_half_life"`
+	LocationsCacheTtl      string `json:"locations_cache_ttl"`
+	VersionCacheTtl        string `json:"version_cache_ttl"`
+	WriteCoalesceWindow    string `json:"write_coalesce_window"`
+	SlowStartThreshold     string `json:"slow_start_threshold"`
+	SlowStartWebhookUrl    string `json:"slow_start_webhook_url"`
+	WebhookUrls            string `json:"webhook_urls"`
+	WebhookEvents          string `json:"webhook_events"`
+	WebhookTemplate        string `json:"webhook_template"`
+	WebhookRelayUrls       string `json:"webhook_relay_urls"`
+	NotificationTemplates  string `json:"notification_templates"`
+	PayloadCasing          string `json:"payload_casing"`
+	ConnectionPreference   string `json:"connection_preference"`
+	MatchmakerLabels       string `json:"matchmaker_labels"`
+	CreateGuardWindow      string `json:"create_guard_window"`
+	CreateMaxPlayers       int    `json:"create_max_players"`
+	CreateMaxUsers         int    `json:"create_max_users"`
+	CreateMaxMetadataBytes int    `json:"create_max_metadata_bytes"`
+	InstanceMetadataFields string `json:"instance_metadata_fields"`
+	InstanceMetadataLimit  int    `json:"instance_metadata_max_bytes"`
+	InstanceMetadataFetch  bool   `json:"instance_metadata_fetch"`
+	Maintenance            bool   `json:"maintenance"`
+	ChaosFaults            string `json:"chaos_faults"`
+	ChaosWebhookDelay      string `json:"chaos_webhook_delay"`
+	StoragePrefix          string `json:"storage_prefix"`
+	StorageIndexMaxEntries int    `json:"storage_index_max_entries"`
 }
 
 // NewEdgegapManagerConfiguration Create New Edgegap EdgegapManager Configuration and Fail if missing values
@@ -47,6 +117,39 @@ func NewEdgegapManagerConfiguration(ctx context.Context) (*EdgegapManagerConfigu
 		return nil, runtime.NewError("EDGEGAP_API_TOKEN not found in environment", 3)
 	}
 
+	// Name of the Edgegap fleet when routing between several fleet managers
+	fleetName, ok := env["NAKAMA_FLEET_NAME"]
+	if !ok || strings.TrimSpace(fleetName) == "" {
+		fleetName = "edgegap"
+	}
+
+	// Optional accounts to fail over to, in priority order
+	failoverTokens := env["EDGEGAP_FAILOVER_API_TOKENS"]
+
+	// Reserved capacity is optional, deployments try the hosts with these location tags before on-demand ones
+	dedicatedLocationTags := env["EDGEGAP_DEDICATED_LOCATION_TAGS"]
+	dedicatedFallback := !strings.EqualFold(strings.TrimSpace(env["EDGEGAP_DEDICATED_FALLBACK"]), "false")
+
+	// Optional games hosted on the cluster with their own Edgegap application, token and version, as JSON by tenant id
+	tenants := strings.TrimSpace(env["EDGEGAP_TENANTS"])
+
+	// Geographic filters of every deployment, e.g. [{"field":"country","values":["<country>"],"filter_type":"not"}]
+	deploymentFilters := env["EDGEGAP_DEPLOYMENT_FILTERS"]
+
+	// Container arguments server callers can set per deployment, as JSON value patterns by flag
+	containerArgs := env["EDGEGAP_CONTAINER_ARGS"]
+
+	// Max duration set on every deployment so Edgegap stops it on its own, 0 keeps the one of the app version
+	deploymentMaxDuration, ok := env["EDGEGAP_DEPLOYMENT_MAX_DURATION"]
+	if !ok || strings.TrimSpace(deploymentMaxDuration) == "" {
+		deploymentMaxDuration = "0"
+	}
+
+	// Tag unique to this cluster, the reconciliation stops the tagged deployments left without an instance record
+	deploymentTag := strings.TrimSpace(env["EDGEGAP_DEPLOYMENT_TAG"])
+	// Tags attributing the cost of each deployment, literal or read from the create metadata, e.g. mode={game_mode}
+	deploymentTags := env["EDGEGAP_DEPLOYMENT_TAGS"]
+
 	app, ok := env["EDGEGAP_APPLICATION"]
 	if !ok {
 		return nil, runtime.NewError("EDGEGAP_APPLICATION not found in environment", 3)
@@ -60,11 +163,17 @@ func NewEdgegapManagerConfiguration(ctx context.Context) (*EdgegapManagerConfigu
 		initialVersion = env["EDGEGAP_VERSION"]
 	}
 
+	// Dynamic versioning is on by default, when off the initial version is always used and cannot be updated
+	dynamicVersioning := !strings.EqualFold(strings.TrimSpace(env["EDGEGAP_DYNAMIC_VERSIONING"]), "false")
+
 	portName, ok := env["EDGEGAP_PORT_NAME"]
 	if !ok {
 		return nil, runtime.NewError("EDGEGAP_PORT_NAME not found in environment", 3)
 	}
 
+	// Port schemes are optional, e.g. "game=udp,web=wss" with "version:port=scheme" overriding an app version
+	portSchemes := env["EDGEGAP_PORT_SCHEMES"]
+
 	nakamaAccessUrl, ok := env["NAKAMA_ACCESS_URL"]
 	if !ok {
 		return nil, runtime.NewError("NAKAMA_ACCESS_URL not found in environment", 3)
@@ -77,6 +186,12 @@ func NewEdgegapManagerConfiguration(ctx context.Context) (*EdgegapManagerConfigu
 		pollingInterval = "0"
 	}
 
+	// Reconciliation lists the accounts and pages of deployments and removes instances with this many workers
+	reconcileWorkers, err := envInt(env, "NAKAMA_RECONCILE_WORKERS", 4)
+	if err != nil {
+		return nil, err
+	}
+
 	cleanupInterval, ok := env["NAKAMA_CLEANUP_INTERVAL"]
 	if !ok {
 		cleanupInterval = "1m"
@@ -89,20 +204,298 @@ func NewEdgegapManagerConfiguration(ctx context.Context) (*EdgegapManagerConfigu
 		reservationMaxDuration = "30s"
 	}
 
+	// Seats are not held by default, e.g. "15s" holds the seats of players receiving the connection info until then
+	seatHoldTtl, ok := env["NAKAMA_SEAT_HOLD_TTL"]
+	if !ok || strings.TrimSpace(seatHoldTtl) == "" {
+		seatHoldTtl = "0"
+	}
+
+	// Joins are not locked by default, e.g. "2s" serializes the seat allocation of each instance for at most 2 seconds
+	joinLockTtl, ok := env["NAKAMA_JOIN_LOCK_TTL"]
+	if !ok || strings.TrimSpace(joinLockTtl) == "" {
+		joinLockTtl = "0"
+	}
+
+	auditInterval, ok := env["NAKAMA_AUDIT_INTERVAL"]
+	if !ok || strings.TrimSpace(auditInterval) == "" {
+		auditInterval = "0"
+	}
+
+	auditHeartbeat := strings.EqualFold(strings.TrimSpace(env["NAKAMA_AUDIT_HEARTBEAT"]), "true")
+
+	// The audit log of fleet mutations is off by default, it adds a storage write per mutation
+	auditLog := strings.EqualFold(strings.TrimSpace(env["NAKAMA_AUDIT_LOG"]), "true")
+	// Daily aggregates of the fleet events, flushed by each node every 30s
+	dailyStats := strings.EqualFold(strings.TrimSpace(env["NAKAMA_DAILY_STATS"]), "true")
+
+	// Beacon latencies submitted by clients place their deployments, halving their weight every half-life
+	beaconHalfLife, ok := env["NAKAMA_BEACON_HALF_LIFE"]
+	if !ok || strings.TrimSpace(beaconHalfLife) == "" {
+		beaconHalfLife = "30m"
+	}
+
+	// The version is cached for the create path, other nodes pick updates up within the TTL
+	versionCacheTtl, ok := env["NAKAMA_VERSION_CACHE_TTL"]
+	if !ok || strings.TrimSpace(versionCacheTtl) == "" {
+		versionCacheTtl = "5s"
+	}
+
+	// The location catalog of the application is cached, locations rarely change
+	locationsCacheTtl, ok := env["NAKAMA_LOCATIONS_CACHE_TTL"]
+	if !ok || strings.TrimSpace(locationsCacheTtl) == "" {
+		locationsCacheTtl = "10m"
+	}
+
+	// Retention is optional, archived instances older than the period are deleted with their player data
+	retentionPeriod, ok := env["NAKAMA_RETENTION_PERIOD"]
+	if !ok || strings.TrimSpace(retentionPeriod) == "" {
+		retentionPeriod = "0"
+	}
+
+	// Lobby merges are optional, lobbies filled below the max fill percentage are merged every interval
+	mergeInterval, ok := env["NAKAMA_MERGE_INTERVAL"]
+	if !ok || strings.TrimSpace(mergeInterval) == "" {
+		mergeInterval = "0"
+	}
+	mergeMaxFill, err := envInt(env, "NAKAMA_MERGE_MAX_FILL", 50)
+	if err != nil {
+		return nil, err
+	}
+	mergeMinAge, ok := env["NAKAMA_MERGE_MIN_AGE"]
+	if !ok || strings.TrimSpace(mergeMinAge) == "" {
+		mergeMinAge = "2m"
+	}
+
+	pendingMaxDuration, ok := env["NAKAMA_PENDING_MAX_DURATION"]
+	if !ok {
+		pendingMaxDuration = "5m"
+	}
+
+	expiryWarning, ok := env["NAKAMA_EXPIRY_WARNING"]
+	if !ok {
+		expiryWarning = "2m"
+	} else if strings.TrimSpace(expiryWarning) == "" {
+		expiryWarning = "0"
+	}
+
+	instanceCacheTtl, ok := env["NAKAMA_INSTANCE_CACHE_TTL"]
+	if !ok || strings.TrimSpace(instanceCacheTtl) == "" {
+		instanceCacheTtl = "0"
+	}
+
+	instanceCacheSize := 10_000
+	if value, ok := env["NAKAMA_INSTANCE_CACHE_SIZE"]; ok && strings.TrimSpace(value) != "" {
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || size < 0 {
+			return nil, errors.New("invalid instance cache size: " + value)
+		}
+		instanceCacheSize = size
+	}
+
+	// Compression of the user lists of the stored instances, disabled by default so records stay readable
+	instanceCompression := strings.ToLower(strings.TrimSpace(env["NAKAMA_INSTANCE_COMPRESSION"]))
+	// Moving the user lists out of the indexed records is opt-in, listings then omit them
+	instanceUsersSplit := strings.EqualFold(strings.TrimSpace(env["NAKAMA_INSTANCE_USERS_SPLIT"]), "true")
+	// Append the changes of every instance write to a per-instance event log
+	instanceEventLog := strings.EqualFold(strings.TrimSpace(env["NAKAMA_INSTANCE_EVENT_LOG"]), "true")
+
+	indexLagWindow, ok := env["NAKAMA_INDEX_LAG_WINDOW"]
+	if !ok {
+		indexLagWindow = "5s"
+	} else if strings.TrimSpace(indexLagWindow) == "" {
+		indexLagWindow = "0"
+	}
+
+	createGuardWindow, ok := env["NAKAMA_CREATE_GUARD_WINDOW"]
+	if !ok {
+		createGuardWindow = "10s"
+	} else if strings.TrimSpace(createGuardWindow) == "" {
+		createGuardWindow = "0"
+	}
+
+	// Create request limits, 0 disables a limit
+	createMaxPlayers, err := envInt(env, "NAKAMA_CREATE_MAX_PLAYERS", 0)
+	if err != nil {
+		return nil, err
+	}
+	createMaxUsers, err := envInt(env, "NAKAMA_CREATE_MAX_USERS", 100)
+	if err != nil {
+		return nil, err
+	}
+	createMaxMetadataBytes, err := envInt(env, "NAKAMA_CREATE_MAX_METADATA_BYTES", 4096)
+	if err != nil {
+		return nil, err
+	}
+
+	// The game server gets every metadata field in its environment by default, e.g. "game_mode,map" forwards only those
+	instanceMetadataFields := env["NAKAMA_INSTANCE_METADATA_FIELDS"]
+	instanceMetadataLimit, err := envInt(env, "NAKAMA_INSTANCE_METADATA_MAX_BYTES", 4096)
+	if err != nil {
+		return nil, err
+	}
+	instanceMetadataFetch := strings.EqualFold(strings.TrimSpace(env["NAKAMA_INSTANCE_METADATA_FETCH"]), "true")
+
+	// Maintenance rejects the instance creates of clients with the maintenance reason, e.g. during a version migration
+	maintenance := strings.EqualFold(strings.TrimSpace(env["NAKAMA_MAINTENANCE"]), "true")
+
+	// Chaos mode is test-only, e.g. "edgegap_error=0.1,drop_connection_event=0.05"
+	chaosFaults := env["NAKAMA_CHAOS_FAULTS"]
+	chaosWebhookDelay, ok := env["NAKAMA_CHAOS_WEBHOOK_DELAY"]
+	if !ok || strings.TrimSpace(chaosWebhookDelay) == "" {
+		chaosWebhookDelay = "10s"
+	}
+
+	// Storage names are prefixed to avoid collisions with the game's own collections
+	storagePrefix, ok := env["NAKAMA_STORAGE_PREFIX"]
+	if !ok || strings.TrimSpace(storagePrefix) == "" {
+		storagePrefix = StorageEdgegapPrefix
+	}
+
+	storageIndexMaxEntries := 1_000_000
+	if value, ok := env["NAKAMA_STORAGE_INDEX_MAX_ENTRIES"]; ok && strings.TrimSpace(value) != "" {
+		entries, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.New("invalid storage index max entries: " + value)
+		}
+		storageIndexMaxEntries = entries
+	}
+
+	writeCoalesceWindow, ok := env["NAKAMA_WRITE_COALESCE_WINDOW"]
+	if !ok || strings.TrimSpace(writeCoalesceWindow) == "" {
+		writeCoalesceWindow = "0"
+	}
+
+	modeMetadataKey, ok := env["NAKAMA_MODE_METADATA_KEY"]
+	if !ok || strings.TrimSpace(modeMetadataKey) == "" {
+		modeMetadataKey = "mode"
+	}
+
+	// Deployment quotas are optional, e.g. "ranked=50,custom=20" with "*" capping all modes
+	modeQuotas := env["NAKAMA_MODE_QUOTAS"]
+
+	// Entitlement RPC is optional, called before Create and Join
+	entitlementRpc := strings.TrimSpace(env["NAKAMA_ENTITLEMENT_RPC"])
+
+	// Rentals are optional, e.g. "gems=100" debited from the creator of a rental instance
+	rentalCost := env["NAKAMA_RENTAL_COST"]
+
+	slowStartThreshold, ok := env["EDGEGAP_SLOW_START_THRESHOLD"]
+	if !ok || strings.TrimSpace(slowStartThreshold) == "" {
+		slowStartThreshold = "0"
+	}
+
+	slowStartWebhookUrl := env["EDGEGAP_SLOW_START_WEBHOOK_URL"]
+
+	// Off by default, game servers declare themselves READY with an instance event
+	readyOnDeployment := strings.EqualFold(strings.TrimSpace(env["EDGEGAP_READY_ON_DEPLOYMENT"]), "true")
+
+	// Off by default, crashed instances otherwise leave their players to find another match
+	crashReplacement := strings.EqualFold(strings.TrimSpace(env["NAKAMA_CRASH_REPLACEMENT"]), "true")
+
+	// Outbound webhooks are optional, urls can be prefixed with "discord:" or "slack:"
+	webhookUrls := env["NAKAMA_WEBHOOK_URLS"]
+	webhookEvents := env["NAKAMA_WEBHOOK_EVENTS"]
+	webhookTemplate := env["NAKAMA_WEBHOOK_TEMPLATE"]
+
+	// Relaying the Edgegap deployment webhooks to third-party urls is optional
+	webhookRelayUrls := env["NAKAMA_WEBHOOK_RELAY_URLS"]
+
+	// Notification templates are optional, a JSON file localizing the notifications
+	notificationTemplates := strings.TrimSpace(env["NAKAMA_NOTIFICATION_TEMPLATES"])
+
+	// Player IPs are encrypted at rest when a key is set
+	playerIpKey := env["NAKAMA_PLAYER_IP_KEY"]
+
+	// Payload casing is optional, "camel" or "snake" for clients unable to map the default field names
+	payloadCasing := strings.ToLower(strings.TrimSpace(env["NAKAMA_PAYLOAD_CASING"]))
+
+	// Clients are told to try the FQDN first by default, "ip" lists the raw IPs first
+	connectionPreference := strings.ToLower(strings.TrimSpace(env["NAKAMA_CONNECTION_PREFERENCE"]))
+	if connectionPreference == "" {
+		connectionPreference = ConnectionPreferDns
+	}
+
+	// Mirroring instance fields in the matchmaker properties is optional, e.g. "region,version,metadata.game_mode"
+	matchmakerLabels := env["NAKAMA_MATCHMAKER_LABELS"]
+
 	mc := EdgegapManagerConfiguration{
 		NakamaNode:             nakamaNode,
+		FleetName:              strings.TrimSpace(fleetName),
 		ApiUrl:                 url,
 		ApiToken:               token,
+		FailoverApiTokens:      failoverTokens,
+		DedicatedLocationTags:  dedicatedLocationTags,
+		DeploymentFilters:      deploymentFilters,
+		ContainerArgs:          containerArgs,
+		DeploymentMaxDuration:  deploymentMaxDuration,
+		DeploymentTag:          deploymentTag,
+		DeploymentTags:         deploymentTags,
+		Tenants:                tenants,
+		DedicatedFallback:      dedicatedFallback,
 		Application:            app,
 		InitialVersion:         initialVersion,
+		DynamicVersioning:      dynamicVersioning,
+		ReadyOnDeployment:      readyOnDeployment,
+		CrashReplacement:       crashReplacement,
 		PortName:               portName,
+		PortSchemes:            portSchemes,
 		NakamaAccessUrl:        nakamaAccessUrl,
 		PollingInterval:        pollingInterval,
+		ReconcileWorkers:       reconcileWorkers,
 		CleanupInterval:        cleanupInterval,
 		ReservationMaxDuration: reservationMaxDuration,
+		SeatHoldTtl:            seatHoldTtl,
+		JoinLockTtl:            joinLockTtl,
+		AuditInterval:          auditInterval,
+		RetentionPeriod:        retentionPeriod,
+		AuditHeartbeat:         auditHeartbeat,
+		AuditLog:               auditLog,
+		DailyStats:             dailyStats,
+		MergeInterval:          mergeInterval,
+		MergeMaxFill:           mergeMaxFill,
+		MergeMinAge:            mergeMinAge,
+		PendingMaxDuration:     pendingMaxDuration,
+		ExpiryWarning:          expiryWarning,
+		ModeMetadataKey:        modeMetadataKey,
+		ModeQuotas:             modeQuotas,
+		EntitlementRpc:         entitlementRpc,
+		RentalCost:             rentalCost,
+		InstanceCacheTtl:       instanceCacheTtl,
+		InstanceCacheSize:      instanceCacheSize,
+		InstanceCompression:    instanceCompression,
+		InstanceUsersSplit:     instanceUsersSplit,
+		InstanceEventLog:       instanceEventLog,
+		IndexLagWindow:         indexLagWindow,
+		BeaconHalfLife:         beaconHalfLife,
+		LocationsCacheTtl:      locationsCacheTtl,
+		VersionCacheTtl:        versionCacheTtl,
+		WriteCoalesceWindow:    writeCoalesceWindow,
+		SlowStartThreshold:     slowStartThreshold,
+		SlowStartWebhookUrl:    slowStartWebhookUrl,
+		WebhookUrls:            webhookUrls,
+		WebhookEvents:          webhookEvents,
+		WebhookTemplate:        webhookTemplate,
+		WebhookRelayUrls:       webhookRelayUrls,
+		NotificationTemplates:  notificationTemplates,
+		PayloadCasing:          payloadCasing,
+		ConnectionPreference:   connectionPreference,
+		MatchmakerLabels:       matchmakerLabels,
+		PlayerIpKey:            playerIpKey,
+		CreateGuardWindow:      createGuardWindow,
+		CreateMaxPlayers:       createMaxPlayers,
+		CreateMaxUsers:         createMaxUsers,
+		CreateMaxMetadataBytes: createMaxMetadataBytes,
+		InstanceMetadataFields: instanceMetadataFields,
+		InstanceMetadataLimit:  instanceMetadataLimit,
+		InstanceMetadataFetch:  instanceMetadataFetch,
+		Maintenance:            maintenance,
+		ChaosFaults:            chaosFaults,
+		ChaosWebhookDelay:      chaosWebhookDelay,
+		StoragePrefix:          strings.TrimSpace(storagePrefix),
+		StorageIndexMaxEntries: storageIndexMaxEntries,
 	}
 
-	err := mc.Validate()
+	err = mc.Validate()
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +503,19 @@ func NewEdgegapManagerConfiguration(ctx context.Context) (*EdgegapManagerConfigu
 	return &mc, nil
 }
 
+// envInt reads a positive integer from the environment, returning the default value when unset.
+func envInt(env map[string]string, key string, defaultValue int) (int, error) {
+	value, ok := env[key]
+	if !ok || strings.TrimSpace(value) == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || i < 0 {
+		return 0, errors.New("invalid " + strings.ToLower(strings.ReplaceAll(key, "_", " ")) + ": " + value)
+	}
+	return i, nil
+}
+
 // Validate Will check if the configuration is valid
 func (emc *EdgegapManagerConfiguration) Validate() error {
 	errs := make([]error, 0)
@@ -136,6 +542,10 @@ func (emc *EdgegapManagerConfiguration) Validate() error {
 		errs = append(errs, errors.New("edgegap application port name must be set"))
 	}
 
+	if _, err := parsePortSchemes(emc.PortSchemes); err != nil {
+		errs = append(errs, err)
+	}
+
 	if emc.NakamaAccessUrl == "" {
 		errs = append(errs, errors.New("nakama access url must be set"))
 	}
@@ -144,6 +554,10 @@ func (emc *EdgegapManagerConfiguration) Validate() error {
 		errs = append(errs, errors.New("invalid polling interval: "+emc.PollingInterval))
 	}
 
+	if emc.ReconcileWorkers < 1 {
+		errs = append(errs, fmt.Errorf("reconcile workers must be at least 1, got %d", emc.ReconcileWorkers))
+	}
+
 	if _, err := time.ParseDuration(emc.CleanupInterval); err != nil {
 		errs = append(errs, errors.New("invalid cleanup interval: "+emc.CleanupInterval))
 	}
@@ -152,6 +566,150 @@ func (emc *EdgegapManagerConfiguration) Validate() error {
 		errs = append(errs, errors.New("invalid reservation max duration: "+emc.ReservationMaxDuration))
 	}
 
+	if ttl, err := time.ParseDuration(emc.SeatHoldTtl); err != nil || ttl < 0 {
+		errs = append(errs, errors.New("invalid seat hold ttl: "+emc.SeatHoldTtl))
+	}
+
+	if _, ok := instanceCodecs[emc.InstanceCompression]; emc.InstanceCompression != "" && !ok {
+		errs = append(errs, errors.New("invalid instance compression, expects gzip: "+emc.InstanceCompression))
+	}
+
+	if _, err := parseTenants(emc.Tenants); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseConfiguredFilters(emc.DeploymentFilters); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseContainerArgsAllowlist(emc.ContainerArgs); err != nil {
+		errs = append(errs, err)
+	}
+
+	if maxDuration, err := time.ParseDuration(emc.DeploymentMaxDuration); err != nil || maxDuration < 0 {
+		errs = append(errs, errors.New("invalid deployment max duration: "+emc.DeploymentMaxDuration))
+	}
+
+	if emc.DeploymentTag != "" && (!deploymentTagName.MatchString(emc.DeploymentTag) || emc.DeploymentTag == "nakama") {
+		errs = append(errs, errors.New("invalid deployment tag, expects 1 to 64 letters, digits, _ or - other than nakama: "+emc.DeploymentTag))
+	}
+
+	if _, err := parseUsageTags(emc.DeploymentTags); err != nil {
+		errs = append(errs, err)
+	}
+
+	if ttl, err := time.ParseDuration(emc.JoinLockTtl); err != nil || ttl < 0 {
+		errs = append(errs, errors.New("invalid join lock ttl: "+emc.JoinLockTtl))
+	}
+
+	if _, err := time.ParseDuration(emc.AuditInterval); err != nil {
+		errs = append(errs, errors.New("invalid audit interval: "+emc.AuditInterval))
+	}
+
+	if _, err := time.ParseDuration(emc.RetentionPeriod); err != nil {
+		errs = append(errs, errors.New("invalid retention period: "+emc.RetentionPeriod))
+	}
+
+	if _, err := time.ParseDuration(emc.MergeInterval); err != nil {
+		errs = append(errs, errors.New("invalid merge interval: "+emc.MergeInterval))
+	}
+
+	if emc.MergeMaxFill < 1 || emc.MergeMaxFill > 100 {
+		errs = append(errs, fmt.Errorf("merge max fill must be a percentage between 1 and 100, got %d", emc.MergeMaxFill))
+	}
+
+	if _, err := time.ParseDuration(emc.MergeMinAge); err != nil {
+		errs = append(errs, errors.New("invalid merge min age: "+emc.MergeMinAge))
+	}
+
+	if _, err := time.ParseDuration(emc.PendingMaxDuration); err != nil {
+		errs = append(errs, errors.New("invalid pending max duration: "+emc.PendingMaxDuration))
+	}
+
+	if _, err := time.ParseDuration(emc.ExpiryWarning); err != nil {
+		errs = append(errs, errors.New("invalid expiry warning: "+emc.ExpiryWarning))
+	}
+
+	if _, err := time.ParseDuration(emc.InstanceCacheTtl); err != nil {
+		errs = append(errs, errors.New("invalid instance cache ttl: "+emc.InstanceCacheTtl))
+	}
+
+	if _, err := time.ParseDuration(emc.IndexLagWindow); err != nil {
+		errs = append(errs, errors.New("invalid index lag window: "+emc.IndexLagWindow))
+	}
+
+	if halfLife, err := time.ParseDuration(emc.BeaconHalfLife); err != nil || halfLife < 0 {
+		errs = append(errs, errors.New("invalid beacon half life: "+emc.BeaconHalfLife))
+	}
+
+	if !emc.DynamicVersioning && emc.InitialVersion == "" {
+		errs = append(errs, errors.New("EDGEGAP_DYNAMIC_VERSIONING=false requires INITIAL_EDGEGAP_VERSION"))
+	}
+
+	if _, err := time.ParseDuration(emc.VersionCacheTtl); err != nil {
+		errs = append(errs, errors.New("invalid version cache ttl: "+emc.VersionCacheTtl))
+	}
+
+	if _, err := time.ParseDuration(emc.LocationsCacheTtl); err != nil {
+		errs = append(errs, errors.New("invalid locations cache ttl: "+emc.LocationsCacheTtl))
+	}
+
+	if _, err := time.ParseDuration(emc.WriteCoalesceWindow); err != nil {
+		errs = append(errs, errors.New("invalid write coalesce window: "+emc.WriteCoalesceWindow))
+	}
+
+	if _, err := time.ParseDuration(emc.CreateGuardWindow); err != nil {
+		errs = append(errs, errors.New("invalid create guard window: "+emc.CreateGuardWindow))
+	}
+
+	if _, err := parsePayloadCasing(emc.PayloadCasing); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseConnectionPreference(emc.ConnectionPreference); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseChaosFaults(emc.ChaosFaults); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseRelayUrls(emc.WebhookRelayUrls); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseMatchmakerLabels(emc.MatchmakerLabels); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseInstanceMetadataFields(emc.InstanceMetadataFields); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := time.ParseDuration(emc.ChaosWebhookDelay); err != nil {
+		errs = append(errs, errors.New("invalid chaos webhook delay: "+emc.ChaosWebhookDelay))
+	}
+
+	if !storagePrefixPattern.MatchString(emc.StoragePrefix) {
+		errs = append(errs, errors.New("invalid storage prefix, expects 1 to 64 letters, digits, '_' or '-': "+emc.StoragePrefix))
+	}
+
+	if emc.StorageIndexMaxEntries <= 0 {
+		errs = append(errs, errors.New("storage index max entries must be positive"))
+	}
+
+	if _, err := parseModeQuotas(emc.ModeQuotas); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := parseRentalCost(emc.RentalCost); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := time.ParseDuration(emc.SlowStartThreshold); err != nil {
+		errs = append(errs, errors.New("invalid slow start threshold: "+emc.SlowStartThreshold))
+	}
+
 	// Validate Edgegap API connection
 	apiHelper := helpers.NewAPIClient(emc.ApiUrl, emc.ApiToken)
 	// Test API connection by checking the application exists
@@ -0,0 +1,54 @@
+package fleetmanager
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Connection address preferences, the order in which clients are told to try the deployment addresses
+const (
+	// ConnectionPreferDns lists the FQDN first, resolved by the client to IPv4 or IPv6 (NAT64 on IPv6-only carriers)
+	ConnectionPreferDns = "dns"
+	// ConnectionPreferIp lists the raw IPs first, skipping the DNS lookup
+	ConnectionPreferIp = "ip"
+)
+
+// parseConnectionPreference validates the configured connection address preference.
+func parseConnectionPreference(value string) (string, error) {
+	switch preference := strings.ToLower(strings.TrimSpace(value)); preference {
+	case ConnectionPreferDns, ConnectionPreferIp:
+		return preference, nil
+	}
+	return "", errors.New("invalid connection preference, expects dns or ip: " + value)
+}
+
+// connectionAddresses lists the addresses of the deployment in the preferred order, without the empty ones. The IPv4
+// address comes before the IPv6 one with the ip preference, as IPv4 networks are still the most common.
+func connectionAddresses(preference string, info *runtime.ConnectionInfo, ipv6 string) []string {
+	if info == nil {
+		return []string{}
+	}
+
+	ordered := []string{info.DnsName, ipv6, info.IpAddress}
+	if preference == ConnectionPreferIp {
+		ordered = []string{info.IpAddress, ipv6, info.DnsName}
+	}
+
+	addresses := make([]string, 0, len(ordered))
+	for _, address := range ordered {
+		if address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// connectionPreference returns the configured connection address preference, dns when not running.
+func connectionPreference() string {
+	if fmInstance == nil {
+		return ConnectionPreferDns
+	}
+	return fmInstance.edgegapManager.configuration.ConnectionPreference
+}
@@ -0,0 +1,240 @@
+package fleetmanager
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Console RPCs, stable names called from the Nakama console API explorer (S2S only)
+const (
+	// RpcIdConsoleInstances lists the active instances as a table
+	RpcIdConsoleInstances = "console_instances"
+	// RpcIdConsoleErrors lists the recent instance errors and dead letters as a table
+	RpcIdConsoleErrors = "console_errors"
+	// RpcIdConsoleVersion reports the Edgegap version state as a table
+	RpcIdConsoleVersion = "console_version"
+)
+
+const (
+	consoleDefaultLimit = 100
+	consoleMaxLimit     = 1_000
+)
+
+// consoleActiveStatuses are the statuses of the instances listed by console_instances
+var consoleActiveStatuses = []string{
+	EdgegapStatusPending,
+	EdgegapStatusStarting,
+	EdgegapStatusRequested,
+	EdgegapStatusRunning,
+	EdgegapStatusReady,
+	EdgegapStatusStopping,
+}
+
+// consoleTable is the reply of the console RPCs, flat rows of strings the console renders as is
+type consoleTable struct {
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+	Count   int        `json:"count"`
+}
+
+type consoleListRequest struct {
+	// Status filters the listed instances, all active statuses by default
+	Status string `json:"status"`
+	Limit  int    `json:"limit"`
+}
+
+// consoleRequest parses the optional payload of a console rpc and bounds its limit.
+func consoleRequest(payload string) (*consoleListRequest, error) {
+	req := &consoleListRequest{}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), req); err != nil {
+			return nil, ErrInvalidInput
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = consoleDefaultLimit
+	}
+	req.Limit = min(req.Limit, consoleMaxLimit)
+	return req, nil
+}
+
+// marshalConsoleTable marshals the table, truncated to the limit.
+func marshalConsoleTable(table consoleTable, limit int) (string, error) {
+	if limit > 0 && len(table.Rows) > limit {
+		table.Rows = table.Rows[:limit]
+	}
+	table.Count = len(table.Rows)
+
+	reply, err := json.Marshal(table)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
+
+// consoleTime formats a time for the console, empty when unset.
+func consoleTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// consoleInstances console rpc listing the active instances, most recent first (S2S only)
+func consoleInstances(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdConsoleInstances); err != nil {
+		return "", err
+	}
+	req, err := consoleRequest(payload)
+	if err != nil {
+		return "", err
+	}
+
+	statuses := consoleActiveStatuses
+	if req.Status != "" {
+		statuses = []string{req.Status}
+	}
+	instances, err := fmInstance.storageManager.listDbInstancesByStatus(ctx, statuses)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list instances for the console")
+		return "", ErrInternalError
+	}
+	slices.SortFunc(instances, func(a, b *runtime.InstanceInfo) int {
+		return b.CreateTime.Compare(a.CreateTime)
+	})
+
+	table := consoleTable{
+		Columns: []string{"id", "status", "players", "max_players", "reservations", "address", "region", "version", "created_at"},
+		Rows:    make([][]string, 0, min(len(instances), req.Limit)),
+	}
+	for _, instance := range instances[:min(len(instances), req.Limit)] {
+		ei, err := fmInstance.storageManager.ExtractEdgegapInstance(instance)
+		if err != nil {
+			continue
+		}
+		address, region := "", ""
+		if ci := instance.ConnectionInfo; ci != nil && ci.Port > 0 {
+			address = cmp.Or(ci.DnsName, ci.IpAddress) + ":" + strconv.Itoa(ci.Port)
+		}
+		if ei.Location != nil {
+			region = ei.Location.Continent
+		}
+		table.Rows = append(table.Rows, []string{
+			instance.Id,
+			instance.Status,
+			strconv.Itoa(instance.PlayerCount),
+			strconv.Itoa(ei.MaxPlayers),
+			strconv.Itoa(len(ei.Reservations)),
+			address,
+			region,
+			ei.Version,
+			consoleTime(instance.CreateTime),
+		})
+	}
+	return marshalConsoleTable(table, req.Limit)
+}
+
+// consoleErrors console rpc listing the instances in error and the webhook payloads that failed to parse, most recent
+// first (S2S only)
+func consoleErrors(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdConsoleErrors); err != nil {
+		return "", err
+	}
+	req, err := consoleRequest(payload)
+	if err != nil {
+		return "", err
+	}
+
+	instances, err := fmInstance.storageManager.listDbInstancesByStatus(ctx, []string{EdgegapStatusError})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list errored instances for the console")
+		return "", ErrInternalError
+	}
+	deadLetters, _, err := fmInstance.storageManager.listDeadLetters(ctx, &deadLetterListRequest{Limit: req.Limit})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list dead letters for the console")
+		return "", ErrInternalError
+	}
+
+	type consoleError struct {
+		at  time.Time
+		row []string
+	}
+	errs := make([]consoleError, 0, len(instances)+len(deadLetters))
+	for _, instance := range instances {
+		ei, err := fmInstance.storageManager.ExtractEdgegapInstance(instance)
+		if err != nil {
+			continue
+		}
+		// Instances errored before the detail was recorded fall back to their creation
+		at := cmp.Or(ei.ErroredAt, instance.CreateTime)
+		errs = append(errs, consoleError{at, []string{consoleTime(at), "instance", instance.Id, ei.ErrorDetail}})
+	}
+	for _, entry := range deadLetters {
+		errs = append(errs, consoleError{entry.ReceivedAt, []string{consoleTime(entry.ReceivedAt), "dead_letter:" + entry.RpcId, entry.Id, entry.Error}})
+	}
+	slices.SortFunc(errs, func(a, b consoleError) int {
+		return b.at.Compare(a.at)
+	})
+
+	table := consoleTable{
+		Columns: []string{"time", "source", "id", "detail"},
+		Rows:    make([][]string, 0, len(errs)),
+	}
+	for _, e := range errs {
+		table.Rows = append(table.Rows, e.row)
+	}
+	return marshalConsoleTable(table, req.Limit)
+}
+
+// ConsoleVersion console rpc reporting the Edgegap version, its source and revision, and the rollout in progress as
+// key and value rows (S2S only)
+func (dvm *DynamicVersionManager) ConsoleVersion(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdConsoleVersion); err != nil {
+		return "", err
+	}
+
+	table := consoleTable{Columns: []string{"key", "value"}, Rows: make([][]string, 0)}
+	add := func(key, value string) {
+		table.Rows = append(table.Rows, []string{key, value})
+	}
+
+	if !dvm.config.DynamicVersioning {
+		add("version", dvm.config.InitialVersion)
+		add(ResponseFieldSource, ResponseSourceStatic)
+		return marshalConsoleTable(table, 0)
+	}
+
+	version, updatedAt, revision, err := dvm.sm.readEdgegapVersionRevision(ctx)
+	if err != nil && !errors.Is(err, ErrorNoVersionFound) {
+		logger.WithField("error", err.Error()).Error("failed to read Edgegap version for the console")
+		return "", ErrInternalError
+	}
+	add("version", version)
+	add(ResponseFieldSource, ResponseSourceDynamic)
+	add("revision", strconv.FormatInt(revision, 10))
+	if updatedAt > 0 {
+		add("updated_at", consoleTime(time.Unix(updatedAt, 0)))
+	}
+
+	rollout, err := dvm.sm.readVersionRollout(ctx)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read version rollout for the console")
+		return "", ErrInternalError
+	}
+	if rollout != nil && rollout.To == version {
+		add("rollout_from", rollout.From)
+		add("rollout_policy", rollout.Policy)
+		add("rollout_share", strconv.Itoa(rollout.share(time.Now()))+"%")
+		add("rollout_started_at", consoleTime(rollout.StartedAt))
+	}
+	return marshalConsoleTable(table, 0)
+}
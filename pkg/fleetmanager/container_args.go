@@ -0,0 +1,105 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CreateMetadataContainerArgsKey holds the container arguments of the deployment by flag, e.g. {"-map": "arena"}.
+// Only server callers can set them.
+const CreateMetadataContainerArgsKey = "container_args"
+
+// containerArgFlag matches the flags of the container arguments, e.g. -map or --tick-rate
+var containerArgFlag = regexp.MustCompile(`^--?[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// containerArgUnsafe matches the characters never allowed in argument values, the arguments being a single string
+// split by the container runtime
+var containerArgUnsafe = regexp.MustCompile("[\\s\"'`\\\\$;|&<>]")
+
+// parseContainerArgsAllowlist parses the EDGEGAP_CONTAINER_ARGS JSON object of value patterns by flag, none when
+// empty. Patterns must match the whole value.
+func parseContainerArgsAllowlist(value string) (map[string]*regexp.Regexp, error) {
+	allowlist := make(map[string]*regexp.Regexp)
+	if strings.TrimSpace(value) == "" {
+		return allowlist, nil
+	}
+
+	var patterns map[string]string
+	if err := json.Unmarshal([]byte(value), &patterns); err != nil {
+		return nil, fmt.Errorf("invalid container args, expects a JSON object of value patterns by flag: %w", err)
+	}
+	for flag, pattern := range patterns {
+		if !containerArgFlag.MatchString(flag) {
+			return nil, fmt.Errorf("invalid container arg flag %q, expects - or -- followed by letters, digits, _, . or -", flag)
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid value pattern of container arg %s: %w", flag, err)
+		}
+		allowlist[flag] = re
+	}
+	return allowlist, nil
+}
+
+// parseContainerArgs reads the container arguments, either decoded from JSON or set by server code as a map of
+// strings.
+func parseContainerArgs(raw any) (map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	args, ok := raw.(map[string]string)
+	if !ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &args); err != nil {
+			return nil, fmt.Errorf("expects an object of string values by flag: %w", err)
+		}
+	}
+	return args, nil
+}
+
+// checkContainerArgs validates the container arguments against the allowlist, returning the invalid flags with the
+// reason.
+func checkContainerArgs(allowlist map[string]*regexp.Regexp, args map[string]string) map[string]string {
+	invalid := make(map[string]string)
+	for flag, value := range args {
+		pattern, ok := allowlist[flag]
+		switch {
+		case !ok:
+			invalid[flag] = "is not allowed by EDGEGAP_CONTAINER_ARGS"
+		case value == "" || containerArgUnsafe.MatchString(value):
+			invalid[flag] = "must be a non-empty value without whitespace, quotes or shell characters"
+		case !pattern.MatchString(value):
+			invalid[flag] = fmt.Sprintf("value %q does not match %s", value, pattern.String())
+		}
+	}
+	return invalid
+}
+
+// containerArguments returns the arguments of the deployment container from the create metadata, empty to keep the
+// arguments of the app version.
+func (em *EdgegapManager) containerArguments(metadata map[string]any) (string, error) {
+	args, err := parseContainerArgs(metadata[CreateMetadataContainerArgsKey])
+	if err != nil || len(args) == 0 {
+		return "", err
+	}
+
+	allowlist, _ := parseContainerArgsAllowlist(em.configuration.ContainerArgs)
+	if invalid := checkContainerArgs(allowlist, args); len(invalid) > 0 {
+		flags := slices.Sorted(maps.Keys(invalid))
+		return "", fmt.Errorf("invalid container arg %s: %s", flags[0], invalid[flags[0]])
+	}
+
+	// Flags are sorted so the same arguments always produce the same command line
+	parts := make([]string, 0, 2*len(args))
+	for _, flag := range slices.Sorted(maps.Keys(args)) {
+		parts = append(parts, flag, args[flag])
+	}
+	return strings.Join(parts, " "), nil
+}
@@ -0,0 +1,74 @@
+package fleetmanager
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseContainerArgsAllowlist(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wantFlags []string
+		wantErr   bool
+	}{
+		{name: "empty", value: "", wantFlags: []string{}},
+		{name: "blank", value: "  ", wantFlags: []string{}},
+		{name: "flags", value: `{"-map": "arena|forest", "--tick-rate": "[0-9]+"}`, wantFlags: []string{"--tick-rate", "-map"}},
+		{name: "not an object", value: `["-map"]`, wantErr: true},
+		{name: "flag without dash", value: `{"map": ".*"}`, wantErr: true},
+		{name: "flag with a space", value: `{"-map name": ".*"}`, wantErr: true},
+		{name: "invalid pattern", value: `{"-map": "("}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowlist, err := parseContainerArgsAllowlist(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseContainerArgsAllowlist() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !slices.Equal(slices.Sorted(maps.Keys(allowlist)), tt.wantFlags) {
+				t.Errorf("parseContainerArgsAllowlist() flags = %v, want %v", slices.Sorted(maps.Keys(allowlist)), tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestCheckContainerArgs(t *testing.T) {
+	allowlist, err := parseContainerArgsAllowlist(`{"-map": "arena|forest", "--name": "[A-Za-z0-9_-]+", "-any": ".*"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		args        map[string]string
+		wantInvalid []string
+	}{
+		{name: "allowed", args: map[string]string{"-map": "arena", "--name": "room_1"}},
+		{name: "pattern matches the whole value", args: map[string]string{"-map": "arena2"}, wantInvalid: []string{"-map"}},
+		{name: "flag not allowed", args: map[string]string{"-map": "forest", "-debug": "1"}, wantInvalid: []string{"-debug"}},
+		{name: "empty value", args: map[string]string{"-any": ""}, wantInvalid: []string{"-any"}},
+		{name: "whitespace", args: map[string]string{"-any": "a b"}, wantInvalid: []string{"-any"}},
+		{name: "tab", args: map[string]string{"-any": "a\tb"}, wantInvalid: []string{"-any"}},
+		{name: "double quote", args: map[string]string{"-any": `a"b`}, wantInvalid: []string{"-any"}},
+		{name: "single quote", args: map[string]string{"-any": "a'b"}, wantInvalid: []string{"-any"}},
+		{name: "backtick", args: map[string]string{"-any": "a`b"}, wantInvalid: []string{"-any"}},
+		{name: "backslash", args: map[string]string{"-any": `a\b`}, wantInvalid: []string{"-any"}},
+		{name: "variable", args: map[string]string{"-any": "$HOME"}, wantInvalid: []string{"-any"}},
+		{name: "command separator", args: map[string]string{"-any": "a;b"}, wantInvalid: []string{"-any"}},
+		{name: "pipe", args: map[string]string{"-any": "a|b"}, wantInvalid: []string{"-any"}},
+		{name: "background", args: map[string]string{"-any": "a&b"}, wantInvalid: []string{"-any"}},
+		{name: "redirection", args: map[string]string{"-any": "a>b", "--name": "<b"}, wantInvalid: []string{"--name", "-any"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalid := checkContainerArgs(allowlist, tt.args)
+			if got := slices.Sorted(maps.Keys(invalid)); !slices.Equal(got, tt.wantInvalid) && len(got)+len(tt.wantInvalid) > 0 {
+				t.Errorf("checkContainerArgs() invalid = %v, want %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package fleetmanager
+
+import (
+	"slices"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Outcomes of a reservation, tagging the edgegap_reservation_outcomes metric
+const (
+	ReservationOutcomeConverted = "converted"
+	ReservationOutcomeExpired   = "expired"
+
+	// conversionUnknown groups instances without a known region or app version, e.g. never ready
+	conversionUnknown = "unknown"
+)
+
+// ConversionStats reports how many reservations converted to connections, a low rate in a region or an app version
+// points to a broken connection flow (e.g. NAT issues).
+type ConversionStats struct {
+	Converted int     `json:"converted"`
+	Expired   int     `json:"expired"`
+	Rate      float64 `json:"rate"`
+}
+
+type conversionStatsReply struct {
+	ConversionStats
+	ByRegion  map[string]ConversionStats `json:"by_region"`
+	ByVersion map[string]ConversionStats `json:"by_version"`
+}
+
+// add counts the reservation outcomes of an instance and refreshes the rate.
+func (cs *ConversionStats) add(converted, expired int) {
+	cs.Converted += converted
+	cs.Expired += expired
+	if total := cs.Converted + cs.Expired; total > 0 {
+		cs.Rate = float64(cs.Converted) / float64(total)
+	}
+}
+
+func newConversionStatsReply() *conversionStatsReply {
+	return &conversionStatsReply{
+		ByRegion:  make(map[string]ConversionStats),
+		ByVersion: make(map[string]ConversionStats),
+	}
+}
+
+// add aggregates the reservation outcomes of an instance in the total, its region and its app version.
+func (r *conversionStatsReply) add(ei *EdgegapInstanceInfo) {
+	if ei.ReservationsConverted == 0 && ei.ReservationsExpired == 0 {
+		return
+	}
+
+	r.ConversionStats.add(ei.ReservationsConverted, ei.ReservationsExpired)
+
+	region := r.ByRegion[ei.conversionRegion()]
+	region.add(ei.ReservationsConverted, ei.ReservationsExpired)
+	r.ByRegion[ei.conversionRegion()] = region
+
+	version := r.ByVersion[ei.conversionVersion()]
+	version.add(ei.ReservationsConverted, ei.ReservationsExpired)
+	r.ByVersion[ei.conversionVersion()] = version
+}
+
+// conversionRegion returns the continent of the deployment, the region instances are filtered by.
+func (ei *EdgegapInstanceInfo) conversionRegion() string {
+	if ei.Location == nil || ei.Location.Continent == "" {
+		return conversionUnknown
+	}
+	return ei.Location.Continent
+}
+
+func (ei *EdgegapInstanceInfo) conversionVersion() string {
+	if ei.Version == "" {
+		return conversionUnknown
+	}
+	return ei.Version
+}
+
+// convertReservations counts the reservations consumed by the connections, to be called before they are removed.
+func (ei *EdgegapInstanceInfo) convertReservations(connections []string) int {
+	converted := 0
+	for _, userId := range ei.Reservations {
+		if slices.Contains(connections, userId) {
+			converted++
+		}
+	}
+	ei.ReservationsConverted += converted
+	return converted
+}
+
+// expireReservations counts the pending reservations as expired, to be called before they are cleared.
+func (ei *EdgegapInstanceInfo) expireReservations() int {
+	expired := len(ei.Reservations)
+	ei.ReservationsExpired += expired
+	return expired
+}
+
+// reportReservationOutcomes records the reservation outcomes in the metrics, tagged by region and app version.
+func reportReservationOutcomes(nk runtime.NakamaModule, ei *EdgegapInstanceInfo, outcome string, count int) {
+	if count == 0 {
+		return
+	}
+	nk.MetricsCounterAdd("edgegap_reservation_outcomes", map[string]string{
+		"region":  ei.conversionRegion(),
+		"version": ei.conversionVersion(),
+		"outcome": outcome,
+	}, int64(count))
+}
@@ -0,0 +1,117 @@
+package fleetmanager
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// CreateMetadataReplaceOnCrashKey is the create metadata key overriding NAKAMA_CRASH_REPLACEMENT for the instance
+	CreateMetadataReplaceOnCrashKey = "replace_on_crash"
+	// CreateMetadataReplacementOfKey is set in the metadata of a replacement to the crashed instance it replaces
+	CreateMetadataReplacementOfKey = "replacement_of"
+)
+
+// replaceOnCrash reports whether the instance is replaced when it crashes with connected players, from its create
+// metadata, falling back to the configuration.
+func replaceOnCrash(config *EdgegapManagerConfiguration, instance *runtime.InstanceInfo) bool {
+	switch v := instance.Metadata[CreateMetadataReplaceOnCrashKey].(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return config.CrashReplacement
+}
+
+// replacementMetadata copies the create metadata of the crashed instance for its replacement, without the fleet
+// manager and game server specific keys, nor the rental and deferred start keys: the players already paid and are
+// waiting to reconnect.
+func replacementMetadata(instance *runtime.InstanceInfo) map[string]any {
+	metadata := make(map[string]any, len(instance.Metadata))
+	for k, v := range instance.Metadata {
+		switch k {
+		case "edgegap", InstanceMetadataCallbackUrl, InstanceMetadataHeartbeatUrl, UpdateMetadataInstanceTokenKey,
+			InstanceMetadataPurchaseKey, CreateMetadataRentalKey, CreateMetadataDeferredStartKey, CreateMetadataMinPlayersKey:
+			continue
+		}
+		metadata[k] = v
+	}
+	metadata[CreateMetadataReplacementOfKey] = instance.Id
+	return metadata
+}
+
+// replaceCrashed deploys a replacement of a READY instance that turned ERROR with connected players, with the same
+// metadata and seats. Its connected players and pending reservations are reserved on the replacement, told right away
+// that the instance is being replaced and get the connection info of the replacement once it is ready. Persistent
+// instances are migrated with admin_persistent_migrate instead.
+func (eem *EdgegapEventManager) replaceCrashed(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, instance *runtime.InstanceInfo) {
+	if !replaceOnCrash(eem.config, instance) {
+		return
+	}
+
+	ei, err := eem.sm.ExtractEdgegapInstance(instance)
+	if err != nil || len(ei.Connections) == 0 || ei.Persistent || ei.ReplacedBy != "" {
+		return
+	}
+
+	userIds := slices.Clone(ei.Connections)
+	for _, userId := range ei.Reservations {
+		if !slices.Contains(userIds, userId) {
+			userIds = append(userIds, userId)
+		}
+	}
+
+	secret := eem.config.NakamaHttpKey
+	var callback runtime.FmCreateCallbackFn = func(status runtime.FmCreateStatus, replacement *runtime.InstanceInfo, sessionInfo []*runtime.SessionInfo, metadata map[string]any, createErr error) {
+		if status != runtime.CreateSuccess {
+			logger.WithField("error", createErr).Error("failed to deploy the replacement of crashed instance %s", instance.Id)
+			nk.MetricsCounterAdd("edgegap_crash_replacements", map[string]string{"outcome": "failed"}, 1)
+			return
+		}
+
+		nk.MetricsCounterAdd("edgegap_crash_replacements", map[string]string{"outcome": "ready"}, 1)
+		err := sendNotifications(fmInstance.ctx, logger, nk, replacement, "connection-info", notificationConnectionInfo, userIds, func(userId string) map[string]interface{} {
+			return connectionInfoContent(replacement, reservationToken(secret, replacement.Id, userId))
+		})
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("failed to notify the players of crashed instance %s", instance.Id)
+		}
+	}
+
+	result, err := fmInstance.Create(ctx, ei.MaxPlayers, userIds, nil, replacementMetadata(instance), callback)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to replace crashed instance %s", instance.Id)
+		nk.MetricsCounterAdd("edgegap_crash_replacements", map[string]string{"outcome": "failed"}, 1)
+		return
+	}
+	replacementId := result[InstanceIdKey]
+
+	// Duplicate error events must not deploy another replacement
+	ei.ReplacedBy = replacementId
+	instance.Metadata["edgegap"] = ei
+	if err = eem.sm.updateDbInstance(ctx, instance); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to link crashed instance %s to its replacement %s", instance.Id, replacementId)
+	}
+
+	eem.webhooks.Dispatch(WebhookEventInstanceReplaced, fmt.Sprintf("Instance %s crashed with %d players, replaced by %s", instance.Id, len(ei.Connections), replacementId), map[string]string{
+		"instance_id":    instance.Id,
+		"replacement_id": replacementId,
+	})
+
+	err = sendNotifications(ctx, logger, nk, instance, "instance-replaced", notificationInstanceReplaced, userIds, func(userId string) map[string]interface{} {
+		return map[string]interface{}{
+			"InstanceId":    instance.Id,
+			"ReplacementId": replacementId,
+		}
+	})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to notify the players of crashed instance %s", instance.Id)
+	}
+
+	logger.Warn("Instance %s crashed with %d players, replaced by %s", instance.Id, len(ei.Connections), replacementId)
+}
@@ -0,0 +1,168 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// StorageKeyCreateGuard is the key of the last create of each user in the creates collection
+const StorageKeyCreateGuard = "last_create"
+
+// ErrorCreateInProgress is returned when another create of the same user is still being requested
+var ErrorCreateInProgress = errors.New("a create request of this user is already in progress")
+
+// EdgegapCreateRecord is the last create of a user, replayed to duplicate creates within the guard window
+type EdgegapCreateRecord struct {
+	IdempotencyKey string    `json:"idempotency_key,omitempty"`
+	DeploymentId   string    `json:"deployment_id,omitempty"`
+	InstanceId     string    `json:"instance_id,omitempty"`
+	CallbackId     string    `json:"callback_id,omitempty"`
+	JoinCode       string    `json:"join_code,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// createGuard suppresses duplicate creates of a user, e.g. a double click on "Play" firing two instance_create
+type createGuard struct {
+	sm      *StorageManager
+	userId  string
+	version string
+}
+
+// instanceId returns the instance the record points to, the pending instance for deferred starts.
+func (r *EdgegapCreateRecord) instanceId() string {
+	if r.InstanceId != "" {
+		return r.InstanceId
+	}
+	return r.DeploymentId
+}
+
+// claimCreate returns the previous create of the user when the request duplicates it: same idempotency key, or an
+// instance still being deployed. Otherwise the create is claimed for the user and the returned guard must be
+// released with the create result.
+func (sm *StorageManager) claimCreate(ctx context.Context, userId, idempotencyKey string, window time.Duration) (*EdgegapCreateRecord, *createGuard, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: sm.createsCollection,
+		Key:        StorageKeyCreateGuard,
+		UserID:     userId,
+	}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only write the claim if nobody claimed (or replaced) the record since it was read
+	version := "*"
+	if len(objects) > 0 {
+		version = objects[0].Version
+
+		var previous EdgegapCreateRecord
+		if err = json.Unmarshal([]byte(objects[0].Value), &previous); err != nil {
+			return nil, nil, err
+		}
+		if time.Since(previous.CreatedAt) < window {
+			duplicate, err := sm.isDuplicateCreate(ctx, &previous, idempotencyKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			if duplicate {
+				if previous.instanceId() == "" {
+					return nil, nil, ErrorCreateInProgress
+				}
+				return &previous, nil, nil
+			}
+		}
+	}
+
+	guard := &createGuard{sm: sm, userId: userId}
+	guard.version, err = guard.write(ctx, &EdgegapCreateRecord{IdempotencyKey: idempotencyKey, CreatedAt: time.Now().UTC()}, version)
+	if err != nil {
+		// Another create of the user claimed the record first
+		sm.logger.WithField("error", err.Error()).Debug("failed to claim create for user %s", userId)
+		return nil, nil, ErrorCreateInProgress
+	}
+
+	return nil, guard, nil
+}
+
+// isDuplicateCreate reports whether a create within the window duplicates the previous one.
+func (sm *StorageManager) isDuplicateCreate(ctx context.Context, previous *EdgegapCreateRecord, idempotencyKey string) (bool, error) {
+	if idempotencyKey != "" && previous.IdempotencyKey == idempotencyKey {
+		return true, nil
+	}
+
+	// Still being claimed by a concurrent create
+	if previous.instanceId() == "" {
+		return true, nil
+	}
+
+	instance, err := sm.getDbInstance(ctx, previous.instanceId())
+	if err != nil || instance == nil {
+		return false, err
+	}
+	switch instance.Status {
+	case EdgegapStatusPending, EdgegapStatusRequested, EdgegapStatusRunning:
+		return true, nil
+	}
+	return false, nil
+}
+
+// write stores the record with the given version, returning its new version.
+func (g *createGuard) write(ctx context.Context, record *EdgegapCreateRecord, version string) (string, error) {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	acks, err := g.sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      g.sm.createsCollection,
+			Key:             StorageKeyCreateGuard,
+			UserID:          g.userId,
+			Value:           string(value),
+			Version:         version,
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(acks) == 0 {
+		return "", errors.New("no storage write ack")
+	}
+	return acks[0].Version, nil
+}
+
+// release records the created instance for the duplicates to come, or frees the claim when the create failed.
+func (g *createGuard) release(ctx context.Context, idempotencyKey string, result map[string]string, createErr error) {
+	if g == nil {
+		return
+	}
+
+	if createErr != nil {
+		if err := g.sm.nk.StorageDelete(ctx, []*runtime.StorageDelete{{
+			Collection: g.sm.createsCollection,
+			Key:        StorageKeyCreateGuard,
+			UserID:     g.userId,
+			Version:    g.version,
+		}}); err != nil {
+			g.sm.logger.WithField("error", err.Error()).Warn("failed to release create claim of user %s", g.userId)
+		}
+		return
+	}
+
+	record := &EdgegapCreateRecord{
+		IdempotencyKey: idempotencyKey,
+		DeploymentId:   result[DeploymentIdKey],
+		InstanceId:     result[InstanceIdKey],
+		CallbackId:     result[CallbackIdKey],
+		JoinCode:       result[JoinCodeKey],
+		CreatedAt:      time.Now().UTC(),
+	}
+	if _, err := g.write(ctx, record, g.version); err != nil {
+		g.sm.logger.WithField("error", err.Error()).Warn("failed to record create of user %s", g.userId)
+	}
+}
@@ -0,0 +1,163 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdUpdateEdgegapCredentials = "update_edgegap_credentials"
+
+	// credentialsRefreshInterval is how often stored credentials are checked, so every Nakama node picks up a rotation
+	credentialsRefreshInterval = 30 * time.Second
+
+	// Log messages
+	LogMessageCredentialsRotated = "Edgegap API token rotated"
+)
+
+type UpdateEdgegapCredentialsRequest struct {
+	ApiToken string `json:"api_token"`
+}
+
+// CredentialsManager resolves the Edgegap API token from storage, allowing rotation without restart
+type CredentialsManager struct {
+	config    *EdgegapManagerConfiguration
+	sm        *StorageManager
+	apiHelper *helpers.APIClient
+	logger    runtime.Logger
+
+	// mu serializes the refreshes of the worker and the rotate rpc
+	mu        sync.Mutex
+	updatedAt int64
+}
+
+// NewCredentialsManager creates a new CredentialsManager, applies stored credentials and keeps them refreshed
+func NewCredentialsManager(ctx context.Context, config *EdgegapManagerConfiguration, sm *StorageManager, apiHelper *helpers.APIClient, logger runtime.Logger) *CredentialsManager {
+	cm := &CredentialsManager{
+		config:    config,
+		sm:        sm,
+		apiHelper: apiHelper,
+		logger:    logger,
+	}
+
+	cm.refresh(ctx)
+	go cm.refreshWorker(ctx)
+
+	return cm
+}
+
+// refresh applies the stored token to the API client if it changed since the last refresh
+func (cm *CredentialsManager) refresh(ctx context.Context) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	encryptedToken, updatedAt, err := cm.sm.ReadEdgegapCredentials(ctx)
+	if err != nil {
+		if !errors.Is(err, ErrorNoCredentialsFound) {
+			cm.logger.WithField("error", err.Error()).Warn("failed to read Edgegap credentials from storage")
+		}
+		return
+	}
+
+	if updatedAt == cm.updatedAt {
+		return
+	}
+
+	token, err := helpers.DecryptString(cm.config.EncryptionKey, encryptedToken)
+	if err != nil {
+		cm.logger.WithField("error", err.Error()).Error("failed to decrypt Edgegap credentials from storage")
+		return
+	}
+
+	cm.apiHelper.SetAuthToken(token)
+	cm.updatedAt = updatedAt
+	cm.logger.Info("Using Edgegap API token from storage")
+}
+
+func (cm *CredentialsManager) refreshWorker(ctx context.Context) {
+	t := time.NewTicker(credentialsRefreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			cm.refresh(ctx)
+		}
+	}
+}
+
+// validateToken checks the token can access the configured application
+func (cm *CredentialsManager) validateToken(token string) error {
+	reply, err := helpers.NewAPIClient(cm.config.ApiUrl, token).Get(fmt.Sprintf("/v1/app/%s", cm.config.Application))
+	if err != nil {
+		return runtime.NewError(fmt.Sprintf("failed to connect to Edgegap API: %v", err), 13) // INTERNAL
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return runtime.NewError(fmt.Sprintf("token cannot access application '%s', status: %s", cm.config.Application, reply.Status), 9) // FAILED_PRECONDITION
+	}
+
+	return nil
+}
+
+// UpdateEdgegapCredentials validates and stores a new Edgegap API token (S2S only)
+// The token is encrypted with the Nakama session encryption key before being stored.
+func (cm *CredentialsManager) UpdateEdgegapCredentials(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if _, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); ok {
+		logger.Warn(LogMessageClientAttemptedS2S + " for Edgegap credentials update")
+		return "", runtime.NewError(ErrorMessageUnauthorized, 7) // PERMISSION_DENIED
+	}
+
+	request := &UpdateEdgegapCredentialsRequest{}
+	if err := json.Unmarshal([]byte(payload), request); err != nil {
+		return "", runtime.NewError("invalid payload format", 3) // INVALID_ARGUMENT
+	}
+
+	token := strings.TrimSpace(request.ApiToken)
+	if token == "" {
+		return "", runtime.NewError("api_token cannot be empty", 3) // INVALID_ARGUMENT
+	}
+
+	if err := cm.validateToken(token); err != nil {
+		logger.Error("Failed to validate Edgegap token: %v", err)
+		return "", err
+	}
+
+	encryptedToken, err := helpers.EncryptString(cm.config.EncryptionKey, token)
+	if err != nil {
+		logger.Error("Failed to encrypt Edgegap token: %v", err)
+		return "", runtime.NewError("failed to store credentials", 13) // INTERNAL
+	}
+
+	if err = cm.sm.WriteEdgegapCredentials(ctx, encryptedToken); err != nil {
+		logger.Error("Failed to store Edgegap credentials: %v", err)
+		return "", runtime.NewError("failed to store credentials", 13) // INTERNAL
+	}
+
+	cm.refresh(ctx)
+	logger.Info(LogMessageCredentialsRotated)
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Edgegap API token updated successfully. Will be used for subsequent calls immediately.",
+	}
+
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return "", runtime.NewError("failed to marshal response", 13) // INTERNAL
+	}
+
+	return string(responseBytes), nil
+}
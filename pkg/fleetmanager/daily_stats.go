@@ -0,0 +1,302 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdFleetDailyStats reports the daily aggregates of the fleet events (S2S only)
+const RpcIdFleetDailyStats = "fleet_daily_stats"
+
+// Fleet events aggregated in the daily stats
+const (
+	DailyStatCreated    = "created"
+	DailyStatReady      = "ready"
+	DailyStatErrors     = "errors"
+	DailyStatTerminated = "terminated"
+)
+
+const (
+	// dailyStatsFlushInterval is how often each node adds the events it counted to the stored aggregates
+	dailyStatsFlushInterval = 30 * time.Second
+	// dailyStatsMaxDays caps the range of days returned by fleet_daily_stats
+	dailyStatsMaxDays = 366
+	// dailyStatsDateLayout formats the day of the aggregates, their storage key
+	dailyStatsDateLayout = "2006-01-02"
+)
+
+// EdgegapDailyCounts counts the fleet events of a day
+type EdgegapDailyCounts struct {
+	Created    int64 `json:"created"`
+	Ready      int64 `json:"ready"`
+	Errors     int64 `json:"errors"`
+	Terminated int64 `json:"terminated"`
+	// TimeToReadyMs sums the time to ready of the ready deployments, AvgTimeToReadyMs averages it
+	TimeToReadyMs    int64 `json:"time_to_ready_ms"`
+	AvgTimeToReadyMs int64 `json:"avg_time_to_ready_ms"`
+}
+
+// EdgegapDailyStats aggregates the fleet events of a UTC day, in total and by region
+type EdgegapDailyStats struct {
+	Date string `json:"date"`
+	EdgegapDailyCounts
+	ByRegion  map[string]EdgegapDailyCounts `json:"by_region"`
+	UpdatedAt time.Time                     `json:"updated_at"`
+}
+
+type fleetDailyStatsRequest struct {
+	// Since and Until are the first and last days reported, as YYYY-MM-DD, the last 7 days by default
+	Since string `json:"since"`
+	Until string `json:"until"`
+}
+
+type fleetDailyStatsReply struct {
+	Days []*EdgegapDailyStats `json:"days"`
+}
+
+// add counts an event, its duration only summed for ready deployments.
+func (c *EdgegapDailyCounts) add(stat string, n int64, timeToReady time.Duration) {
+	switch stat {
+	case DailyStatCreated:
+		c.Created += n
+	case DailyStatReady:
+		c.Ready += n
+		c.TimeToReadyMs += timeToReady.Milliseconds()
+	case DailyStatErrors:
+		c.Errors += n
+	case DailyStatTerminated:
+		c.Terminated += n
+	}
+	c.refresh()
+}
+
+// merge adds the counts of other.
+func (c *EdgegapDailyCounts) merge(other EdgegapDailyCounts) {
+	c.Created += other.Created
+	c.Ready += other.Ready
+	c.Errors += other.Errors
+	c.Terminated += other.Terminated
+	c.TimeToReadyMs += other.TimeToReadyMs
+	c.refresh()
+}
+
+func (c *EdgegapDailyCounts) refresh() {
+	if c.Ready > 0 {
+		c.AvgTimeToReadyMs = c.TimeToReadyMs / c.Ready
+	}
+}
+
+// merge adds the aggregates of other, of the same day.
+func (s *EdgegapDailyStats) merge(other *EdgegapDailyStats) {
+	s.EdgegapDailyCounts.merge(other.EdgegapDailyCounts)
+	for region, counts := range other.ByRegion {
+		merged := s.ByRegion[region]
+		merged.merge(counts)
+		s.ByRegion[region] = merged
+	}
+}
+
+func newDailyStats(date string) *EdgegapDailyStats {
+	return &EdgegapDailyStats{Date: date, ByRegion: make(map[string]EdgegapDailyCounts)}
+}
+
+// dailyStats counts the fleet events of the node in memory, added to the stored aggregates of their day every flush
+type dailyStats struct {
+	sm      *StorageManager
+	mu      sync.Mutex
+	pending map[string]*EdgegapDailyStats
+}
+
+// EnableDailyStats aggregates the fleet events by day in the daily stats collection.
+func (sm *StorageManager) EnableDailyStats(ctx context.Context) {
+	sm.dailyStats = &dailyStats{
+		sm:      sm,
+		pending: make(map[string]*EdgegapDailyStats),
+	}
+	go sm.dailyStats.run(ctx)
+	sm.logger.Info("Aggregating the fleet events by day in the %s collection", sm.dailyStatsCollection)
+}
+
+// recordDailyStat counts a fleet event of the instance in the aggregates of the day, by the region of its deployment
+// once known.
+func (sm *StorageManager) recordDailyStat(stat string, ei *EdgegapInstanceInfo, timeToReady time.Duration) {
+	ds := sm.dailyStats
+	if ds == nil {
+		return
+	}
+
+	date := time.Now().UTC().Format(dailyStatsDateLayout)
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stats, ok := ds.pending[date]
+	if !ok {
+		stats = newDailyStats(date)
+		ds.pending[date] = stats
+	}
+	stats.add(stat, 1, timeToReady)
+	if ei != nil && ei.Location != nil {
+		region := stats.ByRegion[ei.conversionRegion()]
+		region.add(stat, 1, timeToReady)
+		stats.ByRegion[ei.conversionRegion()] = region
+	}
+}
+
+func (ds *dailyStats) run(ctx context.Context) {
+	t := time.NewTicker(dailyStatsFlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Events counted since the last flush are added before stopping
+			ds.flush(context.Background())
+			return
+		case <-t.C:
+			ds.flush(ctx)
+		}
+	}
+}
+
+// flush adds the pending counts to the stored aggregates of their day. Counts failing to be added are kept for the
+// next flush.
+func (ds *dailyStats) flush(ctx context.Context) {
+	ds.mu.Lock()
+	pending := ds.pending
+	ds.pending = make(map[string]*EdgegapDailyStats)
+	ds.mu.Unlock()
+
+	for date, stats := range pending {
+		if err := ds.sm.addDailyStats(ctx, stats); err != nil {
+			ds.sm.logger.WithFields(map[string]any{"date": date, "error": err.Error()}).Warn("failed to store daily stats, retrying next flush")
+			ds.mu.Lock()
+			if current, ok := ds.pending[date]; ok {
+				stats.merge(current)
+			}
+			ds.pending[date] = stats
+			ds.mu.Unlock()
+		}
+	}
+}
+
+// addDailyStats adds the counts to the stored aggregates of their day, retrying when another node added its own
+// meanwhile.
+func (sm *StorageManager) addDailyStats(ctx context.Context, delta *EdgegapDailyStats) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var objects []*api.StorageObject
+		objects, err = sm.nk.StorageRead(ctx, []*runtime.StorageRead{{Collection: sm.dailyStatsCollection, Key: delta.Date}})
+		if err != nil {
+			return err
+		}
+
+		stats, version := newDailyStats(delta.Date), "*"
+		if len(objects) > 0 {
+			if err = json.Unmarshal([]byte(objects[0].Value), stats); err != nil {
+				return err
+			}
+			if stats.ByRegion == nil {
+				stats.ByRegion = make(map[string]EdgegapDailyCounts)
+			}
+			version = objects[0].Version
+		}
+		stats.merge(delta)
+		stats.UpdatedAt = time.Now().UTC()
+
+		value, marshalErr := json.Marshal(stats)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		if _, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+			Collection:      sm.dailyStatsCollection,
+			Key:             delta.Date,
+			Value:           string(value),
+			Version:         version,
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		}}); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// readDailyStats reads the stored aggregates of the days from since to until, oldest first, skipping days without
+// events.
+func (sm *StorageManager) readDailyStats(ctx context.Context, since, until time.Time) ([]*EdgegapDailyStats, error) {
+	reads := make([]*runtime.StorageRead, 0)
+	for day := since; !day.After(until); day = day.AddDate(0, 0, 1) {
+		reads = append(reads, &runtime.StorageRead{Collection: sm.dailyStatsCollection, Key: day.Format(dailyStatsDateLayout)})
+	}
+
+	byDate := make(map[string]*EdgegapDailyStats, len(reads))
+	objects, err := sm.nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objects {
+		stats := newDailyStats(obj.Key)
+		if err = json.Unmarshal([]byte(obj.Value), stats); err != nil {
+			return nil, err
+		}
+		byDate[obj.Key] = stats
+	}
+
+	days := make([]*EdgegapDailyStats, 0, len(byDate))
+	for _, read := range reads {
+		if stats, ok := byDate[read.Key]; ok {
+			days = append(days, stats)
+		}
+	}
+	return days, nil
+}
+
+// fleetDailyStats S2S rpc reporting the daily aggregates of the fleet events, for ops reporting without exporting the
+// instance records
+func fleetDailyStats(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdFleetDailyStats); err != nil {
+		return "", err
+	}
+
+	var req fleetDailyStatsRequest
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", ErrInvalidInput
+		}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	until, since := today, today.AddDate(0, 0, -6)
+	var err error
+	if req.Until != "" {
+		if until, err = time.Parse(dailyStatsDateLayout, req.Until); err != nil {
+			return "", runtime.NewError("until expects a YYYY-MM-DD date", 3) // INVALID_ARGUMENT
+		}
+		since = until.AddDate(0, 0, -6)
+	}
+	if req.Since != "" {
+		if since, err = time.Parse(dailyStatsDateLayout, req.Since); err != nil {
+			return "", runtime.NewError("since expects a YYYY-MM-DD date", 3) // INVALID_ARGUMENT
+		}
+	}
+	if since.After(until) || until.Sub(since) >= dailyStatsMaxDays*24*time.Hour {
+		return "", runtime.NewError("since must be before until, at most 366 days apart", 3) // INVALID_ARGUMENT
+	}
+
+	days, err := fmInstance.storageManager.readDailyStats(ctx, since, until)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read daily stats")
+		return "", ErrInternalError
+	}
+
+	replyString, err := json.Marshal(fleetDailyStatsReply{Days: days})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyString), nil
+}
@@ -0,0 +1,287 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// RpcIdDeadLetterList lists the webhook payloads that failed to parse
+	RpcIdDeadLetterList = "dead_letter_list"
+	// RpcIdDeadLetterReplay replays a dead letter through its webhook handler, or discards it
+	RpcIdDeadLetterReplay = "dead_letter_replay"
+
+	// deadLetterMaxPayload caps the payload stored, larger payloads are kept truncated for inspection only
+	deadLetterMaxPayload = 64 * 1_024
+)
+
+// deadLetterReplayKey marks the context of a replay, a payload failing again is not stored as a dead letter
+type deadLetterReplayKey struct{}
+
+// EdgegapDeadLetter is a webhook payload the plugin failed to parse, kept until replayed or discarded
+type EdgegapDeadLetter struct {
+	Id            string     `json:"id"`
+	RpcId         string     `json:"rpc_id"`
+	Payload       string     `json:"payload"`
+	Truncated     bool       `json:"truncated,omitempty"`
+	Error         string     `json:"error"`
+	ReceivedAt    time.Time  `json:"received_at"`
+	Attempts      int        `json:"attempts"`
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+}
+
+type deadLetterListRequest struct {
+	RpcId  string `json:"rpc_id"`
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+}
+
+type deadLetterListReply struct {
+	Entries []*EdgegapDeadLetter `json:"entries"`
+	Cursor  string               `json:"cursor"`
+}
+
+type deadLetterReplayRequest struct {
+	Id string `json:"id"`
+	// Payload replaces the stored payload, e.g. corrected by hand
+	Payload string `json:"payload"`
+	// Discard deletes the dead letter without replaying it
+	Discard bool `json:"discard"`
+}
+
+type deadLetterReplayReply struct {
+	Id        string `json:"id"`
+	Replayed  bool   `json:"replayed"`
+	Discarded bool   `json:"discarded"`
+	Reply     string `json:"reply,omitempty"`
+}
+
+// replayableHandler returns the webhook handler of the rpc, the lifecycle webhooks stored when they fail to parse and
+// replayed by admins.
+func (eem *EdgegapEventManager) replayableHandler(rpcId string) (rpcFunction, bool) {
+	switch rpcId {
+	case RpcIdEventDeploymentReady:
+		return eem.handleDeploymentReadyEvent, true
+	case RpcIdEventDeploymentError:
+		return eem.handleDeploymentErrorEvent, true
+	case RpcIdEventDeploymentTerminated:
+		return eem.handleDeploymentTerminatedEvent, true
+	case RpcIdEventConnection:
+		return eem.handleConnectionEvent, true
+	case RpcIdEventInstance:
+		return eem.handleInstanceEvent, true
+	case RpcIdEventInstanceUpdate:
+		return eem.handleInstanceUpdateEvent, true
+	}
+	return nil, false
+}
+
+// isDeadLetterReplay reports whether the webhook is replayed by an admin, a dead letter or a historical payload.
+func isDeadLetterReplay(ctx context.Context) bool {
+	replaying, _ := ctx.Value(deadLetterReplayKey{}).(bool)
+	return replaying
+}
+
+// deadLetter stores the webhook payload that failed to parse in the dead letter collection and returns the parse
+// error, so the webhook still fails. Payloads failing again on replay are not stored twice.
+func (eem *EdgegapEventManager) deadLetter(ctx context.Context, rpcId string, payload string, err error) error {
+	if isDeadLetterReplay(ctx) {
+		return err
+	}
+
+	entry := &EdgegapDeadLetter{
+		RpcId:      rpcId,
+		Payload:    payload,
+		Error:      err.Error(),
+		ReceivedAt: time.Now().UTC(),
+	}
+	if len(payload) > deadLetterMaxPayload {
+		entry.Payload = payload[:deadLetterMaxPayload]
+		entry.Truncated = true
+	}
+	entry.Id = chronologicalKey(entry.ReceivedAt)
+
+	if writeErr := eem.sm.writeDeadLetter(ctx, entry); writeErr != nil {
+		eem.sm.logger.WithFields(map[string]any{"error": writeErr.Error(), "rpc_id": rpcId}).Error("failed to store dead letter, webhook payload lost")
+		return err
+	}
+
+	eem.sm.nk.MetricsCounterAdd("edgegap_dead_letters", map[string]string{"rpc": rpcId}, 1)
+	eem.sm.logger.WithFields(map[string]any{"error": err.Error(), "rpc_id": rpcId, "dead_letter_id": entry.Id}).Error("failed to parse webhook payload, stored as dead letter")
+	return err
+}
+
+// writeDeadLetter stores the dead letter under its id.
+func (sm *StorageManager) writeDeadLetter(ctx context.Context, entry *EdgegapDeadLetter) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      sm.deadLetterCollection,
+		Key:             entry.Id,
+		Value:           string(value),
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}})
+	return err
+}
+
+// readDeadLetter returns the dead letter, nil if missing.
+func (sm *StorageManager) readDeadLetter(ctx context.Context, id string) (*EdgegapDeadLetter, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: sm.deadLetterCollection,
+		Key:        id,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	var entry EdgegapDeadLetter
+	if err = json.Unmarshal([]byte(objects[0].Value), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// deleteDeadLetter removes the dead letter once replayed or discarded.
+func (sm *StorageManager) deleteDeadLetter(ctx context.Context, id string) error {
+	return sm.nk.StorageDelete(ctx, []*runtime.StorageDelete{{
+		Collection: sm.deadLetterCollection,
+		Key:        id,
+	}})
+}
+
+// listDeadLetters lists the dead letters oldest first, of the rpc if set.
+func (sm *StorageManager) listDeadLetters(ctx context.Context, req *deadLetterListRequest) ([]*EdgegapDeadLetter, string, error) {
+	objects, cursor, err := sm.nk.StorageList(ctx, "", "", sm.deadLetterCollection, req.Limit, req.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entries := make([]*EdgegapDeadLetter, 0, len(objects))
+	for _, obj := range objects {
+		var entry EdgegapDeadLetter
+		if err = json.Unmarshal([]byte(obj.Value), &entry); err != nil {
+			sm.logger.WithField("error", err.Error()).Warn("failed to parse dead letter %s", obj.Key)
+			continue
+		}
+		if req.RpcId == "" || entry.RpcId == req.RpcId {
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, cursor, nil
+}
+
+// listDeadLetters admin rpc listing the webhook payloads that failed to parse (S2S only)
+func (eem *EdgegapEventManager) listDeadLetters(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdDeadLetterList); err != nil {
+		return "", err
+	}
+
+	req := &deadLetterListRequest{}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), req); err != nil {
+			return "", ErrInvalidInput
+		}
+	}
+	if req.Limit <= 0 {
+		req.Limit = 100
+	}
+	req.Limit = min(req.Limit, 1_000)
+
+	entries, cursor, err := eem.sm.listDeadLetters(ctx, req)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list dead letters")
+		return "", ErrInternalError
+	}
+
+	reply, err := json.Marshal(deadLetterListReply{Entries: entries, Cursor: cursor})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
+
+// replayStoredDeadLetter runs the dead letter through its webhook handler, with the payload if set. It is deleted once
+// replayed, a failed replay is kept with the new error.
+func (eem *EdgegapEventManager) replayStoredDeadLetter(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, entry *EdgegapDeadLetter, payload string) (string, error) {
+	handler, ok := eem.replayableHandler(entry.RpcId)
+	if !ok {
+		return "", runtime.NewError("dead letters of "+entry.RpcId+" cannot be replayed", 9) // FAILED_PRECONDITION
+	}
+
+	if payload == "" {
+		payload = entry.Payload
+	}
+
+	result, replayErr := handler(context.WithValue(ctx, deadLetterReplayKey{}, true), logger, db, nk, payload)
+	if replayErr != nil {
+		now := time.Now().UTC()
+		entry.Attempts++
+		entry.LastAttemptAt = &now
+		entry.Error = replayErr.Error()
+		if err := eem.sm.writeDeadLetter(ctx, entry); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to update dead letter")
+		}
+		return "", runtime.NewError("replay failed: "+replayErr.Error(), 9) // FAILED_PRECONDITION
+	}
+
+	if err := eem.sm.deleteDeadLetter(ctx, entry.Id); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to delete replayed dead letter")
+	}
+	logger.Info("Replayed dead letter %s of %s", entry.Id, entry.RpcId)
+	return result, nil
+}
+
+// replayDeadLetter admin rpc replaying a dead letter through its webhook handler, deleted once it succeeds (S2S only)
+func (eem *EdgegapEventManager) replayDeadLetter(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdDeadLetterReplay); err != nil {
+		return "", err
+	}
+
+	var req *deadLetterReplayRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil || req.Id == "" {
+		return "", ErrInvalidInput
+	}
+
+	entry, err := eem.sm.readDeadLetter(ctx, req.Id)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read dead letter")
+		return "", ErrInternalError
+	}
+	if entry == nil {
+		return "", runtime.NewError("no dead letter found with id "+req.Id, 5) // NOT_FOUND
+	}
+
+	reply := deadLetterReplayReply{Id: entry.Id}
+	if req.Discard {
+		if err = eem.sm.deleteDeadLetter(ctx, entry.Id); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to delete dead letter")
+			return "", ErrInternalError
+		}
+		logger.Info("Discarded dead letter %s of %s", entry.Id, entry.RpcId)
+		reply.Discarded = true
+	} else {
+		result, err := eem.replayStoredDeadLetter(ctx, logger, db, nk, entry, req.Payload)
+		if err != nil {
+			return "", err
+		}
+		reply.Replayed = true
+		reply.Reply = result
+	}
+
+	replyJson, err := json.Marshal(reply)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyJson), nil
+}
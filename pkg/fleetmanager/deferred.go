@@ -0,0 +1,308 @@
+package fleetmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Create metadata and reply keys for deferred start
+const (
+	CreateMetadataDeferredStartKey = "deferred_start"
+	CreateMetadataMinPlayersKey    = "min_players"
+	InstanceIdKey                  = "instance_id"
+	JoinCodeKey                    = "join_code"
+)
+
+const (
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	joinCodeLength   = 6
+)
+
+// deferredStartTimeout is how long a start can hold the STARTING claim of a pending instance, claims left by a node
+// that stopped mid start are cancelled past it whatever NAKAMA_PENDING_MAX_DURATION is
+const deferredStartTimeout = 5 * time.Minute
+
+// ErrorInstanceNotPending is returned when starting an instance that was not created with deferred start or is already started
+var ErrorInstanceNotPending = errors.New("instance is not pending a deferred start")
+
+// ErrorInstanceStarting is returned when joining a pending instance while its deployment is being created
+var ErrorInstanceStarting = errors.New("instance is starting, join its deployment once started")
+
+// isDeferredCreate reports whether the create metadata asks for a deferred start, either explicitly or with a min players gate.
+func isDeferredCreate(metadata map[string]any) bool {
+	deferred, _ := metadata[CreateMetadataDeferredStartKey].(bool)
+	return deferred || parseMinPlayers(metadata) > 0
+}
+
+// parseMinPlayers reads the min players gate from the create metadata, which is a float64 once decoded from JSON.
+func parseMinPlayers(metadata map[string]any) int {
+	switch v := metadata[CreateMetadataMinPlayersKey].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// generateJoinCode returns a short human friendly code, avoiding ambiguous characters.
+func generateJoinCode() (string, error) {
+	buf := make([]byte, joinCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = joinCodeAlphabet[int(b)%len(joinCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// generatePendingInstanceId returns a unique ID for an instance record not yet backed by a deployment.
+func generatePendingInstanceId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "pending-" + hex.EncodeToString(buf), nil
+}
+
+// createDeferred allocates a pending instance record with a join code, without deploying on Edgegap.
+// Players gather with Join until StartDeferred deploys using all their IPs.
+func (efm *EdgegapFleetManager) createDeferred(ctx context.Context, maxPlayers int, userIds []string, callbackId string, metadata map[string]any) (map[string]string, error) {
+	id, err := generatePendingInstanceId()
+	if err != nil {
+		return nil, err
+	}
+
+	joinCode, err := generateJoinCode()
+	if err != nil {
+		return nil, err
+	}
+
+	ownerId, _ := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+
+	var expiresAt time.Time
+	if pendingMaxDuration, err := time.ParseDuration(efm.edgegapManager.configuration.PendingMaxDuration); err == nil && pendingMaxDuration > 0 {
+		expiresAt = time.Now().UTC().Add(pendingMaxDuration)
+	}
+
+	minPlayers := parseMinPlayers(metadata)
+	if maxPlayers >= 0 && minPlayers > maxPlayers {
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, errors.New("min players exceeds max players"))
+		return nil, errors.New("expects min_players to be lower or equal to max_players")
+	}
+
+	_, err = efm.storageManager.createDbInstance(ctx, id, EdgegapStatusPending, EdgegapInstanceInfo{
+		MaxPlayers:       maxPlayers,
+		Reservations:     userIds,
+		CallbackId:       callbackId,
+		OwnerId:          ownerId,
+		JoinCode:         joinCode,
+		MinPlayers:       minPlayers,
+		PendingExpiresAt: expiresAt,
+	}, metadata)
+	if err != nil {
+		efm.logger.WithField("error", err).Error("failed to create Storage Pending Instance Session")
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, errors.New("error while creating Instance Session"))
+		return nil, err
+	}
+
+	efm.logger.Info("Created pending instance %s with join code %s", id, joinCode)
+
+	// The creating users may already satisfy the gate
+	if minPlayers > 0 && len(userIds) >= minPlayers {
+		deploymentId, err := efm.StartDeferred(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{DeploymentIdKey: deploymentId, InstanceIdKey: deploymentId}, nil
+	}
+
+	return map[string]string{
+		DeploymentIdKey: "",
+		InstanceIdKey:   id,
+		JoinCodeKey:     joinCode,
+	}, nil
+}
+
+// StartDeferred deploys a pending instance on Edgegap using the IPs of every gathered player.
+// The instance record is moved to the deployment ID, which is returned.
+func (efm *EdgegapFleetManager) StartDeferred(ctx context.Context, id string) (string, error) {
+	instances, versions, err := efm.storageManager.readDbInstancesForUpdate(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	instance, ok := instances[id]
+	if !ok {
+		return "", errors.New("instance not found")
+	}
+	if instance.Status != EdgegapStatusPending {
+		return "", ErrorInstanceNotPending
+	}
+
+	ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return "", err
+	}
+
+	// Concurrent starts, e.g. two joins reaching min players, race to claim the record, only the winner deploys
+	instance.Status = EdgegapStatusStarting
+	ei.StartingAt = time.Now().UTC()
+	claimed, err := efm.storageManager.writeDbInstancesConditional(ctx, []*runtime.InstanceInfo{instance}, versions)
+	if err != nil {
+		if errors.Is(err, runtime.ErrStorageRejectedVersion) {
+			return "", ErrorInstanceNotPending
+		}
+		return "", err
+	}
+
+	placement, err := efm.placement(ctx, ei.Reservations, instance.Metadata)
+	if err != nil {
+		efm.releaseDeferredStart(ctx, id)
+		return "", err
+	}
+
+	// Forward the create metadata only, not the Edgegap bookkeeping
+	metadata := make(map[string]any, len(instance.Metadata))
+	for k, v := range instance.Metadata {
+		if k != "edgegap" && k != CreateMetadataDeferredStartKey && k != CreateMetadataMinPlayersKey {
+			metadata[k] = v
+		}
+	}
+
+	deployment, err := efm.edgegapManager.CreateDeployment(ctx, placement, metadata)
+	if err != nil {
+		efm.logger.WithField("error", err).Error("failed to create Edgegap instance for pending instance %s", id)
+		efm.releaseDeferredStart(ctx, id)
+		return "", err
+	}
+	if deployment.RequestId == "" {
+		efm.releaseDeferredStart(ctx, id)
+		return "", errors.New("failed to create deployment")
+	}
+
+	// Gathered reservations get a fresh window to connect once the deployment is up
+	ei.ReservationsUpdatedAt = time.Now().UTC()
+	ei.RequestedAt = time.Now().UTC()
+	ei.Account = deployment.Account
+	ei.IdentityHash = deployment.IdentityHash
+	ei.Capacity = deployment.Capacity
+	ei.Filters = deployment.Filters
+	ei.StartingAt = time.Time{}
+	if deployment.MaxDuration > 0 {
+		ei.ExpiresAt = ei.RequestedAt.Add(time.Duration(deployment.MaxDuration) * time.Minute)
+	}
+	instance.Metadata["edgegap"] = ei
+	instance.Id = deployment.RequestId
+	instance.Status = EdgegapStatusRequested
+
+	// The move fails if the claimed record changed meanwhile, e.g. cancelled as expired
+	if err = efm.storageManager.moveDbInstance(ctx, id, claimed[id], instance); err != nil {
+		efm.logger.WithField("error", err).Error("failed to move pending instance %s to deployment %s", id, deployment.RequestId)
+		// The pending instance stays pending, its deployment would run without any record
+		efm.stopOrphanedDeployment(deployment, err)
+		efm.releaseDeferredStart(ctx, id)
+		return "", err
+	}
+
+	efm.logger.Info("Started pending instance %s as deployment %s", id, deployment.RequestId)
+	notifyStatusChanged(ctx, efm.logger, efm.nk, efm.storageManager, instance, ei)
+	efm.storageManager.recordDailyStat(DailyStatCreated, ei, 0)
+
+	return deployment.RequestId, nil
+}
+
+// releaseDeferredStart puts an instance claimed by a failed start back to PENDING, so it can be started again.
+func (efm *EdgegapFleetManager) releaseDeferredStart(ctx context.Context, id string) {
+	instances, versions, err := efm.storageManager.readDbInstancesForUpdate(ctx, id)
+	if err == nil {
+		instance, ok := instances[id]
+		if !ok || instance.Status != EdgegapStatusStarting {
+			return
+		}
+		var ei *EdgegapInstanceInfo
+		if ei, err = efm.storageManager.ExtractEdgegapInstance(instance); err == nil {
+			ei.StartingAt = time.Time{}
+			instance.Status = EdgegapStatusPending
+			_, err = efm.storageManager.writeDbInstancesConditional(ctx, []*runtime.InstanceInfo{instance}, versions)
+		}
+	}
+	if err != nil {
+		efm.logger.WithFields(map[string]any{"instance_id": id, "error": err.Error()}).Error("failed to release the start of pending instance")
+	}
+}
+
+// startIfMinPlayersReached deploys a pending instance once its min players gate is satisfied.
+// It returns the deployment ID, or an empty string if the instance is not ready to start.
+func (efm *EdgegapFleetManager) startIfMinPlayersReached(ctx context.Context, instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) (string, error) {
+	if instance.Status != EdgegapStatusPending || ei.MinPlayers <= 0 || len(ei.Reservations) < ei.MinPlayers {
+		return "", nil
+	}
+
+	efm.logger.Info("Pending instance %s reached %d min players, starting", instance.Id, ei.MinPlayers)
+	return efm.StartDeferred(ctx, instance.Id)
+}
+
+// expirePendingInstances cancels pending instances that were never started in time and notifies their players.
+func (efm *EdgegapFleetManager) expirePendingInstances() {
+	// Instances left STARTING by a node that stopped mid start expire once their claim is stale
+	now := time.Now().UTC()
+	queries := []string{
+		fmt.Sprintf("+value.status:%s +value.metadata.edgegap.pending_expires_at:<\"%s\"", EdgegapStatusPending, now.Format(time.RFC3339)),
+		fmt.Sprintf("+value.status:%s +value.metadata.edgegap.starting_at:<\"%s\"", EdgegapStatusStarting, now.Add(-deferredStartTimeout).Format(time.RFC3339)),
+	}
+	objects := make([]*api.StorageObject, 0)
+	for _, query := range queries {
+		listed, err := efm.storageManager.listIndexes(efm.ctx, query, 1_000)
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to list expired pending instances")
+			return
+		}
+		objects = append(objects, listed...)
+	}
+
+	expiredIds := make([]string, 0)
+	for _, so := range objects {
+		info, err := decodeInstance(so.Value)
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to unmarshal instance info")
+			continue
+		}
+		if err = efm.storageManager.attachUsers(efm.ctx, info); err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to read instance user lists")
+			continue
+		}
+		ei, err := efm.storageManager.ExtractEdgegapInstance(info)
+		if err != nil {
+			continue
+		}
+		reason := errors.New("pending instance did not reach min players in time")
+		switch {
+		case info.Status == EdgegapStatusStarting && !ei.StartingAt.IsZero() && now.Sub(ei.StartingAt) >= deferredStartTimeout:
+			reason = errors.New("pending instance did not start in time")
+		case info.Status != EdgegapStatusPending || ei.PendingExpiresAt.IsZero():
+			continue
+		}
+
+		expiredIds = append(expiredIds, info.Id)
+		efm.callbackHandler.InvokeCallback(ei.CallbackId, runtime.CreateTimeout, info, nil, nil, reason)
+		notifyPendingExpired(efm.ctx, efm.logger, efm.nk, info.Id, ei.Reservations)
+	}
+
+	if len(expiredIds) == 0 {
+		return
+	}
+
+	efm.logger.Info("Cancelling %d expired pending instances", len(expiredIds))
+	if err := efm.storageManager.deleteDbInstances(efm.ctx, expiredIds); err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to delete expired pending instances")
+	}
+}
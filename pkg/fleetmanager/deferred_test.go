@@ -0,0 +1,144 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestStartDeferredClaim(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+	}{
+		{name: "starting", status: EdgegapStatusStarting},
+		{name: "started", status: EdgegapStatusReady},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			node := newFakeFleetManager(newFakeNakama(), &EdgegapManagerConfiguration{})
+			if _, err := node.storageManager.createDbInstance(ctx, "pending-id", tt.status, EdgegapInstanceInfo{MaxPlayers: 4, StartingAt: time.Now().UTC()}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := node.StartDeferred(ctx, "pending-id"); !errors.Is(err, ErrorInstanceNotPending) {
+				t.Errorf("StartDeferred() error = %v, want %v", err, ErrorInstanceNotPending)
+			}
+		})
+	}
+}
+
+func TestStartDeferredClaimRace(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	node := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+	other := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+	if _, err := node.storageManager.createDbInstance(ctx, "pending-id", EdgegapStatusPending, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A join on the other node writes the instance between the read and the claim of the start, which then loses
+	raced := false
+	nk.beforeWrite = func(writes []*runtime.StorageWrite) {
+		if raced {
+			return
+		}
+		raced = true
+		if _, err := other.Join(ctx, "pending-id", []string{"other"}, nil); err != nil {
+			t.Errorf("Join() on the other node error = %v", err)
+		}
+	}
+	if _, err := node.StartDeferred(ctx, "pending-id"); !errors.Is(err, ErrorInstanceNotPending) {
+		t.Fatalf("StartDeferred() losing the claim error = %v, want %v", err, ErrorInstanceNotPending)
+	}
+	stored, err := node.storageManager.getDbInstance(ctx, "pending-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != EdgegapStatusPending {
+		t.Errorf("status = %s, want %s", stored.Status, EdgegapStatusPending)
+	}
+}
+
+func TestJoinStartingInstance(t *testing.T) {
+	ctx := context.Background()
+	node := newFakeFleetManager(newFakeNakama(), &EdgegapManagerConfiguration{})
+	if _, err := node.storageManager.createDbInstance(ctx, "pending-id", EdgegapStatusStarting, EdgegapInstanceInfo{MaxPlayers: 4, StartingAt: time.Now().UTC()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.Join(ctx, "pending-id", []string{"user"}, nil); !errors.Is(err, ErrorInstanceStarting) {
+		t.Errorf("Join() of a starting instance error = %v, want %v", err, ErrorInstanceStarting)
+	}
+}
+
+func TestReleaseDeferredStart(t *testing.T) {
+	ctx := context.Background()
+	node := newFakeFleetManager(newFakeNakama(), &EdgegapManagerConfiguration{})
+	sm := node.storageManager
+	if _, err := sm.createDbInstance(ctx, "starting", EdgegapStatusStarting, EdgegapInstanceInfo{MaxPlayers: 4, StartingAt: time.Now().UTC()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.createDbInstance(ctx, "ready", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	node.releaseDeferredStart(ctx, "starting")
+	node.releaseDeferredStart(ctx, "ready")
+
+	stored, err := sm.getDbInstance(ctx, "starting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ei := stored.Metadata["edgegap"].(*EdgegapInstanceInfo); stored.Status != EdgegapStatusPending || !ei.StartingAt.IsZero() {
+		t.Errorf("released start status = %s, starting at %v, want %s without a claim", stored.Status, ei.StartingAt, EdgegapStatusPending)
+	}
+	if stored, err = sm.getDbInstance(ctx, "ready"); err != nil || stored.Status != EdgegapStatusReady {
+		t.Errorf("status of a started instance = %v, %v, want %s", stored, err, EdgegapStatusReady)
+	}
+}
+
+func TestExpirePendingInstances(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	node := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+	callbacks := &fakeCallbackHandler{}
+	node.callbackHandler = callbacks
+	sm := node.storageManager
+	nk.indexes[sm.collections.index] = sm.collections.instances
+
+	now := time.Now().UTC()
+	instances := []struct {
+		id          string
+		status      string
+		ei          EdgegapInstanceInfo
+		wantExpired bool
+	}{
+		{id: "pending", status: EdgegapStatusPending, ei: EdgegapInstanceInfo{CallbackId: "pending", PendingExpiresAt: now.Add(-time.Second)}, wantExpired: true},
+		{id: "stale-start", status: EdgegapStatusStarting, ei: EdgegapInstanceInfo{CallbackId: "stale-start", StartingAt: now.Add(-deferredStartTimeout - time.Second)}, wantExpired: true},
+		{id: "starting", status: EdgegapStatusStarting, ei: EdgegapInstanceInfo{CallbackId: "starting", StartingAt: now}},
+		{id: "ready", status: EdgegapStatusReady, ei: EdgegapInstanceInfo{CallbackId: "ready"}},
+	}
+	for _, instance := range instances {
+		instance.ei.MaxPlayers = 4
+		if _, err := sm.createDbInstance(ctx, instance.id, instance.status, instance.ei, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node.expirePendingInstances()
+
+	for _, instance := range instances {
+		_, invoked := callbacks.statuses[instance.id]
+		stored := nk.has(sm.collections.instances, instance.id)
+		if instance.wantExpired && (stored || callbacks.statuses[instance.id] != runtime.CreateTimeout) {
+			t.Errorf("%s stored = %v, callback = %v, want deleted with a timeout", instance.id, stored, callbacks.statuses[instance.id])
+		}
+		if !instance.wantExpired && (!stored || invoked) {
+			t.Errorf("%s stored = %v, callback invoked = %v, want kept", instance.id, stored, invoked)
+		}
+	}
+}
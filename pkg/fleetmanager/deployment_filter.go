@@ -0,0 +1,83 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CreateMetadataFiltersKey holds the geographic filters restricting where the deployment can be placed
+const CreateMetadataFiltersKey = "filters"
+
+// Geographic fields and filter types of the Edgegap deployment filters
+var (
+	deploymentFilterFields = []string{"city", "country", "continent", "region", "administrative_division"}
+	deploymentFilterTypes  = []string{"any", "all", "not"}
+)
+
+// parseDeploymentFilters reads geographic deployment filters, either decoded from JSON or set by server code as
+// EdgegapDeploymentFilter.
+func parseDeploymentFilters(raw any) ([]EdgegapDeploymentFilter, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	filters, ok := raw.([]EdgegapDeploymentFilter)
+	if !ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &filters); err != nil {
+			return nil, fmt.Errorf("expects a list of {field, values, filter_type}: %w", err)
+		}
+	}
+
+	for _, filter := range filters {
+		if !slices.Contains(deploymentFilterFields, filter.Field) {
+			return nil, fmt.Errorf("invalid filter field %q, expects one of %s", filter.Field, strings.Join(deploymentFilterFields, ", "))
+		}
+		if !slices.Contains(deploymentFilterTypes, filter.FilterType) {
+			return nil, fmt.Errorf("invalid filter_type %q of field %s, expects one of %s", filter.FilterType, filter.Field, strings.Join(deploymentFilterTypes, ", "))
+		}
+		if len(filter.Values) == 0 {
+			return nil, fmt.Errorf("filter of field %s expects at least one value", filter.Field)
+		}
+	}
+	return filters, nil
+}
+
+// parseConfiguredFilters parses the EDGEGAP_DEPLOYMENT_FILTERS JSON, none when empty.
+func parseConfiguredFilters(value string) ([]EdgegapDeploymentFilter, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var raw any
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("invalid deployment filters: %w", err)
+	}
+	filters, err := parseDeploymentFilters(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deployment filters: %w", err)
+	}
+	return filters, nil
+}
+
+// deploymentFilters returns the filters of a deployment: the configured ones, e.g. countries denied for legal reasons,
+// then the ones of the create metadata and of the players placement preferences.
+func (em *EdgegapManager) deploymentFilters(placement *deploymentPlacement, metadata map[string]any) ([]EdgegapDeploymentFilter, error) {
+	configured, _ := parseConfiguredFilters(em.configuration.DeploymentFilters)
+	requested, err := parseDeploymentFilters(metadata[CreateMetadataFiltersKey])
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make([]EdgegapDeploymentFilter, 0, len(configured)+len(requested)+len(placement.Filters))
+	filters = append(filters, configured...)
+	filters = append(filters, requested...)
+	filters = append(filters, placement.Filters...)
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return filters, nil
+}
@@ -0,0 +1,40 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorDeploymentRejected is returned by Create when the deployment hook rejects the deployment
+var ErrorDeploymentRejected = errors.New("deployment rejected")
+
+// DeploymentHook inspects or modifies the deployment payload right before it is sent to Edgegap, e.g. to add tags or
+// environment variables, or to change the version. The metadata is the create metadata of the instance, a non nil
+// error fails the create. The NAKAMA_* environment variables must be kept for the game server to reach Nakama.
+type DeploymentHook func(ctx context.Context, deployment *EdgegapDeploymentCreation, metadata map[string]any) error
+
+// SetDeploymentHook sets the Go hook called before every deployment is requested. A nil hook removes it.
+func (efm *EdgegapFleetManager) SetDeploymentHook(hook DeploymentHook) {
+	efm.edgegapManager.hookMu.Lock()
+	defer efm.edgegapManager.hookMu.Unlock()
+	efm.edgegapManager.deploymentHook = hook
+}
+
+// applyDeploymentHook runs the deployment hook on the payload, if set.
+func (em *EdgegapManager) applyDeploymentHook(ctx context.Context, deployment *EdgegapDeploymentCreation, metadata map[string]any) error {
+	em.hookMu.RLock()
+	hook := em.deploymentHook
+	em.hookMu.RUnlock()
+
+	if hook == nil {
+		return nil
+	}
+	if err := hook(ctx, deployment, metadata); err != nil {
+		return fmt.Errorf("%w: %s", ErrorDeploymentRejected, err.Error())
+	}
+	if deployment.Version == "" {
+		return fmt.Errorf("%w: the hook removed the version", ErrorDeploymentRejected)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdEdgegapDeploymentStatus proxies the Edgegap deployment status, for operators debugging a deployment without
+// the Edgegap API token
+const RpcIdEdgegapDeploymentStatus = "edgegap_deployment_status"
+
+// deploymentStatusTtl is how long a deployment status is served from the cache, tooling tends to poll
+const deploymentStatusTtl = 5 * time.Second
+
+type edgegapDeploymentStatusRequest struct {
+	RequestId string `json:"request_id"`
+	// Refresh skips the cache
+	Refresh bool `json:"refresh"`
+}
+
+type edgegapDeploymentStatusReply struct {
+	RequestId string `json:"request_id"`
+	// Status is the raw reply of the Edgegap status API
+	Status    json.RawMessage `json:"status"`
+	FetchedAt time.Time       `json:"fetched_at"`
+	Cached    bool            `json:"cached"`
+}
+
+// DeploymentStatus returns the raw Edgegap status of the deployment with the time it was fetched and whether it came
+// from the cache, kept for deploymentStatusTtl unless refreshed.
+func (em *EdgegapManager) DeploymentStatus(requestID string, refresh bool) (json.RawMessage, time.Time, bool, error) {
+	ttl := deploymentStatusTtl
+	if refresh {
+		ttl = 0
+	}
+	return em.deploymentStatuses.get(requestID, ttl, func() (json.RawMessage, error) {
+		return em.fetchDeploymentStatus(requestID)
+	})
+}
+
+func (em *EdgegapManager) fetchDeploymentStatus(requestID string) (json.RawMessage, error) {
+	apiHelper, err := em.apiHelperFor(requestID)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := apiHelper.Get("/v1/status/" + url.PathEscape(requestID))
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Body.Close()
+
+	body, err := io.ReadAll(reply.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch reply.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, ErrorDeploymentNotFound
+	default:
+		return nil, fmt.Errorf("could not get deployment status: status %d, body: %s", reply.StatusCode, string(body))
+	}
+
+	if !json.Valid(body) {
+		return nil, errors.New("invalid deployment status response")
+	}
+	return body, nil
+}
+
+// edgegapDeploymentStatus admin rpc returning the raw Edgegap status of a deployment (S2S only)
+func edgegapDeploymentStatus(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdEdgegapDeploymentStatus); err != nil {
+		return "", err
+	}
+
+	var req edgegapDeploymentStatusRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || strings.TrimSpace(req.RequestId) == "" {
+		return "", ErrInvalidInput
+	}
+
+	status, fetchedAt, cached, err := fmInstance.edgegapManager.DeploymentStatus(req.RequestId, req.Refresh)
+	if err != nil {
+		if errors.Is(err, ErrorDeploymentNotFound) {
+			return "", runtime.NewError(err.Error(), 5) // NOT_FOUND
+		}
+		logger.WithField("error", err.Error()).Error("failed to get Edgegap deployment status")
+		return "", runtime.NewError("failed to get deployment status from Edgegap", 14) // UNAVAILABLE
+	}
+
+	reply, err := json.Marshal(edgegapDeploymentStatusReply{
+		RequestId: req.RequestId,
+		Status:    status,
+		FetchedAt: fetchedAt.UTC(),
+		Cached:    cached,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
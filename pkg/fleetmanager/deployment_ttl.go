@@ -0,0 +1,98 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+// CreateMetadataMaxDurationKey holds the max duration of the deployment in minutes, overriding
+// EDGEGAP_DEPLOYMENT_MAX_DURATION
+const CreateMetadataMaxDurationKey = "max_duration"
+
+// parseMaxDuration reads a max duration in minutes, decoded from JSON or set by server code, 0 when unset.
+func parseMaxDuration(raw any) (int, error) {
+	var minutes float64
+	switch v := raw.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		minutes = float64(v)
+	case float64:
+		minutes = v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("expects a number of minutes: %w", err)
+		}
+		minutes = f
+	default:
+		return 0, fmt.Errorf("expects a number of minutes, got %T", raw)
+	}
+	if minutes < 1 || minutes != math.Trunc(minutes) {
+		return 0, fmt.Errorf("expects a whole number of minutes of at least 1, got %v", minutes)
+	}
+	return int(minutes), nil
+}
+
+// configuredMaxDuration returns EDGEGAP_DEPLOYMENT_MAX_DURATION in minutes, rounded up, 0 to keep the max duration
+// of the app version.
+func configuredMaxDuration(config *EdgegapManagerConfiguration) int {
+	maxDuration, err := time.ParseDuration(config.DeploymentMaxDuration)
+	if err != nil || maxDuration <= 0 {
+		return 0
+	}
+	return int(math.Ceil(maxDuration.Minutes()))
+}
+
+// deploymentMaxDuration returns the max duration of a deployment in minutes, from the create metadata or else the
+// configuration, 0 to keep the max duration of the app version.
+func (em *EdgegapManager) deploymentMaxDuration(metadata map[string]any) int {
+	if minutes, err := parseMaxDuration(metadata[CreateMetadataMaxDurationKey]); err == nil && minutes > 0 {
+		return minutes
+	}
+	return configuredMaxDuration(em.configuration)
+}
+
+// applyMaxDuration sets the max duration of a new deployment with the account that created it, so Edgegap stops it
+// even if Nakama never does. It returns the max duration applied, 0 when none was or it failed, the deployment then
+// keeping the max duration of the app version.
+func (em *EdgegapManager) applyMaxDuration(apiHelper *helpers.APIClient, requestID string, metadata map[string]any) int {
+	minutes := em.deploymentMaxDuration(metadata)
+	if minutes == 0 {
+		return 0
+	}
+	if err := patchDeploymentMaxDuration(apiHelper, requestID, minutes); err != nil {
+		em.logger.WithFields(map[string]any{"request_id": requestID, "error": err.Error()}).Warn("failed to set the max duration of the deployment")
+		return 0
+	}
+	return minutes
+}
+
+// patchDeploymentMaxDuration updates the max duration of a deployment, in minutes since it started.
+func patchDeploymentMaxDuration(apiHelper *helpers.APIClient, requestID string, maxDurationMinutes int) error {
+	reply, err := apiHelper.Patch("/v1/deployments/"+requestID, EdgegapDeploymentDurationUpdate{
+		MaxDuration: maxDurationMinutes,
+	})
+	if err != nil {
+		return err
+	}
+	defer reply.Body.Close()
+
+	switch reply.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound, http.StatusGone:
+		return ErrorDeploymentNotFound
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return ErrorDeploymentUpdateUnsupported
+	default:
+		body, _ := io.ReadAll(reply.Body)
+		return fmt.Errorf("could not update deployment: status %d, body: %s", reply.StatusCode, string(body))
+	}
+}
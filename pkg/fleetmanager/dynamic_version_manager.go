@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"net/http"
 
@@ -32,29 +34,64 @@ const (
 	// Response fields
 	ResponseFieldSource   = "source"
 	ResponseSourceDynamic = "dynamic"
+	ResponseSourceStatic  = "static"
 )
 
+// ErrorDynamicVersioningDisabled is returned when updating the version with EDGEGAP_DYNAMIC_VERSIONING=false
+var ErrorDynamicVersioningDisabled = errors.New("dynamic versioning is disabled, the version is set by INITIAL_EDGEGAP_VERSION")
+
 type UpdateEdgegapVersionRequest struct {
 	Version string `json:"version"`
+	// Async stores the version right away and validates it in the background, rolling it back if invalid
+	Async bool `json:"async"`
+	// Cutover is how new deployments move from the previous version: immediate (default), percentage or time
+	Cutover string `json:"cutover"`
+	// Percentage is the share of new deployments on the new version of a percentage cutover, ramped with version_rollout
+	Percentage int `json:"percentage"`
+	// RampDuration is the duration of a time cutover, e.g. 30m
+	RampDuration string `json:"ramp_duration"`
 }
 
 // DynamicVersionManager manages dynamic versioning for Edgegap deployments
 type DynamicVersionManager struct {
-	config *EdgegapManagerConfiguration
-	sm     *StorageManager
-	logger runtime.Logger
+	config    *EdgegapManagerConfiguration
+	sm        *StorageManager
+	apiHelper *helpers.APIClient
+	webhooks  *WebhookDispatcher
+	logger    runtime.Logger
+	cache     versionCache
+
+	validations versionValidations
+}
+
+// versionRevisionPoll is how often a node checks the stored revision while its cached version is fresh
+const versionRevisionPoll = time.Second
+
+// versionCache keeps the stored version in memory for the hot create path. It is invalidated by updates on this
+// node, and dropped within a second on the other nodes once the stored revision changes.
+type versionCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	version   string
+	revision  int64
+	rollout   *EdgegapVersionRollout
+	fetchedAt time.Time
+	checkedAt time.Time
 }
 
 // NewDynamicVersionManager creates a new DynamicVersionManager instance
-func NewDynamicVersionManager(config *EdgegapManagerConfiguration, sm *StorageManager, logger runtime.Logger) *DynamicVersionManager {
+func NewDynamicVersionManager(config *EdgegapManagerConfiguration, sm *StorageManager, apiHelper *helpers.APIClient, webhooks *WebhookDispatcher, logger runtime.Logger) *DynamicVersionManager {
 	dvm := &DynamicVersionManager{
-		config: config,
-		sm:     sm,
-		logger: logger,
+		config:    config,
+		sm:        sm,
+		apiHelper: apiHelper,
+		webhooks:  webhooks,
+		logger:    logger,
 	}
+	dvm.cache.ttl, _ = time.ParseDuration(config.VersionCacheTtl)
 
-	// Check if initial version should be stored at startup
-	if config.InitialVersion != "" {
+	// Check if initial version should be stored at startup, static versions are never stored
+	if config.InitialVersion != "" && config.DynamicVersioning {
 		ctx := context.Background()
 		// Check if a version is already stored
 		_, _, err := sm.ReadEdgegapVersion(ctx)
@@ -74,10 +111,81 @@ func NewDynamicVersionManager(config *EdgegapManagerConfiguration, sm *StorageMa
 	return dvm
 }
 
+// CurrentVersion returns the stored version used for new deployments, read from storage at most once per cache TTL
+// unless its revision changed. Without dynamic versioning, it is the initial version from the environment.
+func (dvm *DynamicVersionManager) CurrentVersion(ctx context.Context) (string, error) {
+	if !dvm.config.DynamicVersioning {
+		return dvm.config.InitialVersion, nil
+	}
+
+	c := &dvm.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.version != "" && time.Since(c.fetchedAt) < c.ttl {
+		if time.Since(c.checkedAt) < versionRevisionPoll {
+			return c.version, nil
+		}
+		// An update on another node bumped the stored revision, the cached version is reloaded
+		_, _, revision, err := dvm.sm.readEdgegapVersionRevision(ctx)
+		if err != nil {
+			dvm.logger.WithField("error", err.Error()).Warn("failed to check the version revision, deploying the cached %s", c.version)
+			return c.version, nil
+		}
+		if revision == c.revision {
+			c.checkedAt = time.Now()
+			return c.version, nil
+		}
+	}
+
+	version, _, revision, err := dvm.sm.readEdgegapVersionRevision(ctx)
+	if err != nil {
+		return "", err
+	}
+	if c.version != "" && revision != c.revision {
+		dvm.logger.Info("Edgegap version changed to %s (revision %d)", version, revision)
+	}
+	rollout, err := dvm.sm.readVersionRollout(ctx)
+	if err != nil {
+		dvm.logger.WithField("error", err.Error()).Warn("failed to read version rollout, deploying %s", version)
+	}
+
+	now := time.Now()
+	c.version, c.revision, c.rollout, c.fetchedAt, c.checkedAt = version, revision, rollout, now, now
+	return version, nil
+}
+
+// StoreVersion stores the version for new deployments and invalidates the cached one.
+func (dvm *DynamicVersionManager) StoreVersion(ctx context.Context, version string) error {
+	if !dvm.config.DynamicVersioning {
+		return ErrorDynamicVersioningDisabled
+	}
+
+	defer dvm.invalidate()
+	previous, _, _ := dvm.sm.ReadEdgegapVersion(ctx)
+	if err := dvm.sm.WriteEdgegapVersion(ctx, version); err != nil {
+		return err
+	}
+
+	dvm.sm.recordAudit(ctx, &EdgegapAuditEntry{
+		Action: AuditActionVersionChange,
+		Before: map[string]any{"version": previous},
+		After:  map[string]any{"version": version},
+	})
+	return nil
+}
+
+// invalidate drops the cached version, the next deployment reads it from storage.
+func (dvm *DynamicVersionManager) invalidate() {
+	dvm.cache.mu.Lock()
+	defer dvm.cache.mu.Unlock()
+
+	dvm.cache.version = ""
+}
+
 // ValidateVersionWithEdgegap validates that a version exists in Edgegap
 func (dvm *DynamicVersionManager) ValidateVersionWithEdgegap(version string) error {
-	apiHelper := helpers.NewAPIClient(dvm.config.ApiUrl, dvm.config.ApiToken)
-	reply, err := apiHelper.Get(fmt.Sprintf("/v1/app/%s/version/%s", dvm.config.Application, version))
+	reply, err := dvm.apiHelper.Get(fmt.Sprintf("/v1/app/%s/version/%s", dvm.config.Application, version))
 	if err != nil {
 		return fmt.Errorf("failed to validate version with Edgegap API: %w", err)
 	}
@@ -108,6 +216,10 @@ func (dvm *DynamicVersionManager) UpdateEdgegapVersion(ctx context.Context, logg
 		return "", runtime.NewError(ErrorMessageUnauthorized, 7) // PERMISSION_DENIED
 	}
 
+	if !dvm.config.DynamicVersioning {
+		return "", runtime.NewError(ErrorDynamicVersioningDisabled.Error(), 9) // FAILED_PRECONDITION
+	}
+
 	request := &UpdateEdgegapVersionRequest{}
 	if err := json.Unmarshal([]byte(payload), request); err != nil {
 		return "", runtime.NewError("invalid payload format", 3) // INVALID_ARGUMENT
@@ -117,26 +229,69 @@ func (dvm *DynamicVersionManager) UpdateEdgegapVersion(ctx context.Context, logg
 	if request.Version == "" {
 		return "", runtime.NewError("version cannot be empty", 3) // INVALID_ARGUMENT
 	}
+	if err := validateCutover(request); err != nil {
+		return "", err
+	}
+
+	previous, _, err := dvm.sm.ReadEdgegapVersion(ctx)
+	if err != nil && !errors.Is(err, ErrorNoVersionFound) {
+		logger.Error("Failed to read Edgegap version: %v", err)
+		return "", runtime.NewError("failed to read version", 13) // INTERNAL
+	}
+
+	// Async updates without a known validation result are stored optimistically and validated in the background
+	if known, _ := dvm.validations.cached(request.Version); request.Async && !known {
+		if err := dvm.StoreVersion(ctx, request.Version); err != nil {
+			logger.Error("Failed to store Edgegap version: %v", err)
+			return "", runtime.NewError("failed to store version", 13) // INTERNAL
+		}
+		if err := dvm.startRollout(ctx, previous, request); err != nil {
+			logger.Error("Failed to store Edgegap version rollout: %v", err)
+		}
+		go dvm.validateInBackground(request.Version, previous)
+
+		return dvm.updateResponse(request.Version, "pending", "Edgegap version accepted, validating with Edgegap in the background.")
+	}
 
 	// Validate the version exists in Edgegap before storing
-	if err := dvm.ValidateVersionWithEdgegap(request.Version); err != nil {
+	if err := dvm.validateVersion(request.Version); err != nil {
 		logger.Error("Failed to validate version with Edgegap: %v", err)
 		return "", err
 	}
 
 	// Store the Edgegap version using StorageManager
-	if err := dvm.sm.WriteEdgegapVersion(ctx, request.Version); err != nil {
+	if err := dvm.StoreVersion(ctx, request.Version); err != nil {
 		logger.Error("Failed to store Edgegap version: %v", err)
 		return "", runtime.NewError("failed to store version", 13) // INTERNAL
 	}
+	if err := dvm.startRollout(ctx, previous, request); err != nil {
+		logger.Error("Failed to store Edgegap version rollout: %v", err)
+	}
+	dvm.versionApplied(request.Version)
 
-	logger.Info(LogMessageVersionUpdated, request.Version)
+	return dvm.updateResponse(request.Version, "valid", "Edgegap version updated successfully. Will be used for new deployments immediately.")
+}
+
+// versionApplied announces a validated version, and migrates the persistent instances to it.
+func (dvm *DynamicVersionManager) versionApplied(version string) {
+	dvm.logger.Info(LogMessageVersionUpdated, version)
+	dvm.webhooks.Dispatch(WebhookEventVersionChanged, fmt.Sprintf(LogMessageVersionUpdated, version), map[string]string{
+		"version": version,
+	})
 
-	// Return success response
+	// Persistent instances survive version updates by draining into replacements on the new version
+	if fmInstance != nil {
+		go fmInstance.migratePersistentInstances(fmInstance.ctx)
+	}
+}
+
+// updateResponse builds the reply of update_edgegap_version.
+func (dvm *DynamicVersionManager) updateResponse(version, validation, message string) (string, error) {
 	response := map[string]interface{}{
-		"success": true,
-		"version": request.Version,
-		"message": "Edgegap version updated successfully. Will be used for new deployments immediately.",
+		"success":    true,
+		"version":    version,
+		"validation": validation,
+		"message":    message,
 	}
 
 	responseBytes, err := json.Marshal(response)
@@ -158,6 +313,17 @@ func (dvm *DynamicVersionManager) GetEdgegapVersion(ctx context.Context, logger
 
 	response := map[string]interface{}{}
 
+	// Static versions come from the environment
+	if !dvm.config.DynamicVersioning {
+		response["version"] = dvm.config.InitialVersion
+		response[ResponseFieldSource] = ResponseSourceStatic
+		responseBytes, err := json.Marshal(response)
+		if err != nil {
+			return "", runtime.NewError("failed to marshal response", 13) // INTERNAL
+		}
+		return string(responseBytes), nil
+	}
+
 	// Try to read version from storage using StorageManager
 	version, updatedAt, err := dvm.sm.ReadEdgegapVersion(ctx)
 	if err != nil {
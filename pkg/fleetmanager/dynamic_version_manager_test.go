@@ -0,0 +1,56 @@
+package fleetmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVersionCacheRevision(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+	if err := sm.WriteEdgegapVersion(ctx, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &EdgegapManagerConfiguration{DynamicVersioning: true, VersionCacheTtl: "1m"}
+	node := NewDynamicVersionManager(config, sm, nil, nil, fakeLogger{})
+	other := NewDynamicVersionManager(config, sm, nil, nil, fakeLogger{})
+
+	current := func(want string) {
+		t.Helper()
+		if version, err := node.CurrentVersion(ctx); err != nil || version != want {
+			t.Fatalf("CurrentVersion() = %q, %v, want %q", version, err, want)
+		}
+	}
+
+	current("v1")
+	reads := nk.readCount(StorageCollectionEdgegapVersion)
+	current("v1")
+	if got := nk.readCount(StorageCollectionEdgegapVersion); got != reads {
+		t.Errorf("cached version read storage %d times within the revision poll", got-reads)
+	}
+
+	// An update on another node is only seen once the revision is checked again
+	if err := other.StoreVersion(ctx, "v2"); err != nil {
+		t.Fatal(err)
+	}
+	current("v1")
+	node.cache.checkedAt = time.Now().Add(-versionRevisionPoll)
+	current("v2")
+
+	// The revision check of an unchanged version keeps the cache
+	node.cache.checkedAt = time.Now().Add(-versionRevisionPoll)
+	reads = nk.readCount(StorageCollectionEdgegapVersion)
+	current("v2")
+	if got := nk.readCount(StorageCollectionEdgegapVersion); got != reads+1 {
+		t.Errorf("revision check read storage %d times, want once", got-reads)
+	}
+
+	// An update on this node invalidates its cache right away
+	if err := node.StoreVersion(ctx, "v3"); err != nil {
+		t.Fatal(err)
+	}
+	current("v3")
+}
@@ -7,13 +7,23 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
-	"strconv"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/edgegap/nakama-edgegap/internal/helpers"
 	"github.com/heroiclabs/nakama-common/runtime"
 )
 
+// ErrorDeploymentNotFound is returned when the deployment does not exist anymore on Edgegap
+var ErrorDeploymentNotFound = errors.New("edgegap deployment not found")
+
+// ErrorDeploymentUpdateUnsupported is returned when Edgegap does not support prolonging the deployment
+var ErrorDeploymentUpdateUnsupported = errors.New("edgegap deployment update is not supported")
+
 const (
 	// Error messages
 	ErrorMessageNoVersionFound = "no Edgegap version found - please set version using update_edgegap_version RPC or provide INITIAL_EDGEGAP_VERSION"
@@ -25,9 +35,25 @@ const (
 type EdgegapManager struct {
 	configuration  *EdgegapManagerConfiguration
 	apiHelper      *helpers.APIClient
+	accounts       []edgegapAccount
 	logger         runtime.Logger
 	storageManager *StorageManager
 	versionManager *DynamicVersionManager
+	webhooks       *WebhookDispatcher
+	notifications  *NotificationTemplates
+	savedQueries   *SavedQueries
+	chaos          *chaosMonkey
+	tenants        map[string]EdgegapTenant
+	writes         *writeCoalescer
+
+	hookMu         sync.RWMutex
+	deploymentHook DeploymentHook
+
+	beacons   apiCache[[]EdgegapBeacon]
+	locations apiCache[[]EdgegapAvailableLocation]
+
+	deploymentStatuses keyedApiCache[json.RawMessage]
+	ipLookups          keyedApiCache[*EdgegapIpLookup]
 }
 
 // NewEdgegapManager initializes a new EdgegapManager instance.
@@ -47,14 +73,66 @@ func NewEdgegapManager(ctx context.Context, logger runtime.Logger, initializer r
 		return nil, err
 	}
 	configuration.NakamaHttpKey = config.GetRuntime().GetHTTPKey()
+	configuration.EncryptionKey = config.GetSession().GetEncryptionKey()
+
+	sm.SetStoragePrefix(configuration.StoragePrefix)
+	sm.SetPlayerIpKey(configuration.PlayerIpKey)
+	if cacheTtl, err := time.ParseDuration(configuration.InstanceCacheTtl); err == nil {
+		sm.EnableInstanceCache(cacheTtl, configuration.InstanceCacheSize)
+	}
+	// The matchmaker labels look up the connections in the storage index, they are then never compressed
+	sm.EnableInstanceCompression(configuration.InstanceCompression, configuration.MatchmakerLabels == "")
+	if configuration.InstanceUsersSplit {
+		sm.EnableInstanceUsersSplit(configuration.MatchmakerLabels == "")
+	}
+	if configuration.InstanceEventLog {
+		sm.EnableInstanceEventLog()
+	}
+	if lagWindow, err := time.ParseDuration(configuration.IndexLagWindow); err == nil {
+		sm.EnableIndexLagFallback(lagWindow)
+	}
+	if configuration.AuditLog {
+		sm.EnableAuditLog(ctx)
+	}
+	if configuration.DailyStats {
+		sm.EnableDailyStats(ctx)
+	}
 
+	// Shared Edgegap API client, its token can be rotated at runtime
+	apiHelper := helpers.NewAPIClient(configuration.ApiUrl, configuration.ApiToken)
+	cm := NewCredentialsManager(ctx, configuration, sm, apiHelper, logger)
+
+	// Create the outbound webhook dispatcher
+	webhooks, err := NewWebhookDispatcher(ctx, configuration, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the notification templates, the stored ones override the file
+	notifications, err := NewNotificationTemplates(configuration, sm, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Saved queries let instance_list name a query tuned by operators
+	savedQueries := NewSavedQueries(sm, logger)
+
+	// Chaos mode is test-only, it injects failures to validate the client handling of failures
+	chaos := newChaosMonkey(configuration, logger)
+
+	// Coalescing is disabled by default, connection events are then written immediately
+	coalesceWindow, _ := time.ParseDuration(configuration.WriteCoalesceWindow)
 	eem := &EdgegapEventManager{
-		config: configuration,
-		sm:     sm,
+		config:   configuration,
+		sm:       sm,
+		webhooks: webhooks,
+		relay:    NewWebhookRelay(ctx, configuration, logger),
+		writes:   newWriteCoalescer(ctx, logger, sm, coalesceWindow),
+		chaos:    chaos,
 	}
 
 	// Create the DynamicVersionManager
-	dvm := NewDynamicVersionManager(configuration, sm, logger)
+	dvm := NewDynamicVersionManager(configuration, sm, apiHelper, webhooks, logger)
 
 	// Register RPC functions for handling various events
 	rpcToRegisters := map[string]func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error){
@@ -63,29 +141,92 @@ func NewEdgegapManager(ctx context.Context, logger runtime.Logger, initializer r
 		RpcIdEventDeploymentTerminated: eem.handleDeploymentTerminatedEvent,
 		RpcIdEventConnection:           eem.handleConnectionEvent,
 		RpcIdEventInstance:             eem.handleInstanceEvent,
+		RpcIdEventInstanceUpdate:       eem.handleInstanceUpdateEvent,
+		RpcIdEventServerPing:           eem.handleServerPingEvent,
+		RpcIdEventWhoami:               eem.handleWhoami,
 		RpcIdInstanceSessionCreate:     createInstanceSession,
 		RpcIdInstanceSessionGet:        getInstanceSession,
 		RpcIdInstanceSessionJoin:       joinInstanceSession,
 		RpcIdInstanceSessionList:       listInstanceSession,
+		RpcIdSavedQueryList:            savedQueries.ListSavedQueries,
+		RpcIdInstanceWaitlistJoin:      joinInstanceWaitlist,
+		RpcIdInstanceSessionStart:      startInstanceSession,
+		RpcIdInstanceSeatConfirm:       eem.confirmInstanceSeat,
+		RpcIdWorldRoute:                routeWorld,
+		RpcIdPlacementPreferencesSet:   setPlacementPreferences,
+		RpcIdPlacementPreferencesGet:   getPlacementPreferences,
+		RpcIdBeaconList:                listBeacons,
+		RpcIdBeaconLatenciesSubmit:     submitBeaconLatencies,
+		RpcIdEdgegapLocations:          listEdgegapLocations,
 		// S2S RPCs for managing Edgegap version
 		RpcIdUpdateEdgegapVersion: dvm.UpdateEdgegapVersion,
 		RpcIdGetEdgegapVersion:    dvm.GetEdgegapVersion,
+		RpcIdVersionRollout:       dvm.VersionRollout,
+		// S2S RPC for rotating the Edgegap API token
+		RpcIdUpdateEdgegapCredentials: cm.UpdateEdgegapCredentials,
+		// S2S RPC for localizing notifications
+		RpcIdUpdateNotificationTemplates: notifications.UpdateNotificationTemplates,
+		// S2S admin RPCs
+		RpcIdAdminInstanceDelete:          adminDeleteInstance,
+		RpcIdInstanceExtend:               adminExtendInstance,
+		RpcIdFleetStats:                   fleetStats,
+		RpcIdFleetDailyStats:              fleetDailyStats,
+		RpcIdAdminPersistentCreate:        adminCreatePersistent,
+		RpcIdAdminPersistentMigrate:       adminMigratePersistent,
+		RpcIdInstanceResendConnectionInfo: resendConnectionInfo,
+		RpcIdInstanceTransfer:             transferInstance,
+		RpcIdPurgeUserFleetData:           purgeUserFleetData,
+		RpcIdInstanceEventLog:             instanceEventLog,
+		RpcIdFleetTeardown:                fleetTeardown,
+		RpcIdFleetTeardownStatus:          fleetTeardownStatus,
+		RpcIdFleetLoadTest:                fleetLoadTest,
+		RpcIdEdgegapDeploymentStatus:      edgegapDeploymentStatus,
+		RpcIdReportIp:                     reportIp,
+		RpcIdAuditLogList:                 auditLogList,
+		RpcIdDeadLetterList:               eem.listDeadLetters,
+		RpcIdDeadLetterReplay:             eem.replayDeadLetter,
+		RpcIdAdminReplayEvent:             eem.adminReplayEvent,
+		RpcIdRpcSchema:                    rpcSchema,
+		// S2S RPCs for managing the saved queries
+		RpcIdSavedQuerySet:    savedQueries.SetSavedQuery,
+		RpcIdSavedQueryDelete: savedQueries.DeleteSavedQuery,
+		// S2S console RPCs
+		RpcIdConsoleInstances: consoleInstances,
+		RpcIdConsoleErrors:    consoleErrors,
+		RpcIdConsoleVersion:   dvm.ConsoleVersion,
 	}
 
 	// Register each RPC function with the Nakama runtime
 	for rpcId, function := range rpcToRegisters {
-		err = initializer.RegisterRpc(rpcId, function)
+		if keepPayload, ok := auditedRpcs[rpcId]; ok && configuration.AuditLog {
+			function = withAuditLog(rpcId, keepPayload, function)
+		}
+		// Metrics wrap the casing so the latency and sizes are the ones seen by the callers
+		err = initializer.RegisterRpc(rpcId, withRpcMetrics(rpcId, withPayloadCasing(configuration.PayloadCasing, rpcId, function)))
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// The primary account comes first, failover accounts follow in priority order, then the account of each tenant
+	accounts := append([]edgegapAccount{{name: EdgegapAccountPrimary, tenant: TenantDefault, apiHelper: apiHelper}}, parseFailoverAccounts(configuration.ApiUrl, configuration.FailoverApiTokens)...)
+	tenants, _ := parseTenants(configuration.Tenants)
+	accounts = append(accounts, parseTenantAccounts(configuration.ApiUrl, tenants)...)
+	sm.SetTenants(slices.Collect(maps.Keys(tenants)))
+
 	return &EdgegapManager{
 		configuration:  configuration,
-		apiHelper:      helpers.NewAPIClient(configuration.ApiUrl, configuration.ApiToken),
+		apiHelper:      apiHelper,
+		accounts:       accounts,
 		logger:         logger,
 		storageManager: sm,
 		versionManager: dvm,
+		webhooks:       webhooks,
+		notifications:  notifications,
+		savedQueries:   savedQueries,
+		chaos:          chaos,
+		tenants:        tenants,
+		writes:         eem.writes,
 	}, nil
 }
 
@@ -94,74 +235,153 @@ func (em *EdgegapManager) getFormattedUrl(path string) string {
 	return fmt.Sprintf("%s/v2/rpc/%s?http_key=%s&unwrap", em.configuration.NakamaAccessUrl, path, em.configuration.NakamaHttpKey)
 }
 
-// CreateDeployment initiates a new deployment on Edgegap using the given users' IP addresses and metadata.
-func (em *EdgegapManager) CreateDeployment(usersIP []string, metadata map[string]any) (*EdgegapDeploymentResponse, error) {
-	// Prepare deployment data
-	deployment, err := em.getDeploymentCreation(usersIP, metadata)
+// CreateDeployment initiates a new deployment on Edgegap using the given users' placement and metadata.
+func (em *EdgegapManager) CreateDeployment(ctx context.Context, placement *deploymentPlacement, metadata map[string]any) (*EdgegapDeploymentResponse, error) {
+	// Each deployment gets its own identity token to look itself up with whoami
+	identityToken, err := generateIdentityToken()
 	if err != nil {
 		return nil, err
 	}
 
-	// Send deployment request to Edgegap API
-	reply, err := em.apiHelper.Post("/v2/deployments", deployment)
+	// Prepare deployment data
+	deployment, err := em.getDeploymentCreation(placement, metadata, identityToken)
 	if err != nil {
 		return nil, err
 	}
+	if err = em.applyDeploymentHook(ctx, deployment, metadata); err != nil {
+		return nil, err
+	}
+
+	if em.chaos.inject(ChaosVersionNotFound) {
+		return nil, fmt.Errorf("could not create deployment: status %d: version %s not found (chaos)", http.StatusNotFound, deployment.Version)
+	}
+	if em.chaos.inject(ChaosEdgegapError) {
+		return nil, fmt.Errorf("could not create deployment: status %d (chaos)", http.StatusInternalServerError)
+	}
+
+	// Send deployment request to Edgegap API, failing over to the next account of the tenant when one cannot deploy
+	tenant := em.deploymentTenant(metadata)
+	var lastErr error
+	for _, account := range em.accounts {
+		if account.tenant != tenant {
+			continue
+		}
+		response, statusCode, err := em.postCapacityDeployment(account.apiHelper, deployment)
+		if err == nil {
+			response.Account = account.name
+			response.IdentityHash = identityHash(identityToken)
+			response.Filters = deployment.Filters
+			response.MaxDuration = em.applyMaxDuration(account.apiHelper, response.RequestId, metadata)
+			return response, nil
+		}
+
+		lastErr = err
+		if statusCode != 0 && !isFailoverStatus(statusCode) {
+			return nil, err
+		}
+		em.logger.WithFields(map[string]any{"account": account.name, "error": err.Error()}).Warn("Edgegap account cannot deploy, failing over")
+	}
+
+	return nil, lastErr
+}
+
+// postDeployment sends the deployment request with the given account, returning the reply status code on failure.
+func (em *EdgegapManager) postDeployment(apiHelper *helpers.APIClient, deployment *EdgegapDeploymentCreation) (*EdgegapDeploymentResponse, int, error) {
+	reply, err := apiHelper.Post("/v2/deployments", deployment)
+	if err != nil {
+		return nil, 0, err
+	}
 	defer reply.Body.Close()
 
 	// Check if request was accepted
 	if reply.StatusCode != http.StatusAccepted {
 		body, err := io.ReadAll(reply.Body)
 		if err != nil {
-			return nil, err
+			return nil, reply.StatusCode, err
 		}
 		var msg EdgegapApiMessage
 		if jsonErr := json.Unmarshal(body, &msg); jsonErr != nil || msg.Message == "" {
-			return nil, fmt.Errorf("could not create deployment: status %d, body: %s", reply.StatusCode, string(body))
+			return nil, reply.StatusCode, fmt.Errorf("could not create deployment: status %d, body: %s", reply.StatusCode, string(body))
 		}
-		return nil, fmt.Errorf("could not create deployment: status %d: %s", reply.StatusCode, msg.Message)
+		return nil, reply.StatusCode, fmt.Errorf("could not create deployment: status %d: %s", reply.StatusCode, msg.Message)
 	}
 
 	// Parse the response body
 	body, err := io.ReadAll(reply.Body)
 	if err != nil {
-		return nil, err
+		return nil, reply.StatusCode, err
 	}
 
 	var response EdgegapDeploymentResponse
 	if err = json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal deployment response: %w", err)
+		return nil, reply.StatusCode, fmt.Errorf("failed to unmarshal deployment response: %w", err)
 	}
 
-	return &response, nil
+	return &response, reply.StatusCode, nil
 }
 
 // getDeploymentCreation prepares the deployment payload, including metadata and environment variables.
-func (em *EdgegapManager) getDeploymentCreation(usersIP []string, metadata map[string]any) (*EdgegapDeploymentCreation, error) {
+func (em *EdgegapManager) getDeploymentCreation(placement *deploymentPlacement, metadata map[string]any, identityToken string) (*EdgegapDeploymentCreation, error) {
 	var users []EdgegapDeploymentUser
 
 	// Convert user IPs into EdgegapDeploymentUser objects
-	for _, ip := range usersIP {
+	for _, ip := range placement.Ips {
 		users = append(users, EdgegapDeploymentUser{
 			UserType: "ip_address",
 			UserData: EdgegapUserData{IpAddress: ip},
 		})
 	}
 
-	// Marshal metadata into JSON format
-	metadataValue, err := json.Marshal(metadata)
+	// Beacon and explicit locations are placed as geo coordinates users
+	locations, err := parseLocations(metadata)
+	if err != nil {
+		return nil, err
+	}
+	for _, location := range append(slices.Clone(placement.Locations), locations...) {
+		users = append(users, EdgegapDeploymentUser{
+			UserType: "geo_coordinates",
+			UserData: EdgegapUserData{Latitude: &location.Latitude, Longitude: &location.Longitude},
+		})
+	}
+
+	// Geographic filters restrict where the deployment is placed
+	filters, err := em.deploymentFilters(placement, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Server callers can override the container arguments, within the allowlist
+	arguments, err := em.containerArguments(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the forwarded metadata is shipped to the game server, in its environment or fetched on boot
+	metadataVariable, err := em.instanceMetadataVariable(metadata)
 	if err != nil {
 		return nil, err
 	}
 
+	// Tenants deploy their own application, on their configured version
+	tenant, err := em.tenant(em.deploymentTenant(metadata))
+	if err != nil {
+		return nil, err
+	}
+	application := em.configuration.Application
+	if tenant != nil {
+		application = tenant.Application
+	}
+
 	// Use per-deployment version override from metadata if provided,
 	// otherwise fall back to the global version from storage.
 	var version string
 	if v, ok := metadata["edgegap_version"].(string); ok && v != "" {
 		version = v
 		em.logger.Debug("Using per-deployment Edgegap version from metadata: %s", version)
+	} else if tenant != nil {
+		version = tenant.Version
 	} else {
-		version, err = em.getEdgegapVersion()
+		version, err = em.getDeploymentVersion(metadata)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get Edgegap version: %w", err)
 		}
@@ -169,7 +389,7 @@ func (em *EdgegapManager) getDeploymentCreation(usersIP []string, metadata map[s
 
 	// Construct deployment request payload
 	return &EdgegapDeploymentCreation{
-		Application: em.configuration.Application,
+		Application: application,
 		Version:     version,
 		Users:       users,
 		EnvironmentVariables: []EdgegapEnvironmentVariable{
@@ -184,24 +404,49 @@ func (em *EdgegapManager) getDeploymentCreation(usersIP []string, metadata map[s
 				IsHidden: true,
 			},
 			{
-				Key:      "NAKAMA_INSTANCE_METADATA",
-				Value:    string(metadataValue),
-				IsHidden: false,
+				Key:      "NAKAMA_INSTANCE_UPDATE_URL",
+				Value:    em.getFormattedUrl(RpcIdEventInstanceUpdate),
+				IsHidden: true,
 			},
+			{
+				Key:      "NAKAMA_SERVER_PING_URL",
+				Value:    em.getFormattedUrl(RpcIdEventServerPing),
+				IsHidden: true,
+			},
+			{
+				Key:      "NAKAMA_WHOAMI_URL",
+				Value:    em.getFormattedUrl(RpcIdEventWhoami),
+				IsHidden: true,
+			},
+			{
+				Key:      "NAKAMA_IDENTITY_TOKEN",
+				Value:    identityToken,
+				IsHidden: true,
+			},
+			metadataVariable,
 		},
-		Tags: []string{
-			"nakama",
-		},
+		Tags:                em.deploymentTags(metadata),
 		WebhookOnReady:      EdgegapWebhook{Url: em.getFormattedUrl(RpcIdEventDeploymentReady)},
 		WebhookOnError:      EdgegapWebhook{Url: em.getFormattedUrl(RpcIdEventDeploymentError)},
 		WebhookOnTerminated: EdgegapWebhook{Url: em.getFormattedUrl(RpcIdEventDeploymentTerminated)},
+		Filters:             filters,
+		Arguments:           arguments,
 	}, nil
 }
 
 // StopDeployment sends a request to stop an active deployment on Edgegap.
 func (em *EdgegapManager) StopDeployment(requestID string) (*EdgegapApiMessage, error) {
+	apiHelper, err := em.apiHelperFor(requestID)
+	if err != nil {
+		return nil, err
+	}
+	return em.stopDeployment(apiHelper, requestID)
+}
+
+// stopDeployment sends the stop request of a deployment with the given account.
+func (em *EdgegapManager) stopDeployment(apiHelper *helpers.APIClient, requestID string) (*EdgegapApiMessage, error) {
 	// Send stop request to Edgegap API
-	reply, err := em.apiHelper.Delete("/v1/stop/" + requestID)
+	reply, err := apiHelper.Delete("/v1/stop/" + requestID)
 	if err != nil {
 		return nil, err
 	}
@@ -219,55 +464,89 @@ func (em *EdgegapManager) StopDeployment(requestID string) (*EdgegapApiMessage,
 		return &message, err
 	}
 
+	// The deployment already expired or was stopped on Edgegap side
+	if reply.StatusCode == http.StatusNotFound || reply.StatusCode == http.StatusGone {
+		return nil, ErrorDeploymentNotFound
+	}
+
 	return nil, errors.New("Error stopping edgegap deployment " + requestID)
 }
 
-// ListAllDeployments retrieves all deployment summaries from the Edgegap API by paginating until no more pages exist.
-func (em *EdgegapManager) ListAllDeployments() ([]EdgegapDeploymentSummary, error) {
-	var allDeployments []EdgegapDeploymentSummary
-	page := 1
+// UpdateDeploymentMaxDuration prolongs a deployment by updating its max duration, in minutes since it started.
+func (em *EdgegapManager) UpdateDeploymentMaxDuration(requestID string, maxDurationMinutes int) error {
+	apiHelper, err := em.apiHelperFor(requestID)
+	if err != nil {
+		return err
+	}
+	return patchDeploymentMaxDuration(apiHelper, requestID, maxDurationMinutes)
+}
 
-	for {
-		reply, err := em.apiHelper.Get("/v1/deployments?page=" + strconv.Itoa(page))
-		if err != nil {
-			return nil, err
-		}
-		defer reply.Body.Close()
+// ipLookupTtl is how long the location of an IP is cached, instance_list looks it up on every call with max_ping
+const ipLookupTtl = 10 * time.Minute
 
-		if reply.StatusCode != http.StatusOK {
-			return nil, errors.New("error listing all Edgegap deployments")
-		}
+// LookupIP retrieves the geographical location of an IP address from the Edgegap API, cached for ipLookupTtl.
+func (em *EdgegapManager) LookupIP(ip string) (*EdgegapIpLookup, error) {
+	lookup, _, _, err := em.ipLookups.get(ip, ipLookupTtl, func() (*EdgegapIpLookup, error) {
+		return em.fetchIpLookup(ip)
+	})
+	return lookup, err
+}
 
-		body, err := io.ReadAll(reply.Body)
-		if err != nil {
-			return nil, err
-		}
+func (em *EdgegapManager) fetchIpLookup(ip string) (*EdgegapIpLookup, error) {
+	reply, err := em.apiHelper.Get("/v1/ip/" + url.PathEscape(ip) + "/lookup")
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Body.Close()
 
-		var response EdgegapDeploymentList
-		err = json.Unmarshal(body, &response)
-		if err != nil {
-			return nil, err
-		}
+	if reply.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not lookup ip %s: status %d", ip, reply.StatusCode)
+	}
+
+	body, err := io.ReadAll(reply.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var lookup EdgegapIpLookup
+	if err = json.Unmarshal(body, &lookup); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ip lookup response: %w", err)
+	}
 
-		allDeployments = append(allDeployments, response.Data...)
+	return &lookup, nil
+}
 
-		// Check if there's another page
-		if !response.Pagination.HasNext {
-			break
-		}
+// GetVersionMaxDuration retrieves the max duration of deployments for the current app version, 0 if unlimited.
+func (em *EdgegapManager) GetVersionMaxDuration() (time.Duration, error) {
+	version, err := em.getEdgegapVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	reply, err := em.apiHelper.Get(fmt.Sprintf("/v1/app/%s/version/%s", em.configuration.Application, version))
+	if err != nil {
+		return 0, err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("could not get app version: status %d", reply.StatusCode)
+	}
 
-		page = response.Pagination.NextPageNumber
+	var appVersion EdgegapAppVersion
+	if err = json.NewDecoder(reply.Body).Decode(&appVersion); err != nil {
+		return 0, err
 	}
 
-	return allDeployments, nil
+	return time.Duration(appVersion.MaxDuration) * time.Minute, nil
 }
 
-// getEdgegapVersion retrieves the Edgegap version from storage
+// getEdgegapVersion retrieves the Edgegap version from storage, or the environment without dynamic versioning
 func (em *EdgegapManager) getEdgegapVersion() (string, error) {
 	ctx := context.Background()
 
-	// Read version from storage (initial version is already stored at startup if configured)
-	version, _, err := em.storageManager.ReadEdgegapVersion(ctx)
+	// Read version from storage (initial version is already stored at startup if configured), cached in memory
+	version, err := em.versionManager.CurrentVersion(ctx)
 	if err != nil {
 		if errors.Is(err, ErrorNoVersionFound) {
 			return "", errors.New(ErrorMessageNoVersionFound)
@@ -0,0 +1,115 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// portSchemeAllVersions holds the port schemes applied to every app version
+const portSchemeAllVersions = ""
+
+var portSchemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+// EdgegapEndpoint is a connectable port of a deployment, letting web and native builds pick their endpoint.
+type EdgegapEndpoint struct {
+	Name     string `json:"name"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Scheme   string `json:"scheme"`
+	Url      string `json:"url"`
+}
+
+// parsePortSchemes parses "port=scheme" entries, optionally restricted to an app version with "version:port=scheme",
+// e.g. "game=udp,web=wss,v2:web=https".
+func parsePortSchemes(value string) (map[string]map[string]string, error) {
+	schemes := make(map[string]map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, scheme, ok := strings.Cut(entry, "=")
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if !ok || !portSchemePattern.MatchString(scheme) {
+			return nil, fmt.Errorf("invalid port scheme %q, expects [version:]port=scheme", entry)
+		}
+		version, port, found := strings.Cut(key, ":")
+		if !found {
+			version, port = portSchemeAllVersions, key
+		}
+		version, port = strings.TrimSpace(version), strings.TrimSpace(port)
+		if port == "" {
+			return nil, fmt.Errorf("invalid port scheme %q, expects [version:]port=scheme", entry)
+		}
+
+		if schemes[version] == nil {
+			schemes[version] = make(map[string]string)
+		}
+		schemes[version][port] = scheme
+	}
+
+	return schemes, nil
+}
+
+// defaultPortScheme derives the scheme hint from the Edgegap port protocol, UDP is preferred for TCP/UDP ports.
+func defaultPortScheme(protocol string) string {
+	protocol = strings.ToLower(protocol)
+	if strings.Contains(protocol, "udp") {
+		return "udp"
+	}
+	return protocol
+}
+
+// deploymentVersion returns the app version of the deployment. The deployment status reports it, per-deployment
+// overrides are kept in the metadata otherwise.
+func deploymentVersion(instance *runtime.InstanceInfo, deployment *EdgegapDeploymentStatus) string {
+	if deployment.AppVersion != "" {
+		return deployment.AppVersion
+	}
+	version, _ := instance.Metadata["edgegap_version"].(string)
+	return version
+}
+
+// deploymentEndpoints lists the exposed ports of the deployment with the scheme configured for its app version,
+// falling back to the scheme configured for all versions, then to the port protocol.
+func (eem *EdgegapEventManager) deploymentEndpoints(instance *runtime.InstanceInfo, deployment *EdgegapDeploymentStatus) []EdgegapEndpoint {
+	schemes, _ := parsePortSchemes(eem.config.PortSchemes)
+
+	version := deploymentVersion(instance, deployment)
+
+	host := ""
+	if addresses := connectionAddresses(eem.config.ConnectionPreference, &runtime.ConnectionInfo{IpAddress: deployment.PublicIp, DnsName: deployment.Fqdn}, ""); len(addresses) > 0 {
+		host = addresses[0]
+	}
+
+	endpoints := make([]EdgegapEndpoint, 0, len(deployment.Ports))
+	for _, name := range portNames(deployment.Ports) {
+		port := deployment.Ports[name]
+		scheme, ok := schemes[version][name]
+		if !ok {
+			scheme, ok = schemes[portSchemeAllVersions][name]
+		}
+		if !ok {
+			scheme = defaultPortScheme(port.Protocol)
+		}
+
+		endpoint := EdgegapEndpoint{
+			Name:     name,
+			Port:     port.External,
+			Protocol: port.Protocol,
+			Scheme:   scheme,
+		}
+		if scheme != "" && host != "" {
+			endpoint.Url = scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port.External))
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints
+}
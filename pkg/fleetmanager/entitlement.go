@@ -0,0 +1,89 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+// Entitlement check actions
+const (
+	EntitlementActionCreate = "create"
+	EntitlementActionJoin   = "join"
+)
+
+// ErrorEntitlementDenied is returned by Create and Join when the entitlement check rejects the users
+var ErrorEntitlementDenied = errors.New("entitlement denied")
+
+// EntitlementRequest is passed to the entitlement hook, or posted to the entitlement RPC, before a Create or Join
+type EntitlementRequest struct {
+	Action     string         `json:"action"`
+	InstanceId string         `json:"instance_id,omitempty"`
+	UserIds    []string       `json:"user_ids"`
+	Metadata   map[string]any `json:"metadata"`
+}
+
+// EntitlementReply is the reply expected from the entitlement RPC
+type EntitlementReply struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+// EntitlementHook checks the users are entitled to the action, a non nil error rejects it.
+// Hooks can also debit wallets (e.g. with nk.WalletsUpdate) for paid servers, the debit is not refunded
+// if the Create or Join fails afterward.
+type EntitlementHook func(ctx context.Context, req *EntitlementRequest) error
+
+// SetEntitlementHook sets the Go hook called before Create and Join, it takes precedence over NAKAMA_ENTITLEMENT_RPC.
+// A nil hook removes it.
+func (efm *EdgegapFleetManager) SetEntitlementHook(hook EntitlementHook) {
+	efm.hookMu.Lock()
+	defer efm.hookMu.Unlock()
+	efm.entitlementHook = hook
+}
+
+// checkEntitlement runs the entitlement hook, or the configured entitlement RPC, before a Create or Join.
+func (efm *EdgegapFleetManager) checkEntitlement(ctx context.Context, req *EntitlementRequest) error {
+	efm.hookMu.RLock()
+	hook := efm.entitlementHook
+	efm.hookMu.RUnlock()
+
+	if hook != nil {
+		if err := hook(ctx, req); err != nil {
+			return fmt.Errorf("%w: %s", ErrorEntitlementDenied, err.Error())
+		}
+		return nil
+	}
+
+	rpcId := efm.edgegapManager.configuration.EntitlementRpc
+	if rpcId == "" {
+		return nil
+	}
+
+	// Registered RPCs of any runtime can only be reached through the Nakama API
+	reply, err := helpers.NewAPIClient(efm.edgegapManager.getFormattedUrl(rpcId), "").Post("", req)
+	if err != nil {
+		return fmt.Errorf("failed to call entitlement rpc: %w", err)
+	}
+	defer reply.Body.Close()
+
+	body, _ := io.ReadAll(reply.Body)
+	if reply.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: entitlement rpc returned status %d: %s", ErrorEntitlementDenied, reply.StatusCode, string(body))
+	}
+
+	var entitlement EntitlementReply
+	if err = json.Unmarshal(body, &entitlement); err != nil {
+		return fmt.Errorf("failed to parse entitlement rpc reply: %w", err)
+	}
+	if !entitlement.Allowed {
+		return fmt.Errorf("%w: %s", ErrorEntitlementDenied, entitlement.Reason)
+	}
+
+	return nil
+}
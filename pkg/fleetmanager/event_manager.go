@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,6 +20,8 @@ const (
 	RpcIdEventDeploymentTerminated = "edgegap_deployment_terminated"
 	RpcIdEventConnection           = "edgegap_connection"
 	RpcIdEventInstance             = "edgegap_instance"
+	RpcIdEventInstanceUpdate       = "edgegap_instance_update"
+	RpcIdEventServerPing           = "edgegap_server_ping"
 )
 
 var (
@@ -32,8 +36,12 @@ type EventMessage struct {
 }
 
 type EdgegapEventManager struct {
-	config *EdgegapManagerConfiguration
-	sm     *StorageManager
+	config   *EdgegapManagerConfiguration
+	sm       *StorageManager
+	webhooks *WebhookDispatcher
+	relay    *WebhookRelay
+	writes   *writeCoalescer
+	chaos    *chaosMonkey
 }
 
 // unpack extracts headers and query parameters from the context
@@ -59,16 +67,25 @@ func (eem *EdgegapEventManager) unpack(ctx context.Context, payload string) (*Ev
 // handleDeploymentReadyEvent processes the deployment "ready" webhook from Edgegap.
 // It marks the instance as running and stores the connection info (IP, FQDN, external port).
 func (eem *EdgegapEventManager) handleDeploymentReadyEvent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	eem.chaos.delayWebhook()
+
 	msg, err := eem.unpack(ctx, payload)
 	if err != nil {
 		return "", err
 	}
 
+	// Third-party urls get the webhook as received, whatever the outcome here, dead letters were relayed when received
+	if !isDeadLetterReplay(ctx) {
+		eem.relay.Relay(RelayEventDeploymentReady, msg.payload)
+	}
+
 	var deployment EdgegapDeploymentStatus
 	if err := json.Unmarshal([]byte(msg.payload), &deployment); err != nil {
-		return "", err
+		return "", eem.deadLetter(ctx, RpcIdEventDeploymentReady, msg.payload, err)
 	}
 
+	// Webhooks are authoritative, never apply them on a cached copy
+	eem.sm.InvalidateInstance(deployment.RequestId)
 	instance, err := eem.sm.getDbInstance(ctx, deployment.RequestId)
 	if err != nil {
 		return "", err
@@ -78,30 +95,106 @@ func (eem *EdgegapEventManager) handleDeploymentReadyEvent(ctx context.Context,
 	}
 
 	logger.Info("Edgegap deployment ready #%s", deployment.RequestId)
+	port, err := eem.resolvePort(logger, instance, &deployment)
+	if err != nil {
+		// Players could not connect without a port, fail the deployment with the ports it exposes
+		portName, _ := instancePortName(eem.config, instance)
+		return "ok", eem.failDeployment(ctx, logger, instance, err.Error(), map[string]string{
+			"port_name":       portName,
+			"available_ports": strings.Join(portNames(deployment.Ports), ","),
+		})
+	}
 	instance.Status = EdgegapStatusRunning
 	instance.ConnectionInfo = &runtime.ConnectionInfo{
 		IpAddress: deployment.PublicIp,
 		DnsName:   deployment.Fqdn,
-		Port:      deployment.Ports[eem.config.PortName].External,
+		Port:      port.External,
 	}
 
-	return "ok", eem.sm.updateDbInstance(ctx, instance)
+	// Keep the deployment location so instances can be filtered by region
+	ei, err := eem.sm.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return "", err
+	}
+	if deployment.Location.hasCoordinates() {
+		ei.Location = &deployment.Location
+	}
+	ei.Ipv6 = deployment.PublicIpv6
+	ei.Version = deploymentVersion(instance, &deployment)
+	ei.Endpoints = eem.deploymentEndpoints(instance, &deployment)
+	if ei.ExpiresAt.IsZero() {
+		ei.ExpiresAt = eem.deploymentExpiry(logger, instance, ei, deployment.MaxDuration)
+	}
+	instance.Metadata["edgegap"] = ei
+
+	// Game servers that never send the READY instance event are ready as soon as their deployment is
+	if readyOnDeployment(eem.config, instance) {
+		logger.Info("Edgegap instance ready on deployment id=%s", instance.Id)
+		return "ok", eem.markReady(ctx, logger, nk, instance, nil)
+	}
+
+	if err = eem.sm.updateDbInstance(ctx, instance); err != nil {
+		return "", err
+	}
+
+	notifyStatusChanged(ctx, logger, nk, eem.sm, instance, ei)
+	return "ok", nil
+}
+
+// markReady marks the instance as READY with the metadata reported by the game server, then invokes the CreateSuccess
+// callback with the sessions of its players.
+func (eem *EdgegapEventManager) markReady(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, instance *runtime.InstanceInfo, metadata map[string]any) error {
+	instance.Status = EdgegapStatusReady
+
+	// Extract new Metadata coming from the Instance Server and merge it with current
+	instance.Metadata = helpers.MergeMaps(instance.Metadata, metadata)
+
+	ei, err := eem.sm.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return err
+	}
+	eem.recordTimeToReady(ctx, logger, nk, instance, ei)
+	// The ready callback delivers the connection info to the reserved players
+	ei.holdSeats(ei.Reservations, seatHoldTtl(eem.config))
+	instance.Metadata["edgegap"] = ei
+	sessions, sessionsMetadata := createSuccessSessions(eem.config.NakamaHttpKey, instance.Id, ei)
+	fmInstance.provisionReady(ctx, instance)
+
+	if err = eem.sm.updateDbInstance(ctx, instance); err != nil {
+		return err
+	}
+
+	// Invoke the ready callback only after the updated instance (including the
+	// merged game_server metadata) is persisted. Otherwise clients notified by
+	// the callback may query instance_list and read a stale record that is
+	// still missing the game_server fields, causing empty connection info.
+	fmInstance.callbackHandler.InvokeCallback(ei.CallbackId, runtime.CreateSuccess, instance, sessions, sessionsMetadata, nil)
+	return nil
 }
 
 // handleDeploymentErrorEvent processes the deployment "error" webhook from Edgegap.
 // It marks the instance as errored and invokes the CreateError callback so the
 // caller that requested the deployment is notified of the failure.
 func (eem *EdgegapEventManager) handleDeploymentErrorEvent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	eem.chaos.delayWebhook()
+
 	msg, err := eem.unpack(ctx, payload)
 	if err != nil {
 		return "", err
 	}
 
+	// Third-party urls get the webhook as received, whatever the outcome here, dead letters were relayed when received
+	if !isDeadLetterReplay(ctx) {
+		eem.relay.Relay(RelayEventDeploymentError, msg.payload)
+	}
+
 	var deployment EdgegapDeploymentStatus
 	if err := json.Unmarshal([]byte(msg.payload), &deployment); err != nil {
-		return "", err
+		return "", eem.deadLetter(ctx, RpcIdEventDeploymentError, msg.payload, err)
 	}
 
+	// Webhooks are authoritative, never apply them on a cached copy
+	eem.sm.InvalidateInstance(deployment.RequestId)
 	instance, err := eem.sm.getDbInstance(ctx, deployment.RequestId)
 	if err != nil {
 		return "", err
@@ -111,31 +204,99 @@ func (eem *EdgegapEventManager) handleDeploymentErrorEvent(ctx context.Context,
 	}
 
 	logger.Warn("Edgegap deployment error #%s : %s", deployment.RequestId, deployment.ErrorDetail)
+	crashed := instance.Status == EdgegapStatusReady
+	if err = eem.failDeployment(ctx, logger, instance, deployment.ErrorDetail, nil); err != nil {
+		return "", err
+	}
+	if crashed {
+		eem.replaceCrashed(ctx, logger, nk, instance)
+	}
+
+	return "ok", nil
+}
+
+// failDeployment marks the instance as errored, alerts the webhooks with the error detail and extra properties,
+// and invokes the CreateError callback so the caller that requested the deployment is notified of the failure.
+func (eem *EdgegapEventManager) failDeployment(ctx context.Context, logger runtime.Logger, instance *runtime.InstanceInfo, detail string, properties map[string]string) error {
 	instance.Status = EdgegapStatusError
+	webhookProperties := map[string]string{
+		"instance_id":  instance.Id,
+		"error_detail": detail,
+	}
+	for k, v := range properties {
+		webhookProperties[k] = v
+	}
+	eem.webhooks.Dispatch(WebhookEventDeploymentError, fmt.Sprintf("Deployment %s failed: %s", instance.Id, detail), webhookProperties)
 
 	ei, err := eem.sm.ExtractEdgegapInstance(instance)
 	if err != nil {
-		logger.Error("failed to extract edgegap instance for error callback #%s: %v", deployment.RequestId, err)
-		return "", err
+		logger.Error("failed to extract edgegap instance for error callback #%s: %v", instance.Id, err)
+		return err
 	}
-	fmInstance.callbackHandler.InvokeCallback(ei.CallbackId, runtime.CreateError, nil, nil, nil, errors.New("an error occurred with edgegap deployment"))
+	ei.ErrorDetail, ei.ErroredAt = detail, time.Now().UTC()
+	instance.Metadata["edgegap"] = ei
+	eem.sm.recordDailyStat(DailyStatErrors, ei, 0)
+	callbackErr := ErrorDeploymentFailed
+	if len(properties) > 0 {
+		callbackErr = fmt.Errorf("%w: %s", ErrorDeploymentFailed, detail)
+	}
+	fmInstance.callbackHandler.InvokeCallback(ei.CallbackId, runtime.CreateError, instance, nil, nil, callbackErr)
 
-	return "ok", eem.sm.updateDbInstance(ctx, instance)
+	return eem.sm.updateDbInstance(ctx, instance)
+}
+
+// resolvePort returns the port of the deployment named by the instance, or configured, falling back to its first
+// exposed port. A port named by the instance is never substituted, the game server expects players on that port.
+func (eem *EdgegapEventManager) resolvePort(logger runtime.Logger, instance *runtime.InstanceInfo, deployment *EdgegapDeploymentStatus) (EdgegapDeploymentPort, error) {
+	portName, override := instancePortName(eem.config, instance)
+	if port, ok := deployment.Ports[portName]; ok {
+		return port, nil
+	}
+
+	names := portNames(deployment.Ports)
+	if len(names) == 0 {
+		return EdgegapDeploymentPort{}, fmt.Errorf("port %q not found, deployment exposes no ports", portName)
+	}
+	if override {
+		return EdgegapDeploymentPort{}, fmt.Errorf("port %q of the instance not found, deployment exposes %s", portName, strings.Join(names, ","))
+	}
+
+	logger.Warn("Edgegap deployment #%s has no port %q, falling back to port %q (available: %s)", deployment.RequestId, portName, names[0], strings.Join(names, ","))
+	return deployment.Ports[names[0]], nil
+}
+
+// portNames lists the exposed port names in a stable order.
+func portNames(ports map[string]EdgegapDeploymentPort) []string {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
 }
 
 // handleDeploymentTerminatedEvent processes the deployment "terminated" webhook from Edgegap.
 // It marks the instance as terminated so the sync worker can clean it from storage.
 func (eem *EdgegapEventManager) handleDeploymentTerminatedEvent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	eem.chaos.delayWebhook()
+
 	msg, err := eem.unpack(ctx, payload)
 	if err != nil {
 		return "", err
 	}
 
+	// Third-party urls get the webhook as received, whatever the outcome here, dead letters were relayed when received
+	if !isDeadLetterReplay(ctx) {
+		eem.relay.Relay(RelayEventDeploymentTerminated, msg.payload)
+	}
+
 	var deployment EdgegapDeploymentStatus
 	if err := json.Unmarshal([]byte(msg.payload), &deployment); err != nil {
-		return "", err
+		return "", eem.deadLetter(ctx, RpcIdEventDeploymentTerminated, msg.payload, err)
 	}
 
+	// Webhooks are authoritative, never apply them on a cached copy
+	eem.sm.InvalidateInstance(deployment.RequestId)
 	instance, err := eem.sm.getDbInstance(ctx, deployment.RequestId)
 	if err != nil {
 		return "", err
@@ -146,6 +307,9 @@ func (eem *EdgegapEventManager) handleDeploymentTerminatedEvent(ctx context.Cont
 
 	logger.Info("Edgegap deployment terminated #%s", deployment.RequestId)
 	instance.Status = EdgegapStatusTerminated
+	if ei, err := eem.sm.ExtractEdgegapInstance(instance); err == nil {
+		eem.sm.recordDailyStat(DailyStatTerminated, ei, 0)
+	}
 
 	return "ok", eem.sm.updateDbInstance(ctx, instance)
 }
@@ -153,6 +317,10 @@ func (eem *EdgegapEventManager) handleDeploymentTerminatedEvent(ctx context.Cont
 // handleConnectionEvent processes connection-related events.
 // It updates the instance session's connection and reservation metadata.
 func (eem *EdgegapEventManager) handleConnectionEvent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if eem.chaos.inject(ChaosDropConnectionEvent) {
+		return "ok", nil
+	}
+
 	msg, err := eem.unpack(ctx, payload)
 	if err != nil {
 		return "", err
@@ -160,36 +328,165 @@ func (eem *EdgegapEventManager) handleConnectionEvent(ctx context.Context, logge
 
 	var connectionEvent ConnectionEventMessage
 	if err := json.Unmarshal([]byte(msg.payload), &connectionEvent); err != nil {
+		return "", eem.deadLetter(ctx, RpcIdEventConnection, msg.payload, err)
+	}
+
+	instanceId := connectionEvent.InstanceId
+	err = eem.writes.submit(ctx, instanceId, func(instance *runtime.InstanceInfo, edgegapInstance *EdgegapInstanceInfo) (bool, func(ctx context.Context)) {
+		connections := connectionEvent.Connections
+		if connectionEvent.isDelta() {
+			connections = slices.Clone(edgegapInstance.Connections)
+			for _, userId := range connectionEvent.Joined {
+				connections = helpers.AppendIfNotExists(connections, userId)
+			}
+			connections = helpers.RemoveElements(connections, connectionEvent.Left)
+
+			// Nothing to write when the delta does not change the instance
+			if slices.Equal(connections, edgegapInstance.Connections) && !slices.ContainsFunc(edgegapInstance.Reservations, func(userId string) bool {
+				return slices.Contains(connectionEvent.Joined, userId)
+			}) {
+				return false, nil
+			}
+		}
+		if connections == nil {
+			connections = []string{}
+		}
+
+		left := helpers.RemoveElements(edgegapInstance.Connections, connections)
+		before := auditSummary(instance)
+
+		// We want to move all reservations present in the Connections List
+		converted := edgegapInstance.convertReservations(connections)
+		report := func(ctx context.Context) {
+			reportReservationOutcomes(nk, edgegapInstance, ReservationOutcomeConverted, converted)
+			if len(left) > 0 {
+				eem.sm.recordAudit(ctx, &EdgegapAuditEntry{
+					Action:     AuditActionLeave,
+					ActorType:  AuditActorGameServer,
+					Actor:      instanceId,
+					InstanceId: instanceId,
+					UserIds:    left,
+					Before:     before,
+					After:      auditSummary(instance),
+				})
+			}
+		}
+		newReservations := helpers.RemoveElements(edgegapInstance.Reservations, connections)
+		edgegapInstance.Reservations = newReservations
+		edgegapInstance.Connections = connections
+		// Connection events are authoritative over a player count reported with Update
+		edgegapInstance.ReportedPlayerCount = 0
+		edgegapInstance.ReservationsUpdatedAt = time.Now().UTC()
+
+		// A draining persistent instance stops once its last player moved to its replacement
+		if edgegapInstance.DrainingTo != "" && len(connections) == 0 {
+			return true, func(ctx context.Context) {
+				report(ctx)
+				fmInstance.stopDrained(instanceId)
+			}
+		}
+
+		// Freed seats go to the waitlist first
+		promoted := edgegapInstance.promoteWaitlist()
+		if len(promoted) == 0 {
+			return true, report
+		}
+
+		return true, func(ctx context.Context) {
+			report(ctx)
+			logger.Info("Promoted %d waitlisted users on instance %s", len(promoted), instanceId)
+			notifyWaitlistPromoted(ctx, logger, nk, instanceId, promoted)
+		}
+	})
+	if err != nil {
 		return "", err
 	}
 
-	instance, err := eem.sm.getDbInstance(ctx, connectionEvent.InstanceId)
+	return "ok", nil
+}
+
+// handleInstanceUpdateEvent processes player count and metadata updates sent by the game server.
+// It goes through the validated Update path of the fleet manager.
+func (eem *EdgegapEventManager) handleInstanceUpdateEvent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	msg, err := eem.unpack(ctx, payload)
 	if err != nil {
 		return "", err
 	}
 
-	if instance == nil {
-		return "", errors.New("no instance found with instanceId " + connectionEvent.InstanceId)
+	var updateEvent InstanceUpdateMessage
+	if err := json.Unmarshal([]byte(msg.payload), &updateEvent); err != nil {
+		eem.deadLetter(ctx, RpcIdEventInstanceUpdate, msg.payload, err)
+		return "", ErrInvalidInput
+	}
+
+	if updateEvent.InstanceToken != "" {
+		if updateEvent.Metadata == nil {
+			updateEvent.Metadata = make(map[string]any)
+		}
+		updateEvent.Metadata[UpdateMetadataInstanceTokenKey] = updateEvent.InstanceToken
+	}
+
+	eem.writes.flush(updateEvent.InstanceId)
+	if err = fmInstance.Update(ctx, updateEvent.InstanceId, updateEvent.PlayerCount, updateEvent.Metadata); err != nil {
+		if errors.Is(err, ErrorUpdateUnauthorized) {
+			return "", runtime.NewError(err.Error(), 7) // PERMISSION_DENIED
+		}
+		return "", err
+	}
+
+	return "ok", nil
+}
+
+// handleServerPingEvent lets a game server validate its link with Nakama at boot, before declaring READY.
+// Reaching it proves the injected url and HTTP key work, the reply confirms the instance mapping
+// and lists the users expected to connect.
+func (eem *EdgegapEventManager) handleServerPingEvent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdEventServerPing); err != nil {
+		return "", err
 	}
 
-	edgegapInstance, err := eem.sm.ExtractEdgegapInstance(instance)
+	msg, err := eem.unpack(ctx, payload)
 	if err != nil {
 		return "", err
 	}
 
-	// We want to move all reservations present in the Connections List
-	newReservations := helpers.RemoveElements(edgegapInstance.Reservations, connectionEvent.Connections)
-	edgegapInstance.Reservations = newReservations
-	edgegapInstance.Connections = connectionEvent.Connections
-	edgegapInstance.ReservationsUpdatedAt = time.Now().UTC()
-	instance.Metadata["edgegap"] = edgegapInstance
+	var ping ServerPingMessage
+	if err := json.Unmarshal([]byte(msg.payload), &ping); err != nil || ping.InstanceId == "" {
+		return "", ErrInvalidInput
+	}
 
-	err = eem.sm.updateDbInstance(ctx, instance)
+	eem.sm.InvalidateInstance(ping.InstanceId)
+	instance, err := eem.sm.getDbInstance(ctx, ping.InstanceId)
+	if err != nil {
+		return "", err
+	}
+	if instance == nil {
+		logger.Warn("Server ping from unknown instance %s", ping.InstanceId)
+		return "", runtime.NewError("no instance found with instanceId "+ping.InstanceId, 5) // NOT_FOUND
+	}
+
+	if ping.InstanceToken != "" && ping.InstanceToken != instanceToken(eem.config.NakamaHttpKey, instance.Id) {
+		return "", runtime.NewError(ErrorUpdateUnauthorized.Error(), 7) // PERMISSION_DENIED
+	}
+
+	ei, err := eem.sm.ExtractEdgegapInstance(instance)
 	if err != nil {
 		return "", err
 	}
 
-	return "ok", nil
+	reply, err := json.Marshal(ServerPingReply{
+		Ok:           true,
+		InstanceId:   instance.Id,
+		Status:       instance.Status,
+		MaxPlayers:   ei.MaxPlayers,
+		Reservations: ei.Reservations,
+		Connections:  ei.Connections,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(reply), nil
 }
 
 // handleInstanceEvent processes instance state change events.
@@ -202,9 +499,14 @@ func (eem *EdgegapEventManager) handleInstanceEvent(ctx context.Context, logger
 
 	var instanceEvent InstanceEventMessage
 	if err := json.Unmarshal([]byte(msg.payload), &instanceEvent); err != nil {
-		return "", err
+		return "", eem.deadLetter(ctx, RpcIdEventInstance, msg.payload, err)
 	}
 
+	// Coalesced connection changes received before this event are written first
+	eem.writes.flush(instanceEvent.InstanceId)
+
+	// Webhooks are authoritative, never apply them on a cached copy
+	eem.sm.InvalidateInstance(instanceEvent.InstanceId)
 	instance, err := eem.sm.getDbInstance(ctx, instanceEvent.InstanceId)
 	if err != nil {
 		return "", err
@@ -214,22 +516,21 @@ func (eem *EdgegapEventManager) handleInstanceEvent(ctx context.Context, logger
 		return "", errors.New("no instance found with instanceId " + instanceEvent.InstanceId)
 	}
 
-	stopping := false
-	readyCallbackId := ""
+	stopping, crashed := false, false
 
 	switch strings.ToUpper(instanceEvent.Action) {
 	case InstanceEventStateReady:
 		logger.Info("Edgegap instance ready id=%s : %s", instanceEvent.InstanceId, instanceEvent.Message)
-		instance.Status = EdgegapStatusReady
-
-		// Extract new Metadata coming from the Instance Server and merge it with current
-		instance.Metadata = helpers.MergeMaps(instance.Metadata, instanceEvent.Metadata)
 
-		ei, err := eem.sm.ExtractEdgegapInstance(instance)
-		if err != nil {
+		// Already ready on deployment, the game server only adds its metadata
+		if instance.Status == EdgegapStatusReady && readyOnDeployment(eem.config, instance) {
+			instance.Metadata = helpers.MergeMaps(instance.Metadata, instanceEvent.Metadata)
+			break
+		}
+		if err = eem.markReady(ctx, logger, nk, instance, instanceEvent.Metadata); err != nil {
 			return "", err
 		}
-		readyCallbackId = ei.CallbackId
+		return "ok", nil
 
 	case InstanceEventStateStop:
 		logger.Info("Edgegap instance stop #%s: %s", instanceEvent.InstanceId, instanceEvent.Message)
@@ -238,7 +539,13 @@ func (eem *EdgegapEventManager) handleInstanceEvent(ctx context.Context, logger
 
 	case InstanceEventStateError:
 		logger.Error("Edgegap instance state error #%s: %s", instanceEvent.InstanceId, instanceEvent.Message)
+		crashed = instance.Status == EdgegapStatusReady
 		instance.Status = EdgegapStatusError
+		if ei, err := eem.sm.ExtractEdgegapInstance(instance); err == nil {
+			ei.ErrorDetail, ei.ErroredAt = instanceEvent.Message, time.Now().UTC()
+			instance.Metadata["edgegap"] = ei
+			eem.sm.recordDailyStat(DailyStatErrors, ei, 0)
+		}
 
 	default:
 		logger.Error("Unknown action #%s: %s", instanceEvent.Action, instanceEvent.Message)
@@ -250,20 +557,15 @@ func (eem *EdgegapEventManager) handleInstanceEvent(ctx context.Context, logger
 		return "", err
 	}
 
-	// Invoke the ready callback only after the updated instance (including the
-	// merged game_server metadata) is persisted. Otherwise clients notified by
-	// the callback may query instance_list and read a stale record that is
-	// still missing the game_server fields, causing empty connection info.
-	if readyCallbackId != "" {
-		fmInstance.callbackHandler.InvokeCallback(readyCallbackId, runtime.CreateSuccess, instance, nil, nil, nil)
-	}
-
 	if stopping {
 		_, err := fmInstance.edgegapManager.StopDeployment(instanceEvent.InstanceId)
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrorDeploymentNotFound) {
 			return "", err
 		}
 	}
+	if crashed {
+		eem.replaceCrashed(ctx, logger, nk, instance)
+	}
 
 	return "ok", nil
 }
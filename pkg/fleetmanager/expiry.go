@@ -0,0 +1,102 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Game server callback event sent shortly before the deployment expires
+const GameServerEventExpiring = "expiring"
+
+// deploymentExpiry computes when a deployment expires, from the max duration reported by Edgegap
+// or else the max duration of the app version. It returns the zero time when the deployment never expires.
+func (eem *EdgegapEventManager) deploymentExpiry(logger runtime.Logger, instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo, maxDurationMinutes int) time.Time {
+	maxDuration := time.Duration(maxDurationMinutes) * time.Minute
+	if maxDuration <= 0 {
+		var err error
+		maxDuration, err = fmInstance.edgegapManager.GetVersionMaxDuration()
+		if err != nil {
+			logger.WithField("error", err.Error()).Warn("failed to get app version max duration for instance %s", instance.Id)
+			return time.Time{}
+		}
+	}
+	if maxDuration <= 0 {
+		return time.Time{}
+	}
+
+	startedAt := ei.RequestedAt
+	if startedAt.IsZero() {
+		startedAt = instance.CreateTime
+	}
+
+	return startedAt.Add(maxDuration)
+}
+
+// instanceExpiry returns the expiry of an instance, or the zero time if unknown.
+func instanceExpiry(instance *runtime.InstanceInfo) time.Time {
+	if instance == nil {
+		return time.Time{}
+	}
+
+	ei, err := fmInstance.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return ei.ExpiresAt
+}
+
+// warnExpiringInstances warns game servers through their callback url shortly before their deployment expires,
+// so they can end the match gracefully. Each instance is warned once per expiry.
+func (efm *EdgegapFleetManager) warnExpiringInstances() {
+	expiryWarning, err := time.ParseDuration(efm.edgegapManager.configuration.ExpiryWarning)
+	if err != nil || expiryWarning <= 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	query := fmt.Sprintf("+value.metadata.edgegap.expires_at:>\"%s\" +value.metadata.edgegap.expires_at:<=\"%s\"", now.Format(time.RFC3339), now.Add(expiryWarning).Format(time.RFC3339))
+	objects, err := efm.storageManager.listIndexes(efm.ctx, query, 1_000)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to list expiring instances")
+		return
+	}
+
+	results := make([]*runtime.InstanceInfo, 0)
+	versions := make(map[string]string)
+	for _, so := range objects {
+		info, err := decodeInstance(so.Value)
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to unmarshal instance info")
+			continue
+		}
+		if err = efm.storageManager.attachUsers(efm.ctx, info); err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to read instance user lists")
+			continue
+		}
+		ei, err := efm.storageManager.ExtractEdgegapInstance(info)
+		if err != nil || ei.ExpiresAt.IsZero() || ei.ExpiryWarned {
+			continue
+		}
+
+		notifyGameServer(efm.logger, info, GameServerEventExpiring, map[string]any{
+			"expires_at":     ei.ExpiresAt,
+			"expires_in_sec": int64(ei.ExpiresAt.Sub(now).Seconds()),
+		})
+		ei.ExpiryWarned = true
+		info.Metadata["edgegap"] = ei
+		results = append(results, info)
+		versions[info.Id] = so.Version
+	}
+
+	if len(results) == 0 {
+		return
+	}
+
+	efm.logger.Debug("Warned %d instances of their upcoming expiry", len(results))
+	if _, err = efm.storageManager.updateDbInstances(efm.ctx, results, versions); err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to update expiring instances")
+	}
+}
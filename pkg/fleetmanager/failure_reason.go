@@ -0,0 +1,59 @@
+package fleetmanager
+
+import (
+	"errors"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Reasons of the create-failed and create-timeout notifications, localized with the reasons of the notification
+// templates
+const (
+	CreateFailureTimeout            = "timeout"
+	CreateFailureQuotaReached       = "quota_reached"
+	CreateFailureEntitlementDenied  = "entitlement_denied"
+	CreateFailureInsufficientFunds  = "insufficient_funds"
+	CreateFailureRentalUnavailable  = "rental_unavailable"
+	CreateFailurePlacement          = "placement_unavailable"
+	CreateFailureDeploymentRejected = "deployment_rejected"
+	CreateFailureEdgegapUnavailable = "edgegap_unavailable"
+	CreateFailureDeploymentError    = "deployment_error"
+	CreateFailureCancelled          = "cancelled"
+	CreateFailureInternal           = "internal_error"
+)
+
+var (
+	// ErrorEdgegapUnavailable is reported to the create callback when Edgegap refuses or fails the deployment request
+	ErrorEdgegapUnavailable = errors.New("error while communicating with Edgegap")
+	// ErrorDeploymentFailed is reported to the create callback when the deployment goes into error
+	ErrorDeploymentFailed = errors.New("an error occurred with edgegap deployment")
+	// ErrorPendingDeleted is reported to the create callback when a pending instance is deleted before its start
+	ErrorPendingDeleted = errors.New("pending instance deleted before start")
+)
+
+// createFailureReason returns the reason of a failed or timed out creation for the notifications.
+func createFailureReason(status runtime.FmCreateStatus, err error) string {
+	switch {
+	case status == runtime.CreateTimeout:
+		return CreateFailureTimeout
+	case errors.Is(err, ErrorQuotaReached):
+		return CreateFailureQuotaReached
+	case errors.Is(err, ErrorEntitlementDenied):
+		return CreateFailureEntitlementDenied
+	case errors.Is(err, ErrorInsufficientFunds):
+		return CreateFailureInsufficientFunds
+	case errors.Is(err, ErrorRentalUnavailable):
+		return CreateFailureRentalUnavailable
+	case errors.Is(err, ErrorPlacementRequired), errors.Is(err, ErrorInvalidLocations):
+		return CreateFailurePlacement
+	case errors.Is(err, ErrorDeploymentRejected):
+		return CreateFailureDeploymentRejected
+	case errors.Is(err, ErrorEdgegapUnavailable):
+		return CreateFailureEdgegapUnavailable
+	case errors.Is(err, ErrorDeploymentFailed):
+		return CreateFailureDeploymentError
+	case errors.Is(err, ErrorPendingDeleted):
+		return CreateFailureCancelled
+	}
+	return CreateFailureInternal
+}
@@ -0,0 +1,195 @@
+package fleetmanager
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// fakeObjectKey identifies a storage object of the fake Nakama module
+type fakeObjectKey struct {
+	collection string
+	key        string
+	userId     string
+}
+
+// fakeNakama is an in-memory runtime.NakamaModule with Nakama's storage semantics: object versions, conditional
+// writes and deletes, and batches applied all or nothing. Methods it does not implement panic through the nil
+// embedded module.
+type fakeNakama struct {
+	runtime.NakamaModule
+
+	mu      sync.Mutex
+	objects map[fakeObjectKey]*api.StorageObject
+	seq     int
+	reads   map[string]int
+	// indexes maps the storage indexes to their collection, StorageIndexList lists it by key whatever the query
+	indexes map[string]string
+
+	// beforeWrite runs before each write batch is applied, outside the lock, e.g. to interleave a concurrent write
+	beforeWrite func(writes []*runtime.StorageWrite)
+}
+
+func newFakeNakama() *fakeNakama {
+	return &fakeNakama{objects: make(map[fakeObjectKey]*api.StorageObject), reads: make(map[string]int), indexes: make(map[string]string)}
+}
+
+func (f *fakeNakama) StorageRead(ctx context.Context, reads []*runtime.StorageRead) ([]*api.StorageObject, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects := make([]*api.StorageObject, 0, len(reads))
+	for _, read := range reads {
+		f.reads[read.Collection]++
+		if obj, ok := f.objects[fakeObjectKey{read.Collection, read.Key, read.UserID}]; ok {
+			objects = append(objects, &api.StorageObject{
+				Collection:      obj.Collection,
+				Key:             obj.Key,
+				UserId:          obj.UserId,
+				Value:           obj.Value,
+				Version:         obj.Version,
+				PermissionRead:  obj.PermissionRead,
+				PermissionWrite: obj.PermissionWrite,
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (f *fakeNakama) StorageWrite(ctx context.Context, writes []*runtime.StorageWrite) ([]*api.StorageObjectAck, error) {
+	if f.beforeWrite != nil {
+		f.beforeWrite(writes)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, write := range writes {
+		existing, ok := f.objects[fakeObjectKey{write.Collection, write.Key, write.UserID}]
+		switch {
+		case write.Version == "":
+		case write.Version == "*":
+			if ok {
+				return nil, runtime.ErrStorageRejectedVersion
+			}
+		case !ok || existing.Version != write.Version:
+			return nil, runtime.ErrStorageRejectedVersion
+		}
+	}
+
+	acks := make([]*api.StorageObjectAck, 0, len(writes))
+	for _, write := range writes {
+		f.seq++
+		obj := &api.StorageObject{
+			Collection:      write.Collection,
+			Key:             write.Key,
+			UserId:          write.UserID,
+			Value:           write.Value,
+			Version:         strconv.Itoa(f.seq),
+			PermissionRead:  int32(write.PermissionRead),
+			PermissionWrite: int32(write.PermissionWrite),
+		}
+		f.objects[fakeObjectKey{write.Collection, write.Key, write.UserID}] = obj
+		acks = append(acks, &api.StorageObjectAck{Collection: obj.Collection, Key: obj.Key, UserId: obj.UserId, Version: obj.Version})
+	}
+	return acks, nil
+}
+
+func (f *fakeNakama) StorageDelete(ctx context.Context, deletes []*runtime.StorageDelete) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, del := range deletes {
+		existing, ok := f.objects[fakeObjectKey{del.Collection, del.Key, del.UserID}]
+		if del.Version != "" && (!ok || existing.Version != del.Version) {
+			return runtime.ErrStorageRejectedVersion
+		}
+	}
+	for _, del := range deletes {
+		delete(f.objects, fakeObjectKey{del.Collection, del.Key, del.UserID})
+	}
+	return nil
+}
+
+func (f *fakeNakama) StorageIndexList(ctx context.Context, callerID, indexName, query string, limit int, order []string, cursor string) (*api.StorageObjects, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	objects := make([]*api.StorageObject, 0)
+	for key, obj := range f.objects {
+		if key.collection == f.indexes[indexName] {
+			objects = append(objects, obj)
+		}
+	}
+	slices.SortFunc(objects, func(a, b *api.StorageObject) int { return cmp.Compare(a.Key, b.Key) })
+
+	offset, _ := strconv.Atoi(cursor)
+	objects = objects[min(offset, len(objects)):]
+	if len(objects) <= limit {
+		return &api.StorageObjects{Objects: objects}, "", nil
+	}
+	return &api.StorageObjects{Objects: objects[:limit]}, strconv.Itoa(offset + limit), nil
+}
+
+func (f *fakeNakama) MetricsCounterAdd(name string, tags map[string]string, delta int64)          {}
+func (f *fakeNakama) MetricsGaugeSet(name string, tags map[string]string, value float64)          {}
+func (f *fakeNakama) MetricsTimerRecord(name string, tags map[string]string, value time.Duration) {}
+
+// readCount returns the number of objects read from the collection.
+func (f *fakeNakama) readCount(collection string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads[collection]
+}
+
+// has reports whether an object is stored under the key of the collection.
+func (f *fakeNakama) has(collection, key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.objects[fakeObjectKey{collection, key, ""}]
+	return ok
+}
+
+// newFakeFleetManager returns the fleet manager of a Nakama node storing its instances in nk.
+func newFakeFleetManager(nk *fakeNakama, config *EdgegapManagerConfiguration) *EdgegapFleetManager {
+	ctx := context.Background()
+	sm := NewStorageManager(nk, fakeLogger{})
+	em := &EdgegapManager{configuration: config, logger: fakeLogger{}, storageManager: sm}
+	em.writes = newWriteCoalescer(ctx, fakeLogger{}, sm, 0)
+	return &EdgegapFleetManager{ctx: ctx, logger: fakeLogger{}, nk: nk, edgegapManager: em, storageManager: sm}
+}
+
+// fakeLogger discards the logs
+type fakeLogger struct{}
+
+func (l fakeLogger) Debug(format string, v ...interface{})                   {}
+func (l fakeLogger) Info(format string, v ...interface{})                    {}
+func (l fakeLogger) Warn(format string, v ...interface{})                    {}
+func (l fakeLogger) Error(format string, v ...interface{})                   {}
+func (l fakeLogger) WithField(key string, v interface{}) runtime.Logger      { return l }
+func (l fakeLogger) WithFields(fields map[string]interface{}) runtime.Logger { return l }
+func (l fakeLogger) Fields() map[string]interface{}                          { return nil }
+
+// fakeCallbackHandler records the status each create callback was invoked with
+type fakeCallbackHandler struct {
+	mu       sync.Mutex
+	statuses map[string]runtime.FmCreateStatus
+}
+
+func (h *fakeCallbackHandler) GenerateCallbackId() string                                   { return "" }
+func (h *fakeCallbackHandler) SetCallback(callbackId string, fn runtime.FmCreateCallbackFn) {}
+
+func (h *fakeCallbackHandler) InvokeCallback(callbackId string, status runtime.FmCreateStatus, instanceInfo *runtime.InstanceInfo, sessionInfo []*runtime.SessionInfo, metadata map[string]any, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.statuses == nil {
+		h.statuses = make(map[string]runtime.FmCreateStatus)
+	}
+	h.statuses[callbackId] = status
+}
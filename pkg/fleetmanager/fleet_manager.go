@@ -3,18 +3,22 @@ package fleetmanager
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/api"
 	"github.com/heroiclabs/nakama-common/runtime"
 )
 
 const (
 	DeploymentIdKey = "deployment_id"
+	// CallbackIdKey correlates the Create reply with its callback and the notifications sent by it
+	CallbackIdKey = "callback_id"
 )
 
 var (
@@ -32,6 +36,11 @@ type EdgegapFleetManager struct {
 	callbackHandler runtime.FmCallbackHandler
 	edgegapManager  *EdgegapManager
 	storageManager  *StorageManager
+	hookMu          sync.RWMutex
+	entitlementHook EntitlementHook
+	readyHook       ReadyHook
+	waiters         *createWaiters
+	orphans         *orphanTracker
 }
 
 // NewEdgegapFleetManager initializes a new fleet manager instance with dependencies.
@@ -45,17 +54,24 @@ func NewEdgegapFleetManager(ctx context.Context, logger runtime.Logger, db *sql.
 		return nil, err
 	}
 
-	// Register Storage Index for tracking Edgegap instances
-	if err := initializer.RegisterStorageIndex(
-		StorageEdgegapIndex,
-		StorageEdgegapInstancesCollection,
-		"",
-		[]string{"id", "create_time", "status", "player_count", "metadata"},
-		[]string{"create_time", "player_count"},
-		1_000_000,
-		false,
-	); err != nil {
-		return nil, err
+	// Register Storage Index for tracking Edgegap instances, one per tenant
+	for _, collections := range sm.allCollections() {
+		if err := initializer.RegisterStorageIndex(
+			collections.index,
+			collections.instances,
+			"",
+			[]string{"id", "create_time", "status", "player_count", "metadata"},
+			[]string{"create_time", "player_count"},
+			em.configuration.StorageIndexMaxEntries,
+			false,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	// Upgrade the instances written by older releases before serving them
+	if _, err := sm.MigrateInstances(ctx, 100); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to migrate stored instances, they are migrated when read")
 	}
 
 	return &EdgegapFleetManager{
@@ -66,9 +82,16 @@ func NewEdgegapFleetManager(ctx context.Context, logger runtime.Logger, db *sql.
 		callbackHandler: nil,
 		edgegapManager:  em,
 		storageManager:  sm,
+		waiters:         newCreateWaiters(),
+		orphans:         newOrphanTracker(),
 	}, nil
 }
 
+// Name returns the name of the Edgegap fleet, to route to it among other fleets.
+func (efm *EdgegapFleetManager) Name() string {
+	return efm.edgegapManager.configuration.FleetName
+}
+
 // Init sets up the Nakama module and callback handler for the fleet manager.
 func (efm *EdgegapFleetManager) Init(nk runtime.NakamaModule, callbackHandler runtime.FmCallbackHandler) error {
 	efm.nk = nk
@@ -81,39 +104,107 @@ func (efm *EdgegapFleetManager) Init(nk runtime.NakamaModule, callbackHandler ru
 	// Background worker to sync deployment info from Edgegap.
 	go efm.syncInstancesWorker()
 	go efm.runCleanupScheduler()
+	go efm.runAuditScheduler()
+	go efm.runMergeScheduler()
+	go efm.runRetentionScheduler()
 
 	return nil
 }
 
 // Create provisions a new Edgegap deployment based on the given players.
-func (efm *EdgegapFleetManager) Create(ctx context.Context, maxPlayers int, userIds []string, latencies []runtime.FleetUserLatencies, metadata map[string]any, callback runtime.FmCreateCallbackFn) (map[string]string, error) {
+func (efm *EdgegapFleetManager) Create(ctx context.Context, maxPlayers int, userIds []string, latencies []runtime.FleetUserLatencies, metadata map[string]any, callback runtime.FmCreateCallbackFn) (result map[string]string, err error) {
 	efm.logger.Info("Requesting a new Deployment")
 	callbackId := efm.callbackHandler.GenerateCallbackId()
+	if waited, _ := ctx.Value(syncCreateKey{}).(bool); waited {
+		callback = efm.waiters.register(callbackId, callback)
+	}
 	efm.callbackHandler.SetCallback(callbackId, callback)
+	defer func() {
+		if err != nil {
+			efm.waiters.remove(callbackId)
+		} else if result != nil {
+			result[CallbackIdKey] = callbackId
+			efm.storageManager.recordAudit(ctx, &EdgegapAuditEntry{
+				Action:     AuditActionCreate,
+				InstanceId: result[InstanceIdKey],
+				UserIds:    userIds,
+				Details:    map[string]any{"max_players": maxPlayers, "deferred": isDeferredCreate(metadata)},
+			})
+		}
+	}()
 
-	// Fetch IP addresses of users
-	userIps, err := efm.storageManager.getUserIPs(ctx, userIds)
-	if err != nil {
-		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, errors.New("unexpected Error while parsing Users Data"))
+	if err = checkPersistentCreate(ctx, metadata); err != nil {
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, err)
 		return nil, err
 	}
 
-	// Use caller IP if user IPs are unavailable
-	if len(userIps) == 0 {
-		callerIP, ok := ctx.Value(runtime.RUNTIME_CTX_CLIENT_IP).(string)
-		if !ok {
-			efm.logger.Error("failed to extract client IP from context")
-			efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, errors.New("an unexpected error occurred"))
-			return nil, ErrInternalError
+	if err = validateMetadataSize(efm.edgegapManager.configuration, metadata); err != nil {
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, err)
+		return nil, err
+	}
+
+	if err = checkPlacement(metadata, userIds); err != nil {
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, err)
+		return nil, err
+	}
+
+	if err = efm.checkModeQuota(ctx, metadata); err != nil {
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, err)
+		return nil, err
+	}
+
+	if err = efm.checkTenant(ctx, metadata); err != nil {
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, err)
+		return nil, err
+	}
+
+	if err = efm.checkEntitlement(ctx, &EntitlementRequest{Action: EntitlementActionCreate, UserIds: userIds, Metadata: metadata}); err != nil {
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, err)
+		return nil, err
+	}
+
+	// Paid rentals are charged upfront and refunded if the creation fails or times out
+	if isRentalCreate(metadata) {
+		var purchase *EdgegapPurchase
+		purchase, err = efm.chargeRental(ctx, callbackId, userIds)
+		if err != nil {
+			efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, err)
+			return nil, err
+		}
+		efm.callbackHandler.SetCallback(callbackId, efm.refundOnFailure(purchase, callback))
+		metadata[InstanceMetadataPurchaseKey] = purchase
+		defer func() {
+			if err == nil {
+				efm.linkPurchase(ctx, purchase, result)
+			}
+		}()
+	}
+
+	// Deferred start only reserves the instance record, the deployment is created by StartDeferred
+	if isDeferredCreate(metadata) {
+		return efm.createDeferred(ctx, maxPlayers, userIds, callbackId, metadata)
+	}
+
+	// Fetch beacon locations or IP addresses of users, falling back to the caller IP unless explicit placement is required
+	placement, err := efm.placement(ctx, userIds, metadata)
+	if err != nil {
+		callbackErr := errors.New("unexpected Error while parsing Users Data")
+		if errors.Is(err, ErrorPlacementRequired) {
+			callbackErr = err
 		}
-		userIps = append(userIps, callerIP)
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, callbackErr)
+		return nil, err
 	}
 
 	// Request Edgegap deployment
-	deployment, err := efm.edgegapManager.CreateDeployment(userIps, metadata)
+	deployment, err := efm.edgegapManager.CreateDeployment(ctx, placement, metadata)
 	if err != nil {
 		efm.logger.WithField("error", err).Error("failed to create Edgegap instance")
-		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, errors.New("error while communicating with Edgegap"))
+		callbackErr := ErrorEdgegapUnavailable
+		if errors.Is(err, ErrorDeploymentRejected) {
+			callbackErr = err
+		}
+		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, callbackErr)
 		return nil, err
 	}
 
@@ -125,14 +216,34 @@ func (efm *EdgegapFleetManager) Create(ctx context.Context, maxPlayers int, user
 	}
 
 	// Store the new instance session in the database
-	_, err = efm.storageManager.createDbInstance(ctx, deployment.RequestId, maxPlayers, userIds, callbackId, metadata)
+	edgegapInstance := EdgegapInstanceInfo{
+		MaxPlayers:   maxPlayers,
+		Reservations: userIds,
+		CallbackId:   callbackId,
+		RequestedAt:  time.Now().UTC(),
+		Account:      deployment.Account,
+		IdentityHash: deployment.IdentityHash,
+		Capacity:     deployment.Capacity,
+		Filters:      deployment.Filters,
+	}
+	if deployment.MaxDuration > 0 {
+		edgegapInstance.ExpiresAt = edgegapInstance.RequestedAt.Add(time.Duration(deployment.MaxDuration) * time.Minute)
+	}
+	if isPersistentCreate(metadata) {
+		edgegapInstance.makePersistent()
+	}
+	instance, err := efm.storageManager.createDbInstance(ctx, deployment.RequestId, EdgegapStatusRequested, edgegapInstance, metadata)
 	if err != nil {
 		efm.logger.WithField("error", err).Error("failed to create Storage Instance Session")
+		// The deployment would run and be billed without any record to join it or stop it
+		efm.stopOrphanedDeployment(deployment, err)
 		efm.callbackHandler.InvokeCallback(callbackId, runtime.CreateError, nil, nil, nil, errors.New("error while creating Instance Session"))
 		return nil, err
 	}
+	notifyStatusChanged(ctx, efm.logger, efm.nk, efm.storageManager, instance, &edgegapInstance)
+	efm.storageManager.recordDailyStat(DailyStatCreated, &edgegapInstance, 0)
 
-	return map[string]string{DeploymentIdKey: deployment.RequestId}, nil
+	return map[string]string{DeploymentIdKey: deployment.RequestId, InstanceIdKey: deployment.RequestId}, nil
 }
 
 // Get retrieves an instance session instance by its ID.
@@ -141,21 +252,32 @@ func (efm *EdgegapFleetManager) Get(ctx context.Context, id string) (*runtime.In
 }
 
 // List retrieves instance session instances based on a query, sorted by player count and creation time.
+// Instances with split user lists are listed without them, read them by id to get them.
 func (efm *EdgegapFleetManager) List(ctx context.Context, query string, limit int, cursor string) ([]*runtime.InstanceInfo, string, error) {
-	entries, newCursor, err := efm.nk.StorageIndexList(ctx, "", StorageEdgegapIndex, query, limit, []string{"player_count", "-create_time"}, cursor)
+	// Clients list the index of the tenant of their session, other callers the index of every tenant in turn
+	indexes := efm.storageManager.instanceIndexes()
+	if _, isClient := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); isClient {
+		indexes = []string{efm.storageManager.collectionsOfTenant(callerTenant(ctx)).index}
+	}
+	objects, newCursor, err := efm.storageManager.listIndexesPage(ctx, indexes, query, limit, []string{"player_count", "-create_time"}, cursor)
 	if err != nil {
 		return nil, "", err
 	}
 
 	results := make([]*runtime.InstanceInfo, 0)
-	for _, so := range entries.GetObjects() {
-		var info *runtime.InstanceInfo
-		if err = json.Unmarshal([]byte(so.Value), &info); err != nil {
+	for _, so := range objects {
+		info, err := decodeInstance(so.Value)
+		if err != nil {
 			return nil, "", err
 		}
 		results = append(results, info)
 	}
 
+	// Instances created moments ago may not be indexed yet, they have no players and would be listed first
+	if cursor == "" {
+		results = append(efm.storageManager.recoverRecentInstances(ctx, query, results), results...)
+	}
+
 	return results, newCursor, nil
 }
 
@@ -165,113 +287,302 @@ func (efm *EdgegapFleetManager) Join(ctx context.Context, id string, userIds []s
 		return nil, errors.New("expects id to be a valid InstanceSessionId")
 	}
 
-	instance, err := efm.storageManager.getDbInstance(ctx, id)
-	if err != nil {
-		return nil, errors.New("instance not found")
-	}
-
 	if len(userIds) < 1 {
 		return nil, errors.New("expects userIds to have at least one valid user id")
 	}
 
-	edgegapInstance, err := efm.storageManager.ExtractEdgegapInstance(instance)
-	if err != nil {
-		return nil, errors.New("error extracting Edgegap instance")
+	// Draining persistent instances hand their players over to their replacement, which may be draining in turn
+	var instance *runtime.InstanceInfo
+	var edgegapInstance *EdgegapInstanceInfo
+	var err error
+	visited := make(map[string]bool, 1)
+	for {
+		if visited[id] || len(visited) > maxDrainingHops {
+			return nil, ErrorDrainingLoop
+		}
+		visited[id] = true
+
+		// Connection events still coalescing are written first, seats are allocated on the current connections
+		efm.edgegapManager.writes.flush(id)
+		if instance, err = efm.storageManager.getDbInstance(ctx, id); err != nil || instance == nil {
+			return nil, errors.New("instance not found")
+		}
+		if edgegapInstance, err = efm.storageManager.ExtractEdgegapInstance(instance); err != nil {
+			return nil, errors.New("error extracting Edgegap instance")
+		}
+		if edgegapInstance.DrainingTo == "" {
+			break
+		}
+		id = edgegapInstance.DrainingTo
+	}
+
+	joinMetadata := make(map[string]any, len(metadata))
+	for k, v := range metadata {
+		joinMetadata[k] = v
+	}
+	if err = efm.checkEntitlement(ctx, &EntitlementRequest{Action: EntitlementActionJoin, InstanceId: id, UserIds: userIds, Metadata: joinMetadata}); err != nil {
+		return nil, err
 	}
 
 	joinInfo := &runtime.JoinInfo{
 		InstanceInfo: instance,
 		SessionInfo:  nil,
 	}
+	before := auditSummary(instance)
 
-	// Unlimited player count (-1) allows immediate join
-	if edgegapInstance.MaxPlayers < 0 {
+	// Unlimited player count (-1) allows immediate join, persistent shards still track reservations for routing
+	if edgegapInstance.MaxPlayers < 0 && !edgegapInstance.Persistent {
 		return joinInfo, nil
 	}
 
-	// Check if the session can accept more players
-	if instance.PlayerCount+len(edgegapInstance.Reservations)+len(userIds) > edgegapInstance.MaxPlayers {
-		return nil, errors.New("max players reservation limit reached")
+	priority, err := parseJoinPriority(metadata)
+	if err != nil {
+		return nil, err
 	}
 
-	// Add players to the reservation list
-	for _, userId := range userIds {
-		edgegapInstance.Reservations = helpers.AppendIfNotExists(edgegapInstance.Reservations, userId)
+	// Seats are allocated by one join at a time when locked, on the stored copy the previous join wrote
+	if ttl := joinLockTtl(efm.edgegapManager.configuration); ttl > 0 {
+		lock, err := efm.storageManager.acquireJoinLock(ctx, id, userIds[0], ttl)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.release(ctx)
+
+		efm.storageManager.InvalidateInstance(id)
+		if instance, err = efm.storageManager.getDbInstance(ctx, id); err != nil || instance == nil {
+			return nil, errors.New("instance not found")
+		}
+		if edgegapInstance, err = efm.storageManager.ExtractEdgegapInstance(instance); err != nil {
+			return nil, errors.New("error extracting Edgegap instance")
+		}
+		joinInfo.InstanceInfo = instance
+		before = auditSummary(instance)
 	}
 
-	instance.Metadata["edgegap"] = edgegapInstance
+	// Seats are allocated again on a fresh read when another node wrote the instance since it was read
+	for attempt := 1; ; attempt++ {
+		err = efm.reserveSeats(ctx, instance, edgegapInstance, userIds, priority, metadata)
+		if !errors.Is(err, runtime.ErrStorageRejectedVersion) || attempt == updateConflictAttempts {
+			break
+		}
+		efm.storageManager.InvalidateInstance(id)
+		if instance, err = efm.storageManager.getDbInstance(ctx, id); err != nil || instance == nil {
+			return nil, errors.New("instance not found")
+		}
+		if edgegapInstance, err = efm.storageManager.ExtractEdgegapInstance(instance); err != nil {
+			return nil, errors.New("error extracting Edgegap instance")
+		}
+		joinInfo.InstanceInfo = instance
+		before = auditSummary(instance)
+	}
+	switch {
+	case errors.Is(err, ErrorInstanceStarting), errors.Is(err, ErrorInstanceFull), errors.Is(err, ErrorInstanceFullWaitlisted):
+		return nil, err
+	case err != nil:
+		return nil, errors.New("error updating db instance session")
+	}
+	joinInfo.SessionInfo = sessionInfos(efm.edgegapManager.configuration.NakamaHttpKey, id, userIds)
+	efm.storageManager.recordAudit(ctx, &EdgegapAuditEntry{
+		Action:     AuditActionJoin,
+		InstanceId: id,
+		UserIds:    userIds,
+		Before:     before,
+		After:      auditSummary(instance),
+	})
 
-	// Update the instance session in the database
-	err = efm.storageManager.updateDbInstance(ctx, instance)
+	// A pending instance with a min players gate deploys as soon as enough players joined
+	// A concurrent join reaching min players may have claimed the start, deploying with this reservation
+	deploymentId, err := efm.startIfMinPlayersReached(ctx, instance, edgegapInstance)
+	if errors.Is(err, ErrorInstanceNotPending) {
+		err = nil
+	}
 	if err != nil {
-		return nil, errors.New("error updating db instance session")
+		efm.logger.WithField("error", err.Error()).Error("failed to start pending instance %s", id)
+		return nil, errors.New("error starting pending instance")
+	}
+	if deploymentId != "" {
+		if started, err := efm.storageManager.getDbInstance(ctx, deploymentId); err == nil && started != nil {
+			joinInfo.InstanceInfo = started
+		}
 	}
 
 	return joinInfo, nil
 }
 
+// reserveSeats reserves the seats of the joining users on the instance and writes it, or adds them to the waitlist of
+// a full instance when asked to.
+func (efm *EdgegapFleetManager) reserveSeats(ctx context.Context, instance *runtime.InstanceInfo, edgegapInstance *EdgegapInstanceInfo, userIds []string, priority int, metadata map[string]string) error {
+	// The reservations of a starting instance are already deployed with, joins would be lost by its move
+	if instance.Status == EdgegapStatusStarting {
+		return ErrorInstanceStarting
+	}
+
+	// Check if the session can accept more players, bumping lower priority reservations if needed
+	overflow := instance.PlayerCount + len(edgegapInstance.Reservations) + len(userIds) - edgegapInstance.MaxPlayers
+	if edgegapInstance.MaxPlayers >= 0 && overflow > 0 {
+		bumped := edgegapInstance.bumpReservations(overflow, priority)
+		if bumped == nil {
+			if metadata[JoinMetadataWaitlistKey] != "true" {
+				return ErrorInstanceFull
+			}
+
+			edgegapInstance.enqueueWaitlist(userIds, priority)
+			instance.Metadata["edgegap"] = edgegapInstance
+			if err := efm.storageManager.updateDbInstance(ctx, instance); err != nil {
+				return err
+			}
+			return ErrorInstanceFullWaitlisted
+		}
+		efm.logger.Info("Bumped %d lower priority reservations to the waitlist of instance %s", len(bumped), instance.Id)
+	}
+
+	// Add players to the reservation list, joins of a ready instance deliver the connection info right away
+	edgegapInstance.reserve(userIds, priority)
+	if instance.Status == EdgegapStatusReady {
+		edgegapInstance.holdSeats(userIds, seatHoldTtl(efm.edgegapManager.configuration))
+	}
+	instance.Metadata["edgegap"] = edgegapInstance
+
+	// Update the instance session in the database
+	return efm.storageManager.updateDbInstance(ctx, instance)
+}
+
 // Update modifies an instance session's player count and metadata.
+// Callers must either run without a user in context (server) or provide the instance token in the metadata.
+// The player count is clamped to the instance capacity and reserved metadata keys cannot be overwritten.
 func (efm *EdgegapFleetManager) Update(ctx context.Context, id string, playerCount int, metadata map[string]any) error {
 	instance, err := efm.storageManager.getDbInstance(ctx, id)
 	if err != nil {
 		return fmt.Errorf("failed to read instance info from db: %s", err.Error())
 	}
+	if instance == nil {
+		return errors.New("instance not found")
+	}
 
-	efm.logger.Warn("Player Count should not be updated manually and only from the Instance Server SDK")
-	instance.PlayerCount = playerCount
-
-	return efm.storageManager.updateDbInstance(ctx, instance)
-}
+	if !efm.isUpdateAuthorized(ctx, id, metadata) {
+		efm.logger.Warn("Unauthorized update attempt on instance %s", id)
+		return ErrorUpdateUnauthorized
+	}
 
-// Delete removes an instance session from the database.
-func (efm *EdgegapFleetManager) Delete(ctx context.Context, id string) error {
-	_, err := efm.edgegapManager.StopDeployment(id)
+	ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
 	if err != nil {
 		return err
 	}
-	return efm.storageManager.deleteDbInstance(ctx, []string{id})
+
+	playerCount = clampPlayerCount(playerCount, ei.MaxPlayers)
+	changed := playerCount != ei.ReportedPlayerCount
+	ei.ReportedPlayerCount = playerCount
+
+	if mergeUpdateMetadata(instance.Metadata, metadata) {
+		changed = true
+	}
+	instance.Metadata["edgegap"] = ei
+
+	if !changed {
+		return nil
+	}
+
+	if err = efm.storageManager.updateDbInstance(ctx, instance); err != nil {
+		return err
+	}
+
+	if err = efm.nk.Event(ctx, &api.Event{
+		Name: EventInstanceUpdated,
+		Properties: map[string]string{
+			"instance_id":  id,
+			"player_count": strconv.Itoa(instance.PlayerCount),
+		},
+	}); err != nil {
+		efm.logger.WithField("error", err.Error()).Warn("failed to emit instance updated event")
+	}
+
+	return nil
 }
 
-func (efm *EdgegapFleetManager) syncInstancesWorker() {
-	deleteTerminatedInstancesFn := func() {
-		deployments, err := efm.edgegapManager.ListAllDeployments()
-		if err != nil {
-			efm.logger.WithField("error", err.Error()).Error("failed to list edgegap deployments")
-			return
+// Delete removes an instance session from the database.
+func (efm *EdgegapFleetManager) Delete(ctx context.Context, id string) (err error) {
+	// Connection events still coalescing are written first, the audit records the final connections
+	efm.edgegapManager.writes.flush(id)
+	// Pending and starting instances have no deployment to stop yet, a start in flight stops the deployment it creates
+	instance, err := efm.storageManager.getDbInstance(ctx, id)
+	// The named result is audited, whichever return sets it
+	defer func() {
+		if err == nil {
+			efm.storageManager.recordAudit(ctx, &EdgegapAuditEntry{Action: AuditActionDelete, InstanceId: id, Before: auditSummary(instance)})
 		}
-		efm.logger.WithField("active_deployments", len(deployments)).Debug("fetched active deployment instances list")
-		efm.nk.MetricsGaugeSet("edgegap_deployment_count", nil, float64(len(deployments)))
-
-		dbInstances, err := efm.storageManager.listDbInstances(efm.ctx)
-		if err != nil {
-			efm.logger.WithField("error", err.Error()).Error("failed to read instances from db")
-			return
+	}()
+	if err == nil && instance != nil && (instance.Status == EdgegapStatusPending || instance.Status == EdgegapStatusStarting) {
+		if ei, err := efm.storageManager.ExtractEdgegapInstance(instance); err == nil {
+			efm.callbackHandler.InvokeCallback(ei.CallbackId, runtime.CreateError, instance, nil, nil, ErrorPendingDeleted)
 		}
+		return efm.storageManager.deleteDbInstances(ctx, []string{id})
+	}
 
-		activeInstancesMap := make(map[string]struct{}, len(deployments))
-		for _, i := range deployments {
-			if i.Status != DeploymentStatusError {
-				activeInstancesMap[i.RequestId] = struct{}{}
-			}
+	if _, stopErr := efm.edgegapManager.StopDeployment(id); stopErr != nil {
+		if !errors.Is(stopErr, ErrorDeploymentNotFound) {
+			return stopErr
 		}
+		efm.logger.Info("Edgegap deployment %s already stopped, removing instance", id)
+	}
+	return efm.storageManager.deleteDbInstances(ctx, []string{id})
+}
 
-		instancesToRemove := make([]string, 0)
-		for _, dbInfo := range dbInstances {
-			if _, ok := activeInstancesMap[dbInfo.Id]; !ok {
-				instancesToRemove = append(instancesToRemove, dbInfo.Id)
-			}
-		}
+// Extend prolongs the deployment of an instance and returns its new expiry.
+// The game server is notified of the new expiry through its callback url.
+func (efm *EdgegapFleetManager) Extend(ctx context.Context, id string, extension time.Duration) (time.Time, error) {
+	instance, err := efm.storageManager.getDbInstance(ctx, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if instance == nil {
+		return time.Time{}, ErrorDeploymentNotFound
+	}
+
+	ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-		if len(instancesToRemove) > 0 {
-			efm.logger.Debug("Found %d instances to remove", len(instancesToRemove))
+	startedAt := ei.RequestedAt
+	if startedAt.IsZero() {
+		startedAt = instance.CreateTime
+	}
 
-			if err = efm.storageManager.deleteDbInstance(efm.ctx, instancesToRemove); err != nil {
-				efm.logger.WithField("error", err.Error()).Error("failed to delete a game instances")
-				return
-			}
-		}
+	// Extend from the current expiry when known and still ahead
+	base := time.Now().UTC()
+	if ei.ExpiresAt.After(base) {
+		base = ei.ExpiresAt
+	}
+	expiresAt := base.Add(extension)
+
+	maxDuration := int(math.Ceil(expiresAt.Sub(startedAt).Minutes()))
+	if err = efm.edgegapManager.UpdateDeploymentMaxDuration(id, maxDuration); err != nil {
+		return time.Time{}, err
+	}
+
+	ei.ExpiresAt = expiresAt
+	ei.ExpiryWarned = false
+	instance.Metadata["edgegap"] = ei
+	if err = efm.storageManager.updateDbInstance(ctx, instance); err != nil {
+		return time.Time{}, err
+	}
+
+	efm.logger.Info("Extended instance %s until %s", id, expiresAt.Format(time.RFC3339))
+	notifyGameServer(efm.logger, instance, GameServerEventExtended, map[string]any{"expires_at": expiresAt})
+
+	return expiresAt, nil
+}
+
+// ForceDelete removes an instance from storage even if its deployment cannot be stopped, for stuck records.
+func (efm *EdgegapFleetManager) ForceDelete(ctx context.Context, id string) error {
+	if err := efm.Delete(ctx, id); err != nil {
+		efm.logger.WithField("error", err.Error()).Warn("failed to stop deployment %s, force removing instance", id)
+		return efm.storageManager.deleteDbInstances(ctx, []string{id})
 	}
+	return nil
+}
 
+func (efm *EdgegapFleetManager) syncInstancesWorker() {
 	duration, err := time.ParseDuration(efm.edgegapManager.configuration.PollingInterval)
 	if err != nil {
 		efm.logger.WithField("error", err.Error()).Error("failed to parse polling interval, defaulting to 15m")
@@ -283,7 +594,7 @@ func (efm *EdgegapFleetManager) syncInstancesWorker() {
 		return
 	}
 
-	deleteTerminatedInstancesFn()
+	efm.reconcile()
 
 	t := time.NewTicker(duration)
 	defer t.Stop()
@@ -293,7 +604,7 @@ func (efm *EdgegapFleetManager) syncInstancesWorker() {
 		case <-efm.ctx.Done():
 			return
 		case <-t.C:
-			deleteTerminatedInstancesFn()
+			efm.reconcile()
 		}
 	}
 }
@@ -306,40 +617,73 @@ func (efm *EdgegapFleetManager) runCleanupScheduler() {
 	}
 
 	cleanupFn := func() {
+		efm.expirePendingInstances()
+		efm.releaseExpiredSeatHolds()
+		efm.warnExpiringInstances()
+
 		// Remove the Max Duration to get the expired timestamp of reservations
 		searchTime := time.Now().UTC().Add(-reservationMaxDuration)
-		query := fmt.Sprintf("+value.metadata.edgegap.reservations_count:>0 +value.metadata.edgegap.reservations_updated_at:<\"%s\"", searchTime.Format(time.RFC3339))
-		cursor := ""
-		entries, _, err := efm.nk.StorageIndexList(efm.ctx, "", StorageEdgegapIndex, query, 1_000, nil, cursor)
+		query := fmt.Sprintf("+value.metadata.edgegap.reservations_count:>0 +value.metadata.edgegap.reservations_updated_at:<\"%s\" -value.status:%s", searchTime.Format(time.RFC3339), EdgegapStatusPending)
+		objects, err := efm.storageManager.listIndexes(efm.ctx, query, 1_000)
 		if err != nil {
 			efm.logger.WithField("error", err.Error()).Error("failed to list expired reservations instance")
 			return
 		}
 
 		results := make([]*runtime.InstanceInfo, 0)
-		objects := entries.GetObjects()
+		versions := make(map[string]string)
+		promotions := make(map[string][]string)
+		expired := make(map[string]int)
+		expiredInstances := make(map[string]*EdgegapInstanceInfo)
 		if len(objects) > 0 {
 			efm.logger.Debug("Found %d Reservations Instance to cleanup", len(objects))
 			for _, so := range objects {
-				var info *runtime.InstanceInfo
-				if err = json.Unmarshal([]byte(so.Value), &info); err != nil {
+				info, err := decodeInstance(so.Value)
+				if err != nil {
 					efm.logger.WithField("error", err.Error()).Error("failed to unmarshal instance info")
 					continue
 				}
+				if err = efm.storageManager.attachUsers(efm.ctx, info); err != nil {
+					efm.logger.WithField("error", err.Error()).Error("failed to read instance user lists")
+					continue
+				}
 				edgegapInstance, err := efm.storageManager.ExtractEdgegapInstance(info)
 				if err != nil {
 					efm.logger.WithField("error", err.Error()).Error("failed to extract edge gap instance")
 					continue
 				}
+				expired[info.Id] = edgegapInstance.expireReservations()
+				expiredInstances[info.Id] = edgegapInstance
 				edgegapInstance.ReservationsUpdatedAt = time.Now().UTC()
 				edgegapInstance.Reservations = []string{}
+				edgegapInstance.ReservationPriorities = map[string]int{}
+				if promoted := edgegapInstance.promoteWaitlist(); len(promoted) > 0 {
+					promotions[info.Id] = promoted
+				}
 				info.Metadata["edgegap"] = edgegapInstance
 				results = append(results, info)
+				versions[info.Id] = so.Version
 			}
 
-			err = efm.storageManager.updateManyDbInstance(efm.ctx, results)
+			skipped, err := efm.storageManager.updateDbInstances(efm.ctx, results, versions)
 			if err != nil {
 				efm.logger.WithField("error", err.Error()).Error("failed to update expired reservations instance")
+				return
+			}
+
+			// Instances written concurrently keep their reservations until the next cleanup
+			for instanceId, count := range expired {
+				if !slices.Contains(skipped, instanceId) {
+					reportReservationOutcomes(efm.nk, expiredInstances[instanceId], ReservationOutcomeExpired, count)
+				}
+			}
+
+			for instanceId, promoted := range promotions {
+				if slices.Contains(skipped, instanceId) {
+					continue
+				}
+				efm.logger.Debug("Promoted %d waitlisted users on instance %s", len(promoted), instanceId)
+				notifyWaitlistPromoted(efm.ctx, efm.logger, efm.nk, instanceId, promoted)
 			}
 		}
 	}
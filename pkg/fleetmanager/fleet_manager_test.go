@@ -0,0 +1,92 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestJoinConflictOnAnotherNode(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxPlayers       int
+		wantErr          error
+		wantReservations []string
+	}{
+		{name: "seat left", maxPlayers: 2, wantReservations: []string{"other", "user"}},
+		{name: "last seat taken", maxPlayers: 1, wantErr: ErrorInstanceFull, wantReservations: []string{"other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			nk := newFakeNakama()
+			node := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+			node.storageManager.EnableInstanceCache(time.Minute, 10)
+			other := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+
+			if _, err := node.storageManager.createDbInstance(ctx, "id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: tt.maxPlayers}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := node.Get(ctx, "id"); err != nil {
+				t.Fatal(err)
+			}
+
+			// The other node takes a seat while the instance is cached on this one
+			if _, err := other.Join(ctx, "id", []string{"other"}, nil); err != nil {
+				t.Fatalf("Join() on the other node error = %v", err)
+			}
+			_, err := node.Join(ctx, "id", []string{"user"}, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Join() error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, err := other.storageManager.getDbInstance(ctx, "id")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := slices.Sorted(slices.Values(stored.Metadata["edgegap"].(*EdgegapInstanceInfo).Reservations))
+			if !slices.Equal(got, tt.wantReservations) {
+				t.Errorf("reservations = %v, want %v", got, tt.wantReservations)
+			}
+		})
+	}
+}
+
+func TestJoinConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	node := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+	node.storageManager.EnableInstanceCache(time.Minute, 10)
+	other := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+	if _, err := node.storageManager.createDbInstance(ctx, "id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first write of the join loses to a join of the other node between its read and its write
+	interleaved := false
+	nk.beforeWrite = func(writes []*runtime.StorageWrite) {
+		if interleaved {
+			return
+		}
+		interleaved = true
+		if _, err := other.Join(ctx, "id", []string{"other"}, nil); err != nil {
+			t.Errorf("Join() on the other node error = %v", err)
+		}
+	}
+	if _, err := node.Join(ctx, "id", []string{"user"}, nil); err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+
+	stored, err := node.storageManager.getDbInstance(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(slices.Values(stored.Metadata["edgegap"].(*EdgegapInstanceInfo).Reservations)); !slices.Equal(got, []string{"other", "user"}) {
+		t.Errorf("reservations = %v, want [other user]", got)
+	}
+}
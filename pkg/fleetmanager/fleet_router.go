@@ -0,0 +1,211 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// FleetMetadataKey is the create metadata key naming the fleet an instance is created on, the default fleet if unset
+const FleetMetadataKey = "fleet"
+
+// ErrorUnknownFleet is returned when no fleet manager is registered under the requested name
+var ErrorUnknownFleet = errors.New("unknown fleet")
+
+var fleetRouter *FleetRouter
+
+// FleetRouter dispatches to named fleet managers, for hybrid setups such as Edgegap for ranked matches and local
+// bare-metal servers for LAN. Nakama registers a single fleet manager, the router is registered in their place.
+type FleetRouter struct {
+	mu           sync.RWMutex
+	fleets       map[string]runtime.FleetManagerInitializer
+	names        []string
+	defaultFleet string
+	edgegap      *EdgegapFleetManager
+
+	nk              runtime.NakamaModule
+	callbackHandler runtime.FmCallbackHandler
+}
+
+var _ runtime.FleetManagerInitializer = (*FleetRouter)(nil)
+
+// NewFleetRouter creates a router with the Edgegap fleet registered under its configured name, as the default fleet.
+func NewFleetRouter(efm *EdgegapFleetManager) *FleetRouter {
+	name := efm.Name()
+	return &FleetRouter{
+		fleets:       map[string]runtime.FleetManagerInitializer{name: efm},
+		names:        []string{name},
+		defaultFleet: name,
+		edgegap:      efm,
+	}
+}
+
+// Register adds a fleet manager under the name, it is initialized with the router or right away if already running.
+func (fr *FleetRouter) Register(name string, fm runtime.FleetManagerInitializer) error {
+	if name == "" || fm == nil {
+		return errors.New("expects a fleet name and a fleet manager")
+	}
+
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if _, ok := fr.fleets[name]; ok {
+		return fmt.Errorf("fleet %q is already registered", name)
+	}
+	if fr.nk != nil {
+		if err := fm.Init(fr.nk, fr.callbackHandler); err != nil {
+			return err
+		}
+	}
+
+	fr.fleets[name] = fm
+	fr.names = append(fr.names, name)
+	return nil
+}
+
+// SetDefault sets the fleet used when none is named.
+func (fr *FleetRouter) SetDefault(name string) error {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	if _, ok := fr.fleets[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrorUnknownFleet, name)
+	}
+	fr.defaultFleet = name
+	return nil
+}
+
+// Fleet returns the fleet manager registered under the name, the default fleet when the name is empty.
+func (fr *FleetRouter) Fleet(name string) (runtime.FleetManagerInitializer, error) {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	if name == "" {
+		name = fr.defaultFleet
+	}
+	fm, ok := fr.fleets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrorUnknownFleet, name)
+	}
+	return fm, nil
+}
+
+// Names lists the registered fleets in registration order.
+func (fr *FleetRouter) Names() []string {
+	fr.mu.RLock()
+	defer fr.mu.RUnlock()
+
+	return append([]string{}, fr.names...)
+}
+
+// owner returns the fleet manager holding the instance, the default fleet if none does.
+func (fr *FleetRouter) owner(ctx context.Context, id string) (runtime.FleetManagerInitializer, error) {
+	for _, name := range fr.Names() {
+		fm, err := fr.Fleet(name)
+		if err != nil {
+			continue
+		}
+		if instance, err := fm.Get(ctx, id); err == nil && instance != nil {
+			return fm, nil
+		}
+	}
+	return fr.Fleet("")
+}
+
+// Init initializes every registered fleet manager with the Nakama module and callback handler.
+func (fr *FleetRouter) Init(nk runtime.NakamaModule, callbackHandler runtime.FmCallbackHandler) error {
+	fr.mu.Lock()
+	fr.nk = nk
+	fr.callbackHandler = callbackHandler
+	fleets := make([]runtime.FleetManagerInitializer, 0, len(fr.names))
+	for _, name := range fr.names {
+		fleets = append(fleets, fr.fleets[name])
+	}
+	fr.mu.Unlock()
+
+	for _, fm := range fleets {
+		if err := fm.Init(nk, callbackHandler); err != nil {
+			return err
+		}
+	}
+
+	fleetRouter = fr
+	return nil
+}
+
+// Get returns the instance from the fleet holding it.
+func (fr *FleetRouter) Get(ctx context.Context, id string) (*runtime.InstanceInfo, error) {
+	fm, err := fr.owner(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return fm.Get(ctx, id)
+}
+
+// List lists the instances of the default fleet, the query syntax is specific to each fleet.
+func (fr *FleetRouter) List(ctx context.Context, query string, limit int, cursor string) ([]*runtime.InstanceInfo, string, error) {
+	fm, err := fr.Fleet("")
+	if err != nil {
+		return nil, "", err
+	}
+	return fm.List(ctx, query, limit, cursor)
+}
+
+// Create creates the instance on the fleet named in the metadata, the default fleet if unset.
+func (fr *FleetRouter) Create(ctx context.Context, maxPlayers int, userIds []string, latencies []runtime.FleetUserLatencies, metadata map[string]any, callback runtime.FmCreateCallbackFn) (map[string]string, error) {
+	name, _ := metadata[FleetMetadataKey].(string)
+	fm, err := fr.Fleet(name)
+	if err != nil {
+		return nil, err
+	}
+	return fm.Create(ctx, maxPlayers, userIds, latencies, metadata, callback)
+}
+
+// Join reserves the seats on the fleet holding the instance.
+func (fr *FleetRouter) Join(ctx context.Context, id string, userIds []string, metadata map[string]string) (*runtime.JoinInfo, error) {
+	fm, err := fr.owner(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return fm.Join(ctx, id, userIds, metadata)
+}
+
+// Update updates the instance on the fleet holding it.
+func (fr *FleetRouter) Update(ctx context.Context, id string, playerCount int, metadata map[string]any) error {
+	fm, err := fr.owner(ctx, id)
+	if err != nil {
+		return err
+	}
+	return fm.Update(ctx, id, playerCount, metadata)
+}
+
+// Delete deletes the instance from the fleet holding it.
+func (fr *FleetRouter) Delete(ctx context.Context, id string) error {
+	fm, err := fr.owner(ctx, id)
+	if err != nil {
+		return err
+	}
+	return fm.Delete(ctx, id)
+}
+
+// fleetFor resolves the fleet manager an RPC targets by name, the Edgegap fleet when empty.
+func fleetFor(nk runtime.NakamaModule, name string) (runtime.FleetManager, error) {
+	efm, err := edgegapFleet(nk)
+	if name == "" || (efm != nil && name == efm.Name()) {
+		return efm, err
+	}
+
+	router := fleetRouter
+	if router == nil {
+		router, _ = nk.GetFleetManager().(*FleetRouter)
+	}
+	if router != nil {
+		if fm, err := router.Fleet(name); err == nil {
+			return fm, nil
+		}
+	}
+	return nil, runtime.NewError(ErrorUnknownFleet.Error()+": "+name, 3) // INVALID_ARGUMENT
+}
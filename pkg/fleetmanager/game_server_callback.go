@@ -0,0 +1,51 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// InstanceMetadataCallbackUrl is the instance metadata key a game server can set (e.g. with the READY event)
+// to receive notifications from Nakama about its deployment
+const InstanceMetadataCallbackUrl = "callback_url"
+
+// Game server callback events
+const (
+	GameServerEventExtended = "extended"
+)
+
+// GameServerCallback is the body posted to a game server callback url
+type GameServerCallback struct {
+	Event      string         `json:"event"`
+	InstanceId string         `json:"instance_id"`
+	Timestamp  int64          `json:"timestamp"`
+	Data       map[string]any `json:"data"`
+}
+
+// notifyGameServer posts an event to the callback url of the instance, if the game server exposed one.
+func notifyGameServer(logger runtime.Logger, instance *runtime.InstanceInfo, event string, data map[string]any) {
+	callbackUrl, ok := instance.Metadata[InstanceMetadataCallbackUrl].(string)
+	if !ok || callbackUrl == "" {
+		return
+	}
+
+	reply, err := helpers.NewAPIClient(callbackUrl, "").Post("", GameServerCallback{
+		Event:      event,
+		InstanceId: instance.Id,
+		Timestamp:  time.Now().Unix(),
+		Data:       data,
+	})
+	if err == nil {
+		reply.Body.Close()
+		if reply.StatusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("status %d", reply.StatusCode)
+		}
+	}
+	if err != nil {
+		logger.WithFields(map[string]any{"error": err.Error(), "instance_id": instance.Id, "event": event}).Warn("failed to notify game server")
+	}
+}
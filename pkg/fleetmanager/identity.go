@@ -0,0 +1,99 @@
+package fleetmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdEventWhoami lets a game server look up its own instance with the identity token injected in its environment.
+// Edgegap assigns the deployment request ID once the deployment is created, so it cannot be injected up front.
+const RpcIdEventWhoami = "whoami"
+
+type WhoamiMessage struct {
+	IdentityToken string `json:"identity_token"`
+}
+
+type WhoamiReply struct {
+	InstanceId string `json:"instance_id"`
+	// InstanceToken authenticates the game server Update and server ping of its instance
+	InstanceToken string                `json:"instance_token"`
+	Instance      *runtime.InstanceInfo `json:"instance"`
+	// Metadata is the create metadata forwarded to the game server, as in NAKAMA_INSTANCE_METADATA
+	Metadata map[string]any `json:"metadata"`
+}
+
+// generateIdentityToken returns the secret a deployment presents to whoami.
+func generateIdentityToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// identityHash is the indexed digest of an identity token, the token itself is never stored.
+func identityHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleWhoami returns the stored instance of the game server presenting its identity token (S2S only).
+// The instance is only stored once Edgegap accepted the deployment, a game server booting fast may have to retry.
+func (eem *EdgegapEventManager) handleWhoami(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdEventWhoami); err != nil {
+		return "", err
+	}
+
+	msg, err := eem.unpack(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+
+	var whoami WhoamiMessage
+	if err := json.Unmarshal([]byte(msg.payload), &whoami); err != nil || whoami.IdentityToken == "" {
+		return "", ErrInvalidInput
+	}
+
+	query := fmt.Sprintf("+value.metadata.edgegap.identity_hash:%s", identityHash(whoami.IdentityToken))
+	objects, err := eem.sm.listIndexes(ctx, query, 1)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to look up instance by identity")
+		return "", ErrInternalError
+	}
+
+	var instance *runtime.InstanceInfo
+	if len(objects) > 0 {
+		instance, err = decodeInstance(objects[0].Value)
+		if err != nil {
+			return "", ErrInternalError
+		}
+	} else if recovered := eem.sm.recoverRecentInstances(ctx, query, nil); len(recovered) > 0 {
+		// A game server booting fast can ask before its instance is indexed
+		instance = recovered[0]
+	}
+	if instance == nil {
+		return "", runtime.NewError("no instance found for this identity token", 5) // NOT_FOUND
+	}
+	if err = eem.sm.attachUsers(ctx, instance); err != nil {
+		return "", ErrInternalError
+	}
+
+	reply, err := json.Marshal(WhoamiReply{
+		InstanceId:    instance.Id,
+		InstanceToken: instanceToken(eem.config.NakamaHttpKey, instance.Id),
+		Instance:      instance,
+		Metadata:      forwardedMetadata(eem.config, instance.Metadata),
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(reply), nil
+}
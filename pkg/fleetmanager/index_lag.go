@@ -0,0 +1,308 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// recentWrites tracks the instances written by this node until the storage index catches up, so listings can read
+// them directly meanwhile. StorageIndexList is updated asynchronously and can miss a freshly created instance.
+type recentWrites struct {
+	mu      sync.Mutex
+	window  time.Duration
+	written map[string]time.Time
+}
+
+func newRecentWrites(window time.Duration) *recentWrites {
+	return &recentWrites{
+		window:  window,
+		written: make(map[string]time.Time),
+	}
+}
+
+// track records the instances as written now.
+func (r *recentWrites) track(ids ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		r.written[id] = time.Now()
+	}
+}
+
+// forget stops tracking the instances, once deleted or seen in the index.
+func (r *recentWrites) forget(ids ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		delete(r.written, id)
+	}
+}
+
+// pending returns the instances written within the window with their write time, dropping the older ones.
+func (r *recentWrites) pending() map[string]time.Time {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := make(map[string]time.Time, len(r.written))
+	for id, writtenAt := range r.written {
+		if time.Since(writtenAt) > r.window {
+			delete(r.written, id)
+			continue
+		}
+		pending[id] = writtenAt
+	}
+	return pending
+}
+
+// EnableIndexLagFallback reads the instances created by this node directly for window when the storage index
+// does not list them yet.
+func (sm *StorageManager) EnableIndexLagFallback(window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	sm.recent = newRecentWrites(window)
+	sm.logger.Info("Reading instances missing from the storage index for %s after creation", window.String())
+}
+
+// recoverRecentInstances returns the recently written instances matching the query that the index listing missed.
+// Instances found in the listing are no longer tracked, their lag is recorded in the edgegap_index_lag timer and
+// every recovered instance is counted in edgegap_index_lag_recovered.
+func (sm *StorageManager) recoverRecentInstances(ctx context.Context, query string, listed []*runtime.InstanceInfo) []*runtime.InstanceInfo {
+	pending := sm.recent.pending()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	for _, instance := range listed {
+		if writtenAt, ok := pending[instance.Id]; ok {
+			sm.nk.MetricsTimerRecord("edgegap_index_lag", nil, time.Since(writtenAt))
+			sm.recent.forget(instance.Id)
+			delete(pending, instance.Id)
+		}
+	}
+
+	matcher, ok := parseIndexQuery(query)
+	if !ok || len(pending) == 0 {
+		return nil
+	}
+
+	ids := slices.Collect(maps.Keys(pending))
+	tenants, err := sm.resolveTenants(ctx, ids...)
+	if err != nil {
+		sm.logger.WithField("error", err.Error()).Warn("failed to resolve the tenants of instances missing from the storage index")
+		return nil
+	}
+	reads := make([]*runtime.StorageRead, 0, len(pending))
+	for _, id := range ids {
+		reads = append(reads, &runtime.StorageRead{
+			Collection: sm.collectionsOfTenant(tenants[id]).instances,
+			Key:        id,
+		})
+	}
+	objects, err := sm.nk.StorageRead(ctx, reads)
+	if err != nil {
+		sm.logger.WithField("error", err.Error()).Warn("failed to read instances missing from the storage index")
+		return nil
+	}
+
+	recovered := make([]*runtime.InstanceInfo, 0)
+	for _, obj := range objects {
+		var document map[string]any
+		if err := json.Unmarshal([]byte(obj.Value), &document); err != nil || !matcher.matches(document) {
+			continue
+		}
+		instance, err := decodeInstance(obj.Value)
+		if err != nil {
+			continue
+		}
+		recovered = append(recovered, instance)
+	}
+
+	if len(recovered) > 0 {
+		sm.nk.MetricsCounterAdd("edgegap_index_lag_recovered", nil, int64(len(recovered)))
+		sm.logger.Debug("Recovered %d instances missing from the storage index", len(recovered))
+	}
+	return recovered
+}
+
+// indexClause is a single term of a storage index query, e.g. +value.status:READY or -value.player_count:>=4
+type indexClause struct {
+	occur string
+	path  []string
+	op    string
+	value string
+}
+
+type indexQuery struct {
+	clauses []indexClause
+}
+
+// parseIndexQuery parses the subset of the storage index query syntax built by the plugin: required (+), excluded (-)
+// and optional terms on value fields, with phrases and numeric ranges. Other queries are not supported.
+func parseIndexQuery(query string) (*indexQuery, bool) {
+	terms, ok := splitIndexQuery(query)
+	if !ok {
+		return nil, false
+	}
+
+	q := &indexQuery{clauses: make([]indexClause, 0, len(terms))}
+	for _, term := range terms {
+		if term == "*" {
+			continue
+		}
+
+		clause := indexClause{}
+		if strings.HasPrefix(term, "+") || strings.HasPrefix(term, "-") {
+			clause.occur, term = term[:1], term[1:]
+		}
+
+		field, value, ok := strings.Cut(term, ":")
+		if !ok || !strings.HasPrefix(field, "value.") {
+			return nil, false
+		}
+		clause.path = strings.Split(strings.TrimPrefix(field, "value."), ".")
+
+		for _, op := range []string{">=", "<=", ">", "<"} {
+			if strings.HasPrefix(value, op) {
+				clause.op, value = op, value[len(op):]
+				break
+			}
+		}
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, false
+			}
+			value = unquoted
+		} else if strings.ContainsAny(value, `*?()~^\`) {
+			return nil, false
+		}
+		clause.value = value
+
+		q.clauses = append(q.clauses, clause)
+	}
+
+	return q, true
+}
+
+// splitIndexQuery splits the query on spaces outside of quoted phrases.
+func splitIndexQuery(query string) ([]string, bool) {
+	terms := make([]string, 0)
+	var term strings.Builder
+	quoted, escaped := false, false
+	for _, r := range query {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+			continue
+		}
+		term.WriteRune(r)
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms, !quoted
+}
+
+// matches reports whether the stored instance document matches the query: every required term, no excluded term and
+// at least one optional term when the query has no required term.
+func (q *indexQuery) matches(document map[string]any) bool {
+	required, optional, optionalMatched := false, false, false
+	for _, clause := range q.clauses {
+		matched := clause.matches(document)
+		switch clause.occur {
+		case "+":
+			required = true
+			if !matched {
+				return false
+			}
+		case "-":
+			if matched {
+				return false
+			}
+		default:
+			optional = true
+			optionalMatched = optionalMatched || matched
+		}
+	}
+	return required || !optional || optionalMatched
+}
+
+// matches reports whether the field of the document, or any of its elements, matches the clause.
+func (c indexClause) matches(document map[string]any) bool {
+	var field any = document
+	for _, key := range c.path {
+		object, ok := field.(map[string]any)
+		if !ok {
+			return false
+		}
+		field = object[key]
+	}
+
+	if items, ok := field.([]any); ok {
+		for _, item := range items {
+			if c.matchesValue(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return c.matchesValue(field)
+}
+
+func (c indexClause) matchesValue(field any) bool {
+	switch v := field.(type) {
+	case float64:
+		expected, err := strconv.ParseFloat(c.value, 64)
+		if err != nil {
+			return false
+		}
+		switch c.op {
+		case ">":
+			return v > expected
+		case ">=":
+			return v >= expected
+		case "<":
+			return v < expected
+		case "<=":
+			return v <= expected
+		}
+		return v == expected
+	case string:
+		return c.op == "" && v == c.value
+	case bool:
+		return c.op == "" && strconv.FormatBool(v) == c.value
+	}
+	return false
+}
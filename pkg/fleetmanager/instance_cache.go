@@ -0,0 +1,104 @@
+package fleetmanager
+
+import (
+	"sync"
+	"time"
+)
+
+// instanceCache is a short TTL read-through cache of instance records, local to the Nakama node.
+// Other nodes may write an instance while it is cached, so writes of a cached instance are conditional on
+// the cached storage version: a stale copy is rejected instead of overwriting the newer record.
+type instanceCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]cachedInstance
+}
+
+type cachedInstance struct {
+	value     string
+	version   string
+	expiresAt time.Time
+}
+
+func newInstanceCache(ttl time.Duration, size int) *instanceCache {
+	return &instanceCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]cachedInstance),
+	}
+}
+
+// get returns the serialized instance while it is fresh.
+func (c *instanceCache) get(id string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false
+	}
+	return entry.value, true
+}
+
+// version returns the storage version of the cached instance, empty if not cached.
+func (c *instanceCache) version(id string) string {
+	if c == nil {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return ""
+	}
+	return entry.version
+}
+
+// put caches the serialized instance with its storage version.
+func (c *instanceCache) put(id, value, version string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.entries[id]; !ok && len(c.entries) >= c.size {
+		for key, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+
+	c.entries[id] = cachedInstance{
+		value:     value,
+		version:   version,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// invalidate drops the cached instances.
+func (c *instanceCache) invalidate(ids ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		delete(c.entries, id)
+	}
+}
@@ -0,0 +1,66 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestInstanceCacheConditionalWrite(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	node := NewStorageManager(nk, fakeLogger{})
+	node.EnableInstanceCache(time.Minute, 10)
+	other := NewStorageManager(nk, fakeLogger{})
+
+	if _, err := node.createDbInstance(ctx, "id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := node.getDbInstance(ctx, "id")
+	if err != nil || cached == nil {
+		t.Fatalf("getDbInstance() = %v, %v", cached, err)
+	}
+	reads := nk.readCount(node.collections.instances)
+	if _, err = node.getDbInstance(ctx, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if got := nk.readCount(node.collections.instances); got != reads {
+		t.Errorf("cached instance read storage %d times", got-reads)
+	}
+
+	// Another node writes the instance while it is cached
+	stored, err := other.getDbInstance(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Metadata["edgegap"].(*EdgegapInstanceInfo).Reservations = []string{"other"}
+	if err = other.updateDbInstance(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write of the stale copy is rejected instead of overwriting the reservation, and the copy is dropped
+	cached.Metadata["edgegap"].(*EdgegapInstanceInfo).Reservations = []string{"node"}
+	if err = node.updateDbInstance(ctx, cached); !errors.Is(err, runtime.ErrStorageRejectedVersion) {
+		t.Fatalf("updateDbInstance() of a stale copy error = %v, want %v", err, runtime.ErrStorageRejectedVersion)
+	}
+	fresh, err := node.getDbInstance(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fresh.Metadata["edgegap"].(*EdgegapInstanceInfo).Reservations; !slices.Equal(got, []string{"other"}) {
+		t.Errorf("reservations after the rejected write = %v, want [other]", got)
+	}
+
+	// A write of the fresh copy goes through and keeps the cache on its new version
+	fresh.Metadata["edgegap"].(*EdgegapInstanceInfo).Reservations = []string{"other", "node"}
+	if err = node.updateDbInstance(ctx, fresh); err != nil {
+		t.Fatalf("updateDbInstance() of a fresh copy error = %v", err)
+	}
+	if version := node.cache.version("id"); version == "" {
+		t.Error("written instance not cached")
+	}
+}
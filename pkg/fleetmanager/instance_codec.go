@@ -0,0 +1,260 @@
+package fleetmanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// InstanceCodecGzip compresses the user lists of the stored instances with gzip
+	InstanceCodecGzip = "gzip"
+	// InstanceEncodingSplit marks the records whose user lists are stored in their companion object
+	InstanceEncodingSplit = "split"
+
+	// User lists smaller than this are stored as plain JSON, compressing them saves nothing
+	instanceCodecMinBytes = 1024
+)
+
+// ErrorInstanceUsersDetached is returned when writing a split instance listed without its user lists
+var ErrorInstanceUsersDetached = errors.New("instance listed without its user lists, read it by id before writing it")
+
+// instanceCodec compresses the user lists of a stored instance, named by the encoding marker of the record
+type instanceCodec struct {
+	encode func(data []byte) ([]byte, error)
+	decode func(data []byte) ([]byte, error)
+}
+
+// instanceCodecs are the codecs the stored instances can be encoded with, records of every codec are always decoded
+var instanceCodecs = map[string]instanceCodec{
+	InstanceCodecGzip: {
+		encode: func(data []byte) ([]byte, error) {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			if _, err := w.Write(data); err != nil {
+				return nil, err
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		},
+		decode: func(data []byte) ([]byte, error) {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		},
+	},
+}
+
+// packedUsers are the user lists of an instance, the bulk of the records of instances with many players. The
+// connections are only packed when no storage index query looks them up.
+type packedUsers struct {
+	Reservations          []string               `json:"reservations"`
+	ReservationPriorities map[string]int         `json:"reservation_priorities"`
+	Waitlist              []EdgegapWaitlistEntry `json:"waitlist"`
+	SeatHolds             map[string]time.Time   `json:"seat_holds,omitempty"`
+	ConfirmedSeats        []string               `json:"confirmed_seats,omitempty"`
+	Connections           []string               `json:"connections,omitempty"`
+}
+
+// EnableInstanceCompression stores the user lists of the instances encoded with the codec. Connections stay plain
+// when looked up by the matchmaker labels.
+func (sm *StorageManager) EnableInstanceCompression(codec string, packConnections bool) {
+	if _, ok := instanceCodecs[codec]; !ok {
+		return
+	}
+	sm.codec = codec
+	sm.packConnections = packConnections
+	sm.logger.Info("Compressing the stored instances with %s", codec)
+}
+
+// EnableInstanceUsersSplit stores the user lists of the instances in a companion object, out of the storage index.
+// Connections stay in the record when looked up by the matchmaker labels.
+func (sm *StorageManager) EnableInstanceUsersSplit(packConnections bool) {
+	sm.splitUsers = true
+	sm.packConnections = packConnections
+	sm.logger.Info("Storing the user lists of the instances in %s", sm.collections.users)
+}
+
+// encodeInstance serializes the instance for storage, its user lists encoded with the configured codec or split into
+// the returned companion write once large enough. The instance itself is left untouched.
+func (sm *StorageManager) encodeInstance(instance *runtime.InstanceInfo) (string, *runtime.StorageWrite, error) {
+	ei, err := extractEdgegapInstance(instance)
+	if err == nil && ei.detached {
+		return "", nil, ErrorInstanceUsersDetached
+	}
+	if (sm.codec == "" && !sm.splitUsers) || err != nil {
+		value, err := json.Marshal(instance)
+		return string(value), nil, err
+	}
+
+	users := packedUsers{
+		Reservations:          ei.Reservations,
+		ReservationPriorities: ei.ReservationPriorities,
+		Waitlist:              ei.Waitlist,
+		SeatHolds:             ei.SeatHolds,
+		ConfirmedSeats:        ei.ConfirmedSeats,
+	}
+	if sm.packConnections {
+		users.Connections = ei.Connections
+	}
+	data, err := json.Marshal(users)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) < instanceCodecMinBytes {
+		value, err := json.Marshal(instance)
+		return string(value), nil, err
+	}
+
+	// The indexed fields stay plain, only the user lists are replaced by their encoded copy or moved to the companion
+	var companion *runtime.StorageWrite
+	packed := *ei
+	if sm.splitUsers {
+		packed.Encoding = InstanceEncodingSplit
+		companion = &runtime.StorageWrite{
+			Collection:      sm.collectionsOf(instance).users,
+			Key:             instance.Id,
+			Value:           string(data),
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		}
+	} else {
+		encoded, err := instanceCodecs[sm.codec].encode(data)
+		if err != nil {
+			return "", nil, err
+		}
+		packed.Encoding = sm.codec
+		packed.Packed = base64.StdEncoding.EncodeToString(encoded)
+	}
+	packed.Reservations, packed.ReservationPriorities, packed.Waitlist = []string{}, map[string]int{}, []EdgegapWaitlistEntry{}
+	packed.SeatHolds, packed.ConfirmedSeats = nil, nil
+	if sm.packConnections {
+		packed.Connections = []string{}
+	}
+
+	record := *instance
+	record.Metadata = make(map[string]any, len(instance.Metadata))
+	for k, v := range instance.Metadata {
+		record.Metadata[k] = v
+	}
+	record.Metadata["edgegap"] = &packed
+
+	value, err := json.Marshal(&record)
+	return string(value), companion, err
+}
+
+// unpack decodes the user lists of a record written with a codec, the record is then plain. Split records are left
+// detached until their companion is read by attachUsers.
+func (ei *EdgegapInstanceInfo) unpack() error {
+	switch ei.Encoding {
+	case "":
+		return nil
+	case InstanceEncodingSplit:
+		ei.detached = true
+		return nil
+	}
+	codec, ok := instanceCodecs[ei.Encoding]
+	if !ok {
+		return fmt.Errorf("unknown instance encoding %q", ei.Encoding)
+	}
+
+	encoded, err := base64.StdEncoding.DecodeString(ei.Packed)
+	if err != nil {
+		return err
+	}
+	data, err := codec.decode(encoded)
+	if err != nil {
+		return err
+	}
+	var users packedUsers
+	if err = json.Unmarshal(data, &users); err != nil {
+		return err
+	}
+	ei.setUsers(users)
+	return nil
+}
+
+// setUsers restores the user lists of a packed or split record.
+func (ei *EdgegapInstanceInfo) setUsers(users packedUsers) {
+	ei.Reservations = users.Reservations
+	ei.ReservationPriorities = users.ReservationPriorities
+	ei.Waitlist = users.Waitlist
+	ei.SeatHolds = users.SeatHolds
+	ei.ConfirmedSeats = users.ConfirmedSeats
+	if users.Connections != nil {
+		ei.Connections = users.Connections
+	}
+	ei.Encoding, ei.Packed = "", ""
+	ei.detached = false
+}
+
+// attachUsers reads the companion user lists of the split instances, in batched reads. A missing companion leaves
+// the lists empty, the instance then stays writable.
+func (sm *StorageManager) attachUsers(ctx context.Context, instances ...*runtime.InstanceInfo) error {
+	detached := make(map[string]*EdgegapInstanceInfo)
+	reads := make([]*runtime.StorageRead, 0)
+	for _, instance := range instances {
+		ei, err := extractEdgegapInstance(instance)
+		if err != nil || !ei.detached {
+			continue
+		}
+		detached[instance.Id] = ei
+		reads = append(reads, &runtime.StorageRead{Collection: sm.collectionsOf(instance).users, Key: instance.Id})
+	}
+
+	for batch := range slices.Chunk(reads, 100) {
+		objects, err := sm.nk.StorageRead(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			var users packedUsers
+			if err = json.Unmarshal([]byte(obj.Value), &users); err != nil {
+				return fmt.Errorf("failed to decode user lists of instance %s: %w", obj.Key, err)
+			}
+			if ei, ok := detached[obj.Key]; ok {
+				ei.setUsers(users)
+				delete(detached, obj.Key)
+			}
+		}
+	}
+
+	for id, ei := range detached {
+		sm.logger.Warn("user lists of instance %s are missing, attaching empty lists", id)
+		ei.setUsers(packedUsers{Reservations: []string{}, ReservationPriorities: map[string]int{}, Waitlist: []EdgegapWaitlistEntry{}})
+	}
+	return nil
+}
+
+// withUsersWrite appends the companion write of a split instance to its writes, if any.
+func withUsersWrite(writes []*runtime.StorageWrite, users *runtime.StorageWrite) []*runtime.StorageWrite {
+	if users == nil {
+		return writes
+	}
+	return append(writes, users)
+}
+
+// instanceVersion returns the version of the instance record among the acks of a write, skipping its companion.
+func (sm *StorageManager) instanceVersion(acks []*api.StorageObjectAck, id string) string {
+	for _, ack := range acks {
+		if sm.isInstancesCollection(ack.GetCollection()) && ack.GetKey() == id {
+			return ack.GetVersion()
+		}
+	}
+	return ""
+}
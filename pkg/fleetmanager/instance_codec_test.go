@@ -0,0 +1,149 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// codecTestInstance returns an instance with the given number of reservations and connections.
+func codecTestInstance(users int) *runtime.InstanceInfo {
+	ei := &EdgegapInstanceInfo{
+		SchemaVersion:         InstanceSchemaVersion,
+		MaxPlayers:            2 * users,
+		Reservations:          []string{},
+		ReservationPriorities: map[string]int{},
+		Waitlist:              []EdgegapWaitlistEntry{},
+		Connections:           []string{},
+	}
+	for i := 0; i < users; i++ {
+		reserved := fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+		ei.Reservations = append(ei.Reservations, reserved)
+		ei.ReservationPriorities[reserved] = ReservationPriorityNormal
+		ei.Connections = append(ei.Connections, fmt.Sprintf("11111111-0000-0000-0000-%012d", i))
+	}
+	if users > 0 {
+		ei.ConfirmedSeats = []string{ei.Reservations[0]}
+		ei.Waitlist = append(ei.Waitlist, EdgegapWaitlistEntry{UserId: "waiting", Priority: ReservationPriorityNormal, QueuedAt: time.Unix(1700000000, 0).UTC()})
+	}
+	return &runtime.InstanceInfo{
+		Id:       "request-id",
+		Status:   EdgegapStatusReady,
+		Metadata: map[string]any{"edgegap": ei, "mode": "ranked"},
+	}
+}
+
+func TestEncodeInstanceRoundTrip(t *testing.T) {
+	tests := []struct {
+		name            string
+		codec           string
+		split           bool
+		packConnections bool
+		users           int
+		wantEncoding    string
+	}{
+		{name: "plain", users: 50},
+		{name: "gzip below the minimum size", codec: InstanceCodecGzip, users: 1},
+		{name: "gzip", codec: InstanceCodecGzip, users: 50, wantEncoding: InstanceCodecGzip},
+		{name: "gzip with connections", codec: InstanceCodecGzip, packConnections: true, users: 50, wantEncoding: InstanceCodecGzip},
+		{name: "split below the minimum size", split: true, users: 1},
+		{name: "split", split: true, users: 50, wantEncoding: InstanceEncodingSplit},
+		{name: "split with connections", split: true, packConnections: true, users: 50, wantEncoding: InstanceEncodingSplit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := NewStorageManager(nil, nil)
+			sm.codec, sm.splitUsers, sm.packConnections = tt.codec, tt.split, tt.packConnections
+			instance := codecTestInstance(tt.users)
+			want := *instance.Metadata["edgegap"].(*EdgegapInstanceInfo)
+
+			value, companion, err := sm.encodeInstance(instance)
+			if err != nil {
+				t.Fatalf("encodeInstance() error = %v", err)
+			}
+			if got := instance.Metadata["edgegap"].(*EdgegapInstanceInfo); !sameCodecUsers(got, &want) || got.Encoding != "" {
+				t.Fatal("encodeInstance() changed the instance")
+			}
+			if (companion != nil) != (tt.wantEncoding == InstanceEncodingSplit) {
+				t.Fatalf("encodeInstance() companion = %v, want one only when split", companion)
+			}
+
+			var stored struct {
+				Metadata struct {
+					Edgegap EdgegapInstanceInfo `json:"edgegap"`
+				} `json:"metadata"`
+			}
+			if err = json.Unmarshal([]byte(value), &stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Metadata.Edgegap.Encoding != tt.wantEncoding {
+				t.Errorf("stored encoding = %q, want %q", stored.Metadata.Edgegap.Encoding, tt.wantEncoding)
+			}
+			if tt.wantEncoding != "" && len(stored.Metadata.Edgegap.Reservations) != 0 {
+				t.Errorf("stored %d reservations in the record, want them encoded", len(stored.Metadata.Edgegap.Reservations))
+			}
+			if tt.wantEncoding != "" && (len(stored.Metadata.Edgegap.Connections) == 0) != tt.packConnections {
+				t.Errorf("stored %d connections in the record with packed connections %v", len(stored.Metadata.Edgegap.Connections), tt.packConnections)
+			}
+
+			decoded, err := decodeInstance(value)
+			if err != nil {
+				t.Fatalf("decodeInstance() error = %v", err)
+			}
+			got := decoded.Metadata["edgegap"].(*EdgegapInstanceInfo)
+			if companion != nil {
+				if !got.detached {
+					t.Fatal("split record decoded attached")
+				}
+				var users packedUsers
+				if err = json.Unmarshal([]byte(companion.Value), &users); err != nil {
+					t.Fatal(err)
+				}
+				got.setUsers(users)
+			}
+
+			if got.detached || got.Encoding != "" || got.Packed != "" {
+				t.Errorf("decoded record still packed: detached %v, encoding %q", got.detached, got.Encoding)
+			}
+			if !sameCodecUsers(got, &want) {
+				t.Errorf("decoded user lists differ from the encoded instance")
+			}
+			if decoded.Metadata["mode"] != "ranked" || got.MaxPlayers != want.MaxPlayers {
+				t.Errorf("decoded metadata = %v, max players %d", decoded.Metadata["mode"], got.MaxPlayers)
+			}
+		})
+	}
+}
+
+func TestEncodeInstanceDetached(t *testing.T) {
+	sm := NewStorageManager(nil, nil)
+	sm.splitUsers = true
+	instance := codecTestInstance(1)
+	instance.Metadata["edgegap"].(*EdgegapInstanceInfo).detached = true
+
+	if _, _, err := sm.encodeInstance(instance); err != ErrorInstanceUsersDetached {
+		t.Errorf("encodeInstance() error = %v, want %v", err, ErrorInstanceUsersDetached)
+	}
+}
+
+func TestUnpackUnknownEncoding(t *testing.T) {
+	ei := &EdgegapInstanceInfo{Encoding: "zstd", Packed: "AAAA"}
+	if err := ei.unpack(); err == nil {
+		t.Error("unpack() of an unknown encoding succeeded")
+	}
+}
+
+// sameCodecUsers reports whether both instances hold the same user lists.
+func sameCodecUsers(a, b *EdgegapInstanceInfo) bool {
+	return slices.Equal(a.Reservations, b.Reservations) &&
+		maps.Equal(a.ReservationPriorities, b.ReservationPriorities) &&
+		slices.Equal(a.Waitlist, b.Waitlist) &&
+		slices.Equal(a.ConfirmedSeats, b.ConfirmedSeats) &&
+		slices.Equal(a.Connections, b.Connections)
+}
@@ -0,0 +1,438 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdInstanceEventLog reports the event log of an instance with its folded state, and repairs the record from it (S2S only)
+const RpcIdInstanceEventLog = "instance_event_log"
+
+const (
+	// Logs longer than this are compacted, folding their oldest events into the snapshot
+	eventLogCompactAt = 500
+	// Events kept unfolded by a compaction, for debugging
+	eventLogKeep = 100
+	// Unconditional instance writes losing the race on the version of the log are retried with the event appended again
+	eventLogAttempts = 3
+)
+
+// EventSourceWorker is the source of the events written by the background workers, outside any RPC
+const EventSourceWorker = "worker"
+
+// EdgegapInstanceEvent is a change of an instance, as applied by a write of its record
+type EdgegapInstanceEvent struct {
+	Seq int64     `json:"seq"`
+	At  time.Time `json:"at"`
+	// Source is the rpc whose call wrote the change, e.g. edgegap_connection, or worker
+	Source string `json:"source"`
+	// Status is the new status of the instance, empty when unchanged
+	Status   string   `json:"status,omitempty"`
+	Joined   []string `json:"joined,omitempty"`
+	Left     []string `json:"left,omitempty"`
+	Reserved []string `json:"reserved,omitempty"`
+	Released []string `json:"released,omitempty"`
+}
+
+// EdgegapInstanceState is the lifecycle and seats of an instance, folded from its events
+type EdgegapInstanceState struct {
+	Status       string   `json:"status"`
+	Connections  []string `json:"connections"`
+	Reservations []string `json:"reservations"`
+	// Seq is the last event folded in the state
+	Seq int64 `json:"seq"`
+}
+
+// EdgegapInstanceEventLog is the append-only event log of an instance, its compacted events folded in the snapshot
+type EdgegapInstanceEventLog struct {
+	Snapshot EdgegapInstanceState   `json:"snapshot"`
+	Events   []EdgegapInstanceEvent `json:"events"`
+	NextSeq  int64                  `json:"next_seq"`
+}
+
+type instanceEventLogRequest struct {
+	InstanceId string `json:"instance_id"`
+	// Repair writes the folded status, connections and reservations over the record
+	Repair bool `json:"repair"`
+}
+
+type instanceEventLogReply struct {
+	Log   EdgegapInstanceEventLog `json:"log"`
+	State EdgegapInstanceState    `json:"state"`
+	// Drift lists the fields of the record differing from the folded state
+	Drift    []string `json:"drift"`
+	Repaired bool     `json:"repaired"`
+}
+
+// apply folds the event in the state.
+func (s *EdgegapInstanceState) apply(event EdgegapInstanceEvent) {
+	if event.Status != "" {
+		s.Status = event.Status
+	}
+	for _, userId := range event.Joined {
+		s.Connections = helpers.AppendIfNotExists(s.Connections, userId)
+	}
+	s.Connections = helpers.RemoveElements(s.Connections, event.Left)
+	for _, userId := range event.Reserved {
+		s.Reservations = helpers.AppendIfNotExists(s.Reservations, userId)
+	}
+	s.Reservations = helpers.RemoveElements(s.Reservations, event.Released)
+	s.Seq = event.Seq
+}
+
+// state folds the events of the log over its snapshot, in sequence order.
+func (l *EdgegapInstanceEventLog) state() EdgegapInstanceState {
+	state := EdgegapInstanceState{
+		Status:       l.Snapshot.Status,
+		Connections:  slices.Clone(l.Snapshot.Connections),
+		Reservations: slices.Clone(l.Snapshot.Reservations),
+		Seq:          l.Snapshot.Seq,
+	}
+	for _, event := range l.Events {
+		state.apply(event)
+	}
+	if state.Connections == nil {
+		state.Connections = []string{}
+	}
+	if state.Reservations == nil {
+		state.Reservations = []string{}
+	}
+	return state
+}
+
+// append records the change from the folded state to the instance, reporting whether anything changed.
+func (l *EdgegapInstanceEventLog) append(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo, source string) bool {
+	state := l.state()
+	event := EdgegapInstanceEvent{
+		Seq:      l.NextSeq + 1,
+		At:       time.Now().UTC(),
+		Source:   source,
+		Joined:   helpers.RemoveElements(ei.Connections, state.Connections),
+		Left:     helpers.RemoveElements(state.Connections, ei.Connections),
+		Reserved: helpers.RemoveElements(ei.Reservations, state.Reservations),
+		Released: helpers.RemoveElements(state.Reservations, ei.Reservations),
+	}
+	if instance.Status != state.Status {
+		event.Status = instance.Status
+	}
+	if event.Status == "" && len(event.Joined)+len(event.Left)+len(event.Reserved)+len(event.Released) == 0 {
+		return false
+	}
+
+	l.Events = append(l.Events, event)
+	l.NextSeq = event.Seq
+
+	// Compaction folds the oldest events in the snapshot, the folded state is unchanged
+	if len(l.Events) > eventLogCompactAt {
+		compacted := EdgegapInstanceEventLog{Snapshot: l.Snapshot, Events: l.Events[:len(l.Events)-eventLogKeep]}
+		l.Snapshot = compacted.state()
+		l.Events = slices.Clone(l.Events[len(l.Events)-eventLogKeep:])
+	}
+	return true
+}
+
+// scrubUsers removes the users from the events and the snapshot, reporting whether the log referenced them.
+func (l *EdgegapInstanceEventLog) scrubUsers(userIds []string) bool {
+	found := false
+	scrub := func(list []string) []string {
+		kept := helpers.RemoveElements(list, userIds)
+		if len(kept) != len(list) {
+			found = true
+		}
+		return kept
+	}
+
+	l.Snapshot.Connections = scrub(l.Snapshot.Connections)
+	l.Snapshot.Reservations = scrub(l.Snapshot.Reservations)
+	for i := range l.Events {
+		l.Events[i].Joined = scrub(l.Events[i].Joined)
+		l.Events[i].Left = scrub(l.Events[i].Left)
+		l.Events[i].Reserved = scrub(l.Events[i].Reserved)
+		l.Events[i].Released = scrub(l.Events[i].Released)
+	}
+	return found
+}
+
+// EnableInstanceEventLog records the changes of every instance write in the event log of the instance.
+func (sm *StorageManager) EnableInstanceEventLog() {
+	sm.eventLog = true
+	sm.logger.Info("Recording the instance events in %s", sm.collections.events)
+}
+
+// readInstanceEventLog reads the event log of the instance with its version, empty when none was recorded.
+func (sm *StorageManager) readInstanceEventLog(ctx context.Context, id string) (*EdgegapInstanceEventLog, string, error) {
+	collections, err := sm.resolveCollections(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: collections.events,
+		Key:        id,
+	}})
+	if err != nil {
+		return nil, "", err
+	}
+
+	log := &EdgegapInstanceEventLog{Events: []EdgegapInstanceEvent{}}
+	if len(objects) == 0 {
+		return log, "*", nil
+	}
+	if err = json.Unmarshal([]byte(objects[0].Value), log); err != nil {
+		return nil, "", err
+	}
+	return log, objects[0].Version, nil
+}
+
+// writeInstanceEventLog stores the event log in the events collection of its tenant, conditional on the version read.
+func (sm *StorageManager) writeInstanceEventLog(ctx context.Context, collection string, id string, log *EdgegapInstanceEventLog, version string) error {
+	value, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      collection,
+		Key:             id,
+		Value:           string(value),
+		Version:         version,
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}})
+	return err
+}
+
+// withMovedEventLog appends the write of the event log of the instance under its new ID, with the changes of the
+// move, if any was recorded.
+func (sm *StorageManager) withMovedEventLog(ctx context.Context, writes []*runtime.StorageWrite, oldId string, instance *runtime.InstanceInfo) ([]*runtime.StorageWrite, error) {
+	log, version, err := sm.readInstanceEventLog(ctx, oldId)
+	if err != nil {
+		return writes, err
+	}
+	if version == "*" {
+		return sm.withEventLogWrites(ctx, writes, instance)
+	}
+	if ei, err := extractEdgegapInstance(instance); err == nil && !ei.detached {
+		log.append(instance, ei, eventSource(ctx))
+	}
+	value, err := json.Marshal(log)
+	if err != nil {
+		return writes, err
+	}
+	return append(writes, &runtime.StorageWrite{
+		Collection:      sm.collectionsOf(instance).events,
+		Key:             instance.Id,
+		Value:           string(value),
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}), nil
+}
+
+// eventSource returns the rpc whose call writes the instances, worker outside any RPC.
+func eventSource(ctx context.Context) string {
+	if source, ok := ctx.Value(rpcIdKey{}).(string); ok {
+		return source
+	}
+	return EventSourceWorker
+}
+
+// eventLogWrites returns the writes appending the changes of the instances to their event logs by instance ID, each
+// conditional on the version of the log read. They are written in the same transaction as the instances, so the
+// events of concurrent writers are ordered like the records, a writer losing the race on the log fails as a whole.
+func (sm *StorageManager) eventLogWrites(ctx context.Context, instances ...*runtime.InstanceInfo) (map[string]*runtime.StorageWrite, error) {
+	writes := make(map[string]*runtime.StorageWrite)
+	if !sm.eventLog {
+		return writes, nil
+	}
+
+	reads := make([]*runtime.StorageRead, 0, len(instances))
+	for _, instance := range instances {
+		reads = append(reads, &runtime.StorageRead{Collection: sm.collectionsOf(instance).events, Key: instance.Id})
+	}
+	objects, err := sm.nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]*api.StorageObject, len(objects))
+	for _, obj := range objects {
+		stored[obj.Key] = obj
+	}
+
+	source := eventSource(ctx)
+	for _, instance := range instances {
+		ei, err := extractEdgegapInstance(instance)
+		if err != nil || ei.detached {
+			continue
+		}
+
+		log, version := &EdgegapInstanceEventLog{Events: []EdgegapInstanceEvent{}}, "*"
+		if obj, ok := stored[instance.Id]; ok {
+			if err = json.Unmarshal([]byte(obj.Value), log); err != nil {
+				return nil, err
+			}
+			version = obj.Version
+		}
+		if !log.append(instance, ei, source) {
+			continue
+		}
+
+		value, err := json.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		writes[instance.Id] = &runtime.StorageWrite{
+			Collection:      sm.collectionsOf(instance).events,
+			Key:             instance.Id,
+			Value:           string(value),
+			Version:         version,
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		}
+	}
+	return writes, nil
+}
+
+// withEventLogWrites appends the event log writes of the instances to the writes.
+func (sm *StorageManager) withEventLogWrites(ctx context.Context, writes []*runtime.StorageWrite, instances ...*runtime.InstanceInfo) ([]*runtime.StorageWrite, error) {
+	logWrites, err := sm.eventLogWrites(ctx, instances...)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range instances {
+		if write, ok := logWrites[instance.Id]; ok {
+			writes = append(writes, write)
+		}
+	}
+	return writes, nil
+}
+
+// writeWithEventLog writes the instances along with their event logs. Writes of instances unconditional on their
+// version can only be rejected by the version of a log, they are retried with the events appended again.
+func (sm *StorageManager) writeWithEventLog(ctx context.Context, writes []*runtime.StorageWrite, unconditional bool, instances ...*runtime.InstanceInfo) ([]*api.StorageObjectAck, error) {
+	for attempt := 1; ; attempt++ {
+		batch, err := sm.withEventLogWrites(ctx, slices.Clone(writes), instances...)
+		if err != nil {
+			return nil, err
+		}
+		acks, err := sm.nk.StorageWrite(ctx, batch)
+		if !unconditional || !errors.Is(err, runtime.ErrStorageRejectedVersion) || attempt == eventLogAttempts {
+			return acks, err
+		}
+	}
+}
+
+// scrubInstanceEvents removes the users from the event logs of the instances, returning the number of logs changed.
+func (sm *StorageManager) scrubInstanceEvents(ctx context.Context, ids []string, userIds []string) (int, error) {
+	tenants, err := sm.resolveTenants(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+	scrubbed := 0
+	for batch := range slices.Chunk(ids, 100) {
+		reads := make([]*runtime.StorageRead, 0, len(batch))
+		for _, id := range batch {
+			reads = append(reads, &runtime.StorageRead{Collection: sm.collectionsOfTenant(tenants[id]).events, Key: id})
+		}
+		objects, err := sm.nk.StorageRead(ctx, reads)
+		if err != nil {
+			return scrubbed, err
+		}
+
+		for _, obj := range objects {
+			var log EdgegapInstanceEventLog
+			if err = json.Unmarshal([]byte(obj.Value), &log); err != nil || !log.scrubUsers(userIds) {
+				continue
+			}
+			if err = sm.writeInstanceEventLog(ctx, obj.Collection, obj.Key, &log, obj.Version); err != nil {
+				return scrubbed, err
+			}
+			scrubbed++
+		}
+	}
+	return scrubbed, nil
+}
+
+// instanceDrift lists the fields of the instance differing from the folded state.
+func instanceDrift(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo, state EdgegapInstanceState) []string {
+	drift := make([]string, 0)
+	if instance.Status != state.Status {
+		drift = append(drift, "status")
+	}
+	if !sameUsers(ei.Connections, state.Connections) {
+		drift = append(drift, "connections")
+	}
+	if !sameUsers(ei.Reservations, state.Reservations) {
+		drift = append(drift, "reservations")
+	}
+	return drift
+}
+
+// sameUsers reports whether both lists hold the same users, in any order.
+func sameUsers(a, b []string) bool {
+	return len(a) == len(b) && len(helpers.RemoveElements(a, b)) == 0
+}
+
+// instanceEventLog admin rpc reporting the event log of an instance, its folded state and the drift of the record
+// from it, optionally repairing the record (S2S only)
+func instanceEventLog(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdInstanceEventLog); err != nil {
+		return "", err
+	}
+
+	var req *instanceEventLogRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil || req.InstanceId == "" {
+		return "", ErrInvalidInput
+	}
+
+	sm := fmInstance.storageManager
+	log, _, err := sm.readInstanceEventLog(ctx, req.InstanceId)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read instance event log")
+		return "", ErrInternalError
+	}
+	if log.NextSeq == 0 {
+		return "", runtime.NewError("no events recorded for instance "+req.InstanceId, 5) // NOT_FOUND
+	}
+
+	reply := instanceEventLogReply{Log: *log, State: log.state(), Drift: []string{}}
+	instance, err := sm.getDbInstance(ctx, req.InstanceId)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read instance")
+		return "", ErrInternalError
+	}
+	if instance != nil {
+		ei, err := sm.ExtractEdgegapInstance(instance)
+		if err != nil {
+			return "", ErrInternalError
+		}
+		reply.Drift = instanceDrift(instance, ei, reply.State)
+
+		if req.Repair && len(reply.Drift) > 0 {
+			instance.Status = reply.State.Status
+			ei.Connections = reply.State.Connections
+			ei.Reservations = reply.State.Reservations
+			instance.Metadata["edgegap"] = ei
+			if err = sm.updateDbInstance(ctx, instance); err != nil {
+				if errors.Is(err, runtime.ErrStorageRejectedVersion) {
+					return "", runtime.NewError("instance changed during the repair, retry", 10) // ABORTED
+				}
+				logger.WithField("error", err.Error()).Error("failed to repair instance from its event log")
+				return "", ErrInternalError
+			}
+			logger.Warn("Repaired %v of instance %s from its event log", reply.Drift, req.InstanceId)
+			reply.Repaired = true
+		}
+	}
+
+	replyString, err := json.Marshal(reply)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyString), nil
+}
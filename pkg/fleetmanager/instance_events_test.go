@@ -0,0 +1,184 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestEventLogState(t *testing.T) {
+	tests := []struct {
+		name string
+		log  EdgegapInstanceEventLog
+		want EdgegapInstanceState
+	}{
+		{
+			name: "empty",
+			want: EdgegapInstanceState{Connections: []string{}, Reservations: []string{}},
+		},
+		{
+			name: "snapshot only",
+			log: EdgegapInstanceEventLog{
+				Snapshot: EdgegapInstanceState{Status: EdgegapStatusReady, Connections: []string{"a"}, Reservations: []string{"b"}, Seq: 4},
+			},
+			want: EdgegapInstanceState{Status: EdgegapStatusReady, Connections: []string{"a"}, Reservations: []string{"b"}, Seq: 4},
+		},
+		{
+			name: "events folded in order over the snapshot",
+			log: EdgegapInstanceEventLog{
+				Snapshot: EdgegapInstanceState{Status: EdgegapStatusRunning, Reservations: []string{"a", "b"}, Seq: 2},
+				Events: []EdgegapInstanceEvent{
+					{Seq: 3, Status: EdgegapStatusReady},
+					{Seq: 4, Joined: []string{"a"}, Released: []string{"a"}},
+					{Seq: 5, Joined: []string{"a", "c"}, Reserved: []string{"d"}},
+					{Seq: 6, Left: []string{"c"}, Released: []string{"b"}},
+				},
+			},
+			want: EdgegapInstanceState{Status: EdgegapStatusReady, Connections: []string{"a"}, Reservations: []string{"d"}, Seq: 6},
+		},
+		{
+			name: "event without status keeps the status",
+			log: EdgegapInstanceEventLog{
+				Events: []EdgegapInstanceEvent{
+					{Seq: 1, Status: EdgegapStatusReady},
+					{Seq: 2, Joined: []string{"a"}},
+				},
+			},
+			want: EdgegapInstanceState{Status: EdgegapStatusReady, Connections: []string{"a"}, Reservations: []string{}, Seq: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.log.state()
+			if got.Status != tt.want.Status || got.Seq != tt.want.Seq ||
+				!slices.Equal(got.Connections, tt.want.Connections) || !slices.Equal(got.Reservations, tt.want.Reservations) {
+				t.Errorf("state() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventLogStateLeavesSnapshot(t *testing.T) {
+	log := EdgegapInstanceEventLog{
+		Snapshot: EdgegapInstanceState{Connections: []string{"a"}},
+		Events:   []EdgegapInstanceEvent{{Seq: 1, Joined: []string{"b"}, Left: []string{"a"}}},
+	}
+	log.state()
+	if !slices.Equal(log.Snapshot.Connections, []string{"a"}) {
+		t.Errorf("state() changed the snapshot connections to %v", log.Snapshot.Connections)
+	}
+}
+
+func TestEventLogAppend(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		connections []string
+		reserved    []string
+		wantChanged bool
+		want        EdgegapInstanceEvent
+	}{
+		{
+			name:        "unchanged",
+			status:      EdgegapStatusReady,
+			connections: []string{"a"},
+			reserved:    []string{"b"},
+		},
+		{
+			name:        "status",
+			status:      EdgegapStatusStopping,
+			connections: []string{"a"},
+			reserved:    []string{"b"},
+			wantChanged: true,
+			want:        EdgegapInstanceEvent{Status: EdgegapStatusStopping},
+		},
+		{
+			name:        "connections and reservations",
+			status:      EdgegapStatusReady,
+			connections: []string{"b", "c"},
+			reserved:    []string{"d"},
+			wantChanged: true,
+			want:        EdgegapInstanceEvent{Joined: []string{"b", "c"}, Left: []string{"a"}, Reserved: []string{"d"}, Released: []string{"b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := EdgegapInstanceEventLog{
+				Snapshot: EdgegapInstanceState{Status: EdgegapStatusReady, Connections: []string{"a"}, Reservations: []string{"b"}, Seq: 7},
+				NextSeq:  7,
+			}
+			instance := &runtime.InstanceInfo{Status: tt.status}
+			ei := &EdgegapInstanceInfo{Connections: tt.connections, Reservations: tt.reserved}
+
+			if changed := log.append(instance, ei, EventSourceWorker); changed != tt.wantChanged {
+				t.Fatalf("append() = %v, want %v", changed, tt.wantChanged)
+			}
+			if !tt.wantChanged {
+				if len(log.Events) != 0 || log.NextSeq != 7 {
+					t.Errorf("append() recorded %d events, next seq %d", len(log.Events), log.NextSeq)
+				}
+				return
+			}
+
+			if len(log.Events) != 1 || log.NextSeq != 8 {
+				t.Fatalf("append() recorded %d events, next seq %d, want 1 event, next seq 8", len(log.Events), log.NextSeq)
+			}
+			got := log.Events[0]
+			if got.Seq != 8 || got.Source != EventSourceWorker || got.Status != tt.want.Status ||
+				!sameUsers(got.Joined, tt.want.Joined) || !sameUsers(got.Left, tt.want.Left) ||
+				!sameUsers(got.Reserved, tt.want.Reserved) || !sameUsers(got.Released, tt.want.Released) {
+				t.Errorf("append() recorded %+v, want %+v", got, tt.want)
+			}
+
+			state := log.state()
+			if state.Status != tt.status || !slices.Equal(state.Connections, tt.connections) || !slices.Equal(state.Reservations, tt.reserved) {
+				t.Errorf("state() after append = %+v", state)
+			}
+		})
+	}
+}
+
+func TestEventLogCompaction(t *testing.T) {
+	tests := []struct {
+		name       string
+		appends    int
+		wantEvents int
+		wantSeq    int64
+	}{
+		{name: "below the threshold", appends: eventLogCompactAt, wantEvents: eventLogCompactAt, wantSeq: 0},
+		{name: "past the threshold", appends: eventLogCompactAt + 1, wantEvents: eventLogKeep, wantSeq: eventLogCompactAt + 1 - eventLogKeep},
+		{name: "compacted again", appends: 2*eventLogCompactAt - eventLogKeep + 2, wantEvents: eventLogKeep, wantSeq: 2*eventLogCompactAt - 2*eventLogKeep + 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := EdgegapInstanceEventLog{}
+			instance := &runtime.InstanceInfo{Status: EdgegapStatusReady}
+			// Each append connects a new user in place of the previous one
+			var connections []string
+			for i := 1; i <= tt.appends; i++ {
+				connections = []string{fmt.Sprintf("user-%d", i)}
+				if !log.append(instance, &EdgegapInstanceInfo{Connections: connections}, EventSourceWorker) {
+					t.Fatalf("append %d recorded nothing", i)
+				}
+			}
+
+			if len(log.Events) != tt.wantEvents {
+				t.Errorf("events = %d, want %d", len(log.Events), tt.wantEvents)
+			}
+			if log.Snapshot.Seq != tt.wantSeq {
+				t.Errorf("snapshot seq = %d, want %d", log.Snapshot.Seq, tt.wantSeq)
+			}
+			if log.NextSeq != int64(tt.appends) {
+				t.Errorf("next seq = %d, want %d", log.NextSeq, tt.appends)
+			}
+			if state := log.state(); state.Seq != int64(tt.appends) || state.Status != EdgegapStatusReady || !slices.Equal(state.Connections, connections) {
+				t.Errorf("state() after compaction = %+v, want seq %d, status %s and connections %v", state, tt.appends, EdgegapStatusReady, connections)
+			}
+		})
+	}
+}
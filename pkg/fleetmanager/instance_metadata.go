@@ -0,0 +1,63 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// parseInstanceMetadataFields parses the comma separated metadata fields forwarded to the game server, none means all.
+func parseInstanceMetadataFields(value string) ([]string, error) {
+	fields := make([]string, 0)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if field == "edgegap" || strings.ContainsAny(field, ". ") {
+			return nil, fmt.Errorf("invalid instance metadata field %q, expects a top-level metadata key", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// forwardedMetadata returns the metadata the game server receives: the configured fields, or every field, without the
+// fields the plugin stores for itself.
+func forwardedMetadata(config *EdgegapManagerConfiguration, metadata map[string]any) map[string]any {
+	fields, _ := parseInstanceMetadataFields(config.InstanceMetadataFields)
+
+	forwarded := make(map[string]any, len(metadata))
+	for k, v := range metadata {
+		switch k {
+		case "edgegap", InstanceMetadataCallbackUrl, InstanceMetadataHeartbeatUrl, UpdateMetadataInstanceTokenKey:
+			continue
+		}
+		if len(fields) == 0 || slices.Contains(fields, k) {
+			forwarded[k] = v
+		}
+	}
+	return forwarded
+}
+
+// instanceMetadataVariable returns the environment variable shipping the metadata to the game server. Metadata fetched
+// on boot, or larger than NAKAMA_INSTANCE_METADATA_MAX_BYTES, is replaced by NAKAMA_INSTANCE_METADATA_FETCH: the game
+// server reads it from whoami with its identity token instead.
+func (em *EdgegapManager) instanceMetadataVariable(metadata map[string]any) (EdgegapEnvironmentVariable, error) {
+	fetch := EdgegapEnvironmentVariable{Key: "NAKAMA_INSTANCE_METADATA_FETCH", Value: "true"}
+	if em.configuration.InstanceMetadataFetch {
+		return fetch, nil
+	}
+
+	value, err := json.Marshal(forwardedMetadata(em.configuration, metadata))
+	if err != nil {
+		return EdgegapEnvironmentVariable{}, err
+	}
+	if maxBytes := em.configuration.InstanceMetadataLimit; maxBytes > 0 && len(value) > maxBytes {
+		em.logger.Warn("Instance metadata of %d bytes exceeds %d bytes, the game server fetches it from whoami", len(value), maxBytes)
+		return fetch, nil
+	}
+
+	return EdgegapEnvironmentVariable{Key: "NAKAMA_INSTANCE_METADATA", Value: string(value)}, nil
+}
@@ -0,0 +1,108 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// ErrorJoinLocked is returned by Join when another join holds the seat allocation of the instance
+var ErrorJoinLocked = errors.New("another join is allocating the seats of this instance")
+
+// EdgegapJoinLock serializes the seat allocation of an instance across the Nakama nodes, keyed by the instance id
+type EdgegapJoinLock struct {
+	// Owner is the user joining first in the request holding the lock
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// JoinLockError is the ErrorJoinLocked of an instance, with the hint of when to retry
+type JoinLockError struct {
+	InstanceId string
+	Owner      string
+	ExpiresAt  time.Time
+	RetryAfter time.Duration
+}
+
+func (e *JoinLockError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrorJoinLocked, e.RetryAfter)
+}
+
+func (e *JoinLockError) Unwrap() error {
+	return ErrorJoinLocked
+}
+
+// joinLock is a join lock held by this request, released once the seats are allocated
+type joinLock struct {
+	sm         *StorageManager
+	instanceId string
+	version    string
+}
+
+// joinLockTtl returns how long a join holds the seat allocation of an instance at most, 0 if joins are not locked.
+func joinLockTtl(config *EdgegapManagerConfiguration) time.Duration {
+	ttl, _ := time.ParseDuration(config.JoinLockTtl)
+	return max(ttl, 0)
+}
+
+// acquireJoinLock claims the seat allocation of the instance, conditional on the version read so a single join wins.
+// An expired lock is taken over, its holder failed to release it.
+func (sm *StorageManager) acquireJoinLock(ctx context.Context, instanceId, owner string, ttl time.Duration) (*joinLock, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: sm.locksCollection,
+		Key:        instanceId,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	version := "*"
+	if len(objects) > 0 {
+		version = objects[0].Version
+
+		var held EdgegapJoinLock
+		if err = json.Unmarshal([]byte(objects[0].Value), &held); err == nil && now.Before(held.ExpiresAt) {
+			return nil, &JoinLockError{InstanceId: instanceId, Owner: held.Owner, ExpiresAt: held.ExpiresAt, RetryAfter: held.ExpiresAt.Sub(now)}
+		}
+	}
+
+	value, err := json.Marshal(EdgegapJoinLock{Owner: owner, AcquiredAt: now, ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return nil, err
+	}
+	acks, err := sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      sm.locksCollection,
+		Key:             instanceId,
+		Value:           string(value),
+		Version:         version,
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}})
+	if errors.Is(err, runtime.ErrStorageRejectedVersion) || (err == nil && len(acks) == 0) {
+		// Another join claimed the lock since it was read
+		return nil, &JoinLockError{InstanceId: instanceId, ExpiresAt: now.Add(ttl), RetryAfter: ttl}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &joinLock{sm: sm, instanceId: instanceId, version: acks[0].Version}, nil
+}
+
+// release frees the lock for the next join, left to expire if it cannot be deleted.
+func (l *joinLock) release(ctx context.Context) {
+	err := l.sm.nk.StorageDelete(ctx, []*runtime.StorageDelete{{
+		Collection: l.sm.locksCollection,
+		Key:        l.instanceId,
+		Version:    l.version,
+	}})
+	if err != nil {
+		l.sm.logger.WithField("error", err.Error()).Warn("failed to release join lock of instance %s", l.instanceId)
+	}
+}
@@ -0,0 +1,121 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestAcquireJoinLock(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+
+	lock, err := sm.acquireJoinLock(ctx, "id", "a", time.Minute)
+	if err != nil {
+		t.Fatalf("acquireJoinLock() error = %v", err)
+	}
+
+	// A held lock is reported with its owner until released
+	_, err = sm.acquireJoinLock(ctx, "id", "b", time.Minute)
+	var lerr *JoinLockError
+	if !errors.As(err, &lerr) || !errors.Is(err, ErrorJoinLocked) {
+		t.Fatalf("acquireJoinLock() of a held lock error = %v, want %v", err, ErrorJoinLocked)
+	}
+	if lerr.Owner != "a" || lerr.RetryAfter <= 0 || lerr.RetryAfter > time.Minute {
+		t.Errorf("JoinLockError = %+v, want owner a retrying within a minute", lerr)
+	}
+	if _, err = sm.acquireJoinLock(ctx, "other", "b", time.Minute); err != nil {
+		t.Errorf("acquireJoinLock() of another instance error = %v", err)
+	}
+
+	lock.release(ctx)
+	if nk.has(sm.locksCollection, "id") {
+		t.Fatal("released lock still stored")
+	}
+	if _, err = sm.acquireJoinLock(ctx, "id", "b", time.Minute); err != nil {
+		t.Errorf("acquireJoinLock() of a released lock error = %v", err)
+	}
+}
+
+func TestAcquireJoinLockExpired(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+
+	stale, err := sm.acquireJoinLock(ctx, "id", "a", time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	// The expired lock is taken over, the late release of its holder leaves the new lock in place
+	if _, err = sm.acquireJoinLock(ctx, "id", "b", time.Minute); err != nil {
+		t.Fatalf("acquireJoinLock() of an expired lock error = %v", err)
+	}
+	stale.release(ctx)
+	if !nk.has(sm.locksCollection, "id") {
+		t.Error("release of the expired lock deleted the lock taking it over")
+	}
+}
+
+func TestAcquireJoinLockRace(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+	other := NewStorageManager(nk, fakeLogger{})
+
+	// The other node claims the lock between the read and the write of this one
+	raced := false
+	nk.beforeWrite = func(writes []*runtime.StorageWrite) {
+		if raced {
+			return
+		}
+		raced = true
+		if _, err := other.acquireJoinLock(ctx, "id", "b", time.Minute); err != nil {
+			t.Errorf("acquireJoinLock() on the other node error = %v", err)
+		}
+	}
+	if _, err := sm.acquireJoinLock(ctx, "id", "a", time.Minute); !errors.Is(err, ErrorJoinLocked) {
+		t.Errorf("acquireJoinLock() losing the race error = %v, want %v", err, ErrorJoinLocked)
+	}
+}
+
+func TestJoinLocked(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	config := &EdgegapManagerConfiguration{JoinLockTtl: "1m"}
+	node := newFakeFleetManager(nk, config)
+	if _, err := node.storageManager.createDbInstance(ctx, "id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A join waits for the lock of another one
+	held, err := node.storageManager.acquireJoinLock(ctx, "id", "other", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = node.Join(ctx, "id", []string{"user"}, nil); !errors.Is(err, ErrorJoinLocked) {
+		t.Fatalf("Join() of a locked instance error = %v, want %v", err, ErrorJoinLocked)
+	}
+	held.release(ctx)
+
+	// Then allocates its seats and releases the lock
+	if _, err = node.Join(ctx, "id", []string{"user"}, nil); err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+	if nk.has(node.storageManager.locksCollection, "id") {
+		t.Error("join lock not released")
+	}
+	stored, err := node.storageManager.getDbInstance(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stored.Metadata["edgegap"].(*EdgegapInstanceInfo).Reservations; !slices.Equal(got, []string{"user"}) {
+		t.Errorf("reservations = %v, want [user]", got)
+	}
+}
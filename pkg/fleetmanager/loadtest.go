@@ -0,0 +1,279 @@
+package fleetmanager
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdFleetLoadTest simulates create, join and connection event cycles against storage to size Nakama nodes (S2S only)
+const RpcIdFleetLoadTest = "fleet_loadtest"
+
+// EdgegapStatusLoadTest is the status of the simulated instances, skipped by the workers and status filtered queries
+const EdgegapStatusLoadTest = "LOADTEST"
+
+// Load test operations, each timed separately
+const (
+	LoadTestOperationCreate     = "create"
+	LoadTestOperationJoin       = "join"
+	LoadTestOperationConnection = "connection_event"
+	LoadTestOperationDelete     = "delete"
+)
+
+const (
+	loadTestDefaultCycles      = 100
+	loadTestMaxCycles          = 10_000
+	loadTestDefaultConcurrency = 8
+	loadTestMaxConcurrency     = 64
+	loadTestDefaultPlayers     = 4
+	loadTestMaxPlayers         = 100
+	loadTestMaxErrors          = 10
+)
+
+// loadTestRunning allows a single load test per node, concurrent runs would skew each other's measures
+var loadTestRunning atomic.Bool
+
+type fleetLoadTestRequest struct {
+	// Cycles is the number of create, join, connection event and delete cycles to simulate
+	Cycles int `json:"cycles"`
+	// Concurrency is the number of cycles simulated in parallel
+	Concurrency int `json:"concurrency"`
+	// Players is the number of users joining and connecting to each simulated instance
+	Players int `json:"players"`
+}
+
+type fleetLoadTestReply struct {
+	Cycles          int                           `json:"cycles"`
+	Completed       int                           `json:"completed"`
+	Failed          int                           `json:"failed"`
+	Concurrency     int                           `json:"concurrency"`
+	Players         int                           `json:"players"`
+	DurationMs      int64                         `json:"duration_ms"`
+	CyclesPerSecond float64                       `json:"cycles_per_second"`
+	Operations      map[string]*loadTestOperation `json:"operations"`
+	Errors          []string                      `json:"errors"`
+
+	mu      sync.Mutex
+	samples map[string][]int64
+}
+
+// loadTestOperation is the storage latency of an operation over the load test, in milliseconds
+type loadTestOperation struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// record adds the latency of an operation.
+func (r *fleetLoadTestReply) record(operation string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples[operation] = append(r.samples[operation], latency.Microseconds())
+}
+
+// fail counts a failed cycle, keeping the first errors.
+func (r *fleetLoadTestReply) fail(operation string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Failed++
+	if len(r.Errors) < loadTestMaxErrors {
+		r.Errors = append(r.Errors, operation+": "+err.Error())
+	}
+}
+
+// summarize computes the percentiles of every operation and the throughput over the duration.
+func (r *fleetLoadTestReply) summarize(duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.DurationMs = duration.Milliseconds()
+	r.Completed = r.Cycles - r.Failed
+	if duration > 0 {
+		r.CyclesPerSecond = float64(r.Completed) / duration.Seconds()
+	}
+	for operation, samples := range r.samples {
+		slices.Sort(samples)
+		r.Operations[operation] = &loadTestOperation{
+			Count: int64(len(samples)),
+			P50:   float64(percentile(samples, 0.5)) / 1000,
+			P90:   float64(percentile(samples, 0.9)) / 1000,
+			P99:   float64(percentile(samples, 0.99)) / 1000,
+			Max:   float64(samples[len(samples)-1]) / 1000,
+		}
+	}
+}
+
+// loadTestId returns a random id for a simulated instance or user.
+func loadTestId(prefix string) string {
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	return prefix + "-" + hex.EncodeToString(suffix)
+}
+
+// runLoadTestCycle simulates the lifecycle of an instance on the storage paths of the plugin, without any Edgegap call:
+// the instance record is created, the players reserve their seats, a connection event converts the reservations and
+// the record is deleted. Entitlement, notifications and the audit log are not exercised.
+func (efm *EdgegapFleetManager) runLoadTestCycle(ctx context.Context, writes *writeCoalescer, players int, reply *fleetLoadTestReply) {
+	sm := efm.storageManager
+	id := loadTestId("loadtest")
+	userIds := make([]string, 0, players)
+	for i := 0; i < players; i++ {
+		userIds = append(userIds, loadTestId("loadtest-user"))
+	}
+
+	start := time.Now()
+	_, err := sm.createDbInstance(ctx, id, EdgegapStatusLoadTest, EdgegapInstanceInfo{MaxPlayers: players}, map[string]any{})
+	reply.record(LoadTestOperationCreate, time.Since(start))
+	// The record is removed even when a later step fails, a failed create may still have written it
+	defer func() {
+		start := time.Now()
+		if err := sm.deleteDbInstances(context.WithoutCancel(ctx), []string{id}); err != nil {
+			efm.logger.WithFields(map[string]any{"error": err.Error(), "instance_id": id}).Error("failed to delete load test instance")
+			return
+		}
+		reply.record(LoadTestOperationDelete, time.Since(start))
+	}()
+	if err != nil {
+		reply.fail(LoadTestOperationCreate, err)
+		return
+	}
+
+	start = time.Now()
+	err = efm.loadTestJoin(ctx, id, userIds)
+	reply.record(LoadTestOperationJoin, time.Since(start))
+	if err != nil {
+		reply.fail(LoadTestOperationJoin, err)
+		return
+	}
+
+	start = time.Now()
+	err = writes.submit(ctx, id, func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) (bool, func(ctx context.Context)) {
+		ei.convertReservations(userIds)
+		ei.Reservations = []string{}
+		ei.Connections = slices.Clone(userIds)
+		ei.ReservationsUpdatedAt = time.Now().UTC()
+		return true, nil
+	})
+	reply.record(LoadTestOperationConnection, time.Since(start))
+	if err != nil {
+		reply.fail(LoadTestOperationConnection, err)
+	}
+}
+
+// loadTestJoin reserves the seats of the users like Join, reading the instance from storage and writing it back.
+func (efm *EdgegapFleetManager) loadTestJoin(ctx context.Context, id string, userIds []string) error {
+	sm := efm.storageManager
+	sm.InvalidateInstance(id)
+	instance, err := sm.getDbInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		return errors.New("instance not found")
+	}
+
+	ei, err := sm.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return err
+	}
+	ei.reserve(userIds, 0)
+	instance.Metadata["edgegap"] = ei
+
+	return sm.updateDbInstance(ctx, instance)
+}
+
+// LoadTest simulates the cycles with the concurrency and reports the throughput and storage latency of each operation.
+func (efm *EdgegapFleetManager) LoadTest(ctx context.Context, req *fleetLoadTestRequest) *fleetLoadTestReply {
+	reply := &fleetLoadTestReply{
+		Cycles:      req.Cycles,
+		Concurrency: req.Concurrency,
+		Players:     req.Players,
+		Operations:  make(map[string]*loadTestOperation),
+		Errors:      []string{},
+		samples:     make(map[string][]int64),
+	}
+	// Connection events are applied right away, a coalescing window would only measure the timer
+	writes := newWriteCoalescer(ctx, efm.logger, efm.storageManager, 0)
+
+	cycles := make(chan struct{})
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for i := 0; i < req.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range cycles {
+				efm.runLoadTestCycle(ctx, writes, req.Players, reply)
+			}
+		}()
+	}
+
+	scheduled := 0
+	for ; scheduled < req.Cycles && ctx.Err() == nil; scheduled++ {
+		cycles <- struct{}{}
+	}
+	close(cycles)
+	wg.Wait()
+
+	reply.Cycles = scheduled
+	reply.summarize(time.Since(start))
+	efm.nk.MetricsCounterAdd("edgegap_loadtest_cycles", nil, int64(reply.Completed))
+	return reply
+}
+
+// fleetLoadTest admin rpc simulating create, join and connection event cycles against storage, without Edgegap calls (S2S only)
+func fleetLoadTest(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdFleetLoadTest); err != nil {
+		return "", err
+	}
+
+	req := &fleetLoadTestRequest{}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), req); err != nil {
+			return "", ErrInvalidInput
+		}
+	}
+	if req.Cycles == 0 {
+		req.Cycles = loadTestDefaultCycles
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = loadTestDefaultConcurrency
+	}
+	if req.Players == 0 {
+		req.Players = loadTestDefaultPlayers
+	}
+	if req.Cycles < 0 || req.Cycles > loadTestMaxCycles || req.Concurrency < 0 || req.Concurrency > loadTestMaxConcurrency || req.Players < 0 || req.Players > loadTestMaxPlayers {
+		return "", runtime.NewError(fmt.Sprintf("expects cycles up to %d, concurrency up to %d and players up to %d", loadTestMaxCycles, loadTestMaxConcurrency, loadTestMaxPlayers), 3) // INVALID_ARGUMENT
+	}
+	req.Concurrency = min(req.Concurrency, req.Cycles)
+
+	if !loadTestRunning.CompareAndSwap(false, true) {
+		return "", runtime.NewError("a load test is already running on this node", 10) // ABORTED
+	}
+	defer loadTestRunning.Store(false)
+
+	logger.Warn("Fleet load test of %d cycles started, concurrency: %d, players: %d", req.Cycles, req.Concurrency, req.Players)
+	result := fmInstance.LoadTest(ctx, req)
+	logger.Info("Fleet load test completed %d cycles in %dms, %d failed", result.Completed, result.DurationMs, result.Failed)
+
+	reply, err := json.Marshal(result)
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(reply), nil
+}
@@ -0,0 +1,53 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// Round trip in fiber is roughly 1ms per 100km, plus a fixed overhead for routing and last mile
+	pingKmPerMs      = 100.0
+	pingBaseOverhead = 5
+)
+
+// estimatePingMs gives a rough round trip estimate in milliseconds between two locations.
+func estimatePingMs(from, to EdgegapLocation) int {
+	distance := helpers.HaversineDistanceKm(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+	return int(distance/pingKmPerMs) + pingBaseOverhead
+}
+
+// locationQuery appends the region and country filters to a storage index query.
+func locationQuery(query, region, country string) string {
+	clauses := make([]string, 0, 3)
+	if strings.TrimSpace(query) != "" {
+		clauses = append(clauses, query)
+	}
+	if region != "" {
+		clauses = append(clauses, fmt.Sprintf("+value.metadata.edgegap.location.continent:%q", region))
+	}
+	if country != "" {
+		clauses = append(clauses, fmt.Sprintf("+value.metadata.edgegap.location.country:%q", country))
+	}
+	return strings.Join(clauses, " ")
+}
+
+// filterByPing drops instances whose estimated ping from the given location exceeds maxPing.
+// Instances without a known location are kept since their distance cannot be estimated.
+func (sm *StorageManager) filterByPing(instances []*runtime.InstanceInfo, from EdgegapLocation, maxPing int) []*runtime.InstanceInfo {
+	results := make([]*runtime.InstanceInfo, 0, len(instances))
+	for _, instance := range instances {
+		ei, err := sm.ExtractEdgegapInstance(instance)
+		if err != nil || ei.Location == nil || !ei.Location.hasCoordinates() {
+			results = append(results, instance)
+			continue
+		}
+		if estimatePingMs(from, *ei.Location) <= maxPing {
+			results = append(results, instance)
+		}
+	}
+	return results
+}
@@ -0,0 +1,134 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdEdgegapLocations lists the locations the application can be deployed to, for region pickers
+const RpcIdEdgegapLocations = "edgegap_locations"
+
+// EdgegapAvailableLocation is a location the current version of the application can be deployed to
+type EdgegapAvailableLocation struct {
+	City                   string   `json:"city"`
+	Country                string   `json:"country"`
+	Continent              string   `json:"continent"`
+	AdministrativeDivision string   `json:"administrative_division"`
+	Timezone               string   `json:"timezone"`
+	Latitude               float64  `json:"latitude"`
+	Longitude              float64  `json:"longitude"`
+	Type                   string   `json:"type,omitempty"`
+	Tags                   []string `json:"tags,omitempty"`
+}
+
+type edgegapLocationList struct {
+	Locations []EdgegapAvailableLocation `json:"locations"`
+}
+
+type edgegapLocationsReply struct {
+	// Regions are the continents of the locations, the values accepted by the region preferences and filters
+	Regions   []string                   `json:"regions"`
+	Locations []EdgegapAvailableLocation `json:"locations"`
+}
+
+// ListLocations retrieves the locations the current version of the application can be deployed to, cached for
+// NAKAMA_LOCATIONS_CACHE_TTL and fetched again when the version changes.
+func (em *EdgegapManager) ListLocations() ([]EdgegapAvailableLocation, error) {
+	version, err := em.getEdgegapVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := time.ParseDuration(em.configuration.LocationsCacheTtl)
+	if err != nil {
+		return nil, err
+	}
+
+	return em.locations.get(version, ttl, func() ([]EdgegapAvailableLocation, error) {
+		return em.fetchLocations(version)
+	})
+}
+
+func (em *EdgegapManager) fetchLocations(version string) ([]EdgegapAvailableLocation, error) {
+	query := url.Values{"app": {em.configuration.Application}, "version": {version}}
+	reply, err := em.apiHelper.Get("/v1/locations?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not list locations: status %d", reply.StatusCode)
+	}
+
+	body, err := io.ReadAll(reply.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var list edgegapLocationList
+	if err = json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal location list response: %w", err)
+	}
+	if list.Locations == nil {
+		list.Locations = []EdgegapAvailableLocation{}
+	}
+	return list.Locations, nil
+}
+
+// locationRegions returns the sorted distinct continents of the locations.
+func locationRegions(locations []EdgegapAvailableLocation) []string {
+	regions := make([]string, 0)
+	for _, location := range locations {
+		if location.Continent != "" && !slices.Contains(regions, location.Continent) {
+			regions = append(regions, location.Continent)
+		}
+	}
+	slices.Sort(regions)
+	return regions
+}
+
+// canonicalRegions matches the regions to the continents of the locations case-insensitively, returning the first
+// region no location is deployed in.
+func canonicalRegions(regions []string, locations []EdgegapAvailableLocation) ([]string, string) {
+	available := locationRegions(locations)
+	canonical := make([]string, 0, len(regions))
+	for _, region := range regions {
+		i := slices.IndexFunc(available, func(continent string) bool {
+			return strings.EqualFold(continent, region)
+		})
+		if i < 0 {
+			return nil, region
+		}
+		canonical = append(canonical, available[i])
+	}
+	return canonical, ""
+}
+
+// listEdgegapLocations client rpc listing the locations and regions the application can be deployed to
+func listEdgegapLocations(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	locations, err := fmInstance.edgegapManager.ListLocations()
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list Edgegap locations")
+		return "", ErrInternalError
+	}
+
+	reply, err := json.Marshal(edgegapLocationsReply{
+		Regions:   locationRegions(locations),
+		Locations: locations,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
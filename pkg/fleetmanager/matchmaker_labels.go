@@ -0,0 +1,204 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/rtapi"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// MatchmakerLabelPrefix prefixes the matchmaker properties mirrored from the instance of the player, e.g.
+// instance_region, so they never collide with the properties set by the clients
+const MatchmakerLabelPrefix = "instance_"
+
+// labelFields are the instance fields mirrored by name, custom fields are read from the instance metadata with a
+// "metadata." path such as "metadata.game_mode"
+var labelFields = map[string]func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any{
+	"status":      func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any { return instance.Status },
+	"version":     func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any { return ei.Version },
+	"capacity":    func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any { return ei.Capacity },
+	"max_players": func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any { return ei.MaxPlayers },
+	"region": func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any {
+		if ei.Location == nil {
+			return nil
+		}
+		return ei.Location.Continent
+	},
+	"country": func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any {
+		if ei.Location == nil {
+			return nil
+		}
+		return ei.Location.Country
+	},
+	"city": func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) any {
+		if ei.Location == nil {
+			return nil
+		}
+		return ei.Location.City
+	},
+}
+
+// parseMatchmakerLabels parses the comma separated instance fields mirrored in the matchmaker properties.
+func parseMatchmakerLabels(value string) ([]string, error) {
+	fields := make([]string, 0)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, ok := labelFields[field]; !ok && (!customMetadataField.MatchString(field) || strings.HasPrefix(field, "metadata.edgegap.")) {
+			return nil, fmt.Errorf("invalid matchmaker label %q, expects one of status, version, capacity, max_players, region, country, city or a metadata.<field> path", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// matchmakerLabelName returns the matchmaker property of the field, e.g. instance_region or instance_game_mode.
+func matchmakerLabelName(field string) string {
+	return MatchmakerLabelPrefix + strings.ReplaceAll(strings.TrimPrefix(field, "metadata."), ".", "_")
+}
+
+// InstanceMatchmakerProperties returns the fields of the instance as matchmaker properties, strings and booleans as
+// string properties and numbers as numeric properties. Fields missing from the instance are left out.
+func InstanceMatchmakerProperties(instance *runtime.InstanceInfo, fields []string) (map[string]string, map[string]float64) {
+	stringProperties := make(map[string]string)
+	numericProperties := make(map[string]float64)
+	if instance == nil {
+		return stringProperties, numericProperties
+	}
+
+	ei, err := extractEdgegapInstance(instance)
+	if err != nil {
+		return stringProperties, numericProperties
+	}
+
+	for _, field := range fields {
+		var value any
+		if fn, ok := labelFields[field]; ok {
+			value = fn(instance, ei)
+		} else {
+			value = metadataPath(instance.Metadata, strings.Split(strings.TrimPrefix(field, "metadata."), "."))
+		}
+
+		name := matchmakerLabelName(field)
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				stringProperties[name] = v
+			}
+		case bool:
+			stringProperties[name] = strconv.FormatBool(v)
+		case int:
+			numericProperties[name] = float64(v)
+		case float64:
+			numericProperties[name] = v
+		}
+	}
+	return stringProperties, numericProperties
+}
+
+// metadataPath returns the value at the path of the metadata, nil if missing.
+func metadataPath(metadata map[string]any, path []string) any {
+	var value any = metadata
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// getDbInstanceByConnection returns the ready instance the user is connected to, nil if none.
+func (sm *StorageManager) getDbInstanceByConnection(ctx context.Context, userId string) (*runtime.InstanceInfo, error) {
+	query := fmt.Sprintf("+value.metadata.edgegap.connections:%q +value.status:%s", userId, EdgegapStatusReady)
+	objects, err := sm.listIndexes(ctx, query, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	return decodeInstance(objects[0].Value)
+}
+
+// applyMatchmakerLabels replaces the instance properties of a matchmaker ticket with those of the instance the user is
+// connected to. Properties with the prefix sent by the client are always dropped, the labels are server-authored.
+func applyMatchmakerLabels(ctx context.Context, logger runtime.Logger, stringProperties map[string]string, numericProperties map[string]float64) (map[string]string, map[string]float64) {
+	if stringProperties == nil {
+		stringProperties = make(map[string]string)
+	}
+	if numericProperties == nil {
+		numericProperties = make(map[string]float64)
+	}
+	for name := range stringProperties {
+		if strings.HasPrefix(name, MatchmakerLabelPrefix) {
+			delete(stringProperties, name)
+		}
+	}
+	for name := range numericProperties {
+		if strings.HasPrefix(name, MatchmakerLabelPrefix) {
+			delete(numericProperties, name)
+		}
+	}
+
+	userId, _ := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if userId == "" {
+		return stringProperties, numericProperties
+	}
+
+	instance, err := fmInstance.storageManager.getDbInstanceByConnection(ctx, userId)
+	if err != nil {
+		logger.WithField("error", err.Error()).Warn("failed to find the instance of user %s for the matchmaker labels", userId)
+		return stringProperties, numericProperties
+	}
+
+	labels, numericLabels := InstanceMatchmakerProperties(instance, matchmakerLabels())
+	for name, value := range labels {
+		stringProperties[name] = value
+	}
+	for name, value := range numericLabels {
+		numericProperties[name] = value
+	}
+	return stringProperties, numericProperties
+}
+
+// matchmakerLabels returns the instance fields mirrored in the matchmaker properties, none when not running.
+func matchmakerLabels() []string {
+	if fmInstance == nil {
+		return nil
+	}
+	fields, _ := parseMatchmakerLabels(fmInstance.edgegapManager.configuration.MatchmakerLabels)
+	return fields
+}
+
+// OnMatchmakerAddLabels mirrors the configured fields of the instance the user is connected to in the properties of
+// their matchmaker ticket, so matchmaker queries can filter on them, e.g. +properties.instance_region:Europe
+func OnMatchmakerAddLabels(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, in *rtapi.Envelope) (*rtapi.Envelope, error) {
+	add := in.GetMatchmakerAdd()
+	if add == nil || len(matchmakerLabels()) == 0 {
+		return in, nil
+	}
+
+	add.StringProperties, add.NumericProperties = applyMatchmakerLabels(ctx, logger, add.StringProperties, add.NumericProperties)
+	return in, nil
+}
+
+// OnPartyMatchmakerAddLabels mirrors the configured fields of the instance the party leader is connected to in the
+// properties of the party matchmaker ticket.
+func OnPartyMatchmakerAddLabels(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, in *rtapi.Envelope) (*rtapi.Envelope, error) {
+	add := in.GetPartyMatchmakerAdd()
+	if add == nil || len(matchmakerLabels()) == 0 {
+		return in, nil
+	}
+
+	add.StringProperties, add.NumericProperties = applyMatchmakerLabels(ctx, logger, add.StringProperties, add.NumericProperties)
+	return in, nil
+}
@@ -0,0 +1,143 @@
+package fleetmanager
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// mergeCandidate is an under-filled lobby that can be merged into another of the same group
+type mergeCandidate struct {
+	instance *runtime.InstanceInfo
+	ei       *EdgegapInstanceInfo
+	users    []string
+}
+
+func (mc *mergeCandidate) freeSeats() int {
+	return mc.ei.MaxPlayers - len(mc.users)
+}
+
+// mergeGroup returns the key of the lobbies that can be merged together: same game mode, region and app version.
+func (efm *EdgegapFleetManager) mergeGroup(candidate *mergeCandidate) string {
+	mode := fmt.Sprint(candidate.instance.Metadata[efm.edgegapManager.configuration.ModeMetadataKey])
+	return mode + "|" + candidate.ei.conversionRegion() + "|" + candidate.ei.conversionVersion()
+}
+
+// mergeCandidates lists the ready lobbies old enough and filled below the threshold, persistent instances and
+// instances already draining are never merged.
+func (efm *EdgegapFleetManager) mergeCandidates(instances []*runtime.InstanceInfo, maxFill int, minAge time.Duration) map[string][]*mergeCandidate {
+	groups := make(map[string][]*mergeCandidate)
+	for _, instance := range instances {
+		ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+		if err != nil || ei.Persistent || ei.DrainingTo != "" || ei.MaxPlayers <= 0 {
+			continue
+		}
+
+		requestedAt := ei.RequestedAt
+		if requestedAt.IsZero() {
+			requestedAt = instance.CreateTime
+		}
+		users := append(slices.Clone(ei.Connections), ei.Reservations...)
+		if len(users) == 0 || len(users)*100 >= ei.MaxPlayers*maxFill || time.Since(requestedAt) < minAge {
+			continue
+		}
+
+		candidate := &mergeCandidate{instance: instance, ei: ei, users: users}
+		key := efm.mergeGroup(candidate)
+		groups[key] = append(groups[key], candidate)
+	}
+
+	return groups
+}
+
+// mergeLobbies moves the players of the emptiest lobbies of each group into the fullest lobby able to seat them all.
+// Merged lobbies drain into their target and are stopped once empty, it returns the number of merges.
+func (efm *EdgegapFleetManager) mergeLobbies(ctx context.Context, groups map[string][]*mergeCandidate) int {
+	merges := 0
+	for _, candidates := range groups {
+		slices.SortStableFunc(candidates, func(a, b *mergeCandidate) int {
+			return len(b.users) - len(a.users)
+		})
+
+		// Sources are taken from the emptiest, targets from the fullest, so a source is never merged into
+		for i := len(candidates) - 1; i > 0; i-- {
+			source := candidates[i]
+			for _, target := range candidates[:i] {
+				if target.freeSeats() < len(source.users) {
+					continue
+				}
+
+				if _, err := efm.transfer(ctx, source.instance.Id, target.instance.Id, source.users, true); err != nil {
+					efm.logger.WithField("error", err.Error()).Warn("failed to merge instance %s into %s", source.instance.Id, target.instance.Id)
+					break
+				}
+				efm.logger.Info("Merged instance %s into %s with %d users", source.instance.Id, target.instance.Id, len(source.users))
+				target.users = append(target.users, source.users...)
+				merges++
+				break
+			}
+		}
+	}
+
+	return merges
+}
+
+// stopMergedLobbies stops the merged lobbies whose players had a full merge interval to move to their target.
+func (efm *EdgegapFleetManager) stopMergedLobbies(instances []*runtime.InstanceInfo, interval time.Duration) {
+	for _, instance := range instances {
+		ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+		if err != nil || ei.Persistent || ei.DrainingTo == "" {
+			continue
+		}
+		if len(ei.Connections) == 0 && time.Since(ei.ReservationsUpdatedAt) >= interval {
+			efm.stopDrained(instance.Id)
+		}
+	}
+}
+
+// runMergeScheduler periodically merges under-filled lobbies of the same mode, region and app version to reduce
+// cost. Concurrent merges from several Nakama nodes are safe, a transfer aborts if an instance changed meanwhile.
+func (efm *EdgegapFleetManager) runMergeScheduler() {
+	config := efm.edgegapManager.configuration
+	duration, err := time.ParseDuration(config.MergeInterval)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to parse merge interval, disabling lobby merges")
+		return
+	}
+
+	if duration <= 0 {
+		efm.logger.WithField("duration", duration).Info("Skipping merge scheduler: merge_interval set to 0")
+		return
+	}
+	minAge, _ := time.ParseDuration(config.MergeMinAge)
+
+	mergeFn := func() {
+		instances, err := efm.storageManager.listDbInstancesByStatus(efm.ctx, []string{EdgegapStatusReady})
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to list instances to merge")
+			return
+		}
+
+		efm.stopMergedLobbies(instances, duration)
+		merges := efm.mergeLobbies(efm.ctx, efm.mergeCandidates(instances, config.MergeMaxFill, minAge))
+		if merges > 0 {
+			efm.nk.MetricsCounterAdd("edgegap_lobby_merges", nil, int64(merges))
+		}
+	}
+
+	t := time.NewTicker(duration)
+	defer t.Stop()
+
+	efm.logger.Info("Starting merge scheduler every %s", duration.String())
+	for {
+		select {
+		case <-efm.ctx.Done():
+			return
+		case <-t.C:
+			mergeFn()
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// instanceMigration upgrades the raw edgegap metadata of a stored instance from the previous schema version, before it
+// is decoded as EdgegapInstanceInfo. Migrations work on the raw JSON so records the struct can no longer decode can
+// still be upgraded.
+type instanceMigration struct {
+	version     int
+	description string
+	migrate     func(ei map[string]any) error
+}
+
+// instanceMigrations are applied in order, append new ones with the next version and never edit released ones.
+var instanceMigrations = []instanceMigration{
+	{
+		version:     1,
+		description: "initialize missing reservations, connections, waitlist and priorities",
+		migrate: func(ei map[string]any) error {
+			for _, key := range []string{"reservations", "connections", "waitlist"} {
+				if ei[key] == nil {
+					ei[key] = []any{}
+				}
+			}
+			if ei["reservation_priorities"] == nil {
+				ei["reservation_priorities"] = map[string]any{}
+			}
+			return nil
+		},
+	},
+}
+
+// InstanceSchemaVersion is the schema version of the instances written by this release
+var InstanceSchemaVersion = instanceMigrations[len(instanceMigrations)-1].version
+
+// schemaVersion returns the schema version of the raw edgegap metadata, 0 for records written before versioning.
+func schemaVersion(ei map[string]any) int {
+	version, _ := ei["schema_version"].(float64)
+	return int(version)
+}
+
+// migrateEdgegapInstance applies the pending migrations to the raw edgegap metadata, reporting whether it changed.
+func migrateEdgegapInstance(ei map[string]any) (bool, error) {
+	from := schemaVersion(ei)
+	if from >= InstanceSchemaVersion {
+		return false, nil
+	}
+
+	for _, m := range instanceMigrations {
+		if m.version <= from {
+			continue
+		}
+		if err := m.migrate(ei); err != nil {
+			return false, fmt.Errorf("instance migration %d (%s): %w", m.version, m.description, err)
+		}
+		ei["schema_version"] = m.version
+	}
+	return true, nil
+}
+
+// unmarshalEdgegapInstance decodes the edgegap metadata, migrating it in memory first when written by an older release.
+func unmarshalEdgegapInstance(raw []byte) (*EdgegapInstanceInfo, error) {
+	var ei EdgegapInstanceInfo
+	if err := json.Unmarshal(raw, &ei); err == nil && ei.SchemaVersion >= InstanceSchemaVersion {
+		return &ei, ei.unpack()
+	}
+
+	var document map[string]any
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, err
+	}
+	if _, err := migrateEdgegapInstance(document); err != nil {
+		return nil, err
+	}
+	migrated, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	ei = EdgegapInstanceInfo{}
+	if err = json.Unmarshal(migrated, &ei); err != nil {
+		return nil, err
+	}
+	return &ei, ei.unpack()
+}
+
+// MigrateInstances upgrades the stored instances of every tenant written by older releases to InstanceSchemaVersion, in
+// batches of batchSize. Records are rewritten conditionally on their version, a record updated meanwhile was already
+// written with the current schema and is skipped. Every node runs it at startup, records already migrated are left as is.
+func (sm *StorageManager) MigrateInstances(ctx context.Context, batchSize int) (int, error) {
+	start := time.Now()
+	migrated, skipped := 0, 0
+
+	for _, collections := range sm.allCollections() {
+		cursor := ""
+		for {
+			objects, nextCursor, err := sm.nk.StorageList(ctx, "", "", collections.instances, batchSize, cursor)
+			if err != nil {
+				return migrated, err
+			}
+
+			writes := make([]*runtime.StorageWrite, 0)
+			for _, obj := range objects {
+				write, err := sm.migrationWrite(obj)
+				if err != nil {
+					sm.logger.WithField("error", err.Error()).Error("failed to migrate instance %s, skipping it", obj.Key)
+					skipped++
+					continue
+				}
+				if write != nil {
+					writes = append(writes, write)
+				}
+			}
+
+			// A batch fails as a whole on a single conflict, the records are then written one by one
+			if len(writes) > 0 {
+				if _, err = sm.nk.StorageWrite(ctx, writes); err == nil {
+					migrated += len(writes)
+				} else {
+					for _, write := range writes {
+						if _, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{write}); err != nil {
+							skipped++
+							continue
+						}
+						migrated++
+					}
+				}
+				sm.cache.invalidate(migrationKeys(writes)...)
+			}
+
+			if nextCursor == "" {
+				break
+			}
+			cursor = nextCursor
+		}
+	}
+
+	if migrated > 0 || skipped > 0 {
+		sm.logger.Info("Migrated %d instances to schema version %d in %s, skipped %d", migrated, InstanceSchemaVersion, time.Since(start).String(), skipped)
+	}
+	return migrated, nil
+}
+
+// migrationWrite returns the conditional write upgrading the stored instance, nil when already up to date.
+func (sm *StorageManager) migrationWrite(obj *api.StorageObject) (*runtime.StorageWrite, error) {
+	var document map[string]any
+	if err := json.Unmarshal([]byte(obj.Value), &document); err != nil {
+		return nil, err
+	}
+	metadata, ok := document["metadata"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("instance %s has no metadata", obj.Key)
+	}
+	ei, ok := metadata["edgegap"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("instance %s has no edgegap metadata", obj.Key)
+	}
+
+	changed, err := migrateEdgegapInstance(ei)
+	if err != nil || !changed {
+		return nil, err
+	}
+
+	value, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	return &runtime.StorageWrite{
+		Collection:      obj.Collection,
+		Key:             obj.Key,
+		Value:           string(value),
+		Version:         obj.Version,
+		PermissionRead:  int(obj.PermissionRead),
+		PermissionWrite: int(obj.PermissionWrite),
+	}, nil
+}
+
+func migrationKeys(writes []*runtime.StorageWrite) []string {
+	keys := make([]string, 0, len(writes))
+	for _, write := range writes {
+		keys = append(keys, write.Key)
+	}
+	return keys
+}
@@ -3,6 +3,8 @@ package fleetmanager
 import "time"
 
 type EdgegapInstanceInfo struct {
+	// SchemaVersion is the version of this record layout, older records are upgraded by the instance migrations
+	SchemaVersion         int       `json:"schema_version"`
 	MaxPlayers            int       `json:"max_players"`
 	AvailableSeats        int       `json:"available_seats"`
 	CallbackId            string    `json:"callback_id"`
@@ -10,10 +12,78 @@ type EdgegapInstanceInfo struct {
 	ReservationsCount     int       `json:"reservations_count"`
 	ReservationsUpdatedAt time.Time `json:"reservations_updated_at"`
 	Connections           []string  `json:"connections"`
+	// ReservationPriorities holds the priority of each pending reservation, users absent default to ReservationPriorityNormal
+	ReservationPriorities map[string]int         `json:"reservation_priorities"`
+	Waitlist              []EdgegapWaitlistEntry `json:"waitlist"`
+	Location              *EdgegapLocation       `json:"location,omitempty"`
+	OwnerId               string                 `json:"owner_id,omitempty"`
+	JoinCode              string                 `json:"join_code,omitempty"`
+	MinPlayers            int                    `json:"min_players,omitempty"`
+	PendingExpiresAt      time.Time              `json:"pending_expires_at,omitempty"`
+	// StartingAt is when a deferred start claimed the pending instance, the claim expires after deferredStartTimeout
+	StartingAt          time.Time `json:"starting_at,omitempty"`
+	ReportedPlayerCount int       `json:"reported_player_count"`
+	RequestedAt         time.Time `json:"requested_at"`
+	TimeToReadyMs       int64     `json:"time_to_ready_ms"`
+	Account             string    `json:"account,omitempty"`
+	ExpiresAt           time.Time `json:"expires_at,omitempty"`
+	ExpiryWarned        bool      `json:"expiry_warned,omitempty"`
+	// Persistent instances have unlimited seats, SoftCap only limits the advertised available seats
+	Persistent bool   `json:"persistent,omitempty"`
+	SoftCap    int    `json:"soft_cap,omitempty"`
+	DrainingTo string `json:"draining_to,omitempty"`
+	// Endpoints lists every exposed port with its scheme hint, ConnectionInfo only holds the configured port
+	Endpoints []EdgegapEndpoint `json:"endpoints,omitempty"`
+	// Ipv6 is the public IPv6 address of the deployment when Edgegap provides one, ConnectionInfo only holds IPv4
+	Ipv6 string `json:"ipv6,omitempty"`
+	// Version is the app version the deployment runs, the reservation outcomes are aggregated per version and region
+	Version               string `json:"version,omitempty"`
+	ReservationsConverted int    `json:"reservations_converted"`
+	ReservationsExpired   int    `json:"reservations_expired"`
+	// IdentityHash is the digest of the identity token injected in the deployment, looked up by whoami
+	IdentityHash string `json:"identity_hash,omitempty"`
+	// Capacity is whether the deployment runs on the reserved hosts or on-demand
+	Capacity string `json:"capacity,omitempty"`
+	// ReplacedBy is the instance deployed in place of this crashed instance
+	ReplacedBy string `json:"replaced_by,omitempty"`
+	// SeatHolds holds the pending connect reservations until their hold expires, once the connection info is delivered
+	SeatHolds map[string]time.Time `json:"seat_holds,omitempty"`
+	// SeatHoldExpiresAt is the earliest hold expiry, indexed for the cleanup
+	SeatHoldExpiresAt *time.Time `json:"seat_hold_expires_at,omitempty"`
+	// ConfirmedSeats are the held reservations the players confirmed, kept until their connection or reservation expiry
+	ConfirmedSeats []string `json:"confirmed_seats,omitempty"`
+	// ErrorDetail is the reason the deployment or the game server reported when the instance went into error
+	ErrorDetail string    `json:"error_detail,omitempty"`
+	ErroredAt   time.Time `json:"errored_at,omitempty"`
+	// Filters are the geographic filters the deployment was requested with, kept for auditability
+	Filters []EdgegapDeploymentFilter `json:"filters,omitempty"`
+	// Encoding is the codec of Packed, the user lists compressed in storage, decoded back when the record is read
+	Encoding string `json:"encoding,omitempty"`
+	Packed   string `json:"packed,omitempty"`
+
+	// detached is set on split records read without their companion user lists, they cannot be written
+	detached bool
+	// overshoot holds the reservations evicted over the max players until the instance is written
+	overshoot []string
+}
+
+// Reservation priority levels, higher values can bump lower pending reservations when seats are contested
+const (
+	ReservationPriorityNormal = 0
+	ReservationPriorityHigh   = 10
+	ReservationPriorityVip    = 20
+)
+
+type EdgegapWaitlistEntry struct {
+	UserId   string    `json:"user_id"`
+	Priority int       `json:"priority"`
+	QueuedAt time.Time `json:"queued_at"`
 }
 
 type EdgegapUserData struct {
-	IpAddress string `json:"ip_address"`
+	IpAddress string   `json:"ip_address,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
 }
 
 type EdgegapDeploymentUser struct {
@@ -40,6 +110,9 @@ type EdgegapDeploymentCreation struct {
 	WebhookOnReady       EdgegapWebhook               `json:"webhook_on_ready"`
 	WebhookOnError       EdgegapWebhook               `json:"webhook_on_error"`
 	WebhookOnTerminated  EdgegapWebhook               `json:"webhook_on_terminated"`
+	Filters              []EdgegapDeploymentFilter    `json:"filters,omitempty"`
+	// Arguments override the container arguments of the app version
+	Arguments string `json:"arguments,omitempty"`
 }
 
 type EdgegapDeploymentPort struct {
@@ -50,19 +123,57 @@ type EdgegapDeploymentPort struct {
 	Link     string `json:"link"`
 }
 
+type EdgegapLocation struct {
+	City      string  `json:"city"`
+	Country   string  `json:"country"`
+	Continent string  `json:"continent"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// hasCoordinates reports whether the location was returned by Edgegap, its coordinates are zero otherwise.
+func (l EdgegapLocation) hasCoordinates() bool {
+	return l.Latitude != 0 || l.Longitude != 0
+}
+
+type EdgegapIpLookup struct {
+	IpAddress string          `json:"ip_address"`
+	Location  EdgegapLocation `json:"location"`
+}
+
 type EdgegapDeploymentStatus struct {
 	RequestId     string                           `json:"request_id"`
 	Fqdn          string                           `json:"fqdn"`
 	PublicIp      string                           `json:"public_ip"`
+	PublicIpv6    string                           `json:"public_ipv6,omitempty"`
 	CurrentStatus string                           `json:"current_status"`
 	Running       bool                             `json:"running"`
 	Error         bool                             `json:"error"`
 	ErrorDetail   string                           `json:"error_detail"`
 	Ports         map[string]EdgegapDeploymentPort `json:"ports"`
+	Location      EdgegapLocation                  `json:"location"`
+	MaxDuration   int                              `json:"max_duration,omitempty"`
+	AppVersion    string                           `json:"app_version,omitempty"`
 }
 
 type EdgegapDeploymentResponse struct {
-	RequestId string `json:"request_id"`
+	RequestId    string `json:"request_id"`
+	Account      string `json:"-"`
+	IdentityHash string `json:"-"`
+	Capacity     string `json:"-"`
+	// Filters are the geographic filters the deployment was requested with
+	Filters []EdgegapDeploymentFilter `json:"-"`
+	// MaxDuration is the max duration in minutes set on the deployment, 0 for the one of the app version
+	MaxDuration int `json:"-"`
+}
+
+type EdgegapAppVersion struct {
+	Name        string `json:"name"`
+	MaxDuration int    `json:"max_duration"`
+}
+
+type EdgegapDeploymentDurationUpdate struct {
+	MaxDuration int `json:"max_duration"`
 }
 
 type EdgegapApiMessage struct {
@@ -72,9 +183,12 @@ type EdgegapApiMessage struct {
 const DeploymentStatusError = "Status.ERROR"
 
 type EdgegapDeploymentSummary struct {
-	RequestId string `json:"request_id"`
-	Ready     bool   `json:"ready"`
-	Status    string `json:"status"`
+	RequestId string   `json:"request_id"`
+	Ready     bool     `json:"ready"`
+	Status    string   `json:"status"`
+	Tags      []string `json:"tags,omitempty"`
+	// Account is the account the deployment was listed with
+	Account string `json:"-"`
 }
 
 type EdgegapPagination struct {
@@ -92,6 +206,14 @@ type EdgegapDeploymentList struct {
 type ConnectionEventMessage struct {
 	InstanceId  string   `json:"instance_id"`
 	Connections []string `json:"connections"`
+	// Joined and Left are the delta format, used when Connections is absent
+	Joined []string `json:"joined"`
+	Left   []string `json:"left"`
+}
+
+// isDelta reports whether the event uses the delta format instead of the full connection list
+func (m *ConnectionEventMessage) isDelta() bool {
+	return m.Connections == nil && (m.Joined != nil || m.Left != nil)
 }
 
 const (
@@ -100,6 +222,27 @@ const (
 	InstanceEventStateStop  = "STOP"
 )
 
+type InstanceUpdateMessage struct {
+	InstanceId    string         `json:"instance_id"`
+	InstanceToken string         `json:"instance_token"`
+	PlayerCount   int            `json:"player_count"`
+	Metadata      map[string]any `json:"metadata"`
+}
+
+type ServerPingMessage struct {
+	InstanceId    string `json:"instance_id"`
+	InstanceToken string `json:"instance_token"`
+}
+
+type ServerPingReply struct {
+	Ok           bool     `json:"ok"`
+	InstanceId   string   `json:"instance_id"`
+	Status       string   `json:"status"`
+	MaxPlayers   int      `json:"max_players"`
+	Reservations []string `json:"reservations"`
+	Connections  []string `json:"connections"`
+}
+
 type InstanceEventMessage struct {
 	InstanceId string         `json:"instance_id"`
 	Action     string         `json:"action"`
@@ -0,0 +1,340 @@
+package fleetmanager
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdUpdateNotificationTemplates = "update_notification_templates"
+
+	StorageKeyNotificationTemplates = "edgegap_notification_templates"
+
+	// Templates stored by the RPC are reloaded at most this often, so every node picks them up
+	notificationTemplatesRefresh = time.Minute
+)
+
+// NotificationTemplate renders the subject and the text fields of a notification for one locale.
+// The templates receive the notification content fields (e.g. {{.IpAddress}}) and the {{.Instance}} when known.
+type NotificationTemplate struct {
+	Subject string            `json:"subject"`
+	Content map[string]string `json:"content"`
+}
+
+// NotificationTemplatesConfig holds the templates of each notification subject by locale (e.g. "en", "fr-CA"), and the
+// message of each failure reason by locale.
+type NotificationTemplatesConfig struct {
+	DefaultLocale string                                     `json:"default_locale"`
+	Notifications map[string]map[string]NotificationTemplate `json:"notifications"`
+	Reasons       map[string]map[string]string               `json:"reasons"`
+}
+
+type compiledNotificationTemplate struct {
+	subject *template.Template
+	content map[string]*template.Template
+}
+
+type compiledNotificationTemplates struct {
+	defaultLocale string
+	notifications map[string]map[string]*compiledNotificationTemplate
+	reasons       map[string]map[string]string
+}
+
+// NotificationTemplates localizes notifications with templates from the config file, overridden by the stored ones
+type NotificationTemplates struct {
+	sm     *StorageManager
+	logger runtime.Logger
+	file   *compiledNotificationTemplates
+
+	mu       sync.Mutex
+	stored   *compiledNotificationTemplates
+	loadedAt time.Time
+}
+
+// NewNotificationTemplates loads the optional templates file from the configuration.
+func NewNotificationTemplates(config *EdgegapManagerConfiguration, sm *StorageManager, logger runtime.Logger) (*NotificationTemplates, error) {
+	nt := &NotificationTemplates{
+		sm:     sm,
+		logger: logger,
+	}
+
+	if config.NotificationTemplates == "" {
+		return nt, nil
+	}
+	data, err := os.ReadFile(config.NotificationTemplates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification templates: %w", err)
+	}
+	nt.file, err = parseNotificationTemplates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return nt, nil
+}
+
+// parseNotificationTemplates compiles every template, failing on the first invalid one.
+func parseNotificationTemplates(data []byte) (*compiledNotificationTemplates, error) {
+	var config NotificationTemplatesConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid notification templates: %w", err)
+	}
+
+	compiled := &compiledNotificationTemplates{
+		defaultLocale: strings.ToLower(config.DefaultLocale),
+		notifications: make(map[string]map[string]*compiledNotificationTemplate, len(config.Notifications)),
+		reasons:       make(map[string]map[string]string, len(config.Reasons)),
+	}
+	for subject, locales := range config.Notifications {
+		compiled.notifications[subject] = make(map[string]*compiledNotificationTemplate, len(locales))
+		for locale, tmpl := range locales {
+			name := subject + "/" + locale
+			entry := &compiledNotificationTemplate{content: make(map[string]*template.Template, len(tmpl.Content))}
+			if tmpl.Subject != "" {
+				t, err := template.New(name).Parse(tmpl.Subject)
+				if err != nil {
+					return nil, fmt.Errorf("invalid notification template %s subject: %w", name, err)
+				}
+				entry.subject = t
+			}
+			for field, text := range tmpl.Content {
+				t, err := template.New(name + "/" + field).Parse(text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid notification template %s field %s: %w", name, field, err)
+				}
+				entry.content[field] = t
+			}
+			compiled.notifications[subject][strings.ToLower(locale)] = entry
+		}
+	}
+	for reason, locales := range config.Reasons {
+		compiled.reasons[reason] = make(map[string]string, len(locales))
+		for locale, message := range locales {
+			compiled.reasons[reason][strings.ToLower(locale)] = message
+		}
+	}
+
+	return compiled, nil
+}
+
+// templates returns the stored templates when any, otherwise the ones from the config file.
+func (nt *NotificationTemplates) templates(ctx context.Context) *compiledNotificationTemplates {
+	if nt == nil {
+		return nil
+	}
+
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+	if time.Since(nt.loadedAt) > notificationTemplatesRefresh {
+		nt.loadedAt = time.Now()
+		data, err := nt.sm.ReadNotificationTemplates(ctx)
+		if err != nil {
+			nt.logger.WithField("error", err.Error()).Warn("failed to read stored notification templates")
+		} else if data == "" {
+			nt.stored = nil
+		} else if stored, err := parseNotificationTemplates([]byte(data)); err != nil {
+			nt.logger.WithField("error", err.Error()).Warn("invalid stored notification templates")
+		} else {
+			nt.stored = stored
+		}
+	}
+
+	// Storing empty templates falls back to the config file
+	if nt.stored != nil && (len(nt.stored.notifications) > 0 || len(nt.stored.reasons) > 0) {
+		return nt.stored
+	}
+	return nt.file
+}
+
+// lookup finds the template for the locale, then its base language, then the default locale.
+func (ct *compiledNotificationTemplates) lookup(subject, locale string) *compiledNotificationTemplate {
+	return localized(ct.notifications[subject], locale, ct.defaultLocale)
+}
+
+// reasonMessage returns the message of the failure reason for the locale, empty when none is configured.
+func (ct *compiledNotificationTemplates) reasonMessage(reason, locale string) string {
+	return localized(ct.reasons[reason], locale, ct.defaultLocale)
+}
+
+// localized picks the entry of the locale, then of its base language, then of the default locale.
+func localized[T any](locales map[string]T, locale, defaultLocale string) T {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if entry, ok := locales[locale]; ok {
+		return entry
+	}
+	if base, _, found := strings.Cut(locale, "-"); found {
+		if entry, ok := locales[base]; ok {
+			return entry
+		}
+	}
+	return locales[defaultLocale]
+}
+
+// render applies the template of the locale to the notification, keeping the subject and content when none matches.
+func (ct *compiledNotificationTemplates) render(subject, locale string, instance *runtime.InstanceInfo, content map[string]interface{}) (string, map[string]interface{}, error) {
+	tmpl := ct.lookup(subject, locale)
+	if tmpl == nil {
+		return subject, content, nil
+	}
+
+	data := make(map[string]interface{}, len(content)+1)
+	for k, v := range content {
+		data[k] = v
+	}
+	if instance != nil {
+		data["Instance"] = instance
+	}
+
+	rendered := make(map[string]interface{}, len(content)+len(tmpl.content))
+	for k, v := range content {
+		rendered[k] = v
+	}
+	for field, t := range tmpl.content {
+		var text bytes.Buffer
+		if err := t.Execute(&text, data); err != nil {
+			return subject, content, err
+		}
+		rendered[field] = text.String()
+	}
+
+	renderedSubject := subject
+	if tmpl.subject != nil {
+		var text bytes.Buffer
+		if err := tmpl.subject.Execute(&text, data); err != nil {
+			return subject, content, err
+		}
+		renderedSubject = text.String()
+	}
+
+	return renderedSubject, rendered, nil
+}
+
+// sendNotifications sends a notification to each user, localized with the templates of the user's account locale.
+func sendNotifications(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, instance *runtime.InstanceInfo, subject string, code int, userIds []string, content func(userId string) map[string]interface{}) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+
+	var templates *compiledNotificationTemplates
+	casing := PayloadCasingDefault
+	if fmInstance != nil {
+		templates = fmInstance.edgegapManager.notifications.templates(ctx)
+		casing = fmInstance.edgegapManager.configuration.PayloadCasing
+	}
+
+	// Locales are only read when the subject is templated or a failure reason is localized
+	var locales map[string]string
+	locale := func(userId string) string {
+		if locales == nil {
+			locales = make(map[string]string, len(userIds))
+			users, err := nk.UsersGetId(ctx, userIds, nil)
+			if err != nil {
+				logger.WithField("error", err.Error()).Warn("failed to read users locale for notifications")
+			}
+			for _, user := range users {
+				locales[user.GetId()] = user.GetLangTag()
+			}
+		}
+		return locales[userId]
+	}
+
+	notifications := make([]*runtime.NotificationSend, 0, len(userIds))
+	for _, userId := range userIds {
+		userSubject, userContent := subject, content(userId)
+		if reason, ok := userContent["Reason"].(string); ok && templates != nil && templates.reasons[reason] != nil {
+			if message := templates.reasonMessage(reason, locale(userId)); message != "" {
+				userContent["Message"] = message
+			}
+		}
+		if templates != nil && templates.notifications[subject] != nil {
+			var err error
+			userSubject, userContent, err = templates.render(subject, locale(userId), instance, userContent)
+			if err != nil {
+				logger.WithField("error", err.Error()).Warn("failed to render notification template %s", subject)
+			}
+		}
+		notifications = append(notifications, &runtime.NotificationSend{
+			UserID:     userId,
+			Subject:    userSubject,
+			Content:    recaseNotificationContent(casing, userContent),
+			Code:       code,
+			Persistent: false,
+		})
+	}
+
+	return nk.NotificationsSend(ctx, notifications)
+}
+
+// WriteNotificationTemplates stores the notification templates, overriding the config file
+func (sm *StorageManager) WriteNotificationTemplates(ctx context.Context, templates string) error {
+	_, err := sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      StorageCollectionEdgegapVersion,
+			Key:             StorageKeyNotificationTemplates,
+			Value:           templates,
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		},
+	})
+	return err
+}
+
+// ReadNotificationTemplates retrieves the stored notification templates, empty when none are stored
+func (sm *StorageManager) ReadNotificationTemplates(ctx context.Context) (string, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: StorageCollectionEdgegapVersion,
+			Key:        StorageKeyNotificationTemplates,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(objects) == 0 {
+		return "", nil
+	}
+	return objects[0].Value, nil
+}
+
+// UpdateNotificationTemplates validates and stores the notification templates (S2S only)
+func (nt *NotificationTemplates) UpdateNotificationTemplates(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdUpdateNotificationTemplates); err != nil {
+		return "", err
+	}
+
+	compiled, err := parseNotificationTemplates([]byte(payload))
+	if err != nil {
+		return "", runtime.NewError(err.Error(), 3) // INVALID_ARGUMENT
+	}
+
+	if err = nt.sm.WriteNotificationTemplates(ctx, payload); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to store notification templates")
+		return "", ErrInternalError
+	}
+
+	nt.mu.Lock()
+	nt.stored = compiled
+	nt.loadedAt = time.Now()
+	nt.mu.Unlock()
+
+	replyString, err := json.Marshal(map[string]any{
+		"success":  true,
+		"subjects": len(compiled.notifications),
+		"reasons":  len(compiled.reasons),
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
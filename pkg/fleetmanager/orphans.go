@@ -0,0 +1,116 @@
+package fleetmanager
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+// orphanGracePeriod is how long a tagged deployment without an instance record is left running before it is stopped,
+// its record can be written moments after the deployment is created
+const orphanGracePeriod = 2 * time.Minute
+
+// deploymentTagName matches the deployment tags, e.g. nakama-prod-eu
+var deploymentTagName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// orphanTracker remembers when each deployment without an instance record was first seen by the reconciliation
+type orphanTracker struct {
+	firstSeen map[string]time.Time
+}
+
+func newOrphanTracker() *orphanTracker {
+	return &orphanTracker{firstSeen: make(map[string]time.Time)}
+}
+
+// expired returns the candidates seen without a record for longer than the grace period, forgetting the deployments
+// that are no longer candidates.
+func (t *orphanTracker) expired(candidates []string, now time.Time) []string {
+	seen := make(map[string]time.Time, len(candidates))
+	expired := make([]string, 0)
+	for _, id := range candidates {
+		first, ok := t.firstSeen[id]
+		if !ok {
+			first = now
+		}
+		seen[id] = first
+		if now.Sub(first) >= orphanGracePeriod {
+			expired = append(expired, id)
+		}
+	}
+	t.firstSeen = seen
+	return expired
+}
+
+// accountApiHelper returns the API client of the named account, the primary if unknown.
+func (em *EdgegapManager) accountApiHelper(name string) *helpers.APIClient {
+	for _, account := range em.accounts {
+		if account.name == name {
+			return account.apiHelper
+		}
+	}
+	return em.apiHelper
+}
+
+// stopOrphanedDeployment stops a deployment created without its instance record being stored, so it is not billed
+// while no player can ever join it.
+func (efm *EdgegapFleetManager) stopOrphanedDeployment(deployment *EdgegapDeploymentResponse, storageErr error) {
+	logger := efm.logger.WithFields(map[string]any{"request_id": deployment.RequestId, "account": deployment.Account, "error": storageErr.Error()})
+	if _, err := efm.edgegapManager.stopDeployment(efm.edgegapManager.accountApiHelper(deployment.Account), deployment.RequestId); err != nil {
+		logger.WithField("stop_error", err.Error()).Error("failed to stop the deployment of an instance that could not be stored, the reconciliation will retry if tagged")
+		return
+	}
+	logger.Warn("Stopped the deployment of an instance that could not be stored")
+	efm.nk.MetricsCounterAdd("edgegap_orphans_stopped", map[string]string{"source": "create"}, 1)
+}
+
+// stopOrphanedDeployments stops the deployments tagged with EDGEGAP_DEPLOYMENT_TAG that have had no instance record
+// for the grace period, e.g. created by a node that crashed before storing it.
+func (efm *EdgegapFleetManager) stopOrphanedDeployments(deployments []EdgegapDeploymentSummary) {
+	tag := efm.edgegapManager.configuration.DeploymentTag
+	if tag == "" {
+		return
+	}
+
+	accounts := make(map[string]string)
+	candidates := make([]string, 0)
+	for _, deployment := range deployments {
+		if deployment.Status != DeploymentStatusError && slices.Contains(deployment.Tags, tag) {
+			accounts[deployment.RequestId] = deployment.Account
+			candidates = append(candidates, deployment.RequestId)
+		}
+	}
+
+	// Deployments are matched against every stored record, whatever its status
+	orphaned := make([]string, 0)
+	for batch := range slices.Chunk(candidates, 100) {
+		stored, _, err := efm.storageManager.readDbInstancesForUpdate(efm.ctx, batch...)
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to read the instances of tagged deployments")
+			return
+		}
+		for _, id := range batch {
+			if _, ok := stored[id]; !ok {
+				orphaned = append(orphaned, id)
+			}
+		}
+	}
+
+	stopped := make([]string, 0)
+	for _, id := range efm.orphans.expired(orphaned, time.Now()) {
+		if _, err := efm.edgegapManager.stopDeployment(efm.edgegapManager.accountApiHelper(accounts[id]), id); err != nil && !errors.Is(err, ErrorDeploymentNotFound) {
+			efm.logger.WithFields(map[string]any{"request_id": id, "error": err.Error()}).Error("failed to stop orphaned deployment")
+			continue
+		}
+		stopped = append(stopped, id)
+	}
+	if len(stopped) == 0 {
+		return
+	}
+
+	efm.logger.Warn("Stopped %d deployments without an instance record: %s", len(stopped), strings.Join(stopped, ", "))
+	efm.nk.MetricsCounterAdd("edgegap_orphans_stopped", map[string]string{"source": "reconcile"}, int64(len(stopped)))
+}
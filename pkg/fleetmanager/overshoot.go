@@ -0,0 +1,74 @@
+package fleetmanager
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// evictOvershoot removes the most recent reservations exceeding the max players, e.g. left by concurrent joins
+// overwriting each other. Held seats are kept, their players are already connecting. The evicted users are kept until
+// the instance is written, for compensateOvershoot to notify them.
+func (ei *EdgegapInstanceInfo) evictOvershoot(playerCount int) []string {
+	if ei.MaxPlayers < 0 || ei.Persistent {
+		return nil
+	}
+	excess := playerCount + len(ei.Reservations) - ei.MaxPlayers
+	if excess <= 0 {
+		return nil
+	}
+
+	evicted := make([]string, 0, excess)
+	for _, userId := range slices.Backward(ei.Reservations) {
+		if len(evicted) == excess {
+			break
+		}
+		if !ei.isSeatHeld(userId) {
+			evicted = append(evicted, userId)
+		}
+	}
+	if len(evicted) == 0 {
+		return nil
+	}
+
+	ei.Reservations = slices.DeleteFunc(ei.Reservations, func(userId string) bool {
+		return slices.Contains(evicted, userId)
+	})
+	for _, userId := range evicted {
+		delete(ei.ReservationPriorities, userId)
+	}
+	ei.ReservationsUpdatedAt = time.Now().UTC()
+	ei.overshoot = append(ei.overshoot, evicted...)
+	return evicted
+}
+
+// syncOvershoot evicts the reservations of the instance exceeding its max players before it is written.
+func (sm *StorageManager) syncOvershoot(instance *runtime.InstanceInfo) {
+	ei, err := sm.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return
+	}
+	playerCount := max(len(ei.Connections), ei.ReportedPlayerCount)
+	if evicted := ei.evictOvershoot(playerCount); len(evicted) > 0 {
+		instance.Metadata["edgegap"] = ei
+		sm.logger.Warn("Instance %s overshot its %d max players, evicted %d reservations", instance.Id, ei.MaxPlayers, len(evicted))
+	}
+}
+
+// compensateOvershoot notifies the users whose reservation was evicted from the written instances with a seat-lost
+// notification, and counts them in the edgegap_reservation_overshoot metric.
+func (sm *StorageManager) compensateOvershoot(ctx context.Context, instances ...*runtime.InstanceInfo) {
+	for _, instance := range instances {
+		ei, err := sm.ExtractEdgegapInstance(instance)
+		if err != nil || len(ei.overshoot) == 0 {
+			continue
+		}
+		evicted := ei.overshoot
+		ei.overshoot = nil
+
+		sm.nk.MetricsCounterAdd("edgegap_reservation_overshoot", nil, int64(len(evicted)))
+		notifySeatLost(ctx, sm.logger, sm.nk, instance.Id, evicted)
+	}
+}
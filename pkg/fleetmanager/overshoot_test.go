@@ -0,0 +1,91 @@
+package fleetmanager
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEvictOvershoot(t *testing.T) {
+	tests := []struct {
+		name          string
+		ei            EdgegapInstanceInfo
+		playerCount   int
+		wantEvicted   []string
+		wantRemaining []string
+	}{
+		{
+			name:          "within max players",
+			ei:            EdgegapInstanceInfo{MaxPlayers: 4, Reservations: []string{"a", "b"}},
+			playerCount:   2,
+			wantRemaining: []string{"a", "b"},
+		},
+		{
+			name:          "most recent reservations evicted first",
+			ei:            EdgegapInstanceInfo{MaxPlayers: 3, Reservations: []string{"a", "b", "c", "d"}},
+			playerCount:   1,
+			wantEvicted:   []string{"d", "c"},
+			wantRemaining: []string{"a", "b"},
+		},
+		{
+			name: "held seats kept",
+			ei: EdgegapInstanceInfo{
+				MaxPlayers:     2,
+				Reservations:   []string{"a", "b", "c"},
+				SeatHolds:      map[string]time.Time{"c": time.Now()},
+				ConfirmedSeats: []string{"b"},
+			},
+			playerCount:   1,
+			wantEvicted:   []string{"a"},
+			wantRemaining: []string{"b", "c"},
+		},
+		{
+			name: "every seat held",
+			ei: EdgegapInstanceInfo{
+				MaxPlayers:     1,
+				Reservations:   []string{"a", "b"},
+				ConfirmedSeats: []string{"a", "b"},
+			},
+			playerCount:   1,
+			wantRemaining: []string{"a", "b"},
+		},
+		{
+			name:          "unlimited max players",
+			ei:            EdgegapInstanceInfo{MaxPlayers: -1, Reservations: []string{"a", "b"}},
+			playerCount:   10,
+			wantRemaining: []string{"a", "b"},
+		},
+		{
+			name:          "persistent",
+			ei:            EdgegapInstanceInfo{MaxPlayers: 1, Persistent: true, Reservations: []string{"a", "b"}},
+			playerCount:   1,
+			wantRemaining: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ei := tt.ei
+			ei.ReservationPriorities = make(map[string]int)
+			for _, userId := range ei.Reservations {
+				ei.ReservationPriorities[userId] = ReservationPriorityNormal
+			}
+
+			evicted := ei.evictOvershoot(tt.playerCount)
+			if !slices.Equal(evicted, tt.wantEvicted) {
+				t.Errorf("evictOvershoot() = %v, want %v", evicted, tt.wantEvicted)
+			}
+			if !slices.Equal(ei.Reservations, tt.wantRemaining) {
+				t.Errorf("reservations = %v, want %v", ei.Reservations, tt.wantRemaining)
+			}
+			if !slices.Equal(ei.overshoot, tt.wantEvicted) {
+				t.Errorf("overshoot = %v, want %v", ei.overshoot, tt.wantEvicted)
+			}
+			for _, userId := range tt.wantEvicted {
+				if _, ok := ei.ReservationPriorities[userId]; ok {
+					t.Errorf("priority of evicted user %s kept", userId)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdAdminPersistentCreate  = "admin_persistent_create"
+	RpcIdAdminPersistentMigrate = "admin_persistent_migrate"
+
+	// CreateMetadataPersistentKey marks a Create as a persistent, always-on world server
+	CreateMetadataPersistentKey = "persistent"
+
+	// Game server callback event sent when a persistent instance must drain its players to its replacement
+	GameServerEventDrain = "drain"
+)
+
+// ErrorPersistentAdminOnly is returned by Create when a persistent instance is requested outside of the admin RPCs
+var ErrorPersistentAdminOnly = errors.New("persistent instances can only be created through admin rpcs")
+
+// ErrorInstanceNotPersistent is returned when migrating an instance that is not persistent
+var ErrorInstanceNotPersistent = errors.New("instance is not persistent")
+
+// ErrorDrainingLoop is returned by Join when the replacements of a draining instance loop or chain too deep
+var ErrorDrainingLoop = errors.New("draining instance replacements loop")
+
+// maxDrainingHops bounds the replacements a Join follows from a draining instance
+const maxDrainingHops = 5
+
+// persistentCreateKey marks the context of admin RPCs allowed to create persistent instances
+type persistentCreateKey struct{}
+
+type adminPersistentCreateRequest struct {
+	SoftCap  int            `json:"soft_cap"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type adminPersistentMigrateRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+// isPersistentCreate reports whether the Create metadata requests a persistent instance
+func isPersistentCreate(metadata map[string]any) bool {
+	switch v := metadata[CreateMetadataPersistentKey].(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+// checkPersistentCreate rejects persistent instances requested outside of the admin RPCs.
+func checkPersistentCreate(ctx context.Context, metadata map[string]any) error {
+	if !isPersistentCreate(metadata) {
+		return nil
+	}
+	if allowed, _ := ctx.Value(persistentCreateKey{}).(bool); !allowed {
+		return ErrorPersistentAdminOnly
+	}
+	if isDeferredCreate(metadata) {
+		return errors.New("persistent instances cannot use deferred start")
+	}
+	return nil
+}
+
+// makePersistent turns the instance into a persistent one: unlimited seats, with max players as a soft cap.
+func (ei *EdgegapInstanceInfo) makePersistent() {
+	ei.Persistent = true
+	ei.SoftCap = max(ei.MaxPlayers, 0)
+	ei.MaxPlayers = -1
+}
+
+// persistentMetadata copies the metadata of a persistent instance for its replacement,
+// without the fleet manager and game server specific keys.
+func persistentMetadata(instance *runtime.InstanceInfo) map[string]any {
+	metadata := make(map[string]any, len(instance.Metadata))
+	for k, v := range instance.Metadata {
+		switch k {
+		case "edgegap", InstanceMetadataCallbackUrl, InstanceMetadataHeartbeatUrl, UpdateMetadataInstanceTokenKey:
+			continue
+		}
+		metadata[k] = v
+	}
+	metadata[CreateMetadataPersistentKey] = true
+	return metadata
+}
+
+// MigratePersistent deploys a replacement of a persistent instance on the current version and drains the instance
+// into it: once the replacement is ready, joins are redirected to it, the game server is told to move its players
+// and the deployment is stopped when its last player left.
+func (efm *EdgegapFleetManager) MigratePersistent(ctx context.Context, id string) (string, error) {
+	instance, err := efm.storageManager.getDbInstance(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if instance == nil {
+		return "", ErrorDeploymentNotFound
+	}
+
+	ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return "", err
+	}
+	if !ei.Persistent {
+		return "", ErrorInstanceNotPersistent
+	}
+	if ei.DrainingTo != "" {
+		return ei.DrainingTo, nil
+	}
+
+	var callback runtime.FmCreateCallbackFn = func(status runtime.FmCreateStatus, instanceInfo *runtime.InstanceInfo, sessionInfo []*runtime.SessionInfo, metadata map[string]any, createErr error) {
+		if status != runtime.CreateSuccess {
+			efm.logger.WithField("error", createErr).Error("failed to deploy the replacement of persistent instance %s", id)
+			return
+		}
+		if err := efm.drainPersistent(efm.ctx, id, instanceInfo); err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to drain persistent instance %s", id)
+		}
+	}
+
+	result, err := efm.Create(context.WithValue(ctx, persistentCreateKey{}, true), ei.SoftCap, nil, nil, persistentMetadata(instance), callback)
+	if err != nil {
+		return "", err
+	}
+
+	efm.logger.Info("Migrating persistent instance %s to %s", id, result[DeploymentIdKey])
+	return result[DeploymentIdKey], nil
+}
+
+// drainPersistent redirects the joins of a persistent instance to its ready replacement and tells its game server.
+func (efm *EdgegapFleetManager) drainPersistent(ctx context.Context, id string, replacement *runtime.InstanceInfo) error {
+	efm.storageManager.InvalidateInstance(id)
+	instance, err := efm.storageManager.getDbInstance(ctx, id)
+	if err != nil || instance == nil {
+		return err
+	}
+
+	ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return err
+	}
+	ei.DrainingTo = replacement.Id
+	instance.Metadata["edgegap"] = ei
+	if err = efm.storageManager.updateDbInstance(ctx, instance); err != nil {
+		return err
+	}
+
+	notifyGameServer(efm.logger, instance, GameServerEventDrain, map[string]any{
+		"instance_id":     replacement.Id,
+		"connection_info": replacement.ConnectionInfo,
+	})
+
+	// Nobody to move, the instance can stop right away
+	if len(ei.Connections) == 0 {
+		efm.stopDrained(id)
+	}
+
+	return nil
+}
+
+// stopDrained stops the deployment of a drained instance, a migrated persistent instance or a merged lobby.
+func (efm *EdgegapFleetManager) stopDrained(id string) {
+	efm.logger.Info("Instance %s drained, stopping its deployment", id)
+	if _, err := efm.edgegapManager.StopDeployment(id); err != nil && !errors.Is(err, ErrorDeploymentNotFound) {
+		efm.logger.WithField("error", err.Error()).Error("failed to stop drained instance %s", id)
+	}
+}
+
+// migratePersistentInstances migrates every ready persistent instance, after the Edgegap version changed.
+func (efm *EdgegapFleetManager) migratePersistentInstances(ctx context.Context) {
+	instances, err := efm.storageManager.listDbInstancesByStatus(ctx, []string{EdgegapStatusReady})
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to list persistent instances to migrate")
+		return
+	}
+
+	for _, instance := range instances {
+		ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+		if err != nil || !ei.Persistent || ei.DrainingTo != "" {
+			continue
+		}
+		if _, err = efm.MigratePersistent(ctx, instance.Id); err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to migrate persistent instance %s", instance.Id)
+		}
+	}
+}
+
+// adminCreatePersistent admin rpc to create a persistent world server (S2S only)
+func adminCreatePersistent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdAdminPersistentCreate); err != nil {
+		return "", err
+	}
+
+	var req *adminPersistentCreateRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return "", ErrInvalidInput
+	}
+	if req.Metadata == nil {
+		req.Metadata = make(map[string]any)
+	}
+	req.Metadata[CreateMetadataPersistentKey] = true
+
+	var callback runtime.FmCreateCallbackFn = func(status runtime.FmCreateStatus, instanceInfo *runtime.InstanceInfo, sessionInfo []*runtime.SessionInfo, metadata map[string]any, createErr error) {
+		if status == runtime.CreateSuccess {
+			logger.Info("Persistent instance ready: %s", instanceInfo.Id)
+			return
+		}
+		logger.WithField("error", createErr).Error("Failed to create persistent instance")
+	}
+
+	result, err := fmInstance.Create(context.WithValue(ctx, persistentCreateKey{}, true), req.SoftCap, nil, nil, req.Metadata, callback)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to create persistent instance")
+		return "", ErrInternalError
+	}
+
+	replyString, err := json.Marshal(map[string]any{
+		"success":     true,
+		"instance_id": result[DeploymentIdKey],
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
+
+// adminMigratePersistent admin rpc to drain a persistent instance into a replacement on the current version (S2S only)
+func adminMigratePersistent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdAdminPersistentMigrate); err != nil {
+		return "", err
+	}
+
+	var req *adminPersistentMigrateRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.InstanceID == "" {
+		return "", ErrInvalidInput
+	}
+
+	replacementId, err := fmInstance.MigratePersistent(ctx, req.InstanceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrorInstanceNotPersistent):
+			return "", runtime.NewError(err.Error(), 9) // FAILED_PRECONDITION
+		case errors.Is(err, ErrorDeploymentNotFound):
+			return "", runtime.NewError(err.Error(), 5) // NOT_FOUND
+		}
+		logger.WithField("error", err.Error()).Error("failed to migrate persistent instance %s", req.InstanceID)
+		return "", ErrInternalError
+	}
+
+	replyString, err := json.Marshal(map[string]any{
+		"success":        true,
+		"instance_id":    req.InstanceID,
+		"replacement_id": replacementId,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
@@ -0,0 +1,132 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Create metadata keys for explicit deployment placement
+const (
+	// CreateMetadataSkipCallerIpKey disables the caller IP fallback, for callers running from a datacenter
+	CreateMetadataSkipCallerIpKey = "skip_caller_ip"
+	// CreateMetadataLocationsKey holds geo coordinates used for placement in addition to the users IPs
+	CreateMetadataLocationsKey = "locations"
+)
+
+// ErrorPlacementRequired is returned by Create when the caller IP fallback is skipped and no user IP or location is available
+var ErrorPlacementRequired = errors.New("skip_caller_ip requires user ids with known IPs or explicit locations")
+
+// ErrorInvalidLocations is returned by Create when the explicit locations are not valid geo coordinates
+var ErrorInvalidLocations = errors.New("locations must be a list of valid latitude and longitude")
+
+// EdgegapGeoCoordinates is an explicit placement location for a deployment.
+type EdgegapGeoCoordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// deploymentPlacement is where a deployment is placed: the users IPs, the beacon locations of the users with measured
+// latencies, and the region filters of their preferences. Explicit locations are read from the Create metadata.
+type deploymentPlacement struct {
+	Ips       []string
+	Locations []EdgegapGeoCoordinates
+	Filters   []EdgegapDeploymentFilter
+}
+
+// skipCallerIp reports whether the Create metadata disables the caller IP fallback
+func skipCallerIp(metadata map[string]any) bool {
+	switch v := metadata[CreateMetadataSkipCallerIpKey].(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+// parseLocations reads the explicit placement locations from the Create metadata, either decoded
+// from JSON or set by server code as EdgegapGeoCoordinates.
+func parseLocations(metadata map[string]any) ([]EdgegapGeoCoordinates, error) {
+	raw, ok := metadata[CreateMetadataLocationsKey]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	locations, ok := raw.([]EdgegapGeoCoordinates)
+	if !ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, ErrorInvalidLocations
+		}
+		if err = json.Unmarshal(data, &locations); err != nil {
+			return nil, ErrorInvalidLocations
+		}
+	}
+	for _, location := range locations {
+		if location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+			return nil, ErrorInvalidLocations
+		}
+	}
+	return locations, nil
+}
+
+// checkPlacement fails fast when the caller IP fallback is skipped without user ids or locations to place the deployment.
+func checkPlacement(metadata map[string]any, userIds []string) error {
+	locations, err := parseLocations(metadata)
+	if err != nil {
+		return err
+	}
+	if skipCallerIp(metadata) && len(userIds) == 0 && len(locations) == 0 {
+		return ErrorPlacementRequired
+	}
+	return nil
+}
+
+// placement returns where to place the deployment of the users: users with measured beacon latencies are placed on
+// their closest beacon, the others on their IP.
+func (efm *EdgegapFleetManager) placement(ctx context.Context, userIds []string, metadata map[string]any) (*deploymentPlacement, error) {
+	locations, unmeasured := efm.beaconLocations(ctx, userIds)
+	ips, err := efm.placementIps(ctx, unmeasured, metadata, len(locations) > 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deploymentPlacement{
+		Ips:       ips,
+		Locations: locations,
+		Filters:   efm.placementFilters(ctx, userIds),
+	}, nil
+}
+
+// placementIps returns the IPs used to place a deployment: the users IPs, or the caller IP when neither users IPs,
+// beacon locations nor explicit locations are available, unless the Create metadata skips the caller IP fallback.
+func (efm *EdgegapFleetManager) placementIps(ctx context.Context, userIds []string, metadata map[string]any, located bool) ([]string, error) {
+	userIps, missing, err := efm.storageManager.getUserIPs(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		efm.logger.WithField("missing_ips", len(missing)).Warn("Placing deployment without the IP of %d of %d users", len(missing), len(userIds))
+		notifyReportIp(ctx, efm.logger, efm.nk, missing)
+	}
+	if len(userIps) > 0 || located {
+		return userIps, nil
+	}
+
+	if locations, _ := parseLocations(metadata); len(locations) > 0 {
+		return nil, nil
+	}
+	if skipCallerIp(metadata) {
+		return nil, ErrorPlacementRequired
+	}
+
+	callerIP, ok := ctx.Value(runtime.RUNTIME_CTX_CLIENT_IP).(string)
+	if !ok {
+		efm.logger.Error("failed to extract client IP from context")
+		return nil, ErrInternalError
+	}
+	return []string{callerIP}, nil
+}
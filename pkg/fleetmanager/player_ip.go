@@ -0,0 +1,121 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// PlayerIpMetadataKey is the account metadata key where the player IP was stored before the players collection,
+	// still read for the users who did not authenticate since
+	PlayerIpMetadataKey = "PlayerIp"
+
+	// StorageKeyPlayerIp is the key of the player IP of each user in the players collection
+	StorageKeyPlayerIp = "ip"
+
+	// playerIpSealedPrefix marks the player IPs encrypted at rest
+	playerIpSealedPrefix = "enc:"
+)
+
+// Sources of the player IPs used for placement, the source tag of the edgegap_placement_ip metric
+const (
+	PlayerIpSourceStored  = "stored"
+	PlayerIpSourceLegacy  = "legacy"
+	PlayerIpSourceMissing = "missing"
+	PlayerIpSourceInvalid = "invalid"
+)
+
+// ErrorPlayerIpKeyMissing is returned when an encrypted player IP is read without NAKAMA_PLAYER_IP_KEY
+var ErrorPlayerIpKeyMissing = errors.New("player ip is encrypted but NAKAMA_PLAYER_IP_KEY is not set")
+
+// EdgegapPlayerIpRecord is the last IP of a user, used for server placement
+type EdgegapPlayerIpRecord struct {
+	Ip        string    `json:"ip"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// playersCollection resolves the players collection from the runtime environment, authentication hooks run without
+// the fleet manager.
+func playersCollection(ctx context.Context) string {
+	env, _ := ctx.Value(runtime.RUNTIME_CTX_ENV).(map[string]string)
+	prefix := strings.TrimSpace(env["NAKAMA_STORAGE_PREFIX"])
+	if prefix == "" {
+		prefix = StorageEdgegapPrefix
+	}
+	return prefix + "_players"
+}
+
+// writePlayerIp stores the IP in the user's own storage object rather than the account metadata, so other systems
+// writing the metadata never race with authentications. The write is conditional on the version read, and retried
+// when a concurrent authentication of the user replaced it meanwhile.
+func writePlayerIp(ctx context.Context, nk runtime.NakamaModule, collection, userId, ip string) error {
+	value, err := json.Marshal(&EdgegapPlayerIpRecord{Ip: ip, UpdatedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		objects, readErr := nk.StorageRead(ctx, []*runtime.StorageRead{{
+			Collection: collection,
+			Key:        StorageKeyPlayerIp,
+			UserID:     userId,
+		}})
+		if readErr != nil {
+			return readErr
+		}
+
+		version := "*"
+		if len(objects) > 0 {
+			version = objects[0].Version
+		}
+
+		if _, err = nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+			Collection:      collection,
+			Key:             StorageKeyPlayerIp,
+			UserID:          userId,
+			Value:           string(value),
+			Version:         version,
+			PermissionRead:  0, // No read from clients
+			PermissionWrite: 0, // No write from clients
+		}}); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// playerIpKey reads the key encrypting the player IPs from the runtime environment, empty when disabled.
+func playerIpKey(ctx context.Context) string {
+	env, _ := ctx.Value(runtime.RUNTIME_CTX_ENV).(map[string]string)
+	return env["NAKAMA_PLAYER_IP_KEY"]
+}
+
+// sealPlayerIp encrypts the player IP with the key, it is stored as is without a key.
+func sealPlayerIp(key, ip string) (string, error) {
+	if key == "" || ip == "" {
+		return ip, nil
+	}
+	sealed, err := helpers.EncryptString(key, ip)
+	if err != nil {
+		return "", err
+	}
+	return playerIpSealedPrefix + sealed, nil
+}
+
+// openPlayerIp decrypts a sealed player IP, IPs stored before encryption was enabled are returned as is.
+func openPlayerIp(key, value string) (string, error) {
+	sealed, ok := strings.CutPrefix(value, playerIpSealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if key == "" {
+		return "", ErrorPlayerIpKeyMissing
+	}
+	return helpers.DecryptString(key, sealed)
+}
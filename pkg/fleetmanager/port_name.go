@@ -0,0 +1,18 @@
+package fleetmanager
+
+import (
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// CreateMetadataPortNameKey is the create metadata key overriding EDGEGAP_PORT_NAME for the instance, for app versions
+// or modes exposing their game port under another name
+const CreateMetadataPortNameKey = "port_name"
+
+// instancePortName returns the port the connection info is read from, from the create metadata of the instance,
+// falling back to the configuration.
+func instancePortName(config *EdgegapManagerConfiguration, instance *runtime.InstanceInfo) (string, bool) {
+	if name, ok := instance.Metadata[CreateMetadataPortNameKey].(string); ok && name != "" {
+		return name, true
+	}
+	return config.PortName, false
+}
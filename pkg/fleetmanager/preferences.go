@@ -0,0 +1,221 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdPlacementPreferencesSet = "placement_preferences_set"
+	RpcIdPlacementPreferencesGet = "placement_preferences_get"
+
+	// StorageKeyPlacementPreferences is the key of the placement preferences of each user in the players collection
+	StorageKeyPlacementPreferences = "placement_preferences"
+
+	maxPreferredRegions = 10
+	maxPreferredPing    = 1_000
+)
+
+// EdgegapPlacementPreferences are the placement settings of a user: the regions (continents) their instances are
+// deployed in, and the max estimated ping of the instances listed to them.
+type EdgegapPlacementPreferences struct {
+	Regions   []string  `json:"regions"`
+	MaxPing   int       `json:"max_ping"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// valid reports whether the preferences are within bounds, empty preferences clear them.
+func (p *EdgegapPlacementPreferences) valid() bool {
+	if len(p.Regions) > maxPreferredRegions || p.MaxPing < 0 || p.MaxPing > maxPreferredPing {
+		return false
+	}
+	for i, region := range p.Regions {
+		if p.Regions[i] = strings.TrimSpace(region); p.Regions[i] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// getPlacementPreferences reads the placement preferences of the users, users without preferences are omitted.
+func (sm *StorageManager) getPlacementPreferences(ctx context.Context, userIds []string) (map[string]*EdgegapPlacementPreferences, error) {
+	preferences := make(map[string]*EdgegapPlacementPreferences, len(userIds))
+	if len(userIds) == 0 {
+		return preferences, nil
+	}
+
+	reads := make([]*runtime.StorageRead, 0, len(userIds))
+	for _, userId := range userIds {
+		reads = append(reads, &runtime.StorageRead{
+			Collection: sm.playersCollection,
+			Key:        StorageKeyPlacementPreferences,
+			UserID:     userId,
+		})
+	}
+	objects, err := sm.nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range objects {
+		var p EdgegapPlacementPreferences
+		if err = json.Unmarshal([]byte(obj.Value), &p); err != nil {
+			sm.logger.WithField("error", err.Error()).Warn("failed to parse placement preferences of user %s", obj.UserId)
+			continue
+		}
+		preferences[obj.UserId] = &p
+	}
+	return preferences, nil
+}
+
+// setPlacementPreferences stores the placement preferences of the user.
+func (sm *StorageManager) setPlacementPreferences(ctx context.Context, userId string, preferences *EdgegapPlacementPreferences) error {
+	value, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      sm.playersCollection,
+		Key:             StorageKeyPlacementPreferences,
+		UserID:          userId,
+		Value:           string(value),
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}})
+	return err
+}
+
+// intersectRegions returns the regions preferred by every user having preferred regions, users without any accept
+// all regions. It reports false when no user has preferred regions or the preferences conflict.
+func intersectRegions(preferences map[string]*EdgegapPlacementPreferences) ([]string, bool) {
+	var regions []string
+	constrained := false
+	for _, p := range preferences {
+		if len(p.Regions) == 0 {
+			continue
+		}
+		if !constrained {
+			regions = slices.Clone(p.Regions)
+			constrained = true
+			continue
+		}
+		regions = slices.DeleteFunc(regions, func(region string) bool {
+			return !slices.ContainsFunc(p.Regions, func(preferred string) bool {
+				return strings.EqualFold(preferred, region)
+			})
+		})
+	}
+	if !constrained || len(regions) == 0 {
+		return nil, false
+	}
+	slices.Sort(regions)
+	return slices.Compact(regions), true
+}
+
+// placementFilters restricts the deployment to the regions preferred by all the users. Conflicting or unreadable
+// preferences are ignored, the deployment is then placed on the users IPs alone.
+func (efm *EdgegapFleetManager) placementFilters(ctx context.Context, userIds []string) []EdgegapDeploymentFilter {
+	preferences, err := efm.storageManager.getPlacementPreferences(ctx, userIds)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Warn("failed to read placement preferences, ignoring them")
+		return nil
+	}
+
+	regions, ok := intersectRegions(preferences)
+	if !ok {
+		if len(preferences) > 0 {
+			efm.logger.Debug("No region preferred by all of %d users, ignoring region preferences", len(userIds))
+		}
+		return nil
+	}
+
+	return []EdgegapDeploymentFilter{{
+		Field:      "continent",
+		Values:     regions,
+		FilterType: "any",
+	}}
+}
+
+// preferredMaxPing returns the max ping preferred by the user, 0 if none.
+func (sm *StorageManager) preferredMaxPing(ctx context.Context, userId string) int {
+	preferences, err := sm.getPlacementPreferences(ctx, []string{userId})
+	if err != nil {
+		sm.logger.WithField("error", err.Error()).Warn("failed to read placement preferences of user %s", userId)
+		return 0
+	}
+	if p, ok := preferences[userId]; ok {
+		return p.MaxPing
+	}
+	return 0
+}
+
+// setPlacementPreferences client rpc to store the placement preferences of the user, an empty payload clears them
+func setPlacementPreferences(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok {
+		return "", ErrInvalidInput
+	}
+
+	preferences := &EdgegapPlacementPreferences{}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), preferences); err != nil || !preferences.valid() {
+			return "", ErrInvalidInput
+		}
+	}
+
+	// Only regions the application can be deployed to are kept, unchecked if the catalog is unavailable
+	if len(preferences.Regions) > 0 {
+		if locations, err := fmInstance.edgegapManager.ListLocations(); err != nil {
+			logger.WithField("error", err.Error()).Warn("failed to list Edgegap locations, skipping region check")
+		} else {
+			regions, unknown := canonicalRegions(preferences.Regions, locations)
+			if unknown != "" {
+				return "", runtime.NewError("no location available in region: "+unknown, 3) // INVALID_ARGUMENT
+			}
+			preferences.Regions = regions
+		}
+	}
+	preferences.UpdatedAt = time.Now().UTC()
+
+	if err := fmInstance.storageManager.setPlacementPreferences(ctx, userId, preferences); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to store placement preferences")
+		return "", ErrInternalError
+	}
+
+	reply, err := json.Marshal(preferences)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
+
+// getPlacementPreferences client rpc to read the placement preferences of the user
+func getPlacementPreferences(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok {
+		return "", ErrInvalidInput
+	}
+
+	preferences, err := fmInstance.storageManager.getPlacementPreferences(ctx, []string{userId})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read placement preferences")
+		return "", ErrInternalError
+	}
+	p, ok := preferences[userId]
+	if !ok {
+		p = &EdgegapPlacementPreferences{Regions: []string{}}
+	}
+
+	reply, err := json.Marshal(p)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
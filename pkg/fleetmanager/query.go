@@ -0,0 +1,124 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter operators supported by the structured list filters
+const (
+	FilterOpEq  = "eq"
+	FilterOpNe  = "ne"
+	FilterOpGt  = "gt"
+	FilterOpGte = "gte"
+	FilterOpLt  = "lt"
+	FilterOpLte = "lte"
+)
+
+// InstanceFilter is a structured condition on an instance field, compiled to a storage index query.
+type InstanceFilter struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value any    `json:"value"`
+}
+
+// filterFields maps the filterable field names to their storage index path
+var filterFields = map[string]string{
+	"id":                 "value.id",
+	"status":             "value.status",
+	"player_count":       "value.player_count",
+	"available_seats":    "value.metadata.edgegap.available_seats",
+	"max_players":        "value.metadata.edgegap.max_players",
+	"reservations_count": "value.metadata.edgegap.reservations_count",
+	"region":             "value.metadata.edgegap.location.continent",
+	"country":            "value.metadata.edgegap.location.country",
+	"city":               "value.metadata.edgegap.location.city",
+}
+
+// customMetadataField matches custom metadata paths such as "metadata.game_mode"
+var customMetadataField = regexp.MustCompile(`^metadata\.[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+
+// filterFieldPath resolves a filter field to its index path.
+func filterFieldPath(field string) (string, error) {
+	if path, ok := filterFields[field]; ok {
+		return path, nil
+	}
+	if customMetadataField.MatchString(field) && !strings.HasPrefix(field, "metadata.edgegap.") {
+		return "value." + field, nil
+	}
+	return "", fmt.Errorf("unsupported filter field %q", field)
+}
+
+// filterValue formats a filter value, numbers as is and everything else as an escaped phrase.
+func filterValue(value any) (string, bool, error) {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true, nil
+	case int:
+		return strconv.Itoa(v), true, nil
+	case bool:
+		return strconv.FormatBool(v), false, nil
+	case string:
+		escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v)
+		return `"` + escaped + `"`, false, nil
+	default:
+		return "", false, fmt.Errorf("unsupported filter value %v", value)
+	}
+}
+
+// compileFilter turns a single filter into a storage index query clause.
+func compileFilter(filter InstanceFilter) (string, error) {
+	path, err := filterFieldPath(filter.Field)
+	if err != nil {
+		return "", err
+	}
+
+	value, numeric, err := filterValue(filter.Value)
+	if err != nil {
+		return "", err
+	}
+
+	switch filter.Op {
+	case FilterOpEq, "":
+		return fmt.Sprintf("+%s:%s", path, value), nil
+	case FilterOpNe:
+		return fmt.Sprintf("-%s:%s", path, value), nil
+	}
+
+	if !numeric {
+		return "", fmt.Errorf("operator %q expects a numeric value for field %q", filter.Op, filter.Field)
+	}
+
+	switch filter.Op {
+	case FilterOpGt:
+		return fmt.Sprintf("+%s:>%s", path, value), nil
+	case FilterOpGte:
+		return fmt.Sprintf("+%s:>=%s", path, value), nil
+	case FilterOpLt:
+		return fmt.Sprintf("+%s:<%s", path, value), nil
+	case FilterOpLte:
+		return fmt.Sprintf("+%s:<=%s", path, value), nil
+	default:
+		return "", fmt.Errorf("unsupported filter operator %q", filter.Op)
+	}
+}
+
+// compileFilters appends the compiled filters to a storage index query.
+func compileFilters(query string, filters []InstanceFilter) (string, error) {
+	clauses := make([]string, 0, len(filters)+1)
+	if strings.TrimSpace(query) != "" {
+		clauses = append(clauses, query)
+	}
+
+	for _, filter := range filters {
+		clause, err := compileFilter(filter)
+		if err != nil {
+			return "", err
+		}
+		clauses = append(clauses, clause)
+	}
+
+	return strings.Join(clauses, " "), nil
+}
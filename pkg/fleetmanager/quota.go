@@ -0,0 +1,234 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdFleetStats = "fleet_stats"
+
+	// ModeQuotaGlobal is the quota key capping the deployments of all modes
+	ModeQuotaGlobal = "*"
+)
+
+// ErrorQuotaReached is returned by Create when the deployment quota of the game mode is reached
+var ErrorQuotaReached = errors.New("deployment quota reached")
+
+// QuotaError is the ErrorQuotaReached of a game mode or tenant, with its active deployments
+type QuotaError struct {
+	Mode   string
+	Tenant string
+	Active int
+	Quota  int
+}
+
+func (e *QuotaError) Error() string {
+	if e.Tenant != "" {
+		return fmt.Sprintf("%s: %d/%d active deployments for tenant %s", ErrorQuotaReached, e.Active, e.Quota, e.Tenant)
+	}
+	if e.Mode == ModeQuotaGlobal {
+		return fmt.Sprintf("%s: %d/%d active deployments", ErrorQuotaReached, e.Active, e.Quota)
+	}
+	return fmt.Sprintf("%s: %d/%d active deployments for mode %s", ErrorQuotaReached, e.Active, e.Quota, e.Mode)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrorQuotaReached
+}
+
+// ModeUsage reports the active deployments of a game mode against its quota
+type ModeUsage struct {
+	Active int `json:"active"`
+	Quota  int `json:"quota,omitempty"`
+}
+
+type fleetStatsReply struct {
+	Total    int                  `json:"total"`
+	ByStatus map[string]int       `json:"by_status"`
+	Modes    map[string]ModeUsage `json:"modes"`
+	// ByCapacity counts the active instances on the reserved hosts and on-demand, when reserved capacity is configured
+	ByCapacity map[string]int `json:"by_capacity,omitempty"`
+	// Conversion reports the reservations converted to connections, by region and app version
+	Conversion *conversionStatsReply `json:"conversion"`
+}
+
+// parseModeQuotas parses comma separated mode=max entries, e.g. "ranked=50,custom=20,*=100".
+func parseModeQuotas(value string) (map[string]int, error) {
+	quotas := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		mode, limit, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(mode) == "" {
+			return nil, fmt.Errorf("invalid mode quota %q, expects mode=max", entry)
+		}
+		quota, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || quota < 0 {
+			return nil, fmt.Errorf("invalid mode quota %q, expects a positive max", entry)
+		}
+		quotas[strings.TrimSpace(mode)] = quota
+	}
+
+	return quotas, nil
+}
+
+// activeInstancesQuery returns the query matching instances holding (or about to hold) a deployment
+func activeInstancesQuery() string {
+	return fmt.Sprintf("-value.status:%s -value.status:%s", EdgegapStatusTerminated, EdgegapStatusError)
+}
+
+// countInstances counts the instances of every tenant matching the query.
+func (sm *StorageManager) countInstances(ctx context.Context, query string) (int, error) {
+	return sm.countIndexedInstances(ctx, sm.instanceIndexes(), query)
+}
+
+// countIndexedInstances counts the instances matching the query in the given indexes.
+func (sm *StorageManager) countIndexedInstances(ctx context.Context, indexes []string, query string) (int, error) {
+	count := 0
+	cursor := ""
+	for {
+		objects, newCursor, err := sm.listIndexesPage(ctx, indexes, query, 1_000, nil, cursor)
+		if err != nil {
+			return 0, err
+		}
+		count += len(objects)
+		if newCursor == "" || len(objects) == 0 {
+			return count, nil
+		}
+		cursor = newCursor
+	}
+}
+
+// checkModeQuota enforces the global and per game mode deployment quotas before a Create.
+// Quotas are best effort, concurrent creations on several nodes can briefly exceed them.
+func (efm *EdgegapFleetManager) checkModeQuota(ctx context.Context, metadata map[string]any) error {
+	config := efm.edgegapManager.configuration
+	quotas, err := parseModeQuotas(config.ModeQuotas)
+	if err != nil || len(quotas) == 0 {
+		return err
+	}
+
+	if quota, ok := quotas[ModeQuotaGlobal]; ok {
+		active, err := efm.storageManager.countInstances(ctx, activeInstancesQuery())
+		if err != nil {
+			return err
+		}
+		if active >= quota {
+			return efm.quotaReached(ModeQuotaGlobal, active, quota)
+		}
+	}
+
+	mode, ok := metadata[config.ModeMetadataKey]
+	if !ok {
+		return nil
+	}
+	modeName := fmt.Sprint(mode)
+	quota, ok := quotas[modeName]
+	if !ok {
+		return nil
+	}
+
+	query := fmt.Sprintf("+value.metadata.%s:%q %s", config.ModeMetadataKey, modeName, activeInstancesQuery())
+	active, err := efm.storageManager.countInstances(ctx, query)
+	if err != nil {
+		return err
+	}
+	if active >= quota {
+		return efm.quotaReached(modeName, active, quota)
+	}
+
+	return nil
+}
+
+// quotaReached reports a reached quota and returns the informative error for the caller
+func (efm *EdgegapFleetManager) quotaReached(mode string, active, quota int) error {
+	efm.logger.WithFields(map[string]any{"mode": mode, "active": active, "quota": quota}).Warn("deployment quota reached")
+	efm.edgegapManager.webhooks.Dispatch(WebhookEventQuotaReached, fmt.Sprintf("Deployment quota reached for mode %s (%d/%d)", mode, active, quota), map[string]string{
+		"mode":   mode,
+		"active": strconv.Itoa(active),
+		"quota":  strconv.Itoa(quota),
+	})
+
+	return &QuotaError{Mode: mode, Active: active, Quota: quota}
+}
+
+// fleetStats S2S rpc reporting the instances by status, the usage of the game mode quotas and the reservation
+// conversion rates
+func fleetStats(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdFleetStats); err != nil {
+		return "", err
+	}
+
+	config := fmInstance.edgegapManager.configuration
+	quotas, _ := parseModeQuotas(config.ModeQuotas)
+	reply := fleetStatsReply{
+		ByStatus:   make(map[string]int),
+		ByCapacity: make(map[string]int),
+		Modes:      make(map[string]ModeUsage),
+		Conversion: newConversionStatsReply(),
+	}
+	for mode, quota := range quotas {
+		reply.Modes[mode] = ModeUsage{Quota: quota}
+	}
+
+	cursor := ""
+	for {
+		objects, newCursor, err := fmInstance.storageManager.listIndexesPage(ctx, fmInstance.storageManager.instanceIndexes(), "*", 1_000, nil, cursor)
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("failed to list instances for fleet stats")
+			return "", ErrInternalError
+		}
+
+		for _, so := range objects {
+			info, err := decodeInstance(so.Value)
+			if err != nil {
+				continue
+			}
+			reply.Total++
+			reply.ByStatus[info.Status]++
+			ei, err := fmInstance.storageManager.ExtractEdgegapInstance(info)
+			if err == nil {
+				reply.Conversion.add(ei)
+			}
+			if info.Status == EdgegapStatusTerminated || info.Status == EdgegapStatusError {
+				continue
+			}
+
+			if err == nil && ei.Capacity != "" {
+				reply.ByCapacity[ei.Capacity]++
+			}
+
+			usage := reply.Modes[ModeQuotaGlobal]
+			usage.Active++
+			reply.Modes[ModeQuotaGlobal] = usage
+			if mode, ok := info.Metadata[config.ModeMetadataKey]; ok {
+				usage = reply.Modes[fmt.Sprint(mode)]
+				usage.Active++
+				reply.Modes[fmt.Sprint(mode)] = usage
+			}
+		}
+
+		if newCursor == "" || len(objects) == 0 {
+			break
+		}
+		cursor = newCursor
+	}
+
+	replyString, err := json.Marshal(reply)
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
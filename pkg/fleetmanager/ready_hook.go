@@ -0,0 +1,60 @@
+package fleetmanager
+
+import (
+	"context"
+	"maps"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// InstanceMetadataProvisionedKey holds the identifiers of the per-match resources provisioned by the ready hook
+const InstanceMetadataProvisionedKey = "provisioned"
+
+// ReadyHook provisions per-match resources (chat channels, leaderboards, storage buckets...) once an instance is
+// READY, before the create callbacks fire. The returned identifiers are stored in the instance metadata under
+// "provisioned" and sent in the connection-info notification. The hook delays the ready callbacks, keep it short.
+type ReadyHook func(ctx context.Context, instance *runtime.InstanceInfo) (map[string]any, error)
+
+// SetReadyHook sets the Go hook called when an instance is ready. A nil hook removes it.
+func (efm *EdgegapFleetManager) SetReadyHook(hook ReadyHook) {
+	efm.hookMu.Lock()
+	defer efm.hookMu.Unlock()
+	efm.readyHook = hook
+}
+
+// provisionReady runs the ready hook on the instance, adding the provisioned identifiers to its metadata.
+// A failing hook is logged and the instance is still delivered, the match can start without its extra resources.
+func (efm *EdgegapFleetManager) provisionReady(ctx context.Context, instance *runtime.InstanceInfo) {
+	efm.hookMu.RLock()
+	hook := efm.readyHook
+	efm.hookMu.RUnlock()
+
+	if hook == nil {
+		return
+	}
+
+	provisioned, err := hook(ctx, instance)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("ready hook failed for instance %s", instance.Id)
+		return
+	}
+	if len(provisioned) == 0 {
+		return
+	}
+
+	// Resources provisioned by an earlier ready event of the same instance are kept
+	existing, _ := instance.Metadata[InstanceMetadataProvisionedKey].(map[string]any)
+	merged := make(map[string]any, len(existing)+len(provisioned))
+	maps.Copy(merged, existing)
+	maps.Copy(merged, provisioned)
+	instance.Metadata[InstanceMetadataProvisionedKey] = merged
+}
+
+// provisionedContent returns the provisioned identifiers of the instance, nil if none.
+func provisionedContent(instance *runtime.InstanceInfo) map[string]any {
+	provisioned, _ := instance.Metadata[InstanceMetadataProvisionedKey].(map[string]any)
+	if len(provisioned) == 0 {
+		return nil
+	}
+	return provisioned
+}
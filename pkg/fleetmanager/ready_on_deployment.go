@@ -0,0 +1,23 @@
+package fleetmanager
+
+import (
+	"strings"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// CreateMetadataReadyOnDeploymentKey is the create metadata key overriding EDGEGAP_READY_ON_DEPLOYMENT for the
+// instance, for game server builds that never send the READY instance event (e.g. engine plugins without the SDK)
+const CreateMetadataReadyOnDeploymentKey = "ready_on_deployment"
+
+// readyOnDeployment reports whether the instance is READY as soon as its deployment is, from its create metadata,
+// falling back to the configuration.
+func readyOnDeployment(config *EdgegapManagerConfiguration, instance *runtime.InstanceInfo) bool {
+	switch v := instance.Metadata[CreateMetadataReadyOnDeploymentKey].(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return config.ReadyOnDeployment
+}
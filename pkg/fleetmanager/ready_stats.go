@@ -0,0 +1,156 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	StorageKeyReadyStats = "edgegap_ready_stats"
+
+	// EventSlowStart is the Nakama event emitted when a deployment exceeds the slow start threshold
+	EventSlowStart = "edgegap_slow_start"
+
+	// readyStatsWindow is the number of most recent time-to-ready samples kept for percentiles
+	readyStatsWindow = 500
+)
+
+// EdgegapReadyStats holds the rolling time-to-ready samples and percentiles, in milliseconds.
+type EdgegapReadyStats struct {
+	Samples   []int64 `json:"samples"`
+	P50       int64   `json:"p50"`
+	P90       int64   `json:"p90"`
+	P99       int64   `json:"p99"`
+	Count     int64   `json:"count"`
+	UpdatedAt int64   `json:"updated_at"`
+}
+
+// percentile returns the nearest-rank percentile of sorted samples.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	return sorted[min(max(idx, 0), len(sorted)-1)]
+}
+
+// add records a sample, keeping the most recent window, and refreshes the percentiles.
+func (rs *EdgegapReadyStats) add(sample time.Duration) {
+	rs.Samples = append(rs.Samples, sample.Milliseconds())
+	if len(rs.Samples) > readyStatsWindow {
+		rs.Samples = rs.Samples[len(rs.Samples)-readyStatsWindow:]
+	}
+	rs.Count++
+	rs.UpdatedAt = time.Now().Unix()
+
+	sorted := slices.Sorted(slices.Values(rs.Samples))
+	rs.P50 = percentile(sorted, 0.50)
+	rs.P90 = percentile(sorted, 0.90)
+	rs.P99 = percentile(sorted, 0.99)
+}
+
+// ReadReadyStats retrieves the time-to-ready statistics from storage
+func (sm *StorageManager) ReadReadyStats(ctx context.Context) (*EdgegapReadyStats, string, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: StorageCollectionEdgegapVersion,
+			Key:        StorageKeyReadyStats,
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	stats := &EdgegapReadyStats{}
+	if len(objects) == 0 {
+		return stats, "", nil
+	}
+
+	if err := json.Unmarshal([]byte(objects[0].Value), stats); err != nil {
+		return nil, "", err
+	}
+
+	return stats, objects[0].Version, nil
+}
+
+// RecordReadyDuration adds a time-to-ready sample to the rolling statistics in storage
+func (sm *StorageManager) RecordReadyDuration(ctx context.Context, duration time.Duration) (*EdgegapReadyStats, error) {
+	stats, version, err := sm.ReadReadyStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.add(duration)
+
+	value, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+
+	// Conditional write, a concurrent update makes this sample get dropped rather than overwriting others
+	if version == "" {
+		version = "*"
+	}
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      StorageCollectionEdgegapVersion,
+			Key:             StorageKeyReadyStats,
+			Value:           string(value),
+			Version:         version,
+			PermissionRead:  0,
+			PermissionWrite: 0,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// recordTimeToReady stores the time-to-ready of an instance and raises a slow start alert above the threshold.
+func (eem *EdgegapEventManager) recordTimeToReady(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) {
+	requestedAt := ei.RequestedAt
+	if requestedAt.IsZero() {
+		requestedAt = instance.CreateTime
+	}
+	timeToReady := time.Since(requestedAt)
+
+	ei.TimeToReadyMs = timeToReady.Milliseconds()
+	nk.MetricsTimerRecord("edgegap_time_to_ready", nil, timeToReady)
+	eem.sm.recordDailyStat(DailyStatReady, ei, timeToReady)
+
+	if _, err := eem.sm.RecordReadyDuration(ctx, timeToReady); err != nil {
+		logger.WithField("error", err.Error()).Warn("failed to record time to ready")
+	}
+
+	threshold, err := time.ParseDuration(eem.config.SlowStartThreshold)
+	if err != nil || threshold <= 0 || timeToReady <= threshold {
+		return
+	}
+
+	logger.WithFields(map[string]any{
+		"instance_id":   instance.Id,
+		"time_to_ready": timeToReady.String(),
+		"threshold":     threshold.String(),
+	}).Warn("Edgegap deployment slow start")
+
+	properties := map[string]string{
+		"instance_id":      instance.Id,
+		"time_to_ready_ms": strconv.FormatInt(timeToReady.Milliseconds(), 10),
+		"threshold_ms":     strconv.FormatInt(threshold.Milliseconds(), 10),
+	}
+
+	if err = nk.Event(ctx, &api.Event{Name: EventSlowStart, Properties: properties}); err != nil {
+		logger.WithField("error", err.Error()).Warn("failed to emit slow start event")
+	}
+
+	eem.webhooks.Dispatch(WebhookEventSlowStart, fmt.Sprintf("Deployment %s took %s to be ready", instance.Id, timeToReady.Round(time.Second)), properties)
+}
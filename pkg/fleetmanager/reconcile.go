@@ -0,0 +1,256 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+// Reconciliation phases, timed in the edgegap_reconciliation_duration metric
+const (
+	ReconcilePhaseListDeployments = "list_deployments"
+	ReconcilePhaseListInstances   = "list_instances"
+	ReconcilePhaseDelete          = "delete"
+	ReconcilePhaseTotal           = "total"
+)
+
+// reconcileDeleteBatch is the number of instances removed per storage call, batches are removed in parallel
+const reconcileDeleteBatch = 500
+
+// forEachBounded calls fn for every index below n, from at most workers goroutines, and waits for all of them.
+func forEachBounded(n, workers int, fn func(i int)) {
+	workers = max(1, min(workers, n))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// ListAllDeployments retrieves all deployment summaries of every account from the Edgegap API, the accounts in parallel.
+func (em *EdgegapManager) ListAllDeployments() ([]EdgegapDeploymentSummary, error) {
+	results := make([][]EdgegapDeploymentSummary, len(em.accounts))
+	errs := make([]error, len(em.accounts))
+	forEachBounded(len(em.accounts), em.configuration.ReconcileWorkers, func(i int) {
+		account := em.accounts[i]
+		results[i], errs[i] = em.listDeployments(account.apiHelper)
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("account %s: %w", account.name, errs[i])
+		}
+		for j := range results[i] {
+			results[i][j].Account = account.name
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	var allDeployments []EdgegapDeploymentSummary
+	for _, deployments := range results {
+		allDeployments = append(allDeployments, deployments...)
+	}
+	return allDeployments, nil
+}
+
+// listDeploymentsPage retrieves a page of the deployment summaries of an account.
+func listDeploymentsPage(apiHelper *helpers.APIClient, page int) (*EdgegapDeploymentList, error) {
+	reply, err := apiHelper.Get("/v1/deployments?page=" + strconv.Itoa(page))
+	if err != nil {
+		return nil, err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode != http.StatusOK {
+		return nil, errors.New("error listing all Edgegap deployments")
+	}
+
+	body, err := io.ReadAll(reply.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response EdgegapDeploymentList
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// listDeployments retrieves the deployment summaries of an account. The first page tells the page count from the total,
+// the next pages are fetched in parallel; without a total they are followed one by one until no more pages exist.
+func (em *EdgegapManager) listDeployments(apiHelper *helpers.APIClient) ([]EdgegapDeploymentSummary, error) {
+	first, err := listDeploymentsPage(apiHelper, 1)
+	if err != nil {
+		return nil, err
+	}
+	if !first.Pagination.HasNext {
+		return first.Data, nil
+	}
+
+	pageSize := len(first.Data)
+	if first.TotalCount <= pageSize || pageSize == 0 {
+		return followDeploymentPages(apiHelper, first)
+	}
+
+	pages := (first.TotalCount + pageSize - 1) / pageSize
+	results := make([][]EdgegapDeploymentSummary, pages-1)
+	errs := make([]error, pages-1)
+	forEachBounded(pages-1, em.configuration.ReconcileWorkers, func(i int) {
+		response, err := listDeploymentsPage(apiHelper, i+2)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		results[i] = response.Data
+	})
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	// Deployments starting or stopping while paging shift the pages and could skip a live deployment, the pages are
+	// listed again one by one when the total changed meanwhile
+	check, err := listDeploymentsPage(apiHelper, 1)
+	if err != nil {
+		return nil, err
+	}
+	if check.TotalCount != first.TotalCount {
+		return followDeploymentPages(apiHelper, check)
+	}
+
+	// Pages that shifted and back keep the total, the same deployment can then be listed twice
+	allDeployments := first.Data
+	seen := make(map[string]struct{}, first.TotalCount)
+	for _, deployment := range allDeployments {
+		seen[deployment.RequestId] = struct{}{}
+	}
+	for _, page := range results {
+		for _, deployment := range page {
+			if _, ok := seen[deployment.RequestId]; !ok {
+				seen[deployment.RequestId] = struct{}{}
+				allDeployments = append(allDeployments, deployment)
+			}
+		}
+	}
+	return allDeployments, nil
+}
+
+// followDeploymentPages lists the deployments from the given first page, following the next pages one by one.
+func followDeploymentPages(apiHelper *helpers.APIClient, first *EdgegapDeploymentList) ([]EdgegapDeploymentSummary, error) {
+	allDeployments := first.Data
+	for response := first; response.Pagination.HasNext; {
+		var err error
+		if response, err = listDeploymentsPage(apiHelper, response.Pagination.NextPageNumber); err != nil {
+			return nil, err
+		}
+		allDeployments = append(allDeployments, response.Data...)
+	}
+	return allDeployments, nil
+}
+
+// reconcile removes the instances whose deployment is no longer running on Edgegap, and stops the tagged deployments
+// left without an instance, timing each phase.
+func (efm *EdgegapFleetManager) reconcile() {
+	start := time.Now()
+	defer efm.recordReconcilePhase(ReconcilePhaseTotal, start)
+
+	deployments, err := efm.edgegapManager.ListAllDeployments()
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to list edgegap deployments")
+		return
+	}
+	efm.recordReconcilePhase(ReconcilePhaseListDeployments, start)
+	efm.logger.WithField("active_deployments", len(deployments)).Debug("fetched active deployment instances list")
+	efm.nk.MetricsGaugeSet("edgegap_deployment_count", nil, float64(len(deployments)))
+	efm.stopOrphanedDeployments(deployments)
+
+	// Pending instances are not deployed yet, only the deployed statuses are reconciled
+	phaseStart := time.Now()
+	dbInstances, err := efm.storageManager.listDbInstancesByStatus(efm.ctx, reconciledStatuses)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to read instances from db")
+		return
+	}
+	efm.recordReconcilePhase(ReconcilePhaseListInstances, phaseStart)
+
+	activeInstancesMap := make(map[string]struct{}, len(deployments))
+	for _, i := range deployments {
+		if i.Status != DeploymentStatusError {
+			activeInstancesMap[i.RequestId] = struct{}{}
+		}
+	}
+
+	instancesToRemove := make([]string, 0)
+	for _, dbInfo := range dbInstances {
+		// Persistent instances are only removed through the admin RPCs
+		if ei, err := efm.storageManager.ExtractEdgegapInstance(dbInfo); err == nil && ei.Persistent {
+			if _, ok := activeInstancesMap[dbInfo.Id]; !ok {
+				efm.logger.Warn("Persistent instance %s has no active Edgegap deployment", dbInfo.Id)
+			}
+			continue
+		}
+		if _, ok := activeInstancesMap[dbInfo.Id]; !ok {
+			instancesToRemove = append(instancesToRemove, dbInfo.Id)
+		}
+	}
+
+	if len(instancesToRemove) == 0 {
+		return
+	}
+	efm.logger.Debug("Found %d instances to remove", len(instancesToRemove))
+
+	phaseStart = time.Now()
+	removed := efm.removeInstances(instancesToRemove)
+	efm.recordReconcilePhase(ReconcilePhaseDelete, phaseStart)
+	if len(removed) == 0 {
+		return
+	}
+
+	efm.edgegapManager.webhooks.Dispatch(WebhookEventReconciliationDelete, fmt.Sprintf("Reconciliation removed %d instances no longer running on Edgegap", len(removed)), map[string]string{
+		"instance_ids": strings.Join(removed, ","),
+	})
+}
+
+// removeInstances deletes the instances in batches removed in parallel, returning the ids of the batches removed.
+func (efm *EdgegapFleetManager) removeInstances(ids []string) []string {
+	batches := slices.Collect(slices.Chunk(ids, reconcileDeleteBatch))
+	failed := make([]bool, len(batches))
+	forEachBounded(len(batches), efm.edgegapManager.configuration.ReconcileWorkers, func(i int) {
+		if err := efm.storageManager.deleteDbInstances(efm.ctx, batches[i]); err != nil {
+			efm.logger.WithFields(map[string]any{"error": err.Error(), "instances": len(batches[i])}).Error("failed to delete a game instances")
+			failed[i] = true
+		}
+	})
+
+	removed := make([]string, 0, len(ids))
+	for i, batch := range batches {
+		if !failed[i] {
+			removed = append(removed, batch...)
+		}
+	}
+	return removed
+}
+
+// recordReconcilePhase records the duration of a reconciliation phase since its start.
+func (efm *EdgegapFleetManager) recordReconcilePhase(phase string, start time.Time) {
+	efm.nk.MetricsTimerRecord("edgegap_reconciliation_duration", map[string]string{"phase": phase}, time.Since(start))
+}
@@ -0,0 +1,135 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+func TestForEachBounded(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		workers int
+	}{
+		{name: "none", n: 0, workers: 4},
+		{name: "fewer items than workers", n: 3, workers: 8},
+		{name: "more items than workers", n: 50, workers: 4},
+		{name: "no workers configured", n: 5, workers: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var running, peak int32
+			calls := make([]int, tt.n)
+			forEachBounded(tt.n, tt.workers, func(i int) {
+				now := atomic.AddInt32(&running, 1)
+				mu.Lock()
+				calls[i]++
+				peak = max(peak, now)
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			})
+
+			for i, count := range calls {
+				if count != 1 {
+					t.Errorf("index %d called %d times", i, count)
+				}
+			}
+			if limit := int32(max(1, tt.workers)); peak > limit {
+				t.Errorf("%d calls ran at once, want at most %d", peak, limit)
+			}
+		})
+	}
+}
+
+// deploymentPages serves the pages of deployments, with the total count when withTotal. From the second request of the
+// first page, the relisted pages are served instead when set, as if deployments started or stopped meanwhile.
+func deploymentPages(t *testing.T, pages, relisted [][]string, withTotal bool) *httptest.Server {
+	var mu sync.Mutex
+	firstRequests := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number, err := strconv.Atoi(r.URL.Query().Get("page"))
+
+		mu.Lock()
+		if number == 1 {
+			firstRequests++
+		}
+		current := pages
+		if firstRequests > 1 && relisted != nil {
+			current = relisted
+		}
+		mu.Unlock()
+
+		if err != nil || number < 1 || number > len(current) {
+			t.Errorf("unexpected page %q", r.URL.Query().Get("page"))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		list := EdgegapDeploymentList{Data: make([]EdgegapDeploymentSummary, 0)}
+		for _, id := range current[number-1] {
+			list.Data = append(list.Data, EdgegapDeploymentSummary{RequestId: id, Status: "Status.READY"})
+		}
+		list.Pagination = EdgegapPagination{Number: number, NextPageNumber: number + 1, HasNext: number < len(current)}
+		if withTotal {
+			for _, page := range current {
+				list.TotalCount += len(page)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	}))
+}
+
+func TestListDeployments(t *testing.T) {
+	tests := []struct {
+		name      string
+		pages     [][]string
+		relisted  [][]string
+		withTotal bool
+		want      []string
+	}{
+		{name: "single page", pages: [][]string{{"a", "b"}}, withTotal: true, want: []string{"a", "b"}},
+		{name: "pages fetched from the total", pages: [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, withTotal: true, want: []string{"a", "b", "c", "d", "e"}},
+		{name: "pages followed without a total", pages: [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, want: []string{"a", "b", "c", "d", "e"}},
+		{name: "shifted pages listed once", pages: [][]string{{"a", "b"}, {"b", "c"}, {"c", "d"}}, withTotal: true, want: []string{"a", "b", "c", "d"}},
+		{
+			name:      "pages listed again when the total changed",
+			pages:     [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+			relisted:  [][]string{{"b", "c"}, {"d", "e"}},
+			withTotal: true,
+			want:      []string{"b", "c", "d", "e"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := deploymentPages(t, tt.pages, tt.relisted, tt.withTotal)
+			defer server.Close()
+
+			em := &EdgegapManager{configuration: &EdgegapManagerConfiguration{ReconcileWorkers: 2}}
+			deployments, err := em.listDeployments(helpers.NewAPIClient(server.URL, "token"))
+			if err != nil {
+				t.Fatalf("listDeployments() error = %v", err)
+			}
+			got := make([]string, 0, len(deployments))
+			for _, deployment := range deployments {
+				got = append(got, deployment.RequestId)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("listDeployments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	StorageEdgegapPurchasesCollection = StorageEdgegapPrefix + "_purchases"
+
+	// CreateMetadataRentalKey opts a Create into the paid private server flow
+	CreateMetadataRentalKey = "rental"
+	// InstanceMetadataPurchaseKey holds the purchase of a rented instance
+	InstanceMetadataPurchaseKey = "purchase"
+
+	PurchaseStatusCharged  = "CHARGED"
+	PurchaseStatusRefunded = "REFUNDED"
+)
+
+// ErrorRentalUnavailable is returned by Create when a rental is requested but no rental cost is configured
+var ErrorRentalUnavailable = errors.New("server rentals are not enabled")
+
+// ErrorInsufficientFunds is returned by Create when the creator cannot afford the rental
+var ErrorInsufficientFunds = errors.New("insufficient funds for server rental")
+
+// EdgegapPurchase records the wallet debit of a rented instance
+type EdgegapPurchase struct {
+	PurchaseId string           `json:"purchase_id"`
+	UserId     string           `json:"user_id"`
+	InstanceId string           `json:"instance_id,omitempty"`
+	Cost       map[string]int64 `json:"cost"`
+	Status     string           `json:"status"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+// parseRentalCost parses comma separated currency=amount entries, e.g. "gems=100,gold=500".
+func parseRentalCost(value string) (map[string]int64, error) {
+	cost := make(map[string]int64)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		currency, amount, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(currency) == "" {
+			return nil, fmt.Errorf("invalid rental cost %q, expects currency=amount", entry)
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(amount), 10, 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid rental cost %q, expects a positive amount", entry)
+		}
+		cost[strings.TrimSpace(currency)] = value
+	}
+
+	return cost, nil
+}
+
+// isRentalCreate reports whether the Create metadata opts into a paid rental
+func isRentalCreate(metadata map[string]any) bool {
+	rental, ok := metadata[CreateMetadataRentalKey]
+	if !ok {
+		return false
+	}
+
+	switch v := rental.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+// chargeRental debits the rental cost from the creator wallet and records the purchase.
+// The purchase ID is the create callback ID, so the refund can be tied to the create outcome.
+func (efm *EdgegapFleetManager) chargeRental(ctx context.Context, callbackId string, userIds []string) (*EdgegapPurchase, error) {
+	cost, err := parseRentalCost(efm.edgegapManager.configuration.RentalCost)
+	if err != nil || len(cost) == 0 {
+		return nil, ErrorRentalUnavailable
+	}
+
+	// The caller creates the server, falling back on the first user for server initiated creates
+	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userId == "" {
+		if len(userIds) == 0 {
+			return nil, errors.New("expects a user to charge the rental")
+		}
+		userId = userIds[0]
+	}
+
+	changeset := make(map[string]int64, len(cost))
+	for currency, amount := range cost {
+		changeset[currency] = -amount
+	}
+
+	if _, _, err = efm.nk.WalletUpdate(ctx, userId, changeset, map[string]any{"purchase_id": callbackId, "reason": "server_rental"}, true); err != nil {
+		efm.logger.WithFields(map[string]any{"error": err.Error(), "user_id": userId}).Warn("failed to charge server rental")
+		// Only a wallet the cost would make negative is short of funds, other failures are not the player's to fix
+		var negativeErr *runtime.WalletNegativeError
+		if errors.As(err, &negativeErr) {
+			return nil, ErrorInsufficientFunds
+		}
+		return nil, fmt.Errorf("failed to charge server rental: %w", err)
+	}
+
+	now := time.Now().UTC()
+	purchase := &EdgegapPurchase{
+		PurchaseId: callbackId,
+		UserId:     userId,
+		Cost:       cost,
+		Status:     PurchaseStatusCharged,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err = efm.storageManager.writePurchase(ctx, purchase); err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to record purchase %s", purchase.PurchaseId)
+	}
+
+	return purchase, nil
+}
+
+// refundOnFailure wraps the create callback to refund the rental when the creation fails or times out.
+func (efm *EdgegapFleetManager) refundOnFailure(purchase *EdgegapPurchase, callback runtime.FmCreateCallbackFn) runtime.FmCreateCallbackFn {
+	return func(status runtime.FmCreateStatus, instanceInfo *runtime.InstanceInfo, sessionInfo []*runtime.SessionInfo, metadata map[string]any, createErr error) {
+		if status == runtime.CreateError || status == runtime.CreateTimeout {
+			efm.refundRental(purchase)
+		}
+		// Server code may create without a callback
+		if callback != nil {
+			callback(status, instanceInfo, sessionInfo, metadata, createErr)
+		}
+	}
+}
+
+// refundRental credits the rental cost back to the creator wallet.
+func (efm *EdgegapFleetManager) refundRental(purchase *EdgegapPurchase) {
+	logger := efm.logger.WithFields(map[string]any{"purchase_id": purchase.PurchaseId, "user_id": purchase.UserId})
+	if purchase.Status == PurchaseStatusRefunded {
+		return
+	}
+
+	if _, _, err := efm.nk.WalletUpdate(efm.ctx, purchase.UserId, purchase.Cost, map[string]any{"purchase_id": purchase.PurchaseId, "reason": "server_rental_refund"}, true); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to refund server rental")
+		return
+	}
+
+	purchase.Status = PurchaseStatusRefunded
+	purchase.UpdatedAt = time.Now().UTC()
+	if err := efm.storageManager.writePurchase(efm.ctx, purchase); err != nil {
+		logger.WithField("error", err.Error()).Error("failed to record purchase refund")
+	}
+	logger.Info("Refunded server rental")
+}
+
+// writePurchase stores the purchase in the purchases collection, readable by its owner.
+func (sm *StorageManager) writePurchase(ctx context.Context, purchase *EdgegapPurchase) error {
+	value, err := json.Marshal(purchase)
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{
+		{
+			Collection:      sm.purchasesCollection,
+			Key:             purchase.PurchaseId,
+			UserID:          purchase.UserId,
+			Value:           string(value),
+			PermissionRead:  1, // Owner read
+			PermissionWrite: 0, // No write from clients
+		},
+	})
+	return err
+}
+
+// linkPurchase records the instance of a rental once Create resolved it.
+func (efm *EdgegapFleetManager) linkPurchase(ctx context.Context, purchase *EdgegapPurchase, result map[string]string) {
+	instanceId := result[DeploymentIdKey]
+	if id, ok := result[InstanceIdKey]; ok {
+		instanceId = id
+	}
+	if instanceId == "" || purchase.Status != PurchaseStatusCharged {
+		return
+	}
+
+	purchase.InstanceId = instanceId
+	purchase.UpdatedAt = time.Now().UTC()
+	if err := efm.storageManager.writePurchase(ctx, purchase); err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to link purchase %s to instance %s", purchase.PurchaseId, instanceId)
+	}
+}
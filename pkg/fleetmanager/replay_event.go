@@ -0,0 +1,75 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdAdminReplayEvent re-processes a dead letter or a historical webhook payload against the current handlers
+const RpcIdAdminReplayEvent = "admin_replay_event"
+
+type adminReplayEventRequest struct {
+	// DeadLetterId replays the stored dead letter, deleted once replayed
+	DeadLetterId string `json:"dead_letter_id"`
+	// RpcId is the webhook the payload is replayed through, e.g. edgegap_deployment_ready, unless a dead letter is set
+	RpcId string `json:"rpc_id"`
+	// Payload is the webhook payload, e.g. copied from the logs or a relay, it replaces the payload of a dead letter
+	Payload string `json:"payload"`
+}
+
+type adminReplayEventReply struct {
+	RpcId        string `json:"rpc_id"`
+	DeadLetterId string `json:"dead_letter_id,omitempty"`
+	Reply        string `json:"reply"`
+}
+
+// adminReplayEvent admin rpc re-processing a dead letter or a historical webhook payload, e.g. after fixing a bug that
+// mishandled events (S2S only)
+func (eem *EdgegapEventManager) adminReplayEvent(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdAdminReplayEvent); err != nil {
+		return "", err
+	}
+
+	var req *adminReplayEventRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil || (req.DeadLetterId == "" && (req.RpcId == "" || req.Payload == "")) {
+		return "", runtime.NewError("expects a dead_letter_id, or a rpc_id with its payload", 3) // INVALID_ARGUMENT
+	}
+
+	reply := adminReplayEventReply{RpcId: req.RpcId, DeadLetterId: req.DeadLetterId}
+	if req.DeadLetterId != "" {
+		entry, err := eem.sm.readDeadLetter(ctx, req.DeadLetterId)
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("failed to read dead letter")
+			return "", ErrInternalError
+		}
+		if entry == nil {
+			return "", runtime.NewError("no dead letter found with id "+req.DeadLetterId, 5) // NOT_FOUND
+		}
+
+		reply.RpcId = entry.RpcId
+		if reply.Reply, err = eem.replayStoredDeadLetter(ctx, logger, db, nk, entry, req.Payload); err != nil {
+			return "", err
+		}
+	} else {
+		handler, ok := eem.replayableHandler(req.RpcId)
+		if !ok {
+			return "", runtime.NewError("events of "+req.RpcId+" cannot be replayed", 3) // INVALID_ARGUMENT
+		}
+
+		logger.Warn("Replaying %s event", req.RpcId)
+		result, err := handler(context.WithValue(ctx, deadLetterReplayKey{}, true), logger, db, nk, req.Payload)
+		if err != nil {
+			return "", runtime.NewError("replay failed: "+err.Error(), 9) // FAILED_PRECONDITION
+		}
+		reply.Reply = result
+	}
+
+	replyJson, err := json.Marshal(reply)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyJson), nil
+}
@@ -0,0 +1,153 @@
+package fleetmanager
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+)
+
+// Join metadata keys understood by the fleet manager
+const (
+	JoinMetadataPriorityKey = "priority"
+	JoinMetadataWaitlistKey = "waitlist"
+)
+
+// ErrorInstanceFull is returned by Join when the instance is full and no lower priority reservation can be bumped
+var ErrorInstanceFull = errors.New("max players reservation limit reached")
+
+// ErrorInstanceFullWaitlisted is returned by Join when the instance is full and the users were placed in its waitlist
+var ErrorInstanceFullWaitlisted = errors.New("max players reservation limit reached, users added to waitlist")
+
+// parseJoinPriority reads the reservation priority from the Join metadata, defaulting to ReservationPriorityNormal.
+func parseJoinPriority(metadata map[string]string) (int, error) {
+	value, ok := metadata[JoinMetadataPriorityKey]
+	if !ok || value == "" {
+		return ReservationPriorityNormal, nil
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("expects priority to be an integer")
+	}
+
+	return priority, nil
+}
+
+// freeSeats returns the number of seats neither reserved nor connected, or -1 if the instance is unlimited.
+func (ei *EdgegapInstanceInfo) freeSeats() int {
+	if ei.MaxPlayers < 0 {
+		return -1
+	}
+
+	return max(ei.MaxPlayers-len(ei.Reservations)-len(ei.Connections), 0)
+}
+
+// reservationPriority returns the priority of a pending reservation.
+func (ei *EdgegapInstanceInfo) reservationPriority(userId string) int {
+	if priority, ok := ei.ReservationPriorities[userId]; ok {
+		return priority
+	}
+	return ReservationPriorityNormal
+}
+
+// reserve adds the users to the reservations with the given priority.
+func (ei *EdgegapInstanceInfo) reserve(userIds []string, priority int) {
+	if ei.ReservationPriorities == nil {
+		ei.ReservationPriorities = make(map[string]int)
+	}
+
+	for _, userId := range userIds {
+		ei.Reservations = helpers.AppendIfNotExists(ei.Reservations, userId)
+		if priority != ReservationPriorityNormal {
+			ei.ReservationPriorities[userId] = priority
+		} else {
+			delete(ei.ReservationPriorities, userId)
+		}
+	}
+}
+
+// bumpReservations moves count pending reservations with a priority lower than the given one to the waitlist,
+// lowest priority and most recent first, held seats excepted. Nothing is changed if not enough reservations can be bumped.
+func (ei *EdgegapInstanceInfo) bumpReservations(count int, priority int) []string {
+	candidates := make([]string, 0, len(ei.Reservations))
+	for _, userId := range ei.Reservations {
+		// Players holding their seat while connecting are never bumped
+		if ei.reservationPriority(userId) < priority && !ei.isSeatHeld(userId) {
+			candidates = append(candidates, userId)
+		}
+	}
+
+	if len(candidates) < count {
+		return nil
+	}
+
+	// Reverse first so the stable sort keeps the most recent reservations ahead within a priority
+	slices.Reverse(candidates)
+	slices.SortStableFunc(candidates, func(a, b string) int {
+		return ei.reservationPriority(a) - ei.reservationPriority(b)
+	})
+
+	bumped := candidates[:count]
+	for _, userId := range bumped {
+		ei.enqueueWaitlist([]string{userId}, ei.reservationPriority(userId))
+		delete(ei.ReservationPriorities, userId)
+	}
+	ei.Reservations = helpers.RemoveElements(ei.Reservations, bumped)
+
+	return bumped
+}
+
+// enqueueWaitlist adds the users to the waitlist, updating the priority of users already waiting.
+func (ei *EdgegapInstanceInfo) enqueueWaitlist(userIds []string, priority int) {
+	now := time.Now().UTC()
+	for _, userId := range userIds {
+		idx := slices.IndexFunc(ei.Waitlist, func(e EdgegapWaitlistEntry) bool { return e.UserId == userId })
+		if idx >= 0 {
+			ei.Waitlist[idx].Priority = max(ei.Waitlist[idx].Priority, priority)
+			continue
+		}
+		ei.Waitlist = append(ei.Waitlist, EdgegapWaitlistEntry{
+			UserId:   userId,
+			Priority: priority,
+			QueuedAt: now,
+		})
+	}
+}
+
+// promoteWaitlist moves waitlisted users into the free seats, highest priority first then oldest first.
+// It returns the promoted user IDs.
+func (ei *EdgegapInstanceInfo) promoteWaitlist() []string {
+	// Drop users who already got a seat another way
+	ei.Waitlist = slices.DeleteFunc(ei.Waitlist, func(e EdgegapWaitlistEntry) bool {
+		return slices.Contains(ei.Connections, e.UserId) || slices.Contains(ei.Reservations, e.UserId)
+	})
+
+	seats := ei.freeSeats()
+	if len(ei.Waitlist) == 0 || seats == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(ei.Waitlist, func(a, b EdgegapWaitlistEntry) int {
+		if a.Priority != b.Priority {
+			return b.Priority - a.Priority
+		}
+		return a.QueuedAt.Compare(b.QueuedAt)
+	})
+
+	if seats < 0 || seats > len(ei.Waitlist) {
+		seats = len(ei.Waitlist)
+	}
+
+	promoted := make([]string, 0, seats)
+	for _, entry := range ei.Waitlist[:seats] {
+		ei.reserve([]string{entry.UserId}, entry.Priority)
+		promoted = append(promoted, entry.UserId)
+	}
+	ei.Waitlist = slices.Clone(ei.Waitlist[seats:])
+	ei.ReservationsUpdatedAt = time.Now().UTC()
+
+	return promoted
+}
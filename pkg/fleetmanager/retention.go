@@ -0,0 +1,315 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdPurgeUserFleetData erases a user's identifiers and IP address from the fleet data, for GDPR deletion requests
+const RpcIdPurgeUserFleetData = "purge_user_fleet_data"
+
+// archivedStatuses are the statuses of instances kept in storage once their deployment is gone
+var archivedStatuses = []string{EdgegapStatusTerminated, EdgegapStatusError}
+
+type purgeUserFleetDataRequest struct {
+	UserIds []string `json:"user_ids"`
+}
+
+type purgeUserFleetDataReply struct {
+	Success   bool `json:"success"`
+	Instances int  `json:"instances"`
+	Purchases int  `json:"purchases"`
+}
+
+// forgetUsers removes the users from the reservations, connections, waitlist and ownership of the instance.
+// It reports whether the instance referenced any of them.
+func (ei *EdgegapInstanceInfo) forgetUsers(userIds []string) bool {
+	found := false
+	for _, userId := range userIds {
+		if slices.Contains(ei.Reservations, userId) || slices.Contains(ei.Connections, userId) {
+			found = true
+		}
+		if _, ok := ei.ReservationPriorities[userId]; ok {
+			delete(ei.ReservationPriorities, userId)
+			found = true
+		}
+		if ei.OwnerId == userId {
+			ei.OwnerId = ""
+			found = true
+		}
+	}
+
+	waitlist := slices.DeleteFunc(slices.Clone(ei.Waitlist), func(entry EdgegapWaitlistEntry) bool {
+		return slices.Contains(userIds, entry.UserId)
+	})
+	if len(waitlist) != len(ei.Waitlist) {
+		ei.Waitlist = waitlist
+		found = true
+	}
+
+	ei.Reservations = helpers.RemoveElements(ei.Reservations, userIds)
+	ei.Connections = helpers.RemoveElements(ei.Connections, userIds)
+	return found
+}
+
+// forgetInstanceUsers removes the users from the instance and its rental purchase, reporting whether it changed.
+func (sm *StorageManager) forgetInstanceUsers(instance *runtime.InstanceInfo, userIds []string) (bool, error) {
+	ei, err := sm.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return false, err
+	}
+	changed := ei.forgetUsers(userIds)
+	instance.Metadata["edgegap"] = ei
+
+	if purchase, ok := instance.Metadata[InstanceMetadataPurchaseKey].(map[string]any); ok {
+		if userId, _ := purchase["user_id"].(string); slices.Contains(userIds, userId) {
+			purchase["user_id"] = ""
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// PurgeUsers erases the users from every instance record, active and archived, deletes their purchases and create
+// records, and removes the IP address stored in their account metadata. It returns the number of instances and
+// purchases purged.
+func (efm *EdgegapFleetManager) PurgeUsers(ctx context.Context, userIds []string) (int, int, error) {
+	sm := efm.storageManager
+
+	// The whole collection is scanned, the storage index may be capped below the number of records
+	instances, err := sm.listDbInstances(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	purged := make([]string, 0)
+	for _, listed := range instances {
+		if changed, err := sm.forgetInstanceUsers(listed, userIds); err != nil || !changed {
+			continue
+		}
+		if err = efm.purgeInstance(ctx, listed.Id, userIds); err != nil {
+			return len(purged), 0, err
+		}
+		purged = append(purged, listed.Id)
+	}
+	purgedInstances := len(purged)
+
+	purgedPurchases := 0
+	for _, userId := range userIds {
+		purchases, err := efm.purgeUserStorage(ctx, userId)
+		if err != nil {
+			return purgedInstances, purgedPurchases, err
+		}
+		purgedPurchases += purchases
+
+		if err = efm.purgePlayerIp(ctx, userId); err != nil {
+			return purgedInstances, purgedPurchases, err
+		}
+	}
+
+	// The event logs of the purged instances reference the users too, including the removals written by the purge
+	if sm.eventLog {
+		if _, err = sm.scrubInstanceEvents(ctx, purged, userIds); err != nil {
+			return purgedInstances, purgedPurchases, err
+		}
+	}
+
+	efm.logger.Info("Purged fleet data of %d users from %d instances", len(userIds), purgedInstances)
+	return purgedInstances, purgedPurchases, nil
+}
+
+// purgeInstance removes the users from the stored instance, retrying when a concurrent write changed it meanwhile.
+func (efm *EdgegapFleetManager) purgeInstance(ctx context.Context, id string, userIds []string) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		instances, versions, readErr := efm.storageManager.readDbInstancesForUpdate(ctx, id)
+		if readErr != nil {
+			return readErr
+		}
+		instance := instances[id]
+		if instance == nil {
+			return nil
+		}
+
+		changed, forgetErr := efm.storageManager.forgetInstanceUsers(instance, userIds)
+		if forgetErr != nil || !changed {
+			return forgetErr
+		}
+
+		if _, err = efm.storageManager.writeDbInstancesConditional(ctx, []*runtime.InstanceInfo{instance}, versions); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// purgeUserStorage deletes the purchases, create record, player IP, placement preferences and beacon latencies of the
+// user, returning the number of purchases deleted.
+func (efm *EdgegapFleetManager) purgeUserStorage(ctx context.Context, userId string) (int, error) {
+	sm := efm.storageManager
+	deletes := []*runtime.StorageDelete{
+		{
+			Collection: sm.createsCollection,
+			Key:        StorageKeyCreateGuard,
+			UserID:     userId,
+		},
+		{
+			Collection: sm.playersCollection,
+			Key:        StorageKeyPlayerIp,
+			UserID:     userId,
+		},
+		{
+			Collection: sm.playersCollection,
+			Key:        StorageKeyPlacementPreferences,
+			UserID:     userId,
+		},
+		{
+			Collection: sm.playersCollection,
+			Key:        StorageKeyBeaconLatencies,
+			UserID:     userId,
+		},
+	}
+
+	purchases := 0
+	cursor := ""
+	for {
+		objects, nextCursor, err := efm.nk.StorageList(ctx, "", userId, sm.purchasesCollection, 100, cursor)
+		if err != nil {
+			return 0, err
+		}
+		for _, obj := range objects {
+			deletes = append(deletes, &runtime.StorageDelete{
+				Collection: sm.purchasesCollection,
+				Key:        obj.Key,
+				UserID:     userId,
+			})
+			purchases++
+		}
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	return purchases, efm.nk.StorageDelete(ctx, deletes)
+}
+
+// purgePlayerIp removes the IP address stored in the account metadata of the user before the players collection.
+func (efm *EdgegapFleetManager) purgePlayerIp(ctx context.Context, userId string) error {
+	account, err := efm.nk.AccountGetId(ctx, userId)
+	if err != nil {
+		// Accounts already deleted have no metadata left
+		efm.logger.WithField("error", err.Error()).Debug("skipping player ip purge of user %s", userId)
+		return nil
+	}
+
+	var metadata map[string]any
+	if err = json.Unmarshal([]byte(account.GetUser().GetMetadata()), &metadata); err != nil {
+		return err
+	}
+	if _, ok := metadata[PlayerIpMetadataKey]; !ok {
+		return nil
+	}
+	delete(metadata, PlayerIpMetadataKey)
+
+	return efm.nk.AccountUpdateId(ctx, userId, "", metadata, "", "", "", "", "")
+}
+
+// purgeArchivedInstances deletes the archived instances created before the retention period, with their player data.
+func (efm *EdgegapFleetManager) purgeArchivedInstances(ctx context.Context, retention time.Duration) (int, error) {
+	instances, err := efm.storageManager.listDbInstancesByStatus(ctx, archivedStatuses)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	ids := make([]string, 0)
+	for _, instance := range instances {
+		if instance.CreateTime.Before(cutoff) {
+			ids = append(ids, instance.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	return len(ids), efm.storageManager.deleteDbInstances(ctx, ids)
+}
+
+// runRetentionScheduler deletes the archived instances older than the retention period, every hour at most.
+func (efm *EdgegapFleetManager) runRetentionScheduler() {
+	retention, err := time.ParseDuration(efm.edgegapManager.configuration.RetentionPeriod)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to parse retention period, disabling retention")
+		return
+	}
+
+	if retention <= 0 {
+		efm.logger.WithField("duration", retention).Info("Skipping retention scheduler: retention_period set to 0")
+		return
+	}
+
+	retentionFn := func() {
+		purged, err := efm.purgeArchivedInstances(efm.ctx, retention)
+		if err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to purge archived instances")
+			return
+		}
+		if purged > 0 {
+			efm.logger.Info("Retention purged %d archived instances", purged)
+			efm.nk.MetricsCounterAdd("edgegap_retention_purged", nil, int64(purged))
+		}
+	}
+
+	t := time.NewTicker(min(retention, time.Hour))
+	defer t.Stop()
+
+	efm.logger.Info("Starting retention scheduler, keeping archived instances for %s", retention.String())
+	for {
+		select {
+		case <-efm.ctx.Done():
+			return
+		case <-t.C:
+			retentionFn()
+		}
+	}
+}
+
+// purgeUserFleetData admin rpc to erase users from the fleet data, for GDPR deletion requests (S2S only)
+func purgeUserFleetData(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdPurgeUserFleetData); err != nil {
+		return "", err
+	}
+
+	var req *purgeUserFleetDataRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil || len(req.UserIds) == 0 || slices.Contains(req.UserIds, "") {
+		return "", ErrInvalidInput
+	}
+
+	instances, purchases, err := fmInstance.PurgeUsers(ctx, req.UserIds)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to purge fleet data of users")
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return "", runtime.NewError("purge interrupted, call again to complete it", 4) // DEADLINE_EXCEEDED
+		}
+		return "", ErrInternalError
+	}
+
+	reply, err := json.Marshal(purgeUserFleetDataReply{
+		Success:   true,
+		Instances: instances,
+		Purchases: purchases,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(reply), nil
+}
@@ -0,0 +1,54 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// rpcIdKey carries the id of the rpc being called, recorded as the source of the instance events it writes
+type rpcIdKey struct{}
+
+// rpcCaller tags the metrics of a call with its caller: client, server (S2S and server code) or webhook
+func rpcCaller(ctx context.Context, rpcId string) string {
+	switch rpcId {
+	case RpcIdEventDeploymentReady, RpcIdEventDeploymentError, RpcIdEventDeploymentTerminated, RpcIdEventConnection,
+		RpcIdEventInstance, RpcIdEventInstanceUpdate, RpcIdEventServerPing, RpcIdEventWhoami:
+		return "webhook"
+	}
+	if _, isClient := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); isClient {
+		return "client"
+	}
+	return "server"
+}
+
+// rpcErrorCode returns the gRPC status code of an RPC error, 13 (INTERNAL) for plain errors
+func rpcErrorCode(err error) string {
+	var rerr *runtime.Error
+	if errors.As(err, &rerr) {
+		return strconv.Itoa(rerr.Code)
+	}
+	return "13"
+}
+
+// withRpcMetrics records the latency, errors and payload sizes of every call of the RPC, tagged by rpc id and caller.
+// The rpc id is carried in the context of the call.
+func withRpcMetrics(rpcId string, fn rpcFunction) rpcFunction {
+	return func(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+		start := time.Now()
+		reply, err := fn(context.WithValue(ctx, rpcIdKey{}, rpcId), logger, db, nk, payload)
+
+		tags := map[string]string{"rpc": rpcId, "caller": rpcCaller(ctx, rpcId)}
+		nk.MetricsTimerRecord("edgegap_rpc_latency", tags, time.Since(start))
+		nk.MetricsCounterAdd("edgegap_rpc_request_bytes", tags, int64(len(payload)))
+		nk.MetricsCounterAdd("edgegap_rpc_reply_bytes", tags, int64(len(reply)))
+		if err != nil {
+			nk.MetricsCounterAdd("edgegap_rpc_errors", map[string]string{"rpc": rpcId, "caller": tags["caller"], "code": rpcErrorCode(err)}, 1)
+		}
+		return reply, err
+	}
+}
@@ -0,0 +1,248 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// RpcIdSavedQuerySet stores a named instance query (S2S only)
+	RpcIdSavedQuerySet = "saved_query_set"
+	// RpcIdSavedQueryDelete removes a named instance query (S2S only)
+	RpcIdSavedQueryDelete = "saved_query_delete"
+	// RpcIdSavedQueryList lists the named instance queries and the fields of the structured filters
+	RpcIdSavedQueryList = "saved_query_list"
+
+	StorageKeySavedQueries = "edgegap_saved_queries"
+
+	// Queries stored by the RPCs are reloaded at most this often, so every node picks them up
+	savedQueriesRefresh = time.Minute
+)
+
+// ErrorUnknownSavedQuery is returned when instance_list names a saved query that does not exist
+var ErrorUnknownSavedQuery = errors.New("unknown saved query")
+
+// savedQueryName matches the names of the saved queries, e.g. "ranked_open_eu"
+var savedQueryName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// EdgegapSavedQuery is a storage index query named by operators, listed by clients with its name
+type EdgegapSavedQuery struct {
+	Query       string    `json:"query"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type savedQuerySetRequest struct {
+	Name        string `json:"name"`
+	Query       string `json:"query"`
+	Description string `json:"description"`
+}
+
+type savedQueryDeleteRequest struct {
+	Name string `json:"name"`
+}
+
+type savedQueryListReply struct {
+	Queries map[string]EdgegapSavedQuery `json:"queries"`
+	// Fields and Operators document the structured filters of instance_list
+	Fields    []string `json:"fields"`
+	Operators []string `json:"operators"`
+}
+
+// SavedQueries resolves the saved queries of instance_list, cached per node
+type SavedQueries struct {
+	sm     *StorageManager
+	logger runtime.Logger
+
+	mu       sync.Mutex
+	queries  map[string]EdgegapSavedQuery
+	loadedAt time.Time
+}
+
+// NewSavedQueries returns the saved queries, loaded on first use.
+func NewSavedQueries(sm *StorageManager, logger runtime.Logger) *SavedQueries {
+	return &SavedQueries{sm: sm, logger: logger}
+}
+
+// readSavedQueries retrieves the saved queries with the version of their storage object, empty when none are stored.
+func (sm *StorageManager) readSavedQueries(ctx context.Context) (map[string]EdgegapSavedQuery, string, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: StorageCollectionEdgegapVersion,
+		Key:        StorageKeySavedQueries,
+	}})
+	if err != nil {
+		return nil, "", err
+	}
+	queries := make(map[string]EdgegapSavedQuery)
+	if len(objects) == 0 {
+		return queries, "*", nil
+	}
+	if err = json.Unmarshal([]byte(objects[0].Value), &queries); err != nil {
+		return nil, "", err
+	}
+	return queries, objects[0].Version, nil
+}
+
+// writeSavedQueries stores the saved queries, conditional on the version read so concurrent updates are not lost.
+func (sm *StorageManager) writeSavedQueries(ctx context.Context, queries map[string]EdgegapSavedQuery, version string) error {
+	value, err := json.Marshal(queries)
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      StorageCollectionEdgegapVersion,
+		Key:             StorageKeySavedQueries,
+		Value:           string(value),
+		Version:         version,
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}})
+	return err
+}
+
+// all returns the saved queries, reloaded from storage once the refresh elapsed.
+func (s *SavedQueries) all(ctx context.Context) map[string]EdgegapSavedQuery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queries == nil || time.Since(s.loadedAt) > savedQueriesRefresh {
+		s.loadedAt = time.Now()
+		queries, _, err := s.sm.readSavedQueries(ctx)
+		if err != nil {
+			s.logger.WithField("error", err.Error()).Warn("failed to read saved queries")
+		} else {
+			s.queries = queries
+		}
+	}
+	return s.queries
+}
+
+// resolve returns the query of an instance_list request: the saved query followed by the request query.
+func (s *SavedQueries) resolve(ctx context.Context, name, query string) (string, error) {
+	if name == "" {
+		return query, nil
+	}
+	saved, ok := s.all(ctx)[name]
+	if !ok {
+		return "", ErrorUnknownSavedQuery
+	}
+	return strings.TrimSpace(saved.Query + " " + query), nil
+}
+
+// update applies the change to the stored queries and refreshes the cache of this node.
+func (s *SavedQueries) update(ctx context.Context, change func(queries map[string]EdgegapSavedQuery) error) error {
+	queries, version, err := s.sm.readSavedQueries(ctx)
+	if err != nil {
+		return err
+	}
+	if err = change(queries); err != nil {
+		return err
+	}
+	if err = s.sm.writeSavedQueries(ctx, queries, version); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.queries = queries
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// SetSavedQuery admin rpc storing a named query, checked against the storage index first (S2S only)
+func (s *SavedQueries) SetSavedQuery(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdSavedQuerySet); err != nil {
+		return "", err
+	}
+
+	var req *savedQuerySetRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil {
+		return "", ErrInvalidInput
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	if !savedQueryName.MatchString(req.Name) {
+		return "", runtime.NewError("name must be 1 to 64 lowercase letters, digits, _ or -", 3) // INVALID_ARGUMENT
+	}
+	if req.Query == "" {
+		return "", runtime.NewError("query cannot be empty", 3) // INVALID_ARGUMENT
+	}
+	if _, _, err := nk.StorageIndexList(ctx, "", s.sm.collections.index, req.Query, 1, nil, ""); err != nil {
+		return "", runtime.NewError("invalid query: "+err.Error(), 3) // INVALID_ARGUMENT
+	}
+
+	saved := EdgegapSavedQuery{Query: req.Query, Description: req.Description, UpdatedAt: time.Now().UTC()}
+	err := s.update(ctx, func(queries map[string]EdgegapSavedQuery) error {
+		queries[req.Name] = saved
+		return nil
+	})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to store saved query %s", req.Name)
+		return "", runtime.NewError("failed to store saved query, retry", 10) // ABORTED
+	}
+	logger.Info("Saved query %s set: %s", req.Name, req.Query)
+
+	reply, err := json.Marshal(map[string]EdgegapSavedQuery{req.Name: saved})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
+
+// DeleteSavedQuery admin rpc removing a named query, instance_list calls naming it then fail (S2S only)
+func (s *SavedQueries) DeleteSavedQuery(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdSavedQueryDelete); err != nil {
+		return "", err
+	}
+
+	var req *savedQueryDeleteRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil || req.Name == "" {
+		return "", ErrInvalidInput
+	}
+
+	err := s.update(ctx, func(queries map[string]EdgegapSavedQuery) error {
+		if _, ok := queries[req.Name]; !ok {
+			return ErrorUnknownSavedQuery
+		}
+		delete(queries, req.Name)
+		return nil
+	})
+	if errors.Is(err, ErrorUnknownSavedQuery) {
+		return "", runtime.NewError(err.Error()+": "+req.Name, 5) // NOT_FOUND
+	}
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to delete saved query %s", req.Name)
+		return "", runtime.NewError("failed to delete saved query, retry", 10) // ABORTED
+	}
+	logger.Info("Saved query %s deleted", req.Name)
+
+	return "ok", nil
+}
+
+// ListSavedQueries rpc listing the saved queries and the fields and operators of the structured filters of
+// instance_list
+func (s *SavedQueries) ListSavedQueries(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	reply := savedQueryListReply{
+		Queries:   s.all(ctx),
+		Fields:    append(slices.Sorted(maps.Keys(filterFields)), "metadata.<field>"),
+		Operators: []string{FilterOpEq, FilterOpNe, FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte},
+	}
+	if reply.Queries == nil {
+		reply.Queries = make(map[string]EdgegapSavedQuery)
+	}
+
+	replyString, err := json.Marshal(reply)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyString), nil
+}
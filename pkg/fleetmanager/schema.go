@@ -0,0 +1,297 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdRpcSchema serves the OpenAPI document of every RPC payload, to generate typed SDKs
+const RpcIdRpcSchema = "rpc_schema"
+
+// Callers of an RPC, mapped to the OpenAPI security schemes
+const (
+	rpcCallerClient = "client"
+	rpcCallerServer = "server"
+	rpcCallerBoth   = "both"
+)
+
+// rpcReplyOk is the plain "ok" reply of the event RPCs
+type rpcReplyOk string
+
+// rpcPayload describes an RPC, a nil request takes no payload and a nil response replies an untyped JSON object.
+type rpcPayload struct {
+	id       string
+	summary  string
+	caller   string
+	request  any
+	response any
+}
+
+// rpcPayloads lists every registered RPC with its payload types
+var rpcPayloads = []rpcPayload{
+	{RpcIdInstanceSessionList, "List instances", rpcCallerClient, findInstanceSessionRequest{}, instanceSessionListReply{}},
+	{RpcIdInstanceSessionGet, "Get an instance", rpcCallerClient, getInstanceSessionRequest{}, instanceGetReply{}},
+	{RpcIdInstanceSessionCreate, "Create an instance", rpcCallerBoth, createInstanceSessionRequest{}, instanceCreateReply{}},
+	{RpcIdInstanceSessionJoin, "Join an instance", rpcCallerClient, joinInstanceSessionRequest{}, runtime.JoinInfo{}},
+	{RpcIdInstanceWaitlistJoin, "Join an instance or its waitlist", rpcCallerClient, joinInstanceSessionRequest{}, instanceWaitlistJoinReply{}},
+	{RpcIdInstanceSessionStart, "Start a pending instance", rpcCallerClient, startInstanceSessionRequest{}, instanceCreateReply{}},
+	{RpcIdInstanceSeatConfirm, "Confirm connecting to the instance, finalizing the held seat", rpcCallerClient, instanceSeatConfirmRequest{}, instanceSeatConfirmReply{}},
+	{RpcIdWorldRoute, "Route to a persistent world shard", rpcCallerClient, worldRouteRequest{}, worldRouteReply{}},
+	{RpcIdPlacementPreferencesSet, "Store the placement preferences of the user", rpcCallerClient, EdgegapPlacementPreferences{}, EdgegapPlacementPreferences{}},
+	{RpcIdPlacementPreferencesGet, "Get the placement preferences of the user", rpcCallerClient, nil, EdgegapPlacementPreferences{}},
+	{RpcIdSavedQueryList, "List the saved instance queries and the structured filter fields", rpcCallerBoth, nil, savedQueryListReply{}},
+	{RpcIdEdgegapLocations, "List the locations the application can be deployed to", rpcCallerClient, nil, edgegapLocationsReply{}},
+	{RpcIdReportIp, "Store the caller IP for placement", rpcCallerClient, nil, reportIpReply{}},
+	{RpcIdBeaconList, "List the Edgegap beacons to measure", rpcCallerClient, nil, beaconListReply{}},
+	{RpcIdBeaconLatenciesSubmit, "Store the latencies measured to the beacons", rpcCallerClient, beaconLatenciesSubmitRequest{}, beaconLatenciesSubmitReply{}},
+	{RpcIdUpdateEdgegapVersion, "Update the Edgegap version", rpcCallerServer, UpdateEdgegapVersionRequest{}, nil},
+	{RpcIdGetEdgegapVersion, "Get the Edgegap version", rpcCallerServer, nil, nil},
+	{RpcIdVersionRollout, "Report, ramp, complete or abort the rollout of the Edgegap version", rpcCallerServer, versionRolloutRequest{}, versionRolloutReply{}},
+	{RpcIdUpdateEdgegapCredentials, "Rotate the Edgegap API token", rpcCallerServer, UpdateEdgegapCredentialsRequest{}, nil},
+	{RpcIdUpdateNotificationTemplates, "Store the localized notification templates", rpcCallerServer, NotificationTemplatesConfig{}, nil},
+	{RpcIdSavedQuerySet, "Store a named instance query", rpcCallerServer, savedQuerySetRequest{}, nil},
+	{RpcIdSavedQueryDelete, "Remove a named instance query", rpcCallerServer, savedQueryDeleteRequest{}, rpcReplyOk("")},
+	{RpcIdAdminInstanceDelete, "Stop a deployment and remove its instance", rpcCallerServer, adminInstanceDeleteRequest{}, nil},
+	{RpcIdInstanceExtend, "Prolong a deployment", rpcCallerServer, instanceExtendRequest{}, nil},
+	{RpcIdInstanceResendConnectionInfo, "Resend the connection-info notification", rpcCallerServer, instanceResendConnectionInfoRequest{}, nil},
+	{RpcIdInstanceTransfer, "Move users to another instance", rpcCallerServer, instanceTransferRequest{}, nil},
+	{RpcIdPurgeUserFleetData, "Erase users from the fleet data", rpcCallerServer, purgeUserFleetDataRequest{}, purgeUserFleetDataReply{}},
+	{RpcIdInstanceEventLog, "Get the event log of an instance, optionally repairing the instance from it", rpcCallerServer, instanceEventLogRequest{}, instanceEventLogReply{}},
+	{RpcIdEdgegapDeploymentStatus, "Get the raw Edgegap status of a deployment", rpcCallerServer, edgegapDeploymentStatusRequest{}, edgegapDeploymentStatusReply{}},
+	{RpcIdAuditLogList, "List the fleet mutations of the audit log", rpcCallerServer, auditLogListRequest{}, auditLogListReply{}},
+	{RpcIdDeadLetterList, "List the webhook payloads that failed to parse", rpcCallerServer, deadLetterListRequest{}, deadLetterListReply{}},
+	{RpcIdDeadLetterReplay, "Replay or discard a webhook payload that failed to parse", rpcCallerServer, deadLetterReplayRequest{}, deadLetterReplayReply{}},
+	{RpcIdAdminReplayEvent, "Re-process a dead letter or a historical webhook payload", rpcCallerServer, adminReplayEventRequest{}, adminReplayEventReply{}},
+	{RpcIdFleetTeardown, "Stop every active deployment matching a query and purge their records", rpcCallerServer, fleetTeardownRequest{}, fleetTeardownReply{}},
+	{RpcIdFleetTeardownStatus, "Report the progress of the last fleet teardown", rpcCallerServer, nil, EdgegapTeardownJob{}},
+	{RpcIdFleetLoadTest, "Simulate create, join and connection event cycles against storage", rpcCallerServer, fleetLoadTestRequest{}, fleetLoadTestReply{}},
+	{RpcIdFleetStats, "Report the fleet statistics", rpcCallerServer, nil, fleetStatsReply{}},
+	{RpcIdFleetDailyStats, "Report the daily aggregates of the fleet events", rpcCallerServer, fleetDailyStatsRequest{}, fleetDailyStatsReply{}},
+	{RpcIdAdminPersistentCreate, "Create a persistent instance", rpcCallerServer, adminPersistentCreateRequest{}, nil},
+	{RpcIdAdminPersistentMigrate, "Migrate a persistent instance", rpcCallerServer, adminPersistentMigrateRequest{}, nil},
+	{RpcIdEventDeploymentReady, "Edgegap deployment ready webhook", rpcCallerServer, EdgegapDeploymentStatus{}, rpcReplyOk("")},
+	{RpcIdEventDeploymentError, "Edgegap deployment error webhook", rpcCallerServer, EdgegapDeploymentStatus{}, rpcReplyOk("")},
+	{RpcIdEventDeploymentTerminated, "Edgegap deployment terminated webhook", rpcCallerServer, EdgegapDeploymentStatus{}, rpcReplyOk("")},
+	{RpcIdEventConnection, "Game server connection event", rpcCallerServer, ConnectionEventMessage{}, rpcReplyOk("")},
+	{RpcIdEventInstance, "Game server instance event", rpcCallerServer, InstanceEventMessage{}, rpcReplyOk("")},
+	{RpcIdEventInstanceUpdate, "Game server player count and metadata update", rpcCallerServer, InstanceUpdateMessage{}, rpcReplyOk("")},
+	{RpcIdEventServerPing, "Game server ping", rpcCallerServer, ServerPingMessage{}, ServerPingReply{}},
+	{RpcIdEventWhoami, "Game server self identification", rpcCallerServer, WhoamiMessage{}, WhoamiReply{}},
+	{RpcIdRpcSchema, "OpenAPI document of the RPC payloads", rpcCallerServer, nil, nil},
+	{RpcIdConsoleInstances, "List the active instances as a console table", rpcCallerServer, consoleListRequest{}, consoleTable{}},
+	{RpcIdConsoleErrors, "List the recent instance errors and dead letters as a console table", rpcCallerServer, consoleListRequest{}, consoleTable{}},
+	{RpcIdConsoleVersion, "Report the Edgegap version state as a console table", rpcCallerServer, nil, consoleTable{}},
+}
+
+// outboundPayloads lists the payloads posted by the plugin, documented as OpenAPI webhooks
+var outboundPayloads = []rpcPayload{
+	{"webhook", "Outbound webhook to NAKAMA_WEBHOOK_URLS", rpcCallerServer, WebhookMessage{}, nil},
+	{"game_server_callback", "Event posted to the game server callback_url", rpcCallerServer, GameServerCallback{}, nil},
+}
+
+// schemaGenerator derives JSON schemas from the payload types, following their json tags
+type schemaGenerator struct {
+	components map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaName exports the name of the payload type for the OpenAPI components.
+func schemaName(t reflect.Type) string {
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+// schema returns the JSON schema of the type, structs are referenced from the components.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(rpcReplyOk("")):
+		return map[string]any{"type": "string", "const": "ok"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]any{"type": "object"}
+		}
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return map[string]any{"type": "object", "properties": g.properties(t)}
+		}
+		name := schemaName(t)
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // Registered first so recursive types terminate
+			g.components[name] = map[string]any{"type": "object", "properties": g.properties(t)}
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+
+	return map[string]any{}
+}
+
+// properties lists the JSON fields of the struct, embedded structs without a json name are flattened.
+func (g *schemaGenerator) properties(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for k, v := range g.properties(embedded) {
+					properties[k] = v
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+	}
+	return properties
+}
+
+// body returns the OpenAPI media of a payload, an untyped object when the payload has no type.
+func (g *schemaGenerator) body(payload any) map[string]any {
+	schema := map[string]any{"type": "object"}
+	if payload != nil {
+		schema = g.schema(reflect.TypeOf(payload))
+	}
+	return map[string]any{"content": map[string]any{"application/json": map[string]any{"schema": schema}}}
+}
+
+// operation returns the OpenAPI operation of an RPC, called through /v2/rpc/<id> with the unwrap query parameter so
+// the payloads are sent as is.
+func (g *schemaGenerator) operation(rpc rpcPayload) map[string]any {
+	security := []any{}
+	if rpc.caller != rpcCallerServer {
+		security = append(security, map[string]any{"session": []string{}})
+	}
+	if rpc.caller != rpcCallerClient {
+		security = append(security, map[string]any{"httpKey": []string{}})
+	}
+
+	response := g.body(rpc.response)
+	response["description"] = "OK"
+	operation := map[string]any{
+		"operationId": rpc.id,
+		"summary":     rpc.summary,
+		"security":    security,
+		"parameters": []any{map[string]any{
+			"name":            "unwrap",
+			"in":              "query",
+			"required":        true,
+			"allowEmptyValue": true,
+			"schema":          map[string]any{"type": "boolean"},
+		}},
+		"responses": map[string]any{"200": response},
+	}
+	if rpc.request != nil {
+		operation["requestBody"] = g.body(rpc.request)
+	}
+	return operation
+}
+
+// openApiDocument generates the OpenAPI 3.1 document of the RPCs and outbound payloads.
+func openApiDocument() map[string]any {
+	g := &schemaGenerator{components: make(map[string]any)}
+
+	paths := make(map[string]any, len(rpcPayloads))
+	for _, rpc := range rpcPayloads {
+		paths["/v2/rpc/"+rpc.id] = map[string]any{"post": g.operation(rpc)}
+	}
+
+	// The "edgegap" key of the untyped instance metadata is documented on its own
+	g.schema(reflect.TypeOf(EdgegapInstanceInfo{}))
+
+	webhooks := make(map[string]any, len(outboundPayloads))
+	for _, payload := range outboundPayloads {
+		webhooks[payload.id] = map[string]any{"post": map[string]any{
+			"summary":     payload.summary,
+			"requestBody": g.body(payload.request),
+			"responses":   map[string]any{"200": map[string]any{"description": "OK"}},
+		}}
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Nakama Edgegap Fleet Manager RPCs",
+			"version": "1.0.0",
+		},
+		"paths":    paths,
+		"webhooks": webhooks,
+		"components": map[string]any{
+			"schemas": g.components,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{"type": "http", "scheme": "bearer", "description": "Nakama session token"},
+				"httpKey": map[string]any{"type": "apiKey", "in": "query", "name": "http_key", "description": "Nakama HTTP key, server to server"},
+			},
+		},
+	}
+}
+
+var (
+	openApiOnce sync.Once
+	openApiJson string
+	openApiErr  error
+)
+
+// rpcSchema S2S rpc serving the OpenAPI document of the RPC payloads, generated from the payload types
+func rpcSchema(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdRpcSchema); err != nil {
+		return "", err
+	}
+
+	openApiOnce.Do(func() {
+		var document []byte
+		document, openApiErr = json.Marshal(openApiDocument())
+		openApiJson = string(document)
+	})
+	if openApiErr != nil {
+		logger.WithField("error", openApiErr.Error()).Error("failed to generate the rpc schema")
+		return "", ErrInternalError
+	}
+
+	return openApiJson, nil
+}
@@ -0,0 +1,189 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// RpcIdInstanceSeatConfirm lets a player confirm they are connecting to the instance, finalizing their held seat
+const RpcIdInstanceSeatConfirm = "instance_seat_confirm"
+
+type instanceSeatConfirmRequest struct {
+	InstanceId string `json:"instance_id"`
+}
+
+type instanceSeatConfirmReply struct {
+	InstanceId string `json:"instance_id"`
+	Confirmed  bool   `json:"confirmed"`
+}
+
+// seatHoldTtl returns how long a player holds their seat once the connection info is delivered, 0 if seats are not held.
+func seatHoldTtl(config *EdgegapManagerConfiguration) time.Duration {
+	ttl, _ := time.ParseDuration(config.SeatHoldTtl)
+	return max(ttl, 0)
+}
+
+// holdSeats moves the reservations of the users to pending connect until the hold expires, confirmed seats are kept.
+func (ei *EdgegapInstanceInfo) holdSeats(userIds []string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	if ei.SeatHolds == nil {
+		ei.SeatHolds = make(map[string]time.Time)
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl)
+	for _, userId := range userIds {
+		if slices.Contains(ei.Reservations, userId) && !slices.Contains(ei.ConfirmedSeats, userId) {
+			ei.SeatHolds[userId] = expiresAt
+		}
+	}
+}
+
+// isSeatHeld reports whether the reservation of the user is pending connect or confirmed, such seats are never bumped.
+func (ei *EdgegapInstanceInfo) isSeatHeld(userId string) bool {
+	_, held := ei.SeatHolds[userId]
+	return held || slices.Contains(ei.ConfirmedSeats, userId)
+}
+
+// confirmSeat finalizes the held seat of the user, kept until the connection event or the reservation expiry.
+func (ei *EdgegapInstanceInfo) confirmSeat(userId string) bool {
+	if slices.Contains(ei.ConfirmedSeats, userId) {
+		return true
+	}
+	if _, held := ei.SeatHolds[userId]; !held {
+		return false
+	}
+
+	delete(ei.SeatHolds, userId)
+	ei.ConfirmedSeats = append(ei.ConfirmedSeats, userId)
+	return true
+}
+
+// releaseExpiredSeatHolds frees the seats whose hold expired unconfirmed, counted as expired reservations.
+func (ei *EdgegapInstanceInfo) releaseExpiredSeatHolds(now time.Time) []string {
+	released := make([]string, 0)
+	for userId, expiresAt := range ei.SeatHolds {
+		if now.After(expiresAt) {
+			released = append(released, userId)
+			delete(ei.SeatHolds, userId)
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+
+	ei.Reservations = helpers.RemoveElements(ei.Reservations, released)
+	ei.ReservationsExpired += len(released)
+	ei.ReservationsUpdatedAt = now
+	return released
+}
+
+// syncSeatHolds forgets the holds of reservations consumed or expired, and indexes the earliest hold expiry.
+func (ei *EdgegapInstanceInfo) syncSeatHolds() {
+	ei.SeatHoldExpiresAt = nil
+	for userId, expiresAt := range ei.SeatHolds {
+		if !slices.Contains(ei.Reservations, userId) {
+			delete(ei.SeatHolds, userId)
+			continue
+		}
+		if ei.SeatHoldExpiresAt == nil || expiresAt.Before(*ei.SeatHoldExpiresAt) {
+			ei.SeatHoldExpiresAt = &expiresAt
+		}
+	}
+	ei.ConfirmedSeats = slices.DeleteFunc(ei.ConfirmedSeats, func(userId string) bool {
+		return !slices.Contains(ei.Reservations, userId)
+	})
+}
+
+// releaseExpiredSeatHolds frees the seats of players who neither confirmed nor connected within the hold, promoting the
+// waitlist into them.
+func (efm *EdgegapFleetManager) releaseExpiredSeatHolds() {
+	now := time.Now().UTC()
+	query := fmt.Sprintf("+value.metadata.edgegap.seat_hold_expires_at:<\"%s\"", now.Format(time.RFC3339))
+	objects, err := efm.storageManager.listIndexes(efm.ctx, query, 1_000)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to list expired seat holds")
+		return
+	}
+
+	for _, so := range objects {
+		// Read the stored copy, the write is conditional on its version so a concurrent join or connection wins
+		efm.storageManager.InvalidateInstance(so.Key)
+		instance, err := efm.storageManager.getDbInstance(efm.ctx, so.Key)
+		if err != nil || instance == nil {
+			continue
+		}
+		ei, err := efm.storageManager.ExtractEdgegapInstance(instance)
+		if err != nil {
+			continue
+		}
+
+		released := ei.releaseExpiredSeatHolds(now)
+		if len(released) == 0 {
+			continue
+		}
+		promoted := ei.promoteWaitlist()
+		instance.Metadata["edgegap"] = ei
+		if err = efm.storageManager.updateDbInstance(efm.ctx, instance); err != nil {
+			efm.logger.WithField("error", err.Error()).Error("failed to release expired seat holds of instance %s", instance.Id)
+			continue
+		}
+
+		efm.logger.Info("Released %d unconfirmed seats of instance %s", len(released), instance.Id)
+		reportReservationOutcomes(efm.nk, ei, ReservationOutcomeExpired, len(released))
+		if len(promoted) > 0 {
+			notifyWaitlistPromoted(efm.ctx, efm.logger, efm.nk, instance.Id, promoted)
+		}
+	}
+}
+
+// confirmInstanceSeat client rpc confirming the caller is connecting to the instance, their held seat is then kept until
+// the game server reports the connection or the reservation expires
+func (eem *EdgegapEventManager) confirmInstanceSeat(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok || userId == "" {
+		return "", ErrInvalidInput
+	}
+
+	var req *instanceSeatConfirmRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil || req.InstanceId == "" {
+		return "", ErrInvalidInput
+	}
+
+	// Pending connection events go first, the player may already be connected
+	eem.writes.flush(req.InstanceId)
+	eem.sm.InvalidateInstance(req.InstanceId)
+	instance, err := eem.sm.getDbInstance(ctx, req.InstanceId)
+	if err != nil || instance == nil {
+		return "", runtime.NewError("instance not found", 5) // NOT_FOUND
+	}
+	ei, err := eem.sm.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	if !slices.Contains(ei.Connections, userId) && !slices.Contains(ei.ConfirmedSeats, userId) {
+		if !ei.confirmSeat(userId) {
+			return "", runtime.NewError("no held seat on this instance, the hold expired or the seat was not reserved", 9) // FAILED_PRECONDITION
+		}
+		instance.Metadata["edgegap"] = ei
+		if err = eem.sm.updateDbInstance(ctx, instance); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to confirm seat on instance %s", req.InstanceId)
+			return "", runtime.NewError("seat confirmation conflicted with another update, retry", 10) // ABORTED
+		}
+	}
+
+	reply, err := json.Marshal(instanceSeatConfirmReply{InstanceId: req.InstanceId, Confirmed: true})
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(reply), nil
+}
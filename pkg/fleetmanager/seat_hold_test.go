@@ -0,0 +1,121 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestJoinHoldsSeats(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	node := newFakeFleetManager(nk, &EdgegapManagerConfiguration{SeatHoldTtl: "1m"})
+	if _, err := node.storageManager.createDbInstance(ctx, "id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := node.Join(ctx, "id", []string{"a", "b"}, nil); err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+	stored, err := node.storageManager.getDbInstance(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ei := stored.Metadata["edgegap"].(*EdgegapInstanceInfo)
+	for _, userId := range []string{"a", "b"} {
+		if expiresAt, ok := ei.SeatHolds[userId]; !ok || time.Until(expiresAt) <= 0 || time.Until(expiresAt) > time.Minute {
+			t.Errorf("seat hold of %s = %v, %v, want within a minute", userId, expiresAt, ok)
+		}
+	}
+	if ei.SeatHoldExpiresAt == nil {
+		t.Error("earliest seat hold expiry not indexed")
+	}
+}
+
+func TestConfirmInstanceSeat(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+	eem := &EdgegapEventManager{config: &EdgegapManagerConfiguration{}, sm: sm, writes: newWriteCoalescer(ctx, fakeLogger{}, sm, 0)}
+	ei := EdgegapInstanceInfo{
+		MaxPlayers:   4,
+		Reservations: []string{"held", "expired"},
+		SeatHolds:    map[string]time.Time{"held": time.Now().UTC().Add(time.Minute)},
+	}
+	if _, err := sm.createDbInstance(ctx, "id", EdgegapStatusReady, ei, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		userId   string
+		wantCode int
+	}{
+		{name: "held seat", userId: "held"},
+		{name: "confirmed seat", userId: "held"},
+		{name: "hold released", userId: "expired", wantCode: 9},
+		{name: "no reservation", userId: "other", wantCode: 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userCtx := context.WithValue(ctx, runtime.RUNTIME_CTX_USER_ID, tt.userId)
+			_, err := eem.confirmInstanceSeat(userCtx, fakeLogger{}, nil, nk, `{"instance_id": "id"}`)
+			var rerr *runtime.Error
+			switch {
+			case tt.wantCode == 0 && err != nil:
+				t.Fatalf("confirmInstanceSeat() error = %v", err)
+			case tt.wantCode != 0 && (!errors.As(err, &rerr) || rerr.Code != tt.wantCode):
+				t.Fatalf("confirmInstanceSeat() error = %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+
+	stored, err := sm.getDbInstance(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := stored.Metadata["edgegap"].(*EdgegapInstanceInfo)
+	if _, held := got.SeatHolds["held"]; held || !slices.Equal(got.ConfirmedSeats, []string{"held"}) {
+		t.Errorf("seat holds = %v, confirmed seats = %v, want held confirmed", got.SeatHolds, got.ConfirmedSeats)
+	}
+}
+
+func TestReleaseExpiredSeatHolds(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	node := newFakeFleetManager(nk, &EdgegapManagerConfiguration{})
+	sm := node.storageManager
+	nk.indexes[sm.collections.index] = sm.collections.instances
+
+	now := time.Now().UTC()
+	ei := EdgegapInstanceInfo{
+		MaxPlayers:     4,
+		Reservations:   []string{"expired", "held", "confirmed"},
+		SeatHolds:      map[string]time.Time{"expired": now.Add(-time.Second), "held": now.Add(time.Minute)},
+		ConfirmedSeats: []string{"confirmed"},
+	}
+	if _, err := sm.createDbInstance(ctx, "id", EdgegapStatusReady, ei, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	node.releaseExpiredSeatHolds()
+
+	stored, err := sm.getDbInstance(ctx, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := stored.Metadata["edgegap"].(*EdgegapInstanceInfo)
+	if !slices.Equal(got.Reservations, []string{"held", "confirmed"}) {
+		t.Errorf("reservations = %v, want [held confirmed]", got.Reservations)
+	}
+	if got.ReservationsExpired != 1 {
+		t.Errorf("expired reservations = %d, want 1", got.ReservationsExpired)
+	}
+	if _, ok := got.SeatHolds["held"]; !ok || len(got.SeatHolds) != 1 {
+		t.Errorf("seat holds = %v, want the hold of held only", got.SeatHolds)
+	}
+}
@@ -0,0 +1,51 @@
+package fleetmanager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// CallbackMetadataSeatsKey holds the seat index of each user in the CreateSuccess callback metadata
+const CallbackMetadataSeatsKey = "seats"
+
+// reservationToken derives the token a user presents to the game server to claim its seat.
+// It is stable for a user on an instance and can be recomputed from the Nakama HTTP key, so it is never stored.
+func reservationToken(secret, instanceId, userId string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(instanceId + ":" + userId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sessionInfos builds the SessionInfo of each user, using the reservation token as session ID.
+func sessionInfos(secret, instanceId string, userIds []string) []*runtime.SessionInfo {
+	sessions := make([]*runtime.SessionInfo, 0, len(userIds))
+	for _, userId := range userIds {
+		sessions = append(sessions, &runtime.SessionInfo{
+			UserId:    userId,
+			SessionId: reservationToken(secret, instanceId, userId),
+		})
+	}
+	return sessions
+}
+
+// createSuccessSessions returns the SessionInfo and seat assignment of every user holding a seat on the instance,
+// connected users first then reservations, in arrival order.
+func createSuccessSessions(secret string, instanceId string, ei *EdgegapInstanceInfo) ([]*runtime.SessionInfo, map[string]any) {
+	userIds := slices.Clone(ei.Connections)
+	for _, userId := range ei.Reservations {
+		if !slices.Contains(userIds, userId) {
+			userIds = append(userIds, userId)
+		}
+	}
+
+	seats := make(map[string]int, len(userIds))
+	for i, userId := range userIds {
+		seats[userId] = i
+	}
+
+	return sessionInfos(secret, instanceId, userIds), map[string]any{CallbackMetadataSeatsKey: seats}
+}
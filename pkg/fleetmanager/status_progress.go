@@ -0,0 +1,58 @@
+package fleetmanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// estimateRemaining estimates the time left before an instance requested elapsed ago is ready, from the median
+// time-to-ready, or the 90th percentile once the median is exceeded. It is 0 without samples or past both.
+func estimateRemaining(stats *EdgegapReadyStats, elapsed time.Duration) time.Duration {
+	if stats == nil || stats.Count == 0 {
+		return 0
+	}
+	for _, p := range []int64{stats.P50, stats.P90} {
+		if remaining := time.Duration(p)*time.Millisecond - elapsed; remaining > 0 {
+			return remaining
+		}
+	}
+	return 0
+}
+
+// statusChangedContent is the content of the status-changed notification of the instance.
+func statusChangedContent(instance *runtime.InstanceInfo, elapsed, remaining time.Duration) func(string) map[string]interface{} {
+	return func(string) map[string]interface{} {
+		return map[string]interface{}{
+			"InstanceId":           instance.Id,
+			"Status":               instance.Status,
+			"ElapsedMs":            elapsed.Milliseconds(),
+			"EstimatedRemainingMs": remaining.Milliseconds(),
+		}
+	}
+}
+
+// notifyStatusChanged sends the intermediate status of the instance to its reserved users, REQUESTED once the
+// deployment is requested and RUNNING once its container is up, with the estimated time left before READY.
+func notifyStatusChanged(ctx context.Context, logger runtime.Logger, nk runtime.NakamaModule, sm *StorageManager, instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) {
+	if len(ei.Reservations) == 0 {
+		return
+	}
+
+	requestedAt := ei.RequestedAt
+	if requestedAt.IsZero() {
+		requestedAt = instance.CreateTime
+	}
+	elapsed := time.Since(requestedAt)
+
+	stats, _, err := sm.ReadReadyStats(ctx)
+	if err != nil {
+		logger.WithField("error", err.Error()).Warn("failed to read time to ready for status notification")
+	}
+
+	err = sendNotifications(ctx, logger, nk, instance, "status-changed", notificationStatusChanged, ei.Reservations, statusChangedContent(instance, elapsed, estimateRemaining(stats, elapsed)))
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to send notification")
+	}
+}
@@ -4,24 +4,37 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/heroiclabs/nakama-common/api"
 	"github.com/heroiclabs/nakama-common/runtime"
 )
 
 // ErrorNoVersionFound is returned when no Edgegap version is found in storage
 var ErrorNoVersionFound = errors.New("no Edgegap version found in storage")
 
-// Constants for storage collection and index names
+// ErrorNoCredentialsFound is returned when no Edgegap credentials are found in storage
+var ErrorNoCredentialsFound = errors.New("no Edgegap credentials found in storage")
+
+// Constants for storage collection and index names, the defaults when no storage prefix is configured
 const (
-	StorageEdgegapIndex               = "_edgegap_instances_idx"
-	StorageEdgegapInstancesCollection = "_edgegap_instances"
+	StorageEdgegapPrefix              = "_edgegap"
+	StorageEdgegapIndex               = StorageEdgegapPrefix + "_instances_idx"
+	StorageEdgegapInstancesCollection = StorageEdgegapPrefix + "_instances"
 	StorageCollectionEdgegapVersion   = "system"
 	StorageKeyEdgegapVersion          = "edgegap_version"
+	StorageKeyEdgegapCredentials      = "edgegap_credentials"
 )
 
 // Constants representing different statuses of an Edgegap instance
 const (
+	EdgegapStatusPending    = "PENDING"
+	EdgegapStatusStarting   = "STARTING"
 	EdgegapStatusRequested  = "REQUESTED"
 	EdgegapStatusRunning    = "RUNNING"
 	EdgegapStatusReady      = "READY"
@@ -31,40 +44,158 @@ const (
 	EdgegapStatusTerminated = "TERMINATED"
 )
 
+// reconciledStatuses are the statuses of instances backed by an Edgegap deployment
+var reconciledStatuses = []string{
+	EdgegapStatusRequested,
+	EdgegapStatusRunning,
+	EdgegapStatusReady,
+	EdgegapStatusStopping,
+	EdgegapStatusError,
+	EdgegapStatusUnknown,
+	EdgegapStatusTerminated,
+}
+
 // StorageManager handles interactions with Nakama's storage system
 type StorageManager struct {
 	nk     runtime.NakamaModule
 	logger runtime.Logger
+	cache  *instanceCache
+	recent *recentWrites
+	audit  *auditLog
+	// dailyStats aggregates the fleet events by day, nil when disabled
+	dailyStats *dailyStats
+
+	playerIpKey string
+
+	// collections names the instance storage of the default tenant, tenantCollections that of the other tenants, and
+	// tenantsCollection maps the IDs of their instances to their tenant
+	prefix               string
+	collections          instanceCollections
+	tenantCollections    map[string]instanceCollections
+	tenantsCollection    string
+	tenants              tenantLookup
+	purchasesCollection  string
+	createsCollection    string
+	playersCollection    string
+	auditCollection      string
+	deadLetterCollection string
+	locksCollection      string
+	dailyStatsCollection string
+
+	// codec encodes the user lists of the stored instances, plain JSON when empty, splitUsers moves them to the
+	// companion objects of the users collection instead
+	codec           string
+	splitUsers      bool
+	packConnections bool
+	// eventLog appends the changes of every instance write to the event log of the instance
+	eventLog bool
 }
 
 // NewStorageManager creates a new StorageManager instance
 func NewStorageManager(nk runtime.NakamaModule, logger runtime.Logger) *StorageManager {
-	return &StorageManager{
+	sm := &StorageManager{
 		nk:     nk,
 		logger: logger,
 	}
+	sm.SetStoragePrefix(StorageEdgegapPrefix)
+	return sm
+}
+
+// SetStoragePrefix names the instances collection, its index, the purchases, creates and players collections after
+// the prefix. Tenants set afterwards are named after it too.
+func (sm *StorageManager) SetStoragePrefix(prefix string) {
+	sm.prefix = prefix
+	sm.collections = newInstanceCollections(prefix)
+	sm.tenantsCollection = prefix + "_instance_tenants"
+	sm.purchasesCollection = prefix + "_purchases"
+	sm.createsCollection = prefix + "_creates"
+	sm.playersCollection = prefix + "_players"
+	sm.auditCollection = prefix + "_audit"
+	sm.deadLetterCollection = prefix + "_dead_letters"
+	sm.locksCollection = prefix + "_locks"
+	sm.dailyStatsCollection = prefix + "_daily_stats"
+}
+
+// SetPlayerIpKey sets the key decrypting the player IPs encrypted at rest.
+func (sm *StorageManager) SetPlayerIpKey(key string) {
+	sm.playerIpKey = key
+}
+
+// EnableInstanceCache caches instance records read by ID for ttl, up to size instances per node.
+func (sm *StorageManager) EnableInstanceCache(ttl time.Duration, size int) {
+	if ttl <= 0 || size <= 0 {
+		return
+	}
+	sm.cache = newInstanceCache(ttl, size)
+	sm.logger.Info("Caching up to %d instances for %s", size, ttl.String())
+}
+
+// InvalidateInstance drops the cached copy of an instance, so the next read hits storage.
+func (sm *StorageManager) InvalidateInstance(id string) {
+	sm.cache.invalidate(id)
 }
 
 // ExtractEdgegapInstance extracts Edgegap-related data from an instance's metadata.
 func (sm *StorageManager) ExtractEdgegapInstance(instance *runtime.InstanceInfo) (*EdgegapInstanceInfo, error) {
+	return extractEdgegapInstance(instance)
+}
+
+// extractEdgegapInstance returns the typed edgegap metadata, decoding it only for instances not read by decodeInstance.
+func extractEdgegapInstance(instance *runtime.InstanceInfo) (*EdgegapInstanceInfo, error) {
 	// Check if metadata contains "edgegap" key
 	value, ok := instance.Metadata["edgegap"]
 	if !ok {
 		return nil, errors.New("edgegap key not in metadata")
 	}
 
+	// Instances updated in memory already carry the typed struct, only stored instances need decoding
+	switch ei := value.(type) {
+	case *EdgegapInstanceInfo:
+		return ei, nil
+	case EdgegapInstanceInfo:
+		return &ei, nil
+	}
+
 	// Convert metadata to JSON and unmarshal into EdgegapInstanceInfo struct
 	valueString, err := json.Marshal(value)
 	if err != nil {
 		return nil, err
 	}
 
-	var edgegapInstance EdgegapInstanceInfo
-	if err := json.Unmarshal(valueString, &edgegapInstance); err != nil {
+	return unmarshalEdgegapInstance(valueString)
+}
+
+// decodeInstance decodes a stored instance with its edgegap metadata as the typed EdgegapInstanceInfo,
+// so it is decoded once per read instead of being re-marshaled by every ExtractEdgegapInstance.
+func decodeInstance(value string) (*runtime.InstanceInfo, error) {
+	var record struct {
+		runtime.InstanceInfo
+		Metadata map[string]json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
 		return nil, err
 	}
 
-	return &edgegapInstance, nil
+	instance := record.InstanceInfo
+	instance.Metadata = make(map[string]any, len(record.Metadata))
+	for k, raw := range record.Metadata {
+		if k == "edgegap" {
+			ei, err := unmarshalEdgegapInstance(raw)
+			if err != nil {
+				return nil, err
+			}
+			instance.Metadata[k] = ei
+			continue
+		}
+
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		instance.Metadata[k] = v
+	}
+
+	return &instance, nil
 }
 
 // SyncInstance synchronizes Edgegap instance metadata, including player count and available seats.
@@ -76,17 +207,27 @@ func (sm *StorageManager) SyncInstance(instance *runtime.InstanceInfo) error {
 		return err
 	}
 
-	// Get available seats in the session
-	availableSeat, err := sm.GetAvailableSeat(instance)
-	if err != nil {
-		return err
+	// Counts cannot be computed without the user lists of a split instance
+	if edgegapInstance.detached {
+		return ErrorInstanceUsersDetached
 	}
 
+	// Records are always written with the current schema, older ones were migrated when decoded
+	edgegapInstance.SchemaVersion = InstanceSchemaVersion
+
 	// Update player count and available seats
-	instance.PlayerCount = len(edgegapInstance.Connections)
-	edgegapInstance.AvailableSeats = availableSeat
+	instance.PlayerCount = max(len(edgegapInstance.Connections), edgegapInstance.ReportedPlayerCount)
+	edgegapInstance.AvailableSeats = edgegapInstance.availableSeats()
 	edgegapInstance.ReservationsCount = len(edgegapInstance.Reservations)
 
+	// Forget priorities of reservations that were consumed or expired
+	for userId := range edgegapInstance.ReservationPriorities {
+		if !slices.Contains(edgegapInstance.Reservations, userId) {
+			delete(edgegapInstance.ReservationPriorities, userId)
+		}
+	}
+	edgegapInstance.syncSeatHolds()
+
 	// Save updated metadata back into the instance
 	instance.Metadata["edgegap"] = edgegapInstance
 
@@ -100,23 +241,133 @@ func (sm *StorageManager) GetAvailableSeat(instance *runtime.InstanceInfo) (int,
 		return 0, err
 	}
 
-	// Calculate available seats based on max players and reservations
-	if edgegapInstance.MaxPlayers > 0 {
-		return edgegapInstance.MaxPlayers - len(edgegapInstance.Reservations) - len(edgegapInstance.Connections), nil
+	return edgegapInstance.availableSeats(), nil
+}
+
+// availableSeats calculates the number of available seats based on max players and reservations,
+// -1 if max players is not set.
+func (ei *EdgegapInstanceInfo) availableSeats() int {
+	if ei.Persistent && ei.SoftCap > 0 {
+		return max(ei.SoftCap-len(ei.Reservations)-len(ei.Connections), 0)
 	}
 
-	// Return -1 if maxPlayers is not set
-	return -1, nil
+	if ei.MaxPlayers > 0 {
+		return ei.MaxPlayers - len(ei.Reservations) - len(ei.Connections)
+	}
+
+	return -1
 }
 
-// WriteEdgegapVersion stores the Edgegap version in storage
+// WriteEdgegapVersion stores the Edgegap version in storage, incrementing its revision so the version caches of the
+// other nodes drop their copy. The write is conditional on the revision read, and retried when a concurrent update won.
 func (sm *StorageManager) WriteEdgegapVersion(ctx context.Context, version string) error {
-	versionData := map[string]interface{}{
-		"version":    version,
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		objects, readErr := sm.nk.StorageRead(ctx, []*runtime.StorageRead{
+			{
+				Collection: StorageCollectionEdgegapVersion,
+				Key:        StorageKeyEdgegapVersion,
+			},
+		})
+		if readErr != nil {
+			return readErr
+		}
+
+		objectVersion := "*"
+		var revision int64
+		if len(objects) > 0 {
+			objectVersion = objects[0].Version
+			var storedData map[string]interface{}
+			if json.Unmarshal([]byte(objects[0].Value), &storedData) == nil {
+				if stored, ok := storedData["revision"].(float64); ok {
+					revision = int64(stored)
+				}
+			}
+		}
+
+		versionData := map[string]interface{}{
+			"version":    version,
+			"revision":   revision + 1,
+			"updated_at": time.Now().Unix(),
+		}
+
+		versionDataBytes, marshalErr := json.Marshal(versionData)
+		if marshalErr != nil {
+			return marshalErr
+		}
+
+		if _, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{
+			{
+				Collection:      StorageCollectionEdgegapVersion,
+				Key:             StorageKeyEdgegapVersion,
+				Value:           string(versionDataBytes),
+				Version:         objectVersion,
+				PermissionRead:  2, // Public read
+				PermissionWrite: 0, // No write from clients
+			},
+		}); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// ReadEdgegapVersion retrieves the Edgegap version from storage
+func (sm *StorageManager) ReadEdgegapVersion(ctx context.Context) (string, int64, error) {
+	version, updatedAt, _, err := sm.readEdgegapVersionRevision(ctx)
+	return version, updatedAt, err
+}
+
+// readEdgegapVersionRevision retrieves the Edgegap version from storage with its revision, 0 if stored before revisions.
+func (sm *StorageManager) readEdgegapVersionRevision(ctx context.Context) (string, int64, int64, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{
+		{
+			Collection: StorageCollectionEdgegapVersion,
+			Key:        StorageKeyEdgegapVersion,
+		},
+	})
+
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	if len(objects) == 0 {
+		return "", 0, 0, ErrorNoVersionFound
+	}
+
+	// Parse stored version
+	var storedData map[string]interface{}
+	if err := json.Unmarshal([]byte(objects[0].Value), &storedData); err != nil {
+		return "", 0, 0, err
+	}
+
+	version, ok := storedData["version"].(string)
+	if !ok || version == "" {
+		return "", 0, 0, errors.New("invalid Edgegap version format in storage")
+	}
+
+	var updatedAt int64
+	if timestamp, ok := storedData["updated_at"].(float64); ok {
+		updatedAt = int64(timestamp)
+	}
+
+	var revision int64
+	if stored, ok := storedData["revision"].(float64); ok {
+		revision = int64(stored)
+	}
+
+	return version, updatedAt, revision, nil
+}
+
+// WriteEdgegapCredentials stores the encrypted Edgegap API token in storage
+func (sm *StorageManager) WriteEdgegapCredentials(ctx context.Context, encryptedToken string) error {
+	credentialsData := map[string]interface{}{
+		"api_token":  encryptedToken,
 		"updated_at": time.Now().Unix(),
 	}
 
-	versionDataBytes, err := json.Marshal(versionData)
+	credentialsDataBytes, err := json.Marshal(credentialsData)
 	if err != nil {
 		return err
 	}
@@ -124,9 +375,9 @@ func (sm *StorageManager) WriteEdgegapVersion(ctx context.Context, version strin
 	if _, err := sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{
 		{
 			Collection:      StorageCollectionEdgegapVersion,
-			Key:             StorageKeyEdgegapVersion,
-			Value:           string(versionDataBytes),
-			PermissionRead:  2, // Public read
+			Key:             StorageKeyEdgegapCredentials,
+			Value:           string(credentialsDataBytes),
+			PermissionRead:  0, // No read from clients
 			PermissionWrite: 0, // No write from clients
 		},
 	}); err != nil {
@@ -136,12 +387,12 @@ func (sm *StorageManager) WriteEdgegapVersion(ctx context.Context, version strin
 	return nil
 }
 
-// ReadEdgegapVersion retrieves the Edgegap version from storage
-func (sm *StorageManager) ReadEdgegapVersion(ctx context.Context) (string, int64, error) {
+// ReadEdgegapCredentials retrieves the encrypted Edgegap API token from storage
+func (sm *StorageManager) ReadEdgegapCredentials(ctx context.Context) (string, int64, error) {
 	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{
 		{
 			Collection: StorageCollectionEdgegapVersion,
-			Key:        StorageKeyEdgegapVersion,
+			Key:        StorageKeyEdgegapCredentials,
 		},
 	})
 
@@ -150,18 +401,17 @@ func (sm *StorageManager) ReadEdgegapVersion(ctx context.Context) (string, int64
 	}
 
 	if len(objects) == 0 {
-		return "", 0, ErrorNoVersionFound
+		return "", 0, ErrorNoCredentialsFound
 	}
 
-	// Parse stored version
 	var storedData map[string]interface{}
 	if err := json.Unmarshal([]byte(objects[0].Value), &storedData); err != nil {
 		return "", 0, err
 	}
 
-	version, ok := storedData["version"].(string)
-	if !ok || version == "" {
-		return "", 0, errors.New("invalid Edgegap version format in storage")
+	encryptedToken, ok := storedData["api_token"].(string)
+	if !ok || encryptedToken == "" {
+		return "", 0, errors.New("invalid Edgegap credentials format in storage")
 	}
 
 	var updatedAt int64
@@ -169,24 +419,23 @@ func (sm *StorageManager) ReadEdgegapVersion(ctx context.Context) (string, int64
 		updatedAt = int64(timestamp)
 	}
 
-	return version, updatedAt, nil
+	return encryptedToken, updatedAt, nil
 }
 
 // createDbInstance creates and stores a new instance in the database.
-func (sm *StorageManager) createDbInstance(ctx context.Context, id string, maxPlayers int, userIds []string, callbackId string, metadata map[string]any) (*runtime.InstanceInfo, error) {
+func (sm *StorageManager) createDbInstance(ctx context.Context, id string, status string, edgegapInstance EdgegapInstanceInfo, metadata map[string]any) (*runtime.InstanceInfo, error) {
 	// Initialize metadata if nil
 	if metadata == nil {
 		metadata = make(map[string]any)
 	}
 
 	// Store Edgegap-related information in metadata
-	metadata["edgegap"] = EdgegapInstanceInfo{
-		MaxPlayers:            maxPlayers,
-		Reservations:          userIds,
-		ReservationsUpdatedAt: time.Now(),
-		CallbackId:            callbackId,
-		Connections:           []string{},
+	if edgegapInstance.Reservations == nil {
+		edgegapInstance.Reservations = []string{}
 	}
+	edgegapInstance.ReservationsUpdatedAt = time.Now()
+	edgegapInstance.Connections = []string{}
+	metadata["edgegap"] = edgegapInstance
 
 	// Create a new instance session instance
 	instance := &runtime.InstanceInfo{
@@ -194,7 +443,7 @@ func (sm *StorageManager) createDbInstance(ctx context.Context, id string, maxPl
 		ConnectionInfo: nil,
 		CreateTime:     time.Now(),
 		PlayerCount:    0,
-		Status:         EdgegapStatusRequested,
+		Status:         status,
 		Metadata:       metadata,
 	}
 
@@ -205,180 +454,588 @@ func (sm *StorageManager) createDbInstance(ctx context.Context, id string, maxPl
 	}
 
 	// Serialize instance to JSON and store in Nakama
-	value, err := json.Marshal(instance)
+	value, users, err := sm.encodeInstance(instance)
 	if err != nil {
 		return nil, err
 	}
 
 	sw := runtime.StorageWrite{
-		Collection: StorageEdgegapInstancesCollection,
+		Collection: sm.collectionsOf(instance).instances,
 		Key:        id,
 		UserID:     "",
-		Value:      string(value),
+		Value:      value,
+	}
+
+	// The instances of other tenants than the default one are created with the lookup naming their tenant
+	writes, err := sm.withTenantLookup(withUsersWrite([]*runtime.StorageWrite{&sw}, users), instance)
+	if err != nil {
+		return nil, err
+	}
+	_, err = sm.writeWithEventLog(ctx, writes, true, instance)
+	if err != nil {
+		return instance, err
+	}
+	sm.recent.track(id)
+	return instance, nil
+}
+
+// moveDbInstance stores the instance under its new ID and removes the record stored under oldId in a single update,
+// conditional on the version of the old record.
+func (sm *StorageManager) moveDbInstance(ctx context.Context, oldId string, oldVersion string, instance *runtime.InstanceInfo) error {
+	err := sm.SyncInstance(instance)
+	if err != nil {
+		return err
+	}
+
+	value, users, err := sm.encodeInstance(instance)
+	if err != nil {
+		return err
+	}
+
+	collections := sm.collectionsOf(instance)
+	writes, err := sm.withTenantLookup(withUsersWrite([]*runtime.StorageWrite{{
+		Collection: collections.instances,
+		Key:        instance.Id,
+		UserID:     "",
+		Value:      value,
+	}}, users), instance)
+	if err != nil {
+		return err
+	}
+	// The event log follows the instance to its new ID
+	if sm.eventLog {
+		if writes, err = sm.withMovedEventLog(ctx, writes, oldId, instance); err != nil {
+			return err
+		}
 	}
 
-	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{&sw})
-	return instance, err
+	sm.cache.invalidate(oldId, instance.Id)
+	_, _, err = sm.nk.MultiUpdate(ctx, nil, writes, []*runtime.StorageDelete{{
+		Collection: collections.instances,
+		Key:        oldId,
+		Version:    oldVersion,
+	}, {
+		Collection: collections.users,
+		Key:        oldId,
+	}, {
+		Collection: collections.events,
+		Key:        oldId,
+	}, {
+		Collection: sm.tenantsCollection,
+		Key:        oldId,
+	}}, nil, false)
+	if err != nil {
+		return err
+	}
+	sm.tenants.forget(oldId)
+	sm.recent.forget(oldId)
+	sm.recent.track(instance.Id)
+	return nil
 }
 
-// listDbInstances retrieves all stored instance from Nakama.
-func (sm *StorageManager) listDbInstances(ctx context.Context) ([]*runtime.InstanceInfo, error) {
-	instances := make([]*runtime.InstanceInfo, 0)
-	cursor := ""
+// updateConflictAttempts bounds the writes of an instance losing to a concurrent write, each applied again on a
+// fresh read
+const updateConflictAttempts = 3
+
+// readDbInstancesForUpdate reads the instances from storage, bypassing the cache, along with the versions to write
+// them back conditionally. Missing instances are absent from the returned maps.
+func (sm *StorageManager) readDbInstancesForUpdate(ctx context.Context, ids ...string) (map[string]*runtime.InstanceInfo, map[string]string, error) {
+	tenants, err := sm.resolveTenants(ctx, ids...)
+	if err != nil {
+		return nil, nil, err
+	}
+	reads := make([]*runtime.StorageRead, 0, len(ids))
+	for _, id := range ids {
+		reads = append(reads, &runtime.StorageRead{
+			Collection: sm.collectionsOfTenant(tenants[id]).instances,
+			Key:        id,
+		})
+	}
 
-	// Loop to fetch sessions in batches
-	for {
-		objects, nextCursor, err := sm.nk.StorageList(ctx, "", "", StorageEdgegapInstancesCollection, 1_000, cursor)
+	objects, err := sm.nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instances := make(map[string]*runtime.InstanceInfo, len(objects))
+	versions := make(map[string]string, len(objects))
+	for _, obj := range objects {
+		instance, err := decodeInstance(obj.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		instances[obj.Key] = instance
+		versions[obj.Key] = obj.Version
+	}
+	if err = sm.attachUsers(ctx, slices.Collect(maps.Values(instances))...); err != nil {
+		return nil, nil, err
+	}
+
+	return instances, versions, nil
+}
+
+// writeDbInstancesConditional writes the instances in a single transaction, each conditional on its version: either
+// every instance is written or none is. It returns the new versions by instance ID.
+func (sm *StorageManager) writeDbInstancesConditional(ctx context.Context, instances []*runtime.InstanceInfo, versions map[string]string) (map[string]string, error) {
+	writes := make([]*runtime.StorageWrite, 0, len(instances))
+	ids := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		sm.syncOvershoot(instance)
+		if err := sm.SyncInstance(instance); err != nil {
+			return nil, err
+		}
+		value, users, err := sm.encodeInstance(instance)
 		if err != nil {
 			return nil, err
 		}
 
-		// Deserialize each stored object into an instance
-		for _, obj := range objects {
-			var info *runtime.InstanceInfo
-			if err = json.Unmarshal([]byte(obj.Value), &info); err != nil {
+		writes = withUsersWrite(append(writes, &runtime.StorageWrite{
+			Collection: sm.collectionsOf(instance).instances,
+			Key:        instance.Id,
+			UserID:     "",
+			Value:      value,
+			Version:    versions[instance.Id],
+		}), users)
+		ids = append(ids, instance.Id)
+	}
+
+	sm.cache.invalidate(ids...)
+	acks, err := sm.writeWithEventLog(ctx, writes, false, instances...)
+	if err != nil {
+		return nil, err
+	}
+
+	newVersions := make(map[string]string, len(acks))
+	for _, id := range ids {
+		newVersions[id] = sm.instanceVersion(acks, id)
+	}
+	sm.compensateOvershoot(ctx, instances...)
+	return newVersions, nil
+}
+
+// getDbInstanceByJoinCode retrieves a pending instance by its join code, returns nil if none matches.
+func (sm *StorageManager) getDbInstanceByJoinCode(ctx context.Context, joinCode string) (*runtime.InstanceInfo, error) {
+	query := fmt.Sprintf("+value.metadata.edgegap.join_code:%q +value.status:%s", joinCode, EdgegapStatusPending)
+	objects, err := sm.listIndexes(ctx, query, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	instance, err := decodeInstance(objects[0].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return instance, sm.attachUsers(ctx, instance)
+}
+
+// listDbInstances retrieves all stored instance from Nakama, those of every tenant.
+func (sm *StorageManager) listDbInstances(ctx context.Context) ([]*runtime.InstanceInfo, error) {
+	instances := make([]*runtime.InstanceInfo, 0)
+
+	for _, collections := range sm.allCollections() {
+		cursor := ""
+
+		// Loop to fetch sessions in batches
+		for {
+			objects, nextCursor, err := sm.nk.StorageList(ctx, "", "", collections.instances, 1_000, cursor)
+			if err != nil {
 				return nil, err
 			}
-			instances = append(instances, info)
+
+			// Deserialize each stored object into an instance
+			for _, obj := range objects {
+				info, err := decodeInstance(obj.Value)
+				if err != nil {
+					return nil, err
+				}
+				instances = append(instances, info)
+			}
+
+			// Stop if no more results
+			if nextCursor == "" {
+				break
+			}
+			cursor = nextCursor
 		}
+	}
+
+	return instances, sm.attachUsers(ctx, instances...)
+}
 
-		// Stop if no more results
-		if nextCursor == "" {
-			break
+// listDbInstancesByStatus retrieves the stored instances with the given statuses from the storage index of every
+// tenant, listing every status of every index concurrently so large fleets are read in parallel.
+func (sm *StorageManager) listDbInstancesByStatus(ctx context.Context, statuses []string) ([]*runtime.InstanceInfo, error) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		instances = make([]*runtime.InstanceInfo, 0)
+		errs      = make([]error, 0)
+	)
+
+	for _, collections := range sm.allCollections() {
+		for _, status := range statuses {
+			wg.Add(1)
+			go func(index, status string) {
+				defer wg.Done()
+
+				shard := make([]*runtime.InstanceInfo, 0)
+				query := fmt.Sprintf("+value.status:%s", status)
+				cursor := ""
+				for {
+					entries, nextCursor, err := sm.nk.StorageIndexList(ctx, "", index, query, 1_000, nil, cursor)
+					if err != nil {
+						mu.Lock()
+						errs = append(errs, fmt.Errorf("failed to list %s instances of %s: %w", status, index, err))
+						mu.Unlock()
+						return
+					}
+
+					for _, obj := range entries.GetObjects() {
+						info, err := decodeInstance(obj.Value)
+						if err != nil {
+							sm.logger.WithField("error", err.Error()).Error("failed to unmarshal instance info")
+							continue
+						}
+						shard = append(shard, info)
+					}
+
+					if nextCursor == "" || len(entries.GetObjects()) == 0 {
+						break
+					}
+					cursor = nextCursor
+				}
+
+				mu.Lock()
+				instances = append(instances, shard...)
+				mu.Unlock()
+			}(collections.index, status)
 		}
-		cursor = nextCursor
 	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	query := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		query = append(query, fmt.Sprintf("value.status:%s", status))
+	}
+	instances = append(instances, sm.recoverRecentInstances(ctx, strings.Join(query, " "), instances)...)
 
-	return instances, nil
+	return instances, sm.attachUsers(ctx, instances...)
 }
 
 // getDbInstance retrieves a single instance by ID from the Nakama database.
 func (sm *StorageManager) getDbInstance(ctx context.Context, id string) (*runtime.InstanceInfo, error) {
-	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
-		Collection: StorageEdgegapInstancesCollection,
+	if value, ok := sm.cache.get(id); ok {
+		if instance, err := decodeInstance(value); err == nil {
+			return instance, nil
+		}
+		sm.cache.invalidate(id)
+	}
+
+	collections, err := sm.resolveCollections(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The companion user lists are read along with the record when instances are split
+	reads := []*runtime.StorageRead{{
+		Collection: collections.instances,
 		Key:        id,
-	}})
+	}}
+	if sm.splitUsers {
+		reads = append(reads, &runtime.StorageRead{Collection: collections.users, Key: id})
+	}
+	objects, err := sm.nk.StorageRead(ctx, reads)
 	if err != nil {
 		return nil, err
 	}
 
+	var obj, users *api.StorageObject
+	for _, o := range objects {
+		if o.GetCollection() == collections.users {
+			users = o
+		} else {
+			obj = o
+		}
+	}
+
 	// If no session is found, return nil
-	if len(objects) == 0 {
+	if obj == nil {
 		return nil, nil
 	}
 
-	obj := objects[0]
-
 	// Deserialize stored JSON into an instance
-	var instance *runtime.InstanceInfo
-	if err = json.Unmarshal([]byte(obj.Value), &instance); err != nil {
+	instance, err := decodeInstance(obj.Value)
+	if err != nil {
 		return nil, err
 	}
 
+	// Split instances are cached with their user lists, as plain JSON
+	value := obj.Value
+	if ei, err := extractEdgegapInstance(instance); err == nil && ei.detached {
+		var packed packedUsers
+		if users != nil && json.Unmarshal([]byte(users.Value), &packed) == nil {
+			ei.setUsers(packed)
+		} else if err = sm.attachUsers(ctx, instance); err != nil {
+			return nil, err
+		}
+		plain, err := json.Marshal(instance)
+		if err != nil {
+			return nil, err
+		}
+		value = string(plain)
+	}
+	sm.cache.put(id, value, obj.Version)
+
 	return instance, nil
 }
 
 // updateDbInstance updates an existing instance in the database.
 func (sm *StorageManager) updateDbInstance(ctx context.Context, instance *runtime.InstanceInfo) error {
 	// Sync instance metadata before updating storage
+	sm.syncOvershoot(instance)
 	err := sm.SyncInstance(instance)
 	if err != nil {
 		return err
 	}
 
 	// Serialize instance data to JSON
-	value, err := json.Marshal(instance)
+	value, users, err := sm.encodeInstance(instance)
 	if err != nil {
 		return err
 	}
 
-	// Write updated instance to storage
+	// Write updated instance to storage, conditional on the version of a cached copy
 	sw := runtime.StorageWrite{
-		Collection: StorageEdgegapInstancesCollection,
+		Collection: sm.collectionsOf(instance).instances,
 		Key:        instance.Id,
 		UserID:     "",
-		Value:      string(value),
+		Value:      value,
+		Version:    sm.cache.version(instance.Id),
 	}
-	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{&sw})
-	return err
+	acks, err := sm.writeWithEventLog(ctx, withUsersWrite([]*runtime.StorageWrite{&sw}, users), sw.Version == "", instance)
+	if err != nil {
+		sm.cache.invalidate(instance.Id)
+		return err
+	}
+	if version := sm.instanceVersion(acks, instance.Id); version != "" {
+		// Split instances are cached with their user lists, as plain JSON
+		cached := []byte(sw.Value)
+		if users != nil {
+			cached, err = json.Marshal(instance)
+		}
+		if err == nil {
+			sm.cache.put(instance.Id, string(cached), version)
+		}
+	}
+	sm.compensateOvershoot(ctx, instance)
+	return nil
 }
 
-// updateDbInstances updates multiple instance in the database
-func (sm *StorageManager) updateManyDbInstance(ctx context.Context, instances []*runtime.InstanceInfo) error {
+// updateDbInstances updates multiple instances in the database, each conditional on the version it was read at when
+// known. Instances written concurrently since they were read are skipped rather than overwritten, their IDs are
+// returned.
+func (sm *StorageManager) updateDbInstances(ctx context.Context, instances []*runtime.InstanceInfo, versions map[string]string) ([]string, error) {
 	writes := make([]*runtime.StorageWrite, 0, len(instances))
+	batch := make([][]*runtime.StorageWrite, 0, len(instances))
+	written := make([]*runtime.InstanceInfo, 0, len(instances))
 	for _, instance := range instances {
+		sm.syncOvershoot(instance)
 		err := sm.SyncInstance(instance)
 		if err != nil {
 			sm.logger.Error("Error syncing instance %v: %v", instance.Id, err)
 			continue
 		}
 		// Serialize instance data to JSON
-		value, err := json.Marshal(instance)
+		value, users, err := sm.encodeInstance(instance)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Append for Batch Writes
-		writes = append(writes, &runtime.StorageWrite{
-			Collection: StorageEdgegapInstancesCollection,
+		instanceWrites := withUsersWrite([]*runtime.StorageWrite{{
+			Collection: sm.collectionsOf(instance).instances,
 			Key:        instance.Id,
 			UserID:     "",
-			Value:      string(value),
+			Value:      value,
+			Version:    versions[instance.Id],
+		}}, users)
+		writes = append(writes, instanceWrites...)
+		batch = append(batch, instanceWrites)
+		written = append(written, instance)
+	}
+
+	// The batch is written at once, or instance by instance when one of them was written concurrently
+	skipped := make([]string, 0)
+	_, err := sm.writeWithEventLog(ctx, writes, false, written...)
+	if errors.Is(err, runtime.ErrStorageRejectedVersion) {
+		err = nil
+		for i, instanceWrites := range batch {
+			if _, writeErr := sm.writeWithEventLog(ctx, instanceWrites, instanceWrites[0].Version == "", written[i]); errors.Is(writeErr, runtime.ErrStorageRejectedVersion) {
+				skipped = append(skipped, written[i].Id)
+			} else if writeErr != nil {
+				err = writeErr
+			}
+		}
+		written = slices.DeleteFunc(written, func(instance *runtime.InstanceInfo) bool {
+			return slices.Contains(skipped, instance.Id)
 		})
 	}
 
-	_, err := sm.nk.StorageWrite(ctx, writes)
-	return err
+	// Invalidated once written, a read meanwhile would cache the copy overwritten
+	ids := make([]string, 0, len(batch))
+	for _, instanceWrites := range batch {
+		ids = append(ids, instanceWrites[0].Key)
+	}
+	sm.cache.invalidate(ids...)
+	if err != nil {
+		return skipped, err
+	}
+	if len(skipped) > 0 {
+		sm.logger.Warn("Skipped the update of %d instances written concurrently: %s", len(skipped), strings.Join(skipped, ", "))
+	}
+
+	sm.compensateOvershoot(ctx, written...)
+	return skipped, nil
 }
 
-// deleteDbInstance removes instance from Nakama storage.
-func (sm *StorageManager) deleteDbInstance(ctx context.Context, ids []string) error {
-	deletes := make([]*runtime.StorageDelete, 0, len(ids))
+// deleteDbInstances removes the instances from Nakama storage, from the collections of their tenant.
+func (sm *StorageManager) deleteDbInstances(ctx context.Context, ids []string) error {
+	tenants, err := sm.resolveTenants(ctx, ids...)
+	if err != nil {
+		return err
+	}
+	deletes := make([]*runtime.StorageDelete, 0, 5*len(ids))
 
-	// Prepare delete requests for each session ID
+	// Prepare delete requests for each session ID, with its companion user lists, its event log, its tenant lookup and
+	// the join lock left by a join that could not release it
 	for _, id := range ids {
+		collections := sm.collectionsOfTenant(tenants[id])
 		deletes = append(deletes, &runtime.StorageDelete{
-			Collection: StorageEdgegapInstancesCollection,
+			Collection: collections.instances,
+			Key:        id,
+		}, &runtime.StorageDelete{
+			Collection: collections.users,
+			Key:        id,
+		}, &runtime.StorageDelete{
+			Collection: collections.events,
+			Key:        id,
+		}, &runtime.StorageDelete{
+			Collection: sm.locksCollection,
 			Key:        id,
 		})
+		if tenants[id] != TenantDefault {
+			deletes = append(deletes, &runtime.StorageDelete{
+				Collection: sm.tenantsCollection,
+				Key:        id,
+			})
+		}
 	}
 
-	// Execute delete operation
-	if err := sm.nk.StorageDelete(ctx, deletes); err != nil {
-		return err
+	// Execute delete operations in batches, reconciliation of large fleets can remove many instances at once
+	sm.cache.invalidate(ids...)
+	sm.recent.forget(ids...)
+	sm.tenants.forget(ids...)
+	for batch := range slices.Chunk(deletes, 1_000) {
+		if err := sm.nk.StorageDelete(ctx, batch); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// getUserIPs retrieves player IP addresses from their metadata.
-func (sm *StorageManager) getUserIPs(ctx context.Context, userIds []string) ([]string, error) {
+// getUserIPs retrieves player IP addresses from the players collection, falling back to the account metadata where
+// they were stored before. It also returns the users without a usable IP, and counts the source of each user IP in
+// the edgegap_placement_ip metric.
+func (sm *StorageManager) getUserIPs(ctx context.Context, userIds []string) ([]string, []string, error) {
 	userIps := make([]string, 0)
+	missing := make([]string, 0)
+	sources := make(map[string]int64)
+	defer func() {
+		for source, count := range sources {
+			sm.nk.MetricsCounterAdd("edgegap_placement_ip", map[string]string{"source": source}, count)
+		}
+	}()
 
-	// Iterate through user IDs and fetch their metadata
+	reads := make([]*runtime.StorageRead, 0, len(userIds))
 	for _, userId := range userIds {
-		userAccount, err := sm.nk.AccountGetId(ctx, userId)
-		if err != nil {
-			return nil, err
+		reads = append(reads, &runtime.StorageRead{
+			Collection: sm.playersCollection,
+			Key:        StorageKeyPlayerIp,
+			UserID:     userId,
+		})
+	}
+	objects, err := sm.nk.StorageRead(ctx, reads)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored := make(map[string]string, len(objects))
+	for _, obj := range objects {
+		var record EdgegapPlayerIpRecord
+		if err = json.Unmarshal([]byte(obj.Value), &record); err != nil {
+			sm.logger.WithField("error", err.Error()).Warn("failed to parse PlayerIp of user %s", obj.UserId)
+			continue
 		}
+		stored[obj.UserId] = record.Ip
+	}
 
-		// Parse user metadata from JSON
-		userMetadata := make(map[string]interface{})
-		err = json.Unmarshal([]byte(userAccount.User.Metadata), &userMetadata)
-		if err != nil {
-			return nil, err
+	for _, userId := range userIds {
+		source := PlayerIpSourceStored
+		userIp, ok := stored[userId]
+		if !ok {
+			source = PlayerIpSourceLegacy
+			userIp, ok, err = sm.getLegacyUserIP(ctx, userId)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				sm.logger.Warn("User %s has no PlayerIp stored", userId)
+				sources[PlayerIpSourceMissing]++
+				missing = append(missing, userId)
+				continue
+			}
 		}
 
-		// Extract IP address if available
-		userIp, ok := userMetadata["PlayerIp"]
-		if !ok {
-			sm.logger.Warn("User %s metadata does not contain PlayerIp", userId)
+		// Encrypted IPs are only decrypted here, to place the deployment
+		userIp, err = openPlayerIp(sm.playerIpKey, userIp)
+		if err != nil {
+			sm.logger.WithField("error", err.Error()).Warn("failed to decrypt PlayerIp of user %s", userId)
+			sources[PlayerIpSourceInvalid]++
+			missing = append(missing, userId)
 			continue
 		}
-		if userIp != "" {
-			userIps = append(userIps, userIp.(string))
+		if userIp == "" {
+			sources[PlayerIpSourceMissing]++
+			missing = append(missing, userId)
+			continue
 		}
+		sources[source]++
+		userIps = append(userIps, userIp)
+	}
+
+	return userIps, missing, nil
+}
+
+// getLegacyUserIP reads the player IP from the account metadata, for users who did not authenticate since it moved.
+func (sm *StorageManager) getLegacyUserIP(ctx context.Context, userId string) (string, bool, error) {
+	userAccount, err := sm.nk.AccountGetId(ctx, userId)
+	if err != nil {
+		return "", false, err
+	}
+
+	userMetadata := make(map[string]interface{})
+	if err = json.Unmarshal([]byte(userAccount.User.Metadata), &userMetadata); err != nil {
+		return "", false, err
 	}
 
-	return userIps, nil
+	userIp, ok := userMetadata[PlayerIpMetadataKey].(string)
+	return userIp, ok, nil
 }
@@ -0,0 +1,95 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	defaultCreateWaitTimeout = time.Minute
+	maxCreateWaitTimeout     = 5 * time.Minute
+)
+
+// ErrorCreateWaitTimeout is returned when a synchronous create is not ready in time, the deployment keeps going
+var ErrorCreateWaitTimeout = errors.New("instance not ready within the wait timeout")
+
+// syncCreateKey marks the context of a Create waited on until the instance is ready
+type syncCreateKey struct{}
+
+// createOutcome is the result of a Create, as received by its callback
+type createOutcome struct {
+	status   runtime.FmCreateStatus
+	instance *runtime.InstanceInfo
+	sessions []*runtime.SessionInfo
+	err      error
+}
+
+// createWaiters hands the callback outcome of synchronous creates to the RPC waiting on them, keyed by callback ID
+type createWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]chan createOutcome
+}
+
+func newCreateWaiters() *createWaiters {
+	return &createWaiters{waiters: make(map[string]chan createOutcome)}
+}
+
+// register wraps the Create callback to also hand its outcome to the waiter of the callback ID.
+// It is registered before the deployment is requested, so an outcome arriving before wait is kept.
+func (cw *createWaiters) register(callbackId string, callback runtime.FmCreateCallbackFn) runtime.FmCreateCallbackFn {
+	outcomes := make(chan createOutcome, 1)
+	cw.mu.Lock()
+	cw.waiters[callbackId] = outcomes
+	cw.mu.Unlock()
+
+	return func(status runtime.FmCreateStatus, instanceInfo *runtime.InstanceInfo, sessionInfo []*runtime.SessionInfo, metadata map[string]any, err error) {
+		if callback != nil {
+			callback(status, instanceInfo, sessionInfo, metadata, err)
+		}
+		select {
+		case outcomes <- createOutcome{status: status, instance: instanceInfo, sessions: sessionInfo, err: err}:
+		default:
+		}
+	}
+}
+
+// wait blocks until the outcome of the Create is received, the timeout elapses or the context is done.
+func (cw *createWaiters) wait(ctx context.Context, callbackId string, timeout time.Duration) (*createOutcome, error) {
+	cw.mu.Lock()
+	outcomes, ok := cw.waiters[callbackId]
+	cw.mu.Unlock()
+	if !ok {
+		return nil, errors.New("no waiter registered for callback " + callbackId)
+	}
+	defer cw.remove(callbackId)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case outcome := <-outcomes:
+		return &outcome, nil
+	case <-timer.C:
+		return nil, ErrorCreateWaitTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// remove drops the waiter of a Create nobody waits on anymore.
+func (cw *createWaiters) remove(callbackId string) {
+	cw.mu.Lock()
+	delete(cw.waiters, callbackId)
+	cw.mu.Unlock()
+}
+
+// createWaitTimeout returns the requested wait timeout, defaulting to a minute and capped to 5 minutes.
+func createWaitTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultCreateWaitTimeout
+	}
+	return min(time.Duration(seconds)*time.Second, maxCreateWaitTimeout)
+}
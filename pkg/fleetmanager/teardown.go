@@ -0,0 +1,313 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdFleetTeardown       = "fleet_teardown"
+	RpcIdFleetTeardownStatus = "fleet_teardown_status"
+
+	// StorageKeyFleetTeardown is the key of the progress of the last teardown in the system collection
+	StorageKeyFleetTeardown = "edgegap_fleet_teardown"
+
+	// Teardown job statuses
+	TeardownStatusRunning   = "running"
+	TeardownStatusCompleted = "completed"
+
+	teardownConfirmationTtl = 5 * time.Minute
+	teardownWorkers         = 8
+	teardownMaxErrors       = 10
+
+	// teardownStaleAfter is how long without progress before a running teardown is considered dead, e.g. node restart
+	teardownStaleAfter = time.Minute
+)
+
+// teardownStatuses are the statuses of the instances a teardown stops
+var teardownStatuses = []string{
+	EdgegapStatusPending,
+	EdgegapStatusStarting,
+	EdgegapStatusRequested,
+	EdgegapStatusRunning,
+	EdgegapStatusReady,
+	EdgegapStatusStopping,
+	EdgegapStatusUnknown,
+}
+
+// ErrorInvalidConfirmation is returned when a teardown is confirmed with an invalid, expired or mismatched token
+var ErrorInvalidConfirmation = errors.New("invalid or expired confirmation token, call fleet_teardown without confirm to get a new one")
+
+type fleetTeardownRequest struct {
+	// Query restricts the teardown to the instances matching the storage index query, all active instances if empty
+	Query string `json:"query"`
+	// Confirm is the confirmation token returned by a first call with the same query
+	Confirm string `json:"confirm"`
+}
+
+type fleetTeardownReply struct {
+	Matched           int                 `json:"matched"`
+	ConfirmationToken string              `json:"confirmation_token,omitempty"`
+	ExpiresAt         *time.Time          `json:"expires_at,omitempty"`
+	Job               *EdgegapTeardownJob `json:"job,omitempty"`
+}
+
+// EdgegapTeardownJob is the progress of a teardown, stored so every node can report it
+type EdgegapTeardownJob struct {
+	Query      string     `json:"query"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Stopped    int        `json:"stopped"`
+	Failed     int        `json:"failed"`
+	Errors     []string   `json:"errors"`
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// running reports whether the teardown is still progressing.
+func (j *EdgegapTeardownJob) running() bool {
+	return j != nil && j.Status == TeardownStatusRunning && time.Since(j.UpdatedAt) < teardownStaleAfter
+}
+
+// teardownConfirmation issues a token confirming a teardown of the query, valid for teardownConfirmationTtl.
+func (efm *EdgegapFleetManager) teardownConfirmation(query string) (string, time.Time, error) {
+	expiresAt := time.Now().UTC().Add(teardownConfirmationTtl).Truncate(time.Second)
+	token, err := helpers.EncryptString(efm.edgegapManager.configuration.EncryptionKey, strconv.FormatInt(expiresAt.Unix(), 10)+"\n"+query)
+	return token, expiresAt, err
+}
+
+// checkTeardownConfirmation verifies the token was issued for the query and has not expired.
+func (efm *EdgegapFleetManager) checkTeardownConfirmation(query, token string) error {
+	decrypted, err := helpers.DecryptString(efm.edgegapManager.configuration.EncryptionKey, token)
+	if err != nil {
+		return ErrorInvalidConfirmation
+	}
+	expiresAt, confirmedQuery, ok := strings.Cut(decrypted, "\n")
+	if !ok || confirmedQuery != query {
+		return ErrorInvalidConfirmation
+	}
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrorInvalidConfirmation
+	}
+	return nil
+}
+
+// teardownCandidates lists the active instances matching the storage index query.
+func (efm *EdgegapFleetManager) teardownCandidates(ctx context.Context, query string) ([]*runtime.InstanceInfo, error) {
+	candidates := make([]*runtime.InstanceInfo, 0)
+	seen := make(map[string]struct{})
+	cursor := ""
+	for {
+		instances, nextCursor, err := efm.List(ctx, query, 1_000, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range instances {
+			if _, ok := seen[instance.Id]; ok || !slices.Contains(teardownStatuses, instance.Status) {
+				continue
+			}
+			seen[instance.Id] = struct{}{}
+			candidates = append(candidates, instance)
+		}
+		if nextCursor == "" || len(instances) == 0 {
+			return candidates, efm.storageManager.attachUsers(ctx, candidates...)
+		}
+		cursor = nextCursor
+	}
+}
+
+// readTeardownJob reads the progress of the last teardown, nil if none ran.
+func (sm *StorageManager) readTeardownJob(ctx context.Context) (*EdgegapTeardownJob, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: StorageCollectionEdgegapVersion,
+		Key:        StorageKeyFleetTeardown,
+	}})
+	if err != nil || len(objects) == 0 {
+		return nil, err
+	}
+
+	var job EdgegapTeardownJob
+	if err = json.Unmarshal([]byte(objects[0].Value), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// writeTeardownJob stores the progress of the teardown.
+func (sm *StorageManager) writeTeardownJob(ctx context.Context, job *EdgegapTeardownJob) error {
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      StorageCollectionEdgegapVersion,
+		Key:             StorageKeyFleetTeardown,
+		Value:           string(value),
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}})
+	return err
+}
+
+// runTeardown stops the deployments and purges the records of the instances, storing the progress as it goes.
+func (efm *EdgegapFleetManager) runTeardown(job *EdgegapTeardownJob, instances []*runtime.InstanceInfo) {
+	ctx := efm.ctx
+	var mu sync.Mutex
+	progress := func(id string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			job.Failed++
+			if len(job.Errors) < teardownMaxErrors {
+				job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", id, err))
+			}
+		} else {
+			job.Stopped++
+		}
+
+		job.UpdatedAt = time.Now().UTC()
+		if done := job.Stopped + job.Failed; done%10 == 0 && done < job.Total {
+			if err := efm.storageManager.writeTeardownJob(ctx, job); err != nil {
+				efm.logger.WithField("error", err.Error()).Warn("failed to store teardown progress")
+			}
+		}
+	}
+
+	ids := make(chan string)
+	var wg sync.WaitGroup
+	for range teardownWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				progress(id, efm.Delete(ctx, id))
+			}
+		}()
+	}
+	for _, instance := range instances {
+		ids <- instance.Id
+	}
+	close(ids)
+	wg.Wait()
+
+	finishedAt := time.Now().UTC()
+	job.Status = TeardownStatusCompleted
+	job.UpdatedAt = finishedAt
+	job.FinishedAt = &finishedAt
+	if err := efm.storageManager.writeTeardownJob(ctx, job); err != nil {
+		efm.logger.WithField("error", err.Error()).Warn("failed to store teardown progress")
+	}
+
+	efm.nk.MetricsCounterAdd("edgegap_teardown_stopped", nil, int64(job.Stopped))
+	efm.logger.Warn("Fleet teardown completed: %d stopped, %d failed", job.Stopped, job.Failed)
+}
+
+// fleetTeardown admin rpc to stop every active deployment matching the query and purge their records (S2S only).
+// A first call returns the matched count and a confirmation token, the teardown starts when called again with it.
+func fleetTeardown(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdFleetTeardown); err != nil {
+		return "", err
+	}
+
+	var req fleetTeardownRequest
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", ErrInvalidInput
+		}
+	}
+	query := strings.TrimSpace(req.Query)
+	if query == "" {
+		query = "*"
+	}
+
+	if req.Confirm != "" {
+		if err := fmInstance.checkTeardownConfirmation(query, req.Confirm); err != nil {
+			return "", runtime.NewError(err.Error(), 9) // FAILED_PRECONDITION
+		}
+		job, err := fmInstance.storageManager.readTeardownJob(ctx)
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("failed to read teardown progress")
+			return "", ErrInternalError
+		}
+		if job.running() {
+			return "", runtime.NewError("a fleet teardown is already running", 10) // ABORTED
+		}
+	}
+
+	instances, err := fmInstance.teardownCandidates(ctx, query)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list instances to tear down")
+		return "", runtime.NewError("failed to list instances, check the query", 3) // INVALID_ARGUMENT
+	}
+	reply := fleetTeardownReply{Matched: len(instances)}
+
+	if req.Confirm == "" {
+		token, expiresAt, err := fmInstance.teardownConfirmation(query)
+		if err != nil {
+			return "", ErrInternalError
+		}
+		reply.ConfirmationToken = token
+		reply.ExpiresAt = &expiresAt
+	} else {
+		now := time.Now().UTC()
+		reply.Job = &EdgegapTeardownJob{
+			Query:     query,
+			Status:    TeardownStatusRunning,
+			Total:     len(instances),
+			Errors:    []string{},
+			StartedAt: now,
+			UpdatedAt: now,
+		}
+		if err = fmInstance.storageManager.writeTeardownJob(ctx, reply.Job); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to store teardown progress")
+			return "", ErrInternalError
+		}
+		logger.Warn("Fleet teardown of %d instances started, query: %s", len(instances), query)
+
+		job := *reply.Job
+		go fmInstance.runTeardown(&job, instances)
+	}
+
+	replyString, err := json.Marshal(reply)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyString), nil
+}
+
+// fleetTeardownStatus admin rpc reporting the progress of the last fleet teardown (S2S only)
+func fleetTeardownStatus(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdFleetTeardownStatus); err != nil {
+		return "", err
+	}
+
+	job, err := fmInstance.storageManager.readTeardownJob(ctx)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read teardown progress")
+		return "", ErrInternalError
+	}
+	if job == nil {
+		return "", runtime.NewError("no fleet teardown ran", 5) // NOT_FOUND
+	}
+
+	replyString, err := json.Marshal(job)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyString), nil
+}
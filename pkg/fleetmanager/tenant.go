@@ -0,0 +1,187 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// TenantMetadataKey is the create metadata key and the session variable naming the tenant of an instance or caller
+	TenantMetadataKey = "tenant"
+	// TenantDefault is the tenant of the instances deployed on EDGEGAP_APPLICATION with the fleet credentials
+	TenantDefault = "default"
+)
+
+// ErrorUnknownTenant is returned by Create when the tenant is not configured in EDGEGAP_TENANTS
+var ErrorUnknownTenant = errors.New("unknown tenant")
+
+// tenantName matches the tenant ids, e.g. "studio-a"
+var tenantName = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// EdgegapTenant is a game hosted on the cluster with its own Edgegap application and credentials
+type EdgegapTenant struct {
+	Application string `json:"application"`
+	ApiToken    string `json:"api_token"`
+	Version     string `json:"version"`
+	// Quota caps the active deployments of the tenant, unlimited if 0
+	Quota int `json:"quota,omitempty"`
+}
+
+// parseTenants parses the EDGEGAP_TENANTS JSON object of tenants by id, none when empty.
+func parseTenants(value string) (map[string]EdgegapTenant, error) {
+	tenants := make(map[string]EdgegapTenant)
+	if value == "" {
+		return tenants, nil
+	}
+	if err := json.Unmarshal([]byte(value), &tenants); err != nil {
+		return nil, fmt.Errorf("invalid tenants, expects a JSON object of tenants by id: %w", err)
+	}
+
+	for name, tenant := range tenants {
+		if !tenantName.MatchString(name) || name == TenantDefault {
+			return nil, fmt.Errorf("invalid tenant id %q, expects 1 to 64 lowercase letters, digits, _ or - other than %s", name, TenantDefault)
+		}
+		if tenant.Application == "" || tenant.ApiToken == "" || tenant.Version == "" {
+			return nil, fmt.Errorf("tenant %s expects an application, api_token and version", name)
+		}
+		if tenant.Quota < 0 {
+			return nil, fmt.Errorf("tenant %s expects a positive quota", name)
+		}
+	}
+	return tenants, nil
+}
+
+// parseTenantAccounts returns the Edgegap account of each tenant, named after the tenant.
+func parseTenantAccounts(apiUrl string, tenants map[string]EdgegapTenant) []edgegapAccount {
+	accounts := make([]edgegapAccount, 0, len(tenants))
+	for name, tenant := range tenants {
+		accounts = append(accounts, edgegapAccount{
+			name:      tenantAccountName(name),
+			tenant:    name,
+			apiHelper: helpers.NewAPIClient(apiUrl, tenant.ApiToken),
+		})
+	}
+	return accounts
+}
+
+// tenantAccountName returns the account name recorded on the instances of the tenant.
+func tenantAccountName(name string) string {
+	return "tenant-" + name
+}
+
+// callerTenant returns the tenant of the caller session, from its tenant session variable set on authentication.
+func callerTenant(ctx context.Context) string {
+	vars, _ := ctx.Value(runtime.RUNTIME_CTX_VARS).(map[string]string)
+	if tenant := vars[TenantMetadataKey]; tenant != "" {
+		return tenant
+	}
+	return TenantDefault
+}
+
+// instanceTenant returns the tenant of an instance, the default tenant for instances created without tenants.
+func instanceTenant(instance *runtime.InstanceInfo) string {
+	if tenant, ok := instance.Metadata[TenantMetadataKey].(string); ok && tenant != "" {
+		return tenant
+	}
+	return TenantDefault
+}
+
+// metadataTenant returns the tenant named in the create metadata, the default tenant if unset.
+func metadataTenant(metadata map[string]any) string {
+	if tenant, ok := metadata[TenantMetadataKey].(string); ok && tenant != "" {
+		return tenant
+	}
+	return TenantDefault
+}
+
+// tenant returns the configuration of the tenant, nil for the default tenant.
+func (em *EdgegapManager) tenant(name string) (*EdgegapTenant, error) {
+	if name == TenantDefault {
+		return nil, nil
+	}
+	tenant, ok := em.tenants[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrorUnknownTenant, name)
+	}
+	return &tenant, nil
+}
+
+// deploymentTenant returns the tenant a deployment is created for, the default tenant unless tenants are enabled.
+func (em *EdgegapManager) deploymentTenant(metadata map[string]any) string {
+	if !em.tenantsEnabled() {
+		return TenantDefault
+	}
+	return metadataTenant(metadata)
+}
+
+// tenantsEnabled reports whether instances are scoped to tenants.
+func (em *EdgegapManager) tenantsEnabled() bool {
+	return len(em.tenants) > 0
+}
+
+// tenantQuery scopes an instance query to the tenant. Each tenant lists its own index, the query still names the
+// tenant so the instances recovered while missing from the index match it too.
+func tenantQuery(tenant, query string) string {
+	return fmt.Sprintf("+value.metadata.%s:%q %s", TenantMetadataKey, tenant, query)
+}
+
+// checkTenant records the tenant of the instance in its metadata and enforces the tenant quota before a Create.
+// Quotas are best effort, concurrent creations on several nodes can briefly exceed them.
+func (efm *EdgegapFleetManager) checkTenant(ctx context.Context, metadata map[string]any) error {
+	em := efm.edgegapManager
+	if !em.tenantsEnabled() {
+		return nil
+	}
+
+	name := metadataTenant(metadata)
+	tenant, err := em.tenant(name)
+	if err != nil {
+		return err
+	}
+	metadata[TenantMetadataKey] = name
+	if tenant == nil || tenant.Quota == 0 {
+		return nil
+	}
+
+	index := efm.storageManager.collectionsOfTenant(name).index
+	active, err := efm.storageManager.countIndexedInstances(ctx, []string{index}, activeInstancesQuery())
+	if err != nil {
+		return err
+	}
+	if active >= tenant.Quota {
+		efm.logger.WithFields(map[string]any{"tenant": name, "active": active, "quota": tenant.Quota}).Warn("tenant deployment quota reached")
+		em.webhooks.Dispatch(WebhookEventQuotaReached, fmt.Sprintf("Deployment quota reached for tenant %s (%d/%d)", name, active, tenant.Quota), map[string]string{
+			"tenant": name,
+			"active": strconv.Itoa(active),
+			"quota":  strconv.Itoa(tenant.Quota),
+		})
+		return &QuotaError{Tenant: name, Active: active, Quota: tenant.Quota}
+	}
+	return nil
+}
+
+// checkTenantAccess hides the instances of other tenants from client callers, as if they did not exist.
+func checkTenantAccess(ctx context.Context, fm runtime.FleetManager, instanceId string) error {
+	if _, isClient := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); !isClient || !fmInstance.edgegapManager.tenantsEnabled() {
+		return nil
+	}
+	if _, isEdgegap := fm.(*EdgegapFleetManager); !isEdgegap {
+		return nil
+	}
+
+	instance, err := fm.Get(ctx, instanceId)
+	if err != nil {
+		return err
+	}
+	if instance == nil || instanceTenant(instance) != callerTenant(ctx) {
+		return runtime.NewError("instance not found", 5) // NOT_FOUND
+	}
+	return nil
+}
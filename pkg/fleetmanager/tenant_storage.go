@@ -0,0 +1,249 @@
+package fleetmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/heroiclabs/nakama-common/api"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// ErrorInvalidCursor is returned when listing the instances of every tenant from a cursor not returned by a listing
+var ErrorInvalidCursor = errors.New("invalid cursor")
+
+// tenantLookupMaxEntries bounds the tenants of instance IDs remembered per node, forgotten all at once when reached
+const tenantLookupMaxEntries = 10_000
+
+// instanceCollections names the storage of the instances of a tenant: their records, the index of the records, their
+// companion user lists and their event logs
+type instanceCollections struct {
+	index     string
+	instances string
+	users     string
+	events    string
+}
+
+func newInstanceCollections(prefix string) instanceCollections {
+	return instanceCollections{
+		index:     prefix + "_instances_idx",
+		instances: prefix + "_instances",
+		users:     prefix + "_instance_users",
+		events:    prefix + "_instance_events",
+	}
+}
+
+// instanceTenantRecord is the value of the lookup naming the tenant of an instance ID
+type instanceTenantRecord struct {
+	Tenant string `json:"tenant"`
+}
+
+// tenantLookup remembers the tenant of the instance IDs resolved by this node. Instances never change tenant, only the
+// IDs of other tenants than the default one are remembered.
+type tenantLookup struct {
+	mu      sync.Mutex
+	tenants map[string]string
+}
+
+func (l *tenantLookup) get(id string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tenant, ok := l.tenants[id]
+	return tenant, ok
+}
+
+func (l *tenantLookup) put(id, tenant string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tenants == nil || len(l.tenants) >= tenantLookupMaxEntries {
+		l.tenants = make(map[string]string)
+	}
+	l.tenants[id] = tenant
+}
+
+func (l *tenantLookup) forget(ids ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		delete(l.tenants, id)
+	}
+}
+
+// SetTenants stores the instances of each tenant in their own collections and index, named after the storage prefix
+// and the tenant. The instances of the default tenant keep the collections named after the prefix alone.
+func (sm *StorageManager) SetTenants(names []string) {
+	sm.tenantCollections = make(map[string]instanceCollections, len(names))
+	for _, name := range names {
+		if name != TenantDefault {
+			sm.tenantCollections[name] = newInstanceCollections(sm.prefix + "_" + name)
+		}
+	}
+}
+
+// collectionsOfTenant returns the instance collections of the tenant, those of the default tenant when not configured.
+func (sm *StorageManager) collectionsOfTenant(tenant string) instanceCollections {
+	if collections, ok := sm.tenantCollections[tenant]; ok {
+		return collections
+	}
+	return sm.collections
+}
+
+// collectionsOf returns the collections the instance is stored in, after its tenant.
+func (sm *StorageManager) collectionsOf(instance *runtime.InstanceInfo) instanceCollections {
+	return sm.collectionsOfTenant(instanceTenant(instance))
+}
+
+// allCollections returns the instance collections of every tenant, the default tenant first.
+func (sm *StorageManager) allCollections() []instanceCollections {
+	collections := []instanceCollections{sm.collections}
+	for _, name := range slices.Sorted(maps.Keys(sm.tenantCollections)) {
+		collections = append(collections, sm.tenantCollections[name])
+	}
+	return collections
+}
+
+// instanceIndexes returns the instance index of every tenant, the default tenant first.
+func (sm *StorageManager) instanceIndexes() []string {
+	indexes := make([]string, 0, len(sm.tenantCollections)+1)
+	for _, collections := range sm.allCollections() {
+		indexes = append(indexes, collections.index)
+	}
+	return indexes
+}
+
+// isInstancesCollection reports whether the collection holds the instance records of a tenant.
+func (sm *StorageManager) isInstancesCollection(collection string) bool {
+	if collection == sm.collections.instances {
+		return true
+	}
+	for _, collections := range sm.tenantCollections {
+		if collection == collections.instances {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveTenants returns the tenant of each instance ID, read from the lookup written along with the instances of the
+// other tenants than the default one. Webhooks, game server events and the reconciliation only know the deployment ID
+// of an instance, its tenant is resolved from it before its collections are read.
+func (sm *StorageManager) resolveTenants(ctx context.Context, ids ...string) (map[string]string, error) {
+	tenants := make(map[string]string, len(ids))
+	reads := make([]*runtime.StorageRead, 0)
+	for _, id := range ids {
+		tenants[id] = TenantDefault
+		if len(sm.tenantCollections) == 0 {
+			continue
+		}
+		if tenant, ok := sm.tenants.get(id); ok {
+			tenants[id] = tenant
+			continue
+		}
+		reads = append(reads, &runtime.StorageRead{Collection: sm.tenantsCollection, Key: id})
+	}
+
+	for batch := range slices.Chunk(reads, 100) {
+		objects, err := sm.nk.StorageRead(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			var record instanceTenantRecord
+			if err = json.Unmarshal([]byte(obj.Value), &record); err != nil || record.Tenant == "" {
+				continue
+			}
+			tenants[obj.Key] = record.Tenant
+			sm.tenants.put(obj.Key, record.Tenant)
+		}
+	}
+	return tenants, nil
+}
+
+// resolveCollections returns the collections the instance with the given ID is stored in.
+func (sm *StorageManager) resolveCollections(ctx context.Context, id string) (instanceCollections, error) {
+	tenants, err := sm.resolveTenants(ctx, id)
+	if err != nil {
+		return instanceCollections{}, err
+	}
+	return sm.collectionsOfTenant(tenants[id]), nil
+}
+
+// withTenantLookup appends the write of the tenant lookup of the instance to its writes, none for the default tenant.
+func (sm *StorageManager) withTenantLookup(writes []*runtime.StorageWrite, instance *runtime.InstanceInfo) ([]*runtime.StorageWrite, error) {
+	tenant := instanceTenant(instance)
+	if _, ok := sm.tenantCollections[tenant]; !ok {
+		return writes, nil
+	}
+	value, err := json.Marshal(instanceTenantRecord{Tenant: tenant})
+	if err != nil {
+		return nil, err
+	}
+	sm.tenants.put(instance.Id, tenant)
+	return append(writes, &runtime.StorageWrite{
+		Collection:      sm.tenantsCollection,
+		Key:             instance.Id,
+		Value:           string(value),
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}), nil
+}
+
+// listIndexes lists the stored instances matching the query from the index of every tenant, up to limit in total.
+func (sm *StorageManager) listIndexes(ctx context.Context, query string, limit int) ([]*api.StorageObject, error) {
+	objects := make([]*api.StorageObject, 0)
+	for _, collections := range sm.allCollections() {
+		entries, _, err := sm.nk.StorageIndexList(ctx, "", collections.index, query, limit-len(objects), nil, "")
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, entries.GetObjects()...)
+		if len(objects) >= limit {
+			break
+		}
+	}
+	return objects, nil
+}
+
+// listIndexesPage lists a page of the stored instances matching the query from the given indexes in turn. The cursor
+// of a single index is passed through, across several it is prefixed with the position of the index it continues.
+func (sm *StorageManager) listIndexesPage(ctx context.Context, indexes []string, query string, limit int, order []string, cursor string) ([]*api.StorageObject, string, error) {
+	if len(indexes) == 1 {
+		entries, newCursor, err := sm.nk.StorageIndexList(ctx, "", indexes[0], query, limit, order, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		return entries.GetObjects(), newCursor, nil
+	}
+
+	position, indexCursor := 0, ""
+	if cursor != "" {
+		prefix, rest, ok := strings.Cut(cursor, ":")
+		n, err := strconv.Atoi(prefix)
+		if !ok || err != nil || n < 0 || n >= len(indexes) {
+			return nil, "", ErrorInvalidCursor
+		}
+		position, indexCursor = n, rest
+	}
+
+	objects := make([]*api.StorageObject, 0)
+	for ; position < len(indexes) && len(objects) < limit; position++ {
+		entries, newCursor, err := sm.nk.StorageIndexList(ctx, "", indexes[position], query, limit-len(objects), order, indexCursor)
+		if err != nil {
+			return nil, "", err
+		}
+		objects = append(objects, entries.GetObjects()...)
+		if newCursor != "" {
+			return objects, strconv.Itoa(position) + ":" + newCursor, nil
+		}
+		indexCursor = ""
+	}
+	if position < len(indexes) {
+		return objects, strconv.Itoa(position) + ":", nil
+	}
+	return objects, "", nil
+}
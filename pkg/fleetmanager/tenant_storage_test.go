@@ -0,0 +1,124 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+func TestTenantStorageRouting(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+	sm.SetTenants([]string{"studio-a"})
+	tenantCollections := sm.collectionsOfTenant("studio-a")
+
+	if _, err := sm.createDbInstance(ctx, "default-id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 2}, map[string]any{TenantMetadataKey: TenantDefault}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.createDbInstance(ctx, "tenant-id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 2}, map[string]any{TenantMetadataKey: "studio-a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := []struct {
+		collection string
+		key        string
+		want       bool
+	}{
+		{collection: sm.collections.instances, key: "default-id", want: true},
+		{collection: sm.tenantsCollection, key: "default-id"},
+		{collection: tenantCollections.instances, key: "tenant-id", want: true},
+		{collection: sm.collections.instances, key: "tenant-id"},
+		{collection: sm.tenantsCollection, key: "tenant-id", want: true},
+	}
+	for _, tt := range stored {
+		if got := nk.has(tt.collection, tt.key); got != tt.want {
+			t.Errorf("%s stored in %s = %v, want %v", tt.key, tt.collection, got, tt.want)
+		}
+	}
+
+	// Another node resolves the tenant of the instance from its ID alone
+	other := NewStorageManager(nk, fakeLogger{})
+	other.SetTenants([]string{"studio-a"})
+	for _, id := range []string{"default-id", "tenant-id"} {
+		instance, err := other.getDbInstance(ctx, id)
+		if err != nil || instance == nil {
+			t.Fatalf("getDbInstance(%s) = %v, %v", id, instance, err)
+		}
+	}
+	lookups := nk.readCount(sm.tenantsCollection)
+	if _, err := other.getDbInstance(ctx, "tenant-id"); err != nil {
+		t.Fatal(err)
+	}
+	if got := nk.readCount(sm.tenantsCollection); got != lookups {
+		t.Errorf("resolved tenant read %d times again, want it remembered", got-lookups)
+	}
+
+	if err := other.deleteDbInstances(ctx, []string{"default-id", "tenant-id"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range stored {
+		if nk.has(tt.collection, tt.key) {
+			t.Errorf("%s still stored in %s after delete", tt.key, tt.collection)
+		}
+	}
+}
+
+func TestListIndexesPage(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+	sm.SetTenants([]string{"empty", "studio-a"})
+
+	want := make([]string, 0)
+	for i, collections := range sm.allCollections() {
+		nk.indexes[collections.index] = collections.instances
+		if collections == sm.collectionsOfTenant("empty") {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			key := fmt.Sprintf("%d-%d", i, j)
+			if _, err := nk.StorageWrite(ctx, []*runtime.StorageWrite{{Collection: collections.instances, Key: key, Value: "{}"}}); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, key)
+		}
+	}
+
+	for _, limit := range []int{1, 2, 4, 10} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			got := make([]string, 0)
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(want) {
+					t.Fatal("listing did not end")
+				}
+				objects, next, err := sm.listIndexesPage(ctx, sm.instanceIndexes(), "*", limit, nil, cursor)
+				if err != nil {
+					t.Fatalf("listIndexesPage() error = %v", err)
+				}
+				if len(objects) > limit {
+					t.Fatalf("listIndexesPage() listed %d instances, want at most %d", len(objects), limit)
+				}
+				for _, obj := range objects {
+					got = append(got, obj.Key)
+				}
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("listed %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, _, err := sm.listIndexesPage(ctx, sm.instanceIndexes(), "*", 1, nil, "7:"); !errors.Is(err, ErrorInvalidCursor) {
+		t.Errorf("listIndexesPage() with a foreign cursor error = %v, want %v", err, ErrorInvalidCursor)
+	}
+}
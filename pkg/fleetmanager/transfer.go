@@ -0,0 +1,189 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdInstanceTransfer = "instance_transfer"
+
+	// Game server callback event sent when players of the instance are moved to another instance
+	GameServerEventTransfer = "transfer"
+)
+
+var (
+	// ErrorTransferUsersNotFound is returned when a transferred user holds no reservation nor connection on the source
+	ErrorTransferUsersNotFound = errors.New("users not found on the source instance")
+	// ErrorTransferTargetNotReady is returned when the target instance cannot be connected to yet
+	ErrorTransferTargetNotReady = errors.New("target instance is not ready")
+	// ErrorTransferNoSeats is returned when the target instance cannot seat every transferred user
+	ErrorTransferNoSeats = errors.New("not enough seats on the target instance")
+	// ErrorTransferConflict is returned when an instance changed while the transfer was applied, nothing was moved
+	ErrorTransferConflict = errors.New("instances changed during the transfer, nothing was moved")
+)
+
+type instanceTransferRequest struct {
+	SourceInstanceID string   `json:"source_instance_id"`
+	TargetInstanceID string   `json:"target_instance_id"`
+	UserIds          []string `json:"user_ids"`
+}
+
+// cloneInstance returns a deep copy of the instance, to restore it on rollback.
+func cloneInstance(instance *runtime.InstanceInfo) (*runtime.InstanceInfo, error) {
+	value, err := json.Marshal(instance)
+	if err != nil {
+		return nil, err
+	}
+	return decodeInstance(string(value))
+}
+
+// Transfer moves the reservations and connections of the users from the source instance to the target instance,
+// e.g. to merge under-filled lobbies. Both records are written in one transaction, the users then get the connection
+// info of the target and the source game server is told to let them go. Both records are restored if the users could
+// not be notified.
+func (efm *EdgegapFleetManager) Transfer(ctx context.Context, sourceId, targetId string, userIds []string) (*runtime.InstanceInfo, error) {
+	return efm.transfer(ctx, sourceId, targetId, userIds, false)
+}
+
+// transfer moves the users, with drain the joins of the source are also redirected to the target in the same write.
+func (efm *EdgegapFleetManager) transfer(ctx context.Context, sourceId, targetId string, userIds []string, drain bool) (*runtime.InstanceInfo, error) {
+	instances, versions, err := efm.storageManager.readDbInstancesForUpdate(ctx, sourceId, targetId)
+	if err != nil {
+		return nil, err
+	}
+	source, target := instances[sourceId], instances[targetId]
+	if source == nil || target == nil {
+		return nil, ErrorDeploymentNotFound
+	}
+	if target.Status != EdgegapStatusReady || target.ConnectionInfo == nil {
+		return nil, ErrorTransferTargetNotReady
+	}
+
+	originals := make([]*runtime.InstanceInfo, 0, 2)
+	for _, instance := range []*runtime.InstanceInfo{source, target} {
+		original, err := cloneInstance(instance)
+		if err != nil {
+			return nil, err
+		}
+		originals = append(originals, original)
+	}
+
+	sourceEi, err := efm.storageManager.ExtractEdgegapInstance(source)
+	if err != nil {
+		return nil, err
+	}
+	targetEi, err := efm.storageManager.ExtractEdgegapInstance(target)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := slices.DeleteFunc(slices.Clone(userIds), func(userId string) bool {
+		return slices.Contains(sourceEi.Reservations, userId) || slices.Contains(sourceEi.Connections, userId)
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrorTransferUsersNotFound, missing)
+	}
+
+	// Users already seated on the target keep their seat
+	moving := slices.DeleteFunc(slices.Clone(userIds), func(userId string) bool {
+		return slices.Contains(targetEi.Reservations, userId) || slices.Contains(targetEi.Connections, userId)
+	})
+	if seats := targetEi.freeSeats(); seats >= 0 && seats < len(moving) {
+		return nil, fmt.Errorf("%w: %d free, %d needed", ErrorTransferNoSeats, seats, len(moving))
+	}
+
+	// Moved users must connect to the target, they hold a reservation there until they do
+	for _, userId := range moving {
+		targetEi.reserve([]string{userId}, sourceEi.reservationPriority(userId))
+	}
+	sourceEi.Reservations = helpers.RemoveElements(sourceEi.Reservations, userIds)
+	sourceEi.Connections = helpers.RemoveElements(sourceEi.Connections, userIds)
+	sourceEi.ReservationsUpdatedAt = time.Now().UTC()
+	targetEi.ReservationsUpdatedAt = time.Now().UTC()
+	if drain {
+		sourceEi.DrainingTo = targetId
+	}
+	source.Metadata["edgegap"] = sourceEi
+	target.Metadata["edgegap"] = targetEi
+
+	newVersions, err := efm.storageManager.writeDbInstancesConditional(ctx, []*runtime.InstanceInfo{source, target}, versions)
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Warn("failed to write transfer from %s to %s", sourceId, targetId)
+		return nil, ErrorTransferConflict
+	}
+
+	secret := efm.edgegapManager.configuration.NakamaHttpKey
+	err = sendNotifications(ctx, efm.logger, efm.nk, target, "connection-info", notificationConnectionInfo, userIds, func(userId string) map[string]interface{} {
+		return connectionInfoContent(target, reservationToken(secret, target.Id, userId))
+	})
+	if err != nil {
+		efm.logger.WithField("error", err.Error()).Error("failed to notify users transferred from %s to %s, rolling back", sourceId, targetId)
+		if _, rollbackErr := efm.storageManager.writeDbInstancesConditional(ctx, originals, newVersions); rollbackErr != nil {
+			efm.logger.WithField("error", rollbackErr.Error()).Error("failed to roll back transfer from %s to %s", sourceId, targetId)
+		}
+		return nil, err
+	}
+
+	notifyGameServer(efm.logger, source, GameServerEventTransfer, map[string]any{
+		"user_ids":        userIds,
+		"instance_id":     target.Id,
+		"connection_info": target.ConnectionInfo,
+	})
+
+	efm.logger.Info("Transferred %d users from instance %s to %s", len(userIds), sourceId, targetId)
+	return target, nil
+}
+
+// transferInstance admin rpc to move users from an instance to another, e.g. to merge lobbies (S2S only)
+func transferInstance(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdInstanceTransfer); err != nil {
+		return "", err
+	}
+
+	var req *instanceTransferRequest
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req == nil || req.SourceInstanceID == "" || req.TargetInstanceID == "" || len(req.UserIds) == 0 {
+		return "", ErrInvalidInput
+	}
+	if req.SourceInstanceID == req.TargetInstanceID {
+		return "", runtime.NewError("source and target instances must differ", 3) // INVALID_ARGUMENT
+	}
+
+	target, err := fmInstance.Transfer(ctx, req.SourceInstanceID, req.TargetInstanceID, req.UserIds)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrorDeploymentNotFound):
+			return "", runtime.NewError(err.Error(), 5) // NOT_FOUND
+		case errors.Is(err, ErrorTransferUsersNotFound):
+			return "", runtime.NewError(err.Error(), 3) // INVALID_ARGUMENT
+		case errors.Is(err, ErrorTransferTargetNotReady):
+			return "", runtime.NewError(err.Error(), 9) // FAILED_PRECONDITION
+		case errors.Is(err, ErrorTransferNoSeats):
+			return "", runtime.NewError(err.Error(), 8) // RESOURCE_EXHAUSTED
+		case errors.Is(err, ErrorTransferConflict):
+			return "", runtime.NewError(err.Error(), 10) // ABORTED
+		}
+		logger.WithField("error", err.Error()).Error("failed to transfer users from %s to %s", req.SourceInstanceID, req.TargetInstanceID)
+		return "", ErrInternalError
+	}
+
+	replyString, err := json.Marshal(map[string]any{
+		"success":            true,
+		"source_instance_id": req.SourceInstanceID,
+		"target_instance_id": target.Id,
+		"user_ids":           req.UserIds,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
@@ -0,0 +1,79 @@
+package fleetmanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"reflect"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// UpdateMetadataInstanceTokenKey is the Update metadata key holding the instance token, it is never stored
+	UpdateMetadataInstanceTokenKey = "instance_token"
+
+	// EventInstanceUpdated is the Nakama event emitted when Update changes an instance
+	EventInstanceUpdated = "edgegap_instance_updated"
+)
+
+// ErrorUpdateUnauthorized is returned by Update when the caller has neither server credentials nor the instance token
+var ErrorUpdateUnauthorized = errors.New("unauthorized: update requires server authentication or the instance token")
+
+// reservedMetadataKeys cannot be written through Update, they are managed by the fleet manager
+var reservedMetadataKeys = map[string]struct{}{
+	"edgegap":                      {},
+	UpdateMetadataInstanceTokenKey: {},
+	InstanceMetadataPurchaseKey:    {},
+}
+
+// instanceToken derives the token a game server can present to update its own instance.
+func instanceToken(secret, instanceId string) string {
+	return reservationToken(secret, instanceId, "instance")
+}
+
+// isUpdateAuthorized allows server contexts, or client contexts presenting the instance token.
+func (efm *EdgegapFleetManager) isUpdateAuthorized(ctx context.Context, id string, metadata map[string]any) bool {
+	if _, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string); !ok {
+		return true
+	}
+
+	token, _ := metadata[UpdateMetadataInstanceTokenKey].(string)
+	expected := instanceToken(efm.edgegapManager.configuration.NakamaHttpKey, id)
+	return token != "" && hmac.Equal([]byte(token), []byte(expected))
+}
+
+// clampPlayerCount keeps the player count between 0 and the instance capacity, unless unlimited.
+func clampPlayerCount(playerCount, maxPlayers int) int {
+	playerCount = max(playerCount, 0)
+	if maxPlayers >= 0 {
+		playerCount = min(playerCount, maxPlayers)
+	}
+	return playerCount
+}
+
+// mergeUpdateMetadata merges the update into the instance metadata and reports whether anything changed.
+// Reserved keys are ignored, a nil value removes the key, any other value overwrites the current one.
+func mergeUpdateMetadata(current, update map[string]any) bool {
+	changed := false
+	for k, v := range update {
+		if _, reserved := reservedMetadataKeys[k]; reserved {
+			continue
+		}
+
+		existing, exists := current[k]
+		if v == nil {
+			if exists {
+				delete(current, k)
+				changed = true
+			}
+			continue
+		}
+
+		if !exists || !reflect.DeepEqual(existing, v) {
+			current[k] = v
+			changed = true
+		}
+	}
+	return changed
+}
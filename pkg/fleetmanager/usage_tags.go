@@ -0,0 +1,86 @@
+package fleetmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// usageTagUnsafe matches the characters replaced in the values of the usage tags
+var usageTagUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// usageTag is a deployment tag named <name>-<value>, its value either a literal or read from a create metadata key
+type usageTag struct {
+	name        string
+	value       string
+	metadataKey string
+}
+
+// parseUsageTags parses the comma separated EDGEGAP_DEPLOYMENT_TAGS, each "name=value" or "name={metadata_key}".
+func parseUsageTags(value string) ([]usageTag, error) {
+	tags := make([]usageTag, 0)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, template, ok := strings.Cut(entry, "=")
+		name, template = strings.TrimSpace(name), strings.TrimSpace(template)
+		if !ok || !deploymentTagName.MatchString(name) || template == "" {
+			return nil, fmt.Errorf("invalid deployment tag %q, expects name=value or name={metadata_key}", entry)
+		}
+
+		tag := usageTag{name: name}
+		if key, isKey := strings.CutPrefix(template, "{"); isKey {
+			key, closed := strings.CutSuffix(key, "}")
+			if !closed || key == "" {
+				return nil, fmt.Errorf("invalid deployment tag %q, expects name={metadata_key}", entry)
+			}
+			tag.metadataKey = key
+		} else {
+			tag.value = template
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// render returns the tag for the create metadata, false when its metadata key is unset.
+func (t usageTag) render(metadata map[string]any) (string, bool) {
+	value := t.value
+	if t.metadataKey != "" {
+		switch v := metadata[t.metadataKey].(type) {
+		case string:
+			value = v
+		case float64, int, int64, bool:
+			value = fmt.Sprint(v)
+		}
+	}
+	if value == "" {
+		return "", false
+	}
+
+	tag := t.name + "-" + usageTagUnsafe.ReplaceAllString(value, "_")
+	if len(tag) > 64 {
+		tag = tag[:64]
+	}
+	return tag, true
+}
+
+// deploymentTags returns the tags of a deployment: nakama, EDGEGAP_DEPLOYMENT_TAG when set, and the usage tags of
+// EDGEGAP_DEPLOYMENT_TAGS attributing its cost, e.g. mode-ranked or tenant-studio-a.
+func (em *EdgegapManager) deploymentTags(metadata map[string]any) []string {
+	tags := []string{"nakama"}
+	if em.configuration.DeploymentTag != "" {
+		tags = append(tags, em.configuration.DeploymentTag)
+	}
+
+	usageTags, _ := parseUsageTags(em.configuration.DeploymentTags)
+	for _, usage := range usageTags {
+		if tag, ok := usage.render(metadata); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,71 @@
+package fleetmanager
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseUsageTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []usageTag
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: []usageTag{}},
+		{name: "literal", value: "env=prod", want: []usageTag{{name: "env", value: "prod"}}},
+		{name: "metadata key", value: "mode={game_mode}", want: []usageTag{{name: "mode", metadataKey: "game_mode"}}},
+		{
+			name:  "several with spaces and empty entries",
+			value: " env = prod ,, mode={game_mode}, ",
+			want:  []usageTag{{name: "env", value: "prod"}, {name: "mode", metadataKey: "game_mode"}},
+		},
+		{name: "no value", value: "env", wantErr: true},
+		{name: "empty value", value: "env=", wantErr: true},
+		{name: "invalid name", value: "env name=prod", wantErr: true},
+		{name: "unclosed key", value: "mode={game_mode", wantErr: true},
+		{name: "empty key", value: "mode={}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseUsageTags(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUsageTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !slices.Equal(got, tt.want) {
+				t.Errorf("parseUsageTags() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageTagRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      usageTag
+		metadata map[string]any
+		want     string
+		wantOk   bool
+	}{
+		{name: "literal", tag: usageTag{name: "env", value: "prod"}, want: "env-prod", wantOk: true},
+		{name: "string key", tag: usageTag{name: "mode", metadataKey: "game_mode"}, metadata: map[string]any{"game_mode": "ranked"}, want: "mode-ranked", wantOk: true},
+		{name: "number key", tag: usageTag{name: "size", metadataKey: "team_size"}, metadata: map[string]any{"team_size": float64(5)}, want: "size-5", wantOk: true},
+		{name: "bool key", tag: usageTag{name: "ranked", metadataKey: "ranked"}, metadata: map[string]any{"ranked": true}, want: "ranked-true", wantOk: true},
+		{name: "unsafe characters replaced", tag: usageTag{name: "mode", metadataKey: "game_mode"}, metadata: map[string]any{"game_mode": "capture the/flag!"}, want: "mode-capture_the_flag_", wantOk: true},
+		{name: "key unset", tag: usageTag{name: "mode", metadataKey: "game_mode"}, metadata: map[string]any{}},
+		{name: "key empty", tag: usageTag{name: "mode", metadataKey: "game_mode"}, metadata: map[string]any{"game_mode": ""}},
+		{name: "key of another type", tag: usageTag{name: "mode", metadataKey: "game_mode"}, metadata: map[string]any{"game_mode": []any{"ranked"}}},
+		{name: "truncated", tag: usageTag{name: "mode", metadataKey: "game_mode"}, metadata: map[string]any{"game_mode": strings.Repeat("x", 80)}, want: "mode-" + strings.Repeat("x", 59), wantOk: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.tag.render(tt.metadata)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("render() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
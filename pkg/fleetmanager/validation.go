@@ -0,0 +1,135 @@
+package fleetmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// FieldError describes why a request field is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request, returned as INVALID_ARGUMENT by the RPCs
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	details := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		details = append(details, field.Field+": "+field.Message)
+	}
+	return "invalid request, " + strings.Join(details, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns nil when no field is invalid.
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateCreateRequest checks the instance_create request against the configured limits, client callers being
+// held to the stricter ones.
+func validateCreateRequest(config *EdgegapManagerConfiguration, req *createInstanceSessionRequest, isClient bool) error {
+	verr := &ValidationError{}
+
+	switch {
+	case req.MaxPlayers == 0 || req.MaxPlayers < -1:
+		verr.add("max_players", "must be -1 for unlimited or at least 1, got %d", req.MaxPlayers)
+	case config.CreateMaxPlayers > 0 && req.MaxPlayers > config.CreateMaxPlayers:
+		verr.add("max_players", "must be at most %d, got %d", config.CreateMaxPlayers, req.MaxPlayers)
+	}
+
+	if req.MinPlayers < 0 {
+		verr.add("min_players", "must be positive, got %d", req.MinPlayers)
+	} else if req.MaxPlayers > 0 && req.MinPlayers > req.MaxPlayers {
+		verr.add("min_players", "must be at most max_players %d, got %d", req.MaxPlayers, req.MinPlayers)
+	}
+
+	if config.CreateMaxUsers > 0 && len(req.UserIds) > config.CreateMaxUsers {
+		verr.add("user_ids", "must hold at most %d users, got %d", config.CreateMaxUsers, len(req.UserIds))
+	}
+	seen := make(map[string]struct{}, len(req.UserIds))
+	for _, userId := range req.UserIds {
+		if userId == "" {
+			verr.add("user_ids", "must not hold empty user ids")
+			continue
+		}
+		if _, ok := seen[userId]; ok {
+			verr.add("user_ids", "duplicate user id %s", userId)
+		}
+		seen[userId] = struct{}{}
+	}
+	if req.MaxPlayers > 0 && len(seen) > req.MaxPlayers {
+		verr.add("user_ids", "must hold at most max_players %d users, got %d", req.MaxPlayers, len(seen))
+	}
+
+	if v, ok := req.Metadata[CreateMetadataPortNameKey]; ok {
+		if name, isString := v.(string); !isString || strings.TrimSpace(name) == "" {
+			verr.add("metadata."+CreateMetadataPortNameKey, "must be the name of a port of the app version")
+		}
+	}
+
+	if v, ok := req.Metadata[CreateMetadataFiltersKey]; ok {
+		if _, err := parseDeploymentFilters(v); err != nil {
+			verr.add("metadata."+CreateMetadataFiltersKey, "%s", err.Error())
+		}
+	}
+
+	if v, ok := req.Metadata[CreateMetadataMaxDurationKey]; ok {
+		minutes, err := parseMaxDuration(v)
+		switch {
+		case err != nil:
+			verr.add("max_duration", "%s", err.Error())
+		case isClient && configuredMaxDuration(config) > 0 && minutes > configuredMaxDuration(config):
+			verr.add("max_duration", "must be at most %d minutes, got %d", configuredMaxDuration(config), minutes)
+		}
+	}
+
+	if v, ok := req.Metadata[CreateMetadataContainerArgsKey]; ok {
+		args, err := parseContainerArgs(v)
+		if err != nil {
+			verr.add("metadata."+CreateMetadataContainerArgsKey, "%s", err.Error())
+		}
+		allowlist, _ := parseContainerArgsAllowlist(config.ContainerArgs)
+		invalid := checkContainerArgs(allowlist, args)
+		for _, flag := range slices.Sorted(maps.Keys(invalid)) {
+			verr.add("container_args."+flag, "%s", invalid[flag])
+		}
+	}
+
+	// The metadata size is checked by Create, once every create option is set in the metadata
+	return verr.err()
+}
+
+// validateMetadataSize rejects create metadata too large to be shipped to the game server as an environment variable.
+func validateMetadataSize(config *EdgegapManagerConfiguration, metadata map[string]any) error {
+	if config.CreateMaxMetadataBytes <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		verr := &ValidationError{}
+		verr.add("metadata", "must be serializable to JSON: %s", err.Error())
+		return verr
+	}
+	if len(data) > config.CreateMaxMetadataBytes {
+		verr := &ValidationError{}
+		verr.add("metadata", "must be at most %d bytes once serialized, got %d", config.CreateMaxMetadataBytes, len(data))
+		return verr
+	}
+
+	return nil
+}
@@ -0,0 +1,291 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// RpcIdVersionRollout reports, ramps, completes or aborts the rollout of the Edgegap version (S2S only)
+	RpcIdVersionRollout = "version_rollout"
+
+	// StorageKeyEdgegapVersionRollout stores the rollout of the Edgegap version next to the version
+	StorageKeyEdgegapVersionRollout = "edgegap_version_rollout"
+)
+
+// Cutover policies of a version update
+const (
+	// VersionCutoverImmediate deploys every new instance on the new version right away
+	VersionCutoverImmediate = "immediate"
+	// VersionCutoverPercentage deploys a share of the new instances on the new version, ramped with version_rollout
+	VersionCutoverPercentage = "percentage"
+	// VersionCutoverTime ramps the share of new instances on the new version linearly over a duration
+	VersionCutoverTime = "time"
+)
+
+// EdgegapVersionRollout is the cutover from the previous version to the stored one, new deployments pick the previous
+// version for the share not yet cut over
+type EdgegapVersionRollout struct {
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Policy     string    `json:"policy"`
+	Percentage int       `json:"percentage,omitempty"`
+	Duration   string    `json:"duration,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+type versionRolloutRequest struct {
+	// Percentage sets the share of new deployments on the new version of a percentage rollout, 100 completes it
+	Percentage *int `json:"percentage"`
+	// Complete deploys every new instance on the new version
+	Complete bool `json:"complete"`
+	// Abort restores the previous version for every new deployment
+	Abort bool `json:"abort"`
+}
+
+type versionRolloutReply struct {
+	Active   bool                   `json:"active"`
+	Rollout  *EdgegapVersionRollout `json:"rollout,omitempty"`
+	Share    int                    `json:"share"`
+	Complete bool                   `json:"complete,omitempty"`
+	Aborted  bool                   `json:"aborted,omitempty"`
+}
+
+// validateCutover checks the cutover policy of a version update, an empty policy is immediate.
+func validateCutover(request *UpdateEdgegapVersionRequest) error {
+	switch request.Cutover {
+	case "", VersionCutoverImmediate:
+		return nil
+	case VersionCutoverPercentage:
+		if request.Percentage < 0 || request.Percentage > 100 {
+			return runtime.NewError("percentage must be between 0 and 100", 3) // INVALID_ARGUMENT
+		}
+		return nil
+	case VersionCutoverTime:
+		duration, err := time.ParseDuration(request.RampDuration)
+		if err != nil || duration <= 0 {
+			return runtime.NewError("a time cutover expects a positive ramp_duration, e.g. 30m", 3) // INVALID_ARGUMENT
+		}
+		return nil
+	}
+	return runtime.NewError(fmt.Sprintf("invalid cutover %q, expects immediate, percentage or time", request.Cutover), 3) // INVALID_ARGUMENT
+}
+
+// share returns the percentage of new deployments on the new version at the time.
+func (r *EdgegapVersionRollout) share(now time.Time) int {
+	switch r.Policy {
+	case VersionCutoverPercentage:
+		return r.Percentage
+	case VersionCutoverTime:
+		duration, err := time.ParseDuration(r.Duration)
+		if err != nil || duration <= 0 {
+			return 100
+		}
+		return min(100, int(100*now.Sub(r.StartedAt)/duration))
+	}
+	return 100
+}
+
+// writeVersionRollout stores the rollout of the version.
+func (sm *StorageManager) writeVersionRollout(ctx context.Context, rollout *EdgegapVersionRollout) error {
+	value, err := json.Marshal(rollout)
+	if err != nil {
+		return err
+	}
+
+	_, err = sm.nk.StorageWrite(ctx, []*runtime.StorageWrite{{
+		Collection:      StorageCollectionEdgegapVersion,
+		Key:             StorageKeyEdgegapVersionRollout,
+		Value:           string(value),
+		PermissionRead:  0, // No read from clients
+		PermissionWrite: 0, // No write from clients
+	}})
+	return err
+}
+
+// readVersionRollout returns the rollout of the version, nil if none.
+func (sm *StorageManager) readVersionRollout(ctx context.Context) (*EdgegapVersionRollout, error) {
+	objects, err := sm.nk.StorageRead(ctx, []*runtime.StorageRead{{
+		Collection: StorageCollectionEdgegapVersion,
+		Key:        StorageKeyEdgegapVersionRollout,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	var rollout EdgegapVersionRollout
+	if err = json.Unmarshal([]byte(objects[0].Value), &rollout); err != nil {
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+// deleteVersionRollout removes the rollout once complete or aborted.
+func (sm *StorageManager) deleteVersionRollout(ctx context.Context) error {
+	return sm.nk.StorageDelete(ctx, []*runtime.StorageDelete{{
+		Collection: StorageCollectionEdgegapVersion,
+		Key:        StorageKeyEdgegapVersionRollout,
+	}})
+}
+
+// startRollout stores the cutover from the previous version to the updated one. Immediate cutovers, and updates
+// without a previous version, clear any rollout in progress.
+func (dvm *DynamicVersionManager) startRollout(ctx context.Context, previous string, request *UpdateEdgegapVersionRequest) error {
+	defer dvm.invalidate()
+	if request.Cutover == "" || request.Cutover == VersionCutoverImmediate || previous == "" || previous == request.Version {
+		return dvm.sm.deleteVersionRollout(ctx)
+	}
+
+	rollout := &EdgegapVersionRollout{
+		From:      previous,
+		To:        request.Version,
+		Policy:    request.Cutover,
+		Duration:  request.RampDuration,
+		StartedAt: time.Now().UTC(),
+	}
+	if request.Cutover == VersionCutoverPercentage {
+		rollout.Percentage = request.Percentage
+	}
+	if err := dvm.sm.writeVersionRollout(ctx, rollout); err != nil {
+		return err
+	}
+
+	dvm.logger.Info("Edgegap version rollout from %s to %s started, cutover: %s", rollout.From, rollout.To, rollout.Policy)
+	return nil
+}
+
+// DeploymentVersion returns the version of a new deployment: during a rollout, the previous version for the share of
+// deployments not yet cut over, drawn at random. Persistent instances always use the stored version, they are migrated
+// to it as soon as it is applied.
+func (dvm *DynamicVersionManager) DeploymentVersion(ctx context.Context, metadata map[string]any) (string, error) {
+	version, err := dvm.CurrentVersion(ctx)
+	if err != nil || isPersistentCreate(metadata) {
+		return version, err
+	}
+
+	dvm.cache.mu.Lock()
+	rollout := dvm.cache.rollout
+	dvm.cache.mu.Unlock()
+
+	// A rollback or a later update replaces the version the rollout is heading to, the rollout no longer applies
+	if rollout == nil || rollout.To != version {
+		return version, nil
+	}
+	if rand.IntN(100) < rollout.share(time.Now()) {
+		return version, nil
+	}
+	return rollout.From, nil
+}
+
+// getDeploymentVersion retrieves the Edgegap version of a new deployment, following the rollout of the version.
+func (em *EdgegapManager) getDeploymentVersion(metadata map[string]any) (string, error) {
+	version, err := em.versionManager.DeploymentVersion(context.Background(), metadata)
+	if err != nil {
+		if errors.Is(err, ErrorNoVersionFound) {
+			return "", errors.New(ErrorMessageNoVersionFound)
+		}
+		return "", fmt.Errorf("failed to read Edgegap version from storage: %w", err)
+	}
+
+	em.logger.Debug(LogMessageUsingVersionFromStorage, version)
+	return version, nil
+}
+
+// VersionRollout admin rpc reporting the rollout of the Edgegap version, ramping a percentage rollout, completing or
+// aborting it (S2S only)
+func (dvm *DynamicVersionManager) VersionRollout(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	if err := requireS2S(ctx, logger, RpcIdVersionRollout); err != nil {
+		return "", err
+	}
+
+	req := &versionRolloutRequest{}
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), req); err != nil {
+			return "", ErrInvalidInput
+		}
+	}
+	if req.Complete && req.Abort {
+		return "", runtime.NewError("expects either complete or abort", 3) // INVALID_ARGUMENT
+	}
+
+	rollout, err := dvm.sm.readVersionRollout(ctx)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to read version rollout")
+		return "", ErrInternalError
+	}
+	version, _, err := dvm.sm.ReadEdgegapVersion(ctx)
+	if err != nil && !errors.Is(err, ErrorNoVersionFound) {
+		logger.WithField("error", err.Error()).Error("failed to read Edgegap version")
+		return "", ErrInternalError
+	}
+
+	reply := versionRolloutReply{Share: 100}
+	if rollout == nil || rollout.To != version {
+		if req.Percentage != nil || req.Complete || req.Abort {
+			return "", runtime.NewError("no version rollout in progress", 9) // FAILED_PRECONDITION
+		}
+		return marshalRolloutReply(reply)
+	}
+
+	switch {
+	case req.Abort:
+		if err = dvm.StoreVersion(ctx, rollout.From); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to restore Edgegap version")
+			return "", ErrInternalError
+		}
+		if err = dvm.sm.deleteVersionRollout(ctx); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to delete version rollout")
+		}
+		logger.Warn("Edgegap version rollout to %s aborted, restored %s", rollout.To, rollout.From)
+		dvm.versionApplied(rollout.From)
+		reply.Rollout, reply.Share, reply.Aborted = rollout, 0, true
+		return marshalRolloutReply(reply)
+	case req.Complete || (req.Percentage != nil && *req.Percentage == 100):
+		if err = dvm.sm.deleteVersionRollout(ctx); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to delete version rollout")
+			return "", ErrInternalError
+		}
+		dvm.invalidate()
+		logger.Info("Edgegap version rollout to %s completed", rollout.To)
+		reply.Rollout, reply.Complete = rollout, true
+		return marshalRolloutReply(reply)
+	case req.Percentage != nil:
+		if rollout.Policy != VersionCutoverPercentage {
+			return "", runtime.NewError("only percentage rollouts are ramped by hand", 9) // FAILED_PRECONDITION
+		}
+		if *req.Percentage < 0 || *req.Percentage > 100 {
+			return "", runtime.NewError("percentage must be between 0 and 100", 3) // INVALID_ARGUMENT
+		}
+		rollout.Percentage = *req.Percentage
+		if err = dvm.sm.writeVersionRollout(ctx, rollout); err != nil {
+			logger.WithField("error", err.Error()).Error("failed to store version rollout")
+			return "", ErrInternalError
+		}
+		dvm.invalidate()
+		logger.Info("Edgegap version rollout to %s ramped to %d%%", rollout.To, rollout.Percentage)
+	}
+
+	reply.Active = true
+	reply.Rollout = rollout
+	reply.Share = rollout.share(time.Now())
+	return marshalRolloutReply(reply)
+}
+
+// marshalRolloutReply marshals the reply of version_rollout.
+func marshalRolloutReply(reply versionRolloutReply) (string, error) {
+	replyJson, err := json.Marshal(reply)
+	if err != nil {
+		return "", ErrInternalError
+	}
+	return string(replyJson), nil
+}
@@ -0,0 +1,125 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	// versionValidTtl and versionInvalidTtl are how long a validation result of Edgegap is reused
+	versionValidTtl   = 10 * time.Minute
+	versionInvalidTtl = time.Minute
+
+	// versionValidationAttempts is how many times a background validation is tried when the Edgegap API fails
+	versionValidationAttempts = 3
+)
+
+// versionValidations caches the validation results of Edgegap, only definitive answers are kept
+type versionValidations struct {
+	mu      sync.Mutex
+	results map[string]versionValidation
+}
+
+type versionValidation struct {
+	err       error
+	checkedAt time.Time
+}
+
+// cached reports whether a validation result of the version is still fresh, and returns it.
+func (v *versionValidations) cached(version string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	result, ok := v.results[version]
+	if !ok {
+		return false, nil
+	}
+	ttl := versionValidTtl
+	if result.err != nil {
+		ttl = versionInvalidTtl
+	}
+	if time.Since(result.checkedAt) >= ttl {
+		delete(v.results, version)
+		return false, nil
+	}
+	return true, result.err
+}
+
+func (v *versionValidations) store(version string, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.results == nil {
+		v.results = make(map[string]versionValidation)
+	}
+	v.results[version] = versionValidation{err: err, checkedAt: time.Now()}
+}
+
+// isVersionNotFound reports whether the validation error is Edgegap answering the version does not exist.
+func isVersionNotFound(err error) bool {
+	var rerr *runtime.Error
+	return errors.As(err, &rerr) && rerr.Code == 5 // NOT_FOUND
+}
+
+// validateVersion validates the version with Edgegap, reusing a recent result. API failures are not cached.
+func (dvm *DynamicVersionManager) validateVersion(version string) error {
+	if ok, err := dvm.validations.cached(version); ok {
+		return err
+	}
+
+	err := dvm.ValidateVersionWithEdgegap(version)
+	if err == nil || isVersionNotFound(err) {
+		dvm.validations.store(version, err)
+	}
+	return err
+}
+
+// validateInBackground validates a version stored optimistically, retrying API failures. An invalid version is rolled
+// back to the previous one, if still current, with a version_rollback alert. A version that could not be validated
+// is kept.
+func (dvm *DynamicVersionManager) validateInBackground(version, previous string) {
+	var err error
+	for attempt := 0; attempt < versionValidationAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+		if err = dvm.validateVersion(version); err == nil {
+			dvm.logger.Info("Edgegap version %s validated", version)
+			dvm.versionApplied(version)
+			return
+		}
+		if isVersionNotFound(err) {
+			break
+		}
+	}
+
+	if !isVersionNotFound(err) {
+		dvm.logger.WithField("error", err.Error()).Warn("could not validate Edgegap version %s, keeping it", version)
+		return
+	}
+
+	ctx := context.Background()
+	current, _, readErr := dvm.sm.ReadEdgegapVersion(ctx)
+	if readErr != nil || current != version {
+		dvm.logger.Warn("Edgegap version %s is invalid but was already replaced, skipping rollback", version)
+		return
+	}
+
+	message := fmt.Sprintf("Edgegap version %s does not exist, rolled back to %s", version, previous)
+	if previous == "" {
+		message = fmt.Sprintf("Edgegap version %s does not exist and no previous version to roll back to", version)
+	} else if storeErr := dvm.StoreVersion(ctx, previous); storeErr != nil {
+		message = fmt.Sprintf("Edgegap version %s does not exist, failed to roll back to %s: %v", version, previous, storeErr)
+	}
+
+	dvm.logger.Error(message)
+	dvm.webhooks.Dispatch(WebhookEventVersionRollback, message, map[string]string{
+		"version":  version,
+		"previous": previous,
+	})
+}
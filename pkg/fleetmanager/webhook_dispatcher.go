@@ -0,0 +1,201 @@
+package fleetmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/edgegap/nakama-edgegap/internal/helpers"
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Outbound webhook events
+const (
+	WebhookEventDeploymentError      = "deployment_error"
+	WebhookEventReconciliationDelete = "reconciliation_delete"
+	WebhookEventVersionChanged       = "version_changed"
+	WebhookEventVersionRollback      = "version_rollback"
+	WebhookEventQuotaReached         = "quota_reached"
+	WebhookEventSlowStart            = "slow_start"
+	WebhookEventInstanceReplaced     = "instance_replaced"
+)
+
+// Outbound webhook target kinds, selected with a "kind:" prefix on the url
+const (
+	WebhookKindGeneric = "generic"
+	WebhookKindDiscord = "discord"
+	WebhookKindSlack   = "slack"
+)
+
+const (
+	defaultWebhookTemplate = "[{{.Event}}] {{.Message}}"
+	webhookQueueSize       = 256
+	webhookMaxAttempts     = 3
+	webhookRetryDelay      = time.Second
+)
+
+// WebhookMessage is an outbound notification, also the data available to the payload template
+type WebhookMessage struct {
+	Event      string            `json:"event"`
+	Message    string            `json:"message"`
+	Properties map[string]string `json:"properties"`
+	Timestamp  int64             `json:"timestamp"`
+}
+
+type webhookTarget struct {
+	kind   string
+	url    string
+	events map[string]struct{}
+}
+
+// WebhookDispatcher delivers outbound webhooks asynchronously, retrying failed deliveries
+type WebhookDispatcher struct {
+	logger   runtime.Logger
+	targets  []webhookTarget
+	template *template.Template
+	queue    chan WebhookMessage
+}
+
+// parseWebhookTarget parses "kind:url" or a plain url for a generic target.
+func parseWebhookTarget(value string, events map[string]struct{}) webhookTarget {
+	value = strings.TrimSpace(value)
+	for _, kind := range []string{WebhookKindDiscord, WebhookKindSlack, WebhookKindGeneric} {
+		if rest, ok := strings.CutPrefix(value, kind+":"); ok {
+			return webhookTarget{kind: kind, url: rest, events: events}
+		}
+	}
+	return webhookTarget{kind: WebhookKindGeneric, url: value, events: events}
+}
+
+// parseWebhookEvents parses a comma separated list of events, an empty list subscribes to every event.
+func parseWebhookEvents(value string) map[string]struct{} {
+	events := make(map[string]struct{})
+	for _, event := range strings.Split(value, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events[event] = struct{}{}
+		}
+	}
+	return events
+}
+
+// NewWebhookDispatcher creates the dispatcher from the configuration and starts its delivery worker.
+func NewWebhookDispatcher(ctx context.Context, config *EdgegapManagerConfiguration, logger runtime.Logger) (*WebhookDispatcher, error) {
+	tmplText := config.WebhookTemplate
+	if tmplText == "" {
+		tmplText = defaultWebhookTemplate
+	}
+	tmpl, err := template.New("webhook").Parse(tmplText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+
+	wd := &WebhookDispatcher{
+		logger:   logger,
+		targets:  make([]webhookTarget, 0),
+		template: tmpl,
+		queue:    make(chan WebhookMessage, webhookQueueSize),
+	}
+
+	events := parseWebhookEvents(config.WebhookEvents)
+	for _, url := range strings.Split(config.WebhookUrls, ",") {
+		if strings.TrimSpace(url) != "" {
+			wd.targets = append(wd.targets, parseWebhookTarget(url, events))
+		}
+	}
+
+	// Kept for backward compatibility, only receives slow start alerts
+	if config.SlowStartWebhookUrl != "" {
+		wd.targets = append(wd.targets, parseWebhookTarget(config.SlowStartWebhookUrl, map[string]struct{}{WebhookEventSlowStart: {}}))
+	}
+
+	if len(wd.targets) > 0 {
+		go wd.run(ctx)
+	}
+
+	return wd, nil
+}
+
+// Dispatch queues a webhook for every target subscribed to the event, without blocking the caller.
+func (wd *WebhookDispatcher) Dispatch(event, message string, properties map[string]string) {
+	if wd == nil || len(wd.targets) == 0 {
+		return
+	}
+
+	select {
+	case wd.queue <- WebhookMessage{Event: event, Message: message, Properties: properties, Timestamp: time.Now().Unix()}:
+	default:
+		wd.logger.WithField("event", event).Warn("webhook queue full, dropping outbound webhook")
+	}
+}
+
+func (wd *WebhookDispatcher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-wd.queue:
+			for _, target := range wd.targets {
+				if _, ok := target.events[msg.Event]; len(target.events) > 0 && !ok {
+					continue
+				}
+				wd.deliver(ctx, target, msg)
+			}
+		}
+	}
+}
+
+// payload formats the message for the target kind.
+func (wd *WebhookDispatcher) payload(target webhookTarget, msg WebhookMessage) (any, error) {
+	var text bytes.Buffer
+	if err := wd.template.Execute(&text, msg); err != nil {
+		return nil, err
+	}
+
+	switch target.kind {
+	case WebhookKindDiscord:
+		return map[string]string{"content": text.String()}, nil
+	case WebhookKindSlack:
+		return map[string]string{"text": text.String()}, nil
+	default:
+		return map[string]any{
+			"event":      msg.Event,
+			"message":    msg.Message,
+			"text":       text.String(),
+			"properties": msg.Properties,
+			"timestamp":  msg.Timestamp,
+		}, nil
+	}
+}
+
+// deliver posts the message to a target, retrying with a linear backoff.
+func (wd *WebhookDispatcher) deliver(ctx context.Context, target webhookTarget, msg WebhookMessage) {
+	payload, err := wd.payload(target, msg)
+	if err != nil {
+		wd.logger.WithField("error", err.Error()).Error("failed to render webhook payload")
+		return
+	}
+
+	client := helpers.NewAPIClient(target.url, "")
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		reply, err := client.Post("", payload)
+		if err == nil {
+			reply.Body.Close()
+			if reply.StatusCode < http.StatusBadRequest {
+				return
+			}
+			err = fmt.Errorf("status %d", reply.StatusCode)
+		}
+
+		wd.logger.WithFields(map[string]any{"error": err.Error(), "event": msg.Event, "attempt": attempt}).Warn("failed to deliver webhook")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * webhookRetryDelay):
+		}
+	}
+}
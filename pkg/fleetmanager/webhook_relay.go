@@ -0,0 +1,138 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// Relayed Edgegap deployment webhooks, named in the X-Edgegap-Webhook header of the relayed request
+const (
+	RelayEventDeploymentReady      = "deployment_ready"
+	RelayEventDeploymentError      = "deployment_error"
+	RelayEventDeploymentTerminated = "deployment_terminated"
+)
+
+// WebhookRelayHeader names the Edgegap webhook a relayed request carries
+const WebhookRelayHeader = "X-Edgegap-Webhook"
+
+type relayedWebhook struct {
+	event   string
+	payload string
+}
+
+// WebhookRelay re-posts the deployment webhooks received from Edgegap to third-party urls, e.g. a studio analytics
+// endpoint, asynchronously and with retries. Edgegap only accepts a single url per deployment webhook.
+type WebhookRelay struct {
+	logger runtime.Logger
+	urls   []string
+	client *http.Client
+	queue  chan relayedWebhook
+}
+
+// parseRelayUrls parses the comma separated relay urls, which must be absolute http(s) urls.
+func parseRelayUrls(value string) ([]string, error) {
+	urls := make([]string, 0)
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, errors.New("invalid webhook relay url: " + raw)
+		}
+		urls = append(urls, raw)
+	}
+	return urls, nil
+}
+
+// NewWebhookRelay creates the relay from the configuration and starts its delivery worker when urls are configured.
+func NewWebhookRelay(ctx context.Context, config *EdgegapManagerConfiguration, logger runtime.Logger) *WebhookRelay {
+	// The configuration is validated beforehand
+	urls, _ := parseRelayUrls(config.WebhookRelayUrls)
+
+	wr := &WebhookRelay{
+		logger: logger,
+		urls:   urls,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan relayedWebhook, webhookQueueSize),
+	}
+
+	if len(wr.urls) > 0 {
+		logger.Info("Relaying Edgegap deployment webhooks to %d urls", len(wr.urls))
+		go wr.run(ctx)
+	}
+
+	return wr
+}
+
+// Relay queues the webhook payload received from Edgegap for every relay url, without blocking the caller.
+func (wr *WebhookRelay) Relay(event, payload string) {
+	if wr == nil || len(wr.urls) == 0 {
+		return
+	}
+
+	select {
+	case wr.queue <- relayedWebhook{event: event, payload: payload}:
+	default:
+		wr.logger.WithField("event", event).Warn("webhook relay queue full, dropping relayed webhook")
+	}
+}
+
+func (wr *WebhookRelay) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-wr.queue:
+			for _, target := range wr.urls {
+				wr.deliver(ctx, target, msg)
+			}
+		}
+	}
+}
+
+// deliver posts the payload as received to the url, retrying with a linear backoff.
+func (wr *WebhookRelay) deliver(ctx context.Context, target string, msg relayedWebhook) {
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		err := wr.post(ctx, target, msg)
+		if err == nil {
+			return
+		}
+
+		wr.logger.WithFields(map[string]any{"error": err.Error(), "event": msg.event, "attempt": attempt}).Warn("failed to relay webhook")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * webhookRetryDelay):
+		}
+	}
+}
+
+func (wr *WebhookRelay) post(ctx context.Context, target string, msg relayedWebhook) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(msg.payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookRelayHeader, msg.event)
+
+	reply, err := wr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	reply.Body.Close()
+
+	if reply.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %d", reply.StatusCode)
+	}
+	return nil
+}
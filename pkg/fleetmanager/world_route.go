@@ -0,0 +1,168 @@
+package fleetmanager
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+const (
+	RpcIdWorldRoute = "world_route"
+
+	// InstanceMetadataWorldKey groups the shards of a persistent world
+	InstanceMetadataWorldKey = "world"
+
+	// friendStateMutual is the Nakama friend state of mutual friends
+	friendStateMutual = 0
+)
+
+type worldRouteRequest struct {
+	World string `json:"world"`
+}
+
+type worldRouteReply struct {
+	InstanceId     string                  `json:"instance_id"`
+	ConnectionInfo *runtime.ConnectionInfo `json:"connection_info"`
+	Population     int                     `json:"population"`
+	Friends        int                     `json:"friends"`
+}
+
+// shardCandidate is a persistent instance considered by the router
+type shardCandidate struct {
+	instance *runtime.InstanceInfo
+	ei       *EdgegapInstanceInfo
+	friends  int
+}
+
+// load returns the occupancy of the shard relative to its soft cap, or its raw population without soft cap
+func (sc *shardCandidate) load() float64 {
+	population := len(sc.ei.Connections) + len(sc.ei.Reservations)
+	if sc.ei.SoftCap > 0 {
+		return float64(population) / float64(sc.ei.SoftCap)
+	}
+	return float64(population)
+}
+
+// full reports whether the shard reached its soft cap
+func (sc *shardCandidate) full() bool {
+	return sc.ei.SoftCap > 0 && len(sc.ei.Connections)+len(sc.ei.Reservations) >= sc.ei.SoftCap
+}
+
+// pickShard prefers the shard with the most friends of the user that is not full,
+// and otherwise balances the population on the least loaded shard.
+func pickShard(candidates []*shardCandidate) *shardCandidate {
+	var best *shardCandidate
+	for _, c := range candidates {
+		if best == nil {
+			best = c
+			continue
+		}
+		if c.full() != best.full() {
+			if !c.full() {
+				best = c
+			}
+			continue
+		}
+		if c.friends != best.friends {
+			if c.friends > best.friends {
+				best = c
+			}
+			continue
+		}
+		if c.load() < best.load() {
+			best = c
+		}
+	}
+	return best
+}
+
+// mutualFriends returns the IDs of the mutual friends of the user
+func mutualFriends(ctx context.Context, nk runtime.NakamaModule, userId string) (map[string]struct{}, error) {
+	friends := make(map[string]struct{})
+	state := friendStateMutual
+	cursor := ""
+	for {
+		list, nextCursor, err := nk.FriendsList(ctx, userId, 1_000, &state, cursor)
+		if err != nil {
+			return nil, err
+		}
+		for _, friend := range list {
+			friends[friend.GetUser().GetId()] = struct{}{}
+		}
+		if nextCursor == "" || len(list) == 0 {
+			return friends, nil
+		}
+		cursor = nextCursor
+	}
+}
+
+// routeWorld client rpc assigning the user to a shard of a persistent world
+func routeWorld(ctx context.Context, logger runtime.Logger, db *sql.DB, nk runtime.NakamaModule, payload string) (string, error) {
+	userId, ok := ctx.Value(runtime.RUNTIME_CTX_USER_ID).(string)
+	if !ok {
+		return "", ErrInvalidInput
+	}
+
+	var req worldRouteRequest
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &req); err != nil {
+			return "", ErrInvalidInput
+		}
+	}
+
+	instances, err := fmInstance.storageManager.listDbInstancesByStatus(ctx, []string{EdgegapStatusReady})
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("failed to list world shards")
+		return "", ErrInternalError
+	}
+
+	friends, err := mutualFriends(ctx, nk, userId)
+	if err != nil {
+		logger.WithField("error", err.Error()).Warn("failed to list friends, routing on population only")
+		friends = map[string]struct{}{}
+	}
+
+	candidates := make([]*shardCandidate, 0)
+	for _, instance := range instances {
+		ei, err := fmInstance.storageManager.ExtractEdgegapInstance(instance)
+		if err != nil || !ei.Persistent || ei.DrainingTo != "" {
+			continue
+		}
+		if req.World != "" && fmt.Sprint(instance.Metadata[InstanceMetadataWorldKey]) != req.World {
+			continue
+		}
+
+		candidate := &shardCandidate{instance: instance, ei: ei}
+		for _, connected := range ei.Connections {
+			if _, ok := friends[connected]; ok {
+				candidate.friends++
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	shard := pickShard(candidates)
+	if shard == nil {
+		return "", runtime.NewError("no shard available", 14) // UNAVAILABLE
+	}
+
+	joinInfo, err := fmInstance.Join(ctx, shard.instance.Id, []string{userId}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	replyString, err := json.Marshal(worldRouteReply{
+		InstanceId:     joinInfo.InstanceInfo.Id,
+		ConnectionInfo: joinInfo.InstanceInfo.ConnectionInfo,
+		Population:     len(shard.ei.Connections),
+		Friends:        shard.friends,
+	})
+	if err != nil {
+		return "", ErrInternalError
+	}
+
+	return string(replyString), nil
+}
@@ -0,0 +1,168 @@
+package fleetmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// instanceMutation applies a change to an instance. It reports whether the instance changed and
+// optionally returns a function run once the change is written.
+type instanceMutation func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) (changed bool, after func(ctx context.Context))
+
+// writeCoalescer merges the changes of bursty webhook traffic into a single storage write per instance.
+// Changes submitted within the window are applied in their arrival order on a fresh read of the instance.
+type writeCoalescer struct {
+	ctx     context.Context
+	logger  runtime.Logger
+	sm      *StorageManager
+	window  time.Duration
+	mu      sync.Mutex
+	pending map[string]*pendingWrite
+}
+
+type pendingWrite struct {
+	mutations []instanceMutation
+	timer     *time.Timer
+	// failures counts the flushes that failed to write the mutations, they are dropped after coalesceMaxFailures
+	failures int
+}
+
+const (
+	// coalesceWriteAttempts bounds the writes of one flush losing to a concurrent write of the instance
+	coalesceWriteAttempts = 3
+	// coalesceMaxFailures bounds the flushes of mutations that keep failing, e.g. during a database outage
+	coalesceMaxFailures = 5
+)
+
+func newWriteCoalescer(ctx context.Context, logger runtime.Logger, sm *StorageManager, window time.Duration) *writeCoalescer {
+	return &writeCoalescer{
+		ctx:     ctx,
+		logger:  logger,
+		sm:      sm,
+		window:  window,
+		pending: make(map[string]*pendingWrite),
+	}
+}
+
+// submit queues the mutation of the instance, it is applied immediately when coalescing is disabled.
+func (wc *writeCoalescer) submit(ctx context.Context, id string, mutation instanceMutation) error {
+	if wc.window <= 0 {
+		return wc.apply(ctx, id, []instanceMutation{mutation})
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	pw, ok := wc.pending[id]
+	if !ok {
+		pw = &pendingWrite{}
+		pw.timer = time.AfterFunc(wc.window, func() {
+			wc.flush(id)
+		})
+		wc.pending[id] = pw
+	}
+	pw.mutations = append(pw.mutations, mutation)
+
+	return nil
+}
+
+// flush writes the pending mutations of the instance now, so a direct write keeps the arrival order. Mutations
+// failing to be written are queued again ahead of the mutations submitted meanwhile, until they fail
+// coalesceMaxFailures times.
+func (wc *writeCoalescer) flush(id string) {
+	wc.mu.Lock()
+	pw, ok := wc.pending[id]
+	if ok {
+		pw.timer.Stop()
+		delete(wc.pending, id)
+	}
+	wc.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	err := wc.apply(wc.ctx, id, pw.mutations)
+	if err == nil {
+		return
+	}
+	logger := wc.logger.WithFields(map[string]any{"error": err.Error(), "instance_id": id, "changes": len(pw.mutations)})
+	if errors.Is(err, errorCoalescedInstanceNotFound) || pw.failures+1 >= coalesceMaxFailures || wc.ctx.Err() != nil {
+		logger.Error("failed to write coalesced instance changes, dropping them")
+		return
+	}
+	logger.Warn("failed to write coalesced instance changes, retrying")
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	retry := &pendingWrite{mutations: pw.mutations, failures: pw.failures + 1}
+	if next, ok := wc.pending[id]; ok {
+		next.timer.Stop()
+		retry.mutations = append(retry.mutations, next.mutations...)
+	}
+	retry.timer = time.AfterFunc(max(wc.window, time.Second), func() {
+		wc.flush(id)
+	})
+	wc.pending[id] = retry
+}
+
+// errorCoalescedInstanceNotFound is returned when the mutated instance no longer exists, its mutations are dropped
+var errorCoalescedInstanceNotFound = errors.New("no instance found")
+
+// apply reads the instance, applies the mutations in order and writes it once if anything changed. Writes losing to
+// a concurrent write of the instance are applied again on a fresh read.
+func (wc *writeCoalescer) apply(ctx context.Context, id string, mutations []instanceMutation) error {
+	var err error
+	for attempt := 0; attempt < coalesceWriteAttempts; attempt++ {
+		if err = wc.applyOnce(ctx, id, mutations); !errors.Is(err, runtime.ErrStorageRejectedVersion) {
+			return err
+		}
+	}
+	return err
+}
+
+func (wc *writeCoalescer) applyOnce(ctx context.Context, id string, mutations []instanceMutation) error {
+	// Webhooks are authoritative, never apply them on a cached copy
+	wc.sm.InvalidateInstance(id)
+	instance, err := wc.sm.getDbInstance(ctx, id)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		return fmt.Errorf("%w with instanceId %s", errorCoalescedInstanceNotFound, id)
+	}
+
+	ei, err := wc.sm.ExtractEdgegapInstance(instance)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	afters := make([]func(ctx context.Context), 0)
+	for _, mutation := range mutations {
+		mutationChanged, after := mutation(instance, ei)
+		changed = changed || mutationChanged
+		if after != nil {
+			afters = append(afters, after)
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	instance.Metadata["edgegap"] = ei
+	if err = wc.sm.updateDbInstance(ctx, instance); err != nil {
+		return err
+	}
+
+	for _, after := range afters {
+		after(ctx)
+	}
+
+	return nil
+}
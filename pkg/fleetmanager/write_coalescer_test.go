@@ -0,0 +1,149 @@
+package fleetmanager
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/heroiclabs/nakama-common/runtime"
+)
+
+// connect returns a mutation adding the connection of the user, recording its after function in runs
+func connect(userId string, runs *[]string) instanceMutation {
+	return func(instance *runtime.InstanceInfo, ei *EdgegapInstanceInfo) (bool, func(ctx context.Context)) {
+		ei.Connections = append(ei.Connections, userId)
+		return true, func(ctx context.Context) {
+			*runs = append(*runs, userId)
+		}
+	}
+}
+
+func storedConnections(t *testing.T, sm *StorageManager, id string) []string {
+	t.Helper()
+	sm.InvalidateInstance(id)
+	instance, err := sm.getDbInstance(context.Background(), id)
+	if err != nil || instance == nil {
+		t.Fatalf("getDbInstance() = %v, %v", instance, err)
+	}
+	return instance.Metadata["edgegap"].(*EdgegapInstanceInfo).Connections
+}
+
+func TestWriteCoalescerFlush(t *testing.T) {
+	ctx := context.Background()
+	nk := newFakeNakama()
+	sm := NewStorageManager(nk, fakeLogger{})
+	if _, err := sm.createDbInstance(ctx, "id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+	writes := 0
+	nk.beforeWrite = func([]*runtime.StorageWrite) { writes++ }
+
+	wc := newWriteCoalescer(ctx, fakeLogger{}, sm, time.Hour)
+	runs := make([]string, 0)
+	for _, userId := range []string{"a", "b", "c"} {
+		if err := wc.submit(ctx, "id", connect(userId, &runs)); err != nil {
+			t.Fatalf("submit() error = %v", err)
+		}
+	}
+	if writes != 0 || len(storedConnections(t, sm, "id")) != 0 {
+		t.Fatalf("submit() wrote within the window")
+	}
+
+	wc.flush("id")
+	if writes != 1 {
+		t.Errorf("flush() wrote %d times, want 1", writes)
+	}
+	if got := storedConnections(t, sm, "id"); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("connections = %v, want [a b c]", got)
+	}
+	if !slices.Equal(runs, []string{"a", "b", "c"}) {
+		t.Errorf("after functions run = %v, want [a b c]", runs)
+	}
+
+	// Nothing is left to write
+	wc.flush("id")
+	if writes != 1 {
+		t.Errorf("flush() of no pending change wrote %d times, want 1", writes)
+	}
+}
+
+func TestWriteCoalescerConflict(t *testing.T) {
+	tests := []struct {
+		name          string
+		conflicts     int
+		wantFailures  int
+		wantConnected []string
+	}{
+		{name: "written on a fresh read", conflicts: 1, wantConnected: []string{"other", "a"}},
+		{name: "queued again", conflicts: coalesceWriteAttempts, wantFailures: 1, wantConnected: []string{"other", "other", "other"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			nk := newFakeNakama()
+			sm := NewStorageManager(nk, fakeLogger{})
+			sm.EnableInstanceCache(time.Minute, 10)
+			other := NewStorageManager(nk, fakeLogger{})
+			if _, err := sm.createDbInstance(ctx, "id", EdgegapStatusReady, EdgegapInstanceInfo{MaxPlayers: 4}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			// The other node connects a player between each read and write of the coalescer, up to conflicts times
+			conflicts, writing := 0, false
+			nk.beforeWrite = func([]*runtime.StorageWrite) {
+				if writing || conflicts == tt.conflicts {
+					return
+				}
+				conflicts++
+				writing = true
+				defer func() { writing = false }()
+				otherRuns := make([]string, 0)
+				if err := newWriteCoalescer(ctx, fakeLogger{}, other, 0).submit(ctx, "id", connect("other", &otherRuns)); err != nil {
+					t.Errorf("submit() on the other node error = %v", err)
+				}
+			}
+
+			wc := newWriteCoalescer(ctx, fakeLogger{}, sm, time.Hour)
+			runs := make([]string, 0)
+			if err := wc.submit(ctx, "id", connect("a", &runs)); err != nil {
+				t.Fatal(err)
+			}
+			wc.flush("id")
+
+			if got := storedConnections(t, sm, "id"); !slices.Equal(got, tt.wantConnected) {
+				t.Errorf("connections = %v, want %v", got, tt.wantConnected)
+			}
+			wc.mu.Lock()
+			defer wc.mu.Unlock()
+			pw, pending := wc.pending["id"]
+			if tt.wantFailures == 0 {
+				if pending || !slices.Equal(runs, []string{"a"}) {
+					t.Errorf("pending = %v, after functions run = %v, want written once", pending, runs)
+				}
+				return
+			}
+			if !pending || pw.failures != tt.wantFailures || len(pw.mutations) != 1 || len(runs) != 0 {
+				t.Fatalf("pending = %v, failures = %d, after functions run = %v, want queued again", pending, pw.failures, runs)
+			}
+			pw.timer.Stop()
+		})
+	}
+}
+
+func TestWriteCoalescerInstanceNotFound(t *testing.T) {
+	ctx := context.Background()
+	wc := newWriteCoalescer(ctx, fakeLogger{}, NewStorageManager(newFakeNakama(), fakeLogger{}), time.Hour)
+	runs := make([]string, 0)
+	if err := wc.submit(ctx, "missing", connect("a", &runs)); err != nil {
+		t.Fatal(err)
+	}
+
+	wc.flush("missing")
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if _, pending := wc.pending["missing"]; pending || len(runs) != 0 {
+		t.Errorf("changes of a missing instance queued again = %v, after functions run = %v, want dropped", pending, runs)
+	}
+}
@@ -0,0 +1,260 @@
+// Package serversdk helps Go dedicated game servers deployed by the Edgegap fleet manager report their
+// state to Nakama, using the event urls injected in the deployment environment.
+package serversdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Environment variables injected in every deployment
+const (
+	EnvConnectionEventUrl = "NAKAMA_CONNECTION_EVENT_URL"
+	EnvInstanceEventUrl   = "NAKAMA_INSTANCE_EVENT_URL"
+	EnvInstanceUpdateUrl  = "NAKAMA_INSTANCE_UPDATE_URL"
+	EnvServerPingUrl      = "NAKAMA_SERVER_PING_URL"
+	EnvInstanceMetadata   = "NAKAMA_INSTANCE_METADATA"
+	EnvRequestId          = "ARBITRIUM_REQUEST_ID"
+)
+
+// Instance event actions
+const (
+	ActionReady = "READY"
+	ActionError = "ERROR"
+	ActionStop  = "STOP"
+)
+
+// ErrorMissingEnvironment is returned by NewFromEnv when the deployment environment is incomplete
+var ErrorMissingEnvironment = errors.New("missing nakama environment variables")
+
+// Client reports the state of a game server instance to Nakama.
+type Client struct {
+	InstanceId         string
+	ConnectionEventUrl string
+	InstanceEventUrl   string
+	InstanceUpdateUrl  string
+	ServerPingUrl      string
+	// InstanceToken is only required when updates are relayed with a user session instead of the injected urls
+	InstanceToken string
+	// Metadata is the create metadata of the instance
+	Metadata map[string]any
+
+	HTTPClient *http.Client
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+type connectionEvent struct {
+	InstanceId  string   `json:"instance_id"`
+	Connections []string `json:"connections,omitempty"`
+	Joined      []string `json:"joined,omitempty"`
+	Left        []string `json:"left,omitempty"`
+}
+
+type instanceEvent struct {
+	InstanceId string         `json:"instance_id"`
+	Action     string         `json:"action"`
+	Message    string         `json:"message"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+}
+
+// PingReply confirms the instance is known to Nakama and lists the users expected to connect
+type PingReply struct {
+	Ok           bool     `json:"ok"`
+	InstanceId   string   `json:"instance_id"`
+	Status       string   `json:"status"`
+	MaxPlayers   int      `json:"max_players"`
+	Reservations []string `json:"reservations"`
+	Connections  []string `json:"connections"`
+}
+
+type serverPing struct {
+	InstanceId    string `json:"instance_id"`
+	InstanceToken string `json:"instance_token,omitempty"`
+}
+
+type instanceUpdate struct {
+	InstanceId    string         `json:"instance_id"`
+	InstanceToken string         `json:"instance_token,omitempty"`
+	PlayerCount   int            `json:"player_count"`
+	Metadata      map[string]any `json:"metadata,omitempty"`
+}
+
+// NewFromEnv creates a Client from the environment variables injected by the fleet manager.
+func NewFromEnv() (*Client, error) {
+	c := &Client{
+		InstanceId:         os.Getenv(EnvRequestId),
+		ConnectionEventUrl: os.Getenv(EnvConnectionEventUrl),
+		InstanceEventUrl:   os.Getenv(EnvInstanceEventUrl),
+		InstanceUpdateUrl:  os.Getenv(EnvInstanceUpdateUrl),
+		ServerPingUrl:      os.Getenv(EnvServerPingUrl),
+		HTTPClient:         &http.Client{Timeout: 10 * time.Second},
+		MaxRetries:         3,
+		RetryDelay:         time.Second,
+	}
+
+	if c.InstanceId == "" || c.ConnectionEventUrl == "" || c.InstanceEventUrl == "" {
+		return nil, ErrorMissingEnvironment
+	}
+
+	if metadata := os.Getenv(EnvInstanceMetadata); metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &c.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", EnvInstanceMetadata, err)
+		}
+	}
+
+	return c, nil
+}
+
+// Ping validates the link with Nakama, call it at boot before Ready.
+func (c *Client) Ping(ctx context.Context) (*PingReply, error) {
+	if c.ServerPingUrl == "" {
+		return nil, fmt.Errorf("%w: %s", ErrorMissingEnvironment, EnvServerPingUrl)
+	}
+
+	var reply PingReply
+	if err := c.call(ctx, c.ServerPingUrl, serverPing{InstanceId: c.InstanceId, InstanceToken: c.InstanceToken}, &reply); err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+// Ready tells Nakama the game server accepts players. The metadata is merged into the instance metadata,
+// e.g. to expose a callback_url or heartbeat_url.
+func (c *Client) Ready(ctx context.Context, message string, metadata map[string]any) error {
+	return c.post(ctx, c.InstanceEventUrl, instanceEvent{
+		InstanceId: c.InstanceId,
+		Action:     ActionReady,
+		Message:    message,
+		Metadata:   metadata,
+	})
+}
+
+// Error reports an error of the game server.
+func (c *Client) Error(ctx context.Context, message string) error {
+	return c.post(ctx, c.InstanceEventUrl, instanceEvent{
+		InstanceId: c.InstanceId,
+		Action:     ActionError,
+		Message:    message,
+	})
+}
+
+// Stop asks Nakama to stop the deployment, e.g. once the match ended.
+func (c *Client) Stop(ctx context.Context, message string) error {
+	return c.post(ctx, c.InstanceEventUrl, instanceEvent{
+		InstanceId: c.InstanceId,
+		Action:     ActionStop,
+		Message:    message,
+	})
+}
+
+// Connections reports the user IDs currently connected to the game server, consuming their reservations.
+func (c *Client) Connections(ctx context.Context, userIds []string) error {
+	if userIds == nil {
+		userIds = []string{}
+	}
+	return c.post(ctx, c.ConnectionEventUrl, connectionEvent{
+		InstanceId:  c.InstanceId,
+		Connections: userIds,
+	})
+}
+
+// ConnectionsDelta reports the users who joined and left since the last connection event,
+// a compact alternative to Connections for servers with many players.
+func (c *Client) ConnectionsDelta(ctx context.Context, joined, left []string) error {
+	if len(joined) == 0 && len(left) == 0 {
+		return nil
+	}
+	return c.post(ctx, c.ConnectionEventUrl, connectionEvent{
+		InstanceId: c.InstanceId,
+		Joined:     joined,
+		Left:       left,
+	})
+}
+
+// Heartbeat reports the player count and optional metadata of the game server.
+func (c *Client) Heartbeat(ctx context.Context, playerCount int, metadata map[string]any) error {
+	if c.InstanceUpdateUrl == "" {
+		return fmt.Errorf("%w: %s", ErrorMissingEnvironment, EnvInstanceUpdateUrl)
+	}
+	return c.post(ctx, c.InstanceUpdateUrl, instanceUpdate{
+		InstanceId:    c.InstanceId,
+		InstanceToken: c.InstanceToken,
+		PlayerCount:   playerCount,
+		Metadata:      metadata,
+	})
+}
+
+// post sends the payload, retrying network and server errors with a linear backoff.
+func (c *Client) post(ctx context.Context, url string, payload any) error {
+	return c.call(ctx, url, payload, nil)
+}
+
+// call sends the payload and decodes the reply into out when not nil.
+func (c *Client) call(ctx context.Context, url string, payload any, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = c.send(ctx, httpClient, url, body, out)
+		var status statusError
+		if err == nil || attempt >= c.MaxRetries || (errors.As(err, &status) && status.code < http.StatusInternalServerError) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * c.RetryDelay):
+		}
+	}
+}
+
+// statusError is returned for non 2xx replies, only server errors are retried
+type statusError struct {
+	code int
+	body string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("nakama replied with status %d: %s", e.code, e.body)
+}
+
+func (c *Client) send(ctx context.Context, httpClient *http.Client, url string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	reply, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer reply.Body.Close()
+
+	if reply.StatusCode < http.StatusOK || reply.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(reply.Body)
+		return statusError{code: reply.StatusCode, body: string(respBody)}
+	}
+
+	if out != nil {
+		return json.NewDecoder(reply.Body).Decode(out)
+	}
+	return nil
+}